@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"todoApp/api/responses"
+	"todoApp/audit"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/logging"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+)
+
+// Every mutation carried out by the RequestHandler is recorded in the audit log. The RequestHandler carries out one
+// command at a time, so the values it reads before and after a change are exactly what that command changed. The log
+// is only kept in memory until UseAuditLog is given one kept in a file.
+var auditLog = audit.NewLog()
+
+func AuditLog() *audit.Log {
+	return auditLog
+}
+
+// UseAuditLog records mutations in log from now on. It is called at startup, before any requests are handled.
+func UseAuditLog(log *audit.Log) {
+	auditLog = log
+}
+
+// record adds an entry for a mutation made on behalf of the request that ctx belongs to. before and after are nil
+// for things that were created or deleted.
+func record(ctx context.Context, operation string, listId int, itemId int, before any, after any) {
+	entry := audit.Entry{
+		Tenant:    tenants.From(ctx),
+		Operation: operation,
+		ListId:    listId,
+		ItemId:    itemId,
+		Before:    marshalValue(before),
+		After:     marshalValue(after),
+		RequestId: logging.RequestID(ctx),
+	}
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		entry.ActorId = principal.UserId
+		entry.Actor = principal.Username
+	}
+	if _, err := auditLog.Append(entry); err != nil {
+		logging.FromContext(ctx).Error("error recording audit log entry", "operation", operation, "error", err)
+	}
+}
+
+func marshalValue(value any) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return encoded
+}
+
+// itemAt returns the item at index, or nil if there isn't one.
+func itemAt(ctx context.Context, dataService dataService.IDataService, listId int, index int) *data.TodoItem {
+	item, err := dataService.GetTodoItem(ctx, listId, index)
+	if err != nil {
+		return nil
+	}
+	return &item
+}
+
+// lastItem returns the last item of a list, which is the one most recently created.
+func lastItem(ctx context.Context, dataService dataService.IDataService, listId int) *data.TodoItem {
+	items, err := dataService.GetAllTodoItems(ctx, listId)
+	if err != nil || len(items) == 0 {
+		return nil
+	}
+	return &items[len(items)-1]
+}
+
+// memberOf returns the member of a list with the given user ID, or nil if they aren't one.
+func memberOf(ctx context.Context, dataService dataService.IDataService, listId int, userId int) *data.Member {
+	members, err := dataService.GetListMembers(ctx, listId)
+	if err != nil {
+		return nil
+	}
+	index := slices.IndexFunc(members, func(member data.Member) bool { return member.UserId == userId })
+	if index < 0 {
+		return nil
+	}
+	return &members[index]
+}
+
+// resolveListId turns a list ID of 0 into the ID of the caller's default list, so entries always name the list. It only
+// looks the list up, recording a change mustn't change anything else. The change was made to the default list, so it
+// exists by now.
+func resolveListId(ctx context.Context, dataService dataService.IDataService, listId int) int {
+	if listId != 0 {
+		return listId
+	}
+	listId, _ = dataService.GetDefaultListId(ctx)
+	return listId
+}
+
+func recordItemChange(ctx context.Context, dataService dataService.IDataService, operation string, listId int, before *data.TodoItem, after *data.TodoItem) {
+	itemId := 0
+	var beforeValue, afterValue any
+	if before != nil {
+		itemId, beforeValue = before.Id, *before
+	}
+	if after != nil {
+		itemId, afterValue = after.Id, *after
+	}
+	record(ctx, operation, resolveListId(ctx, dataService, listId), itemId, beforeValue, afterValue)
+}
+
+func recordMemberChange(ctx context.Context, dataService dataService.IDataService, operation string, listId int, before *data.Member, after *data.Member) {
+	var beforeValue, afterValue any
+	if before != nil {
+		beforeValue = *before
+	}
+	if after != nil {
+		afterValue = *after
+	}
+	record(ctx, operation, resolveListId(ctx, dataService, listId), 0, beforeValue, afterValue)
+}
+
+// AuditHandler returns the audit log entries of the caller's tenant. They can be filtered with the 'actor', 'list'
+// and 'item' query parameters, and by time with 'from' and 'to' as RFC 3339 timestamps.
+func AuditHandler(log *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := audit.Filter{Tenant: tenants.From(r.Context()), Actor: query.Get("actor")}
+
+		var err error
+		if filter.ListId, err = optionalInt(query.Get("list")); err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid list parameter")
+			return
+		}
+		if filter.ItemId, err = optionalInt(query.Get("item")); err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid item parameter")
+			return
+		}
+		if filter.From, err = optionalTime(query.Get("from")); err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "from must be an RFC 3339 timestamp")
+			return
+		}
+		if filter.To, err = optionalTime(query.Get("to")); err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "to must be an RFC 3339 timestamp")
+			return
+		}
+
+		entries, err := log.Entries(filter)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error reading audit log", "error", err)
+			responses.WriteError(w, http.StatusInternalServerError, "the audit log couldn't be read")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// AuditVerifyHandler checks the hash chain of the whole audit log. With the 'seq' and 'hash' query parameters, e.g. a
+// head returned by an earlier check, it also checks that the log still holds that entry.
+func AuditVerifyHandler(log *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var head audit.Head
+		if query.Has("seq") || query.Has("hash") {
+			seq, err := strconv.ParseUint(query.Get("seq"), 10, 64)
+			if err != nil || seq == 0 || query.Get("hash") == "" {
+				responses.WriteError(w, http.StatusBadRequest, "seq and hash must be given together, seq as a positive number")
+				return
+			}
+			head = audit.Head{Seq: seq, Hash: query.Get("hash")}
+		}
+
+		result := log.VerifyHead(head)
+		if !result.Valid {
+			logging.FromContext(r.Context()).Error("audit log verification failed", "error", result.Error)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func optionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func optionalTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"todoApp/audit"
+	"todoApp/auth"
+	"todoApp/logging"
+)
+
+func TestRequestHandler_RecordsMutations(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+	auditLog = audit.NewLog()
+
+	send := func(method string, path string, handler http.Handler) {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(`{"Name":"Item"}`))
+		ctx := auth.WithPrincipal(req.Context(), auth.Principal{UserId: 1, Username: "alice"})
+		req = req.WithContext(logging.WithRequestID(ctx, "request-"+method))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send(http.MethodPost, "/todoapp/item/?list=2", CreateHandler(mockDataService))
+	send(http.MethodPut, "/todoapp/item/0?list=2", MarkItemAsCompleteHandler(mockDataService))
+	send(http.MethodDelete, "/todoapp/item/0?list=2", DeleteHandler(mockDataService))
+	send(http.MethodDelete, "/todoapp/item/5?list=2", DeleteHandler(mockDataService))
+	send(http.MethodPost, "/todoapp/item/?list=3", CreateHandler(mockDataService))
+
+	entries, err := auditLog.Entries(audit.Filter{})
+	if err != nil {
+		t.Fatalf("Unexpected error reading the audit log. Got: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Unexpected number of entries, failed and forbidden commands should not be recorded. Got: %+v", entries)
+	}
+	for i, operation := range []string{"create", "markAsComplete", "delete"} {
+		entry := entries[i]
+		if entry.Operation != operation || entry.Actor != "alice" || entry.ListId != 2 || entry.RequestId == "" {
+			t.Errorf("entry was not recorded correctly. Got: %+v", entry)
+		}
+	}
+	if entries[2].Before == nil || entries[2].After != nil {
+		t.Errorf("delete should record the item before it was deleted. Got: %+v", entries[2])
+	}
+	if result := auditLog.Verify(); !result.Valid {
+		t.Errorf("audit log failed verification. Got: %+v", result)
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	auditLog = audit.NewLog()
+	auditLog.Append(audit.Entry{Tenant: "default", Actor: "alice", Operation: "create", ListId: 1, ItemId: 1})
+	second, _ := auditLog.Append(audit.Entry{Tenant: "default", Actor: "bob", Operation: "create", ListId: 1, ItemId: 2})
+	auditLog.Append(audit.Entry{Tenant: "team-a", Actor: "alice", Operation: "create", ListId: 1, ItemId: 3})
+
+	testCases := []struct {
+		testName        string
+		query           string
+		expectedStatus  int
+		expectedEntries int
+	}{
+		{"Testing without filters", "", http.StatusOK, 2},
+		{"Testing by actor", "?actor=alice", http.StatusOK, 1},
+		{"Testing by item", "?item=2", http.StatusOK, 1},
+		{"Testing a time range", "?from=2000-01-01T00:00:00Z&to=2000-01-02T00:00:00Z", http.StatusOK, 0},
+		{"Testing an invalid time", "?from=yesterday", http.StatusBadRequest, 0},
+		{"Testing an invalid item", "?item=first", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			AuditHandler(auditLog).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todoapp/audit"+test.query, nil))
+
+			var entries []audit.Entry
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, test.expectedStatus)
+			} else if status == http.StatusOK {
+				if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil || len(entries) != test.expectedEntries {
+					t.Errorf("handler returned unexpected entries. Got: %+v", entries)
+				}
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	AuditVerifyHandler(auditLog).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todoapp/audit/verify", nil))
+	var result audit.Verification
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil || !result.Valid || result.Entries != 3 {
+		t.Errorf("verify handler returned unexpected result. Got: %+v", result)
+	}
+
+	// A head recorded earlier is checked too
+	headCases := []struct {
+		testName       string
+		query          string
+		expectedStatus int
+		expectedValid  bool
+	}{
+		{"Testing a head the log holds", fmt.Sprintf("?seq=2&hash=%s", second.Hash), http.StatusOK, true},
+		{"Testing a head the log doesn't hold", fmt.Sprintf("?seq=2&hash=%s", second.PrevHash), http.StatusOK, false},
+		{"Testing a head past the end of the log", fmt.Sprintf("?seq=4&hash=%s", second.Hash), http.StatusOK, false},
+		{"Testing a head without a hash", "?seq=2", http.StatusBadRequest, false},
+	}
+	for _, test := range headCases {
+		t.Run(test.testName, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			AuditVerifyHandler(auditLog).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todoapp/audit/verify"+test.query, nil))
+			var result audit.Verification
+			json.NewDecoder(rr.Body).Decode(&result)
+			if rr.Code != test.expectedStatus || result.Valid != test.expectedValid {
+				t.Errorf("Unexpected verification. Got: %v %+v", rr.Code, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"todoApp/data"
+	"todoApp/logging"
+	dataService "todoApp/services"
+)
+
+var ErrForbidden = errors.New("your role on this list does not allow this")
+
+// requiredRoles is the role a caller needs on a list for each command. Commands that aren't listed only need the
+// caller to be a member of the list, which the data service already checks.
+var requiredRoles = map[string]data.Role{
+	"create":         data.RoleEditor,
+	"markAsComplete": data.RoleEditor,
+	"delete":         data.RoleEditor,
+	"move":           data.RoleEditor,
+	"sync":           data.RoleEditor,
+	"addMember":      data.RoleOwner,
+	"updateMember":   data.RoleOwner,
+	"removeMember":   data.RoleOwner,
+	"manageWebhooks": data.RoleOwner,
+}
+
+// authorize checks the caller's role on a list allows the command. It runs on the RequestHandler goroutine, so a
+// member's role can't change between the check and the command being carried out.
+func authorize(ctx context.Context, dataService dataService.IDataService, command string, listId int) error {
+	required, restricted := requiredRoles[command]
+	if !restricted {
+		return nil
+	}
+
+	role, err := dataService.GetListRole(ctx, listId)
+	if err != nil {
+		return err
+	}
+	if !role.Allows(required) {
+		logging.FromContext(ctx).Info("command forbidden", "command", command, "list", listId, "role", role, "required", required)
+		return ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"todoApp/api/responses"
+	"todoApp/backup"
+	"todoApp/logging"
+)
+
+// Backups cover the users and the lists of every tenant, so like the API keys their handlers work on the stores
+// directly rather than through the RequestHandler.
+
+// BackupHandler sends an archive of everything the source stores, taken at one moment.
+func BackupHandler(source backup.Source) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		archive := source.Snapshot()
+		// The archive is written to a buffer first, so a failure can still be answered with an error
+		var body bytes.Buffer
+		if err := backup.Write(&body, archive); err != nil {
+			logging.FromContext(r.Context()).Error("error writing backup", "error", err)
+			responses.WriteError(w, http.StatusInternalServerError, "error writing backup")
+			return
+		}
+		record(r.Context(), "backup", 0, 0, nil, map[string]any{"Created": archive.Created, "Tenants": len(archive.Tenants)})
+		logging.FromContext(r.Context()).Info("backup taken", "tenants", len(archive.Tenants), "bytes", body.Len())
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+backup.FileName(archive.Created)+`"`)
+		w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+		body.WriteTo(w)
+	}
+}
+
+// RestoreHandler restores the archive in the request body. The 'mode' query parameter is 'replace', the default, to
+// replace the source's data with the archive's, or 'merge' to only add what the source doesn't have.
+func RestoreHandler(source backup.Source) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := backup.Mode(r.URL.Query().Get("mode"))
+		if mode == "" {
+			mode = backup.Replace
+		}
+		if !mode.Valid() {
+			responses.WriteError(w, http.StatusBadRequest, backup.ErrUnknownMode.Error())
+			return
+		}
+
+		archive, _, err := backup.Read(http.MaxBytesReader(w, r.Body, backup.MaxArchiveBytes))
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			responses.WriteError(w, http.StatusRequestEntityTooLarge, "backup archive is too large")
+			return
+		case err != nil:
+			responses.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		report, err := source.Restore(r.Context(), archive, mode)
+		if err != nil {
+			responses.WriteError(w, errorStatus(err, http.StatusUnprocessableEntity), err.Error())
+			return
+		}
+		record(r.Context(), "restore", 0, 0, nil, report)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
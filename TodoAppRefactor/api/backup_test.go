@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"todoApp/audit"
+	"todoApp/auth"
+	"todoApp/backup"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+	"todoApp/users"
+)
+
+func TestBackupAndRestoreHandlers(t *testing.T) {
+	auditLog = audit.NewLog()
+	source := backup.Source{
+		Users: users.NewUserService(),
+		Lists: tenants.NewRouter(func(string) *dataService.DataService { return dataService.NewDataService() }, tenants.Quota{}),
+	}
+	source.Users.Register("alice", "password123")
+	admin := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice", Admin: true})
+
+	w := httptest.NewRecorder()
+	BackupHandler(source).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/backup", nil).WithContext(admin))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("Unexpected backup response. Got: %d, %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	archive := w.Body.Bytes()
+	if _, _, err := backup.Read(bytes.NewReader(archive)); err != nil {
+		t.Fatalf("The backup can't be read: %v", err)
+	}
+
+	testCases := []struct {
+		testName       string
+		query          string
+		body           []byte
+		source         backup.Source
+		expectedStatus int
+	}{
+		{"Testing a restore that replaces the data", "", archive, source, http.StatusOK},
+		{"Testing a restore that merges the data", "?mode=merge", archive, source, http.StatusOK},
+		{"Testing an unknown mode", "?mode=overwrite", archive, source, http.StatusBadRequest},
+		{"Testing an invalid archive", "", []byte("not an archive"), source, http.StatusUnprocessableEntity},
+		{"Testing a replicated node", "", archive, backup.Source{Users: source.Users, Lists: source.Lists, Replicated: true}, http.StatusConflict},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/admin/restore"+test.query, bytes.NewReader(test.body)).WithContext(admin)
+			RestoreHandler(test.source).ServeHTTP(w, req)
+			if w.Code != test.expectedStatus {
+				t.Fatalf("Unexpected status. Got: %d, Expected: %d, %s", w.Code, test.expectedStatus, w.Body.String())
+			}
+			if w.Code == http.StatusOK {
+				var report backup.Report
+				if err := json.NewDecoder(w.Body).Decode(&report); err != nil || len(report.Tenants) != 1 {
+					t.Errorf("Unexpected report. Got: %+v, %v", report, err)
+				}
+			}
+		})
+	}
+
+	entries, err := auditLog.Entries(audit.Filter{})
+	if err != nil || len(entries) != 3 || entries[0].Operation != "backup" || entries[1].Operation != "restore" || entries[0].Actor != "alice" {
+		t.Errorf("Backups and restores should be audited. Got: %+v", entries)
+	}
+}
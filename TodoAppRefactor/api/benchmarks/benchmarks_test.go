@@ -42,12 +42,14 @@ func BenchmarkRandomApiCalls(b *testing.B) {
 	defer server.Close()
 	fmt.Printf("Server started on: %s\n", server.Listener.Addr().String())
 
+	rejectedBefore := api.RejectedRequests()
 	for i := 0; i < b.N; i++ {
 		wg.Add(1)
 		go CallRandomApiCall(&wg, b)
 	}
 
 	wg.Wait()
+	b.ReportMetric(float64(api.RejectedRequests()-rejectedBefore)/float64(b.N), "rejected/op")
 	close(stopCh)
 	requestHandlerwg.Wait()
 }
@@ -108,7 +110,8 @@ func CallRandomApiCall(wg *sync.WaitGroup, b *testing.B) {
 		return
 	}
 
-	if status := httpResponseRec.Code; status != http.StatusOK && status != http.StatusCreated {
+	// Requests shed by the command queue are expected when the server is flooded
+	if status := httpResponseRec.Code; status != http.StatusOK && status != http.StatusCreated && status != http.StatusServiceUnavailable {
 		expectedError := "item at specified index does not exist"
 		if strings.TrimSpace(httpResponseRec.Body.String()) != expectedError {
 			b.Errorf("handler returned unexpected body. Got: %v Want: %v", strings.TrimSpace(httpResponseRec.Body.String()), expectedError)
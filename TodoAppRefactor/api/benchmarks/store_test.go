@@ -0,0 +1,179 @@
+package benchmarks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"todoApp/auth"
+	"todoApp/backup"
+	"todoApp/data"
+	"todoApp/persist"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+	"todoApp/users"
+)
+
+// listSizes are how many items the list changed by the store benchmarks starts with.
+var listSizes = []int{1_000, 100_000}
+
+var owner = auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+
+// backend is a way of keeping list data: create makes a data service kept in dir, and save, if set, is what has to
+// happen after a change for it to be kept.
+type backend struct {
+	name   string
+	create func(b *testing.B, dir string) *dataService.DataService
+	save   func(b *testing.B, dir string, lists *dataService.DataService)
+}
+
+var backends = []backend{
+	{name: "memory", create: func(b *testing.B, dir string) *dataService.DataService { return dataService.NewDataService() }},
+	{
+		// The JSON file is the state file the server writes to -data-dir, here written after every change
+		name:   "json",
+		create: func(b *testing.B, dir string) *dataService.DataService { return dataService.NewDataService() },
+		save: func(b *testing.B, dir string, lists *dataService.DataService) {
+			snapshot := backup.Archive{Users: users.NewUserService().Snapshot(), Tenants: map[string]data.Snapshot{tenants.DefaultTenant: lists.Snapshot()}}
+			if err := persist.Save(dir, snapshot); err != nil {
+				b.Fatal(err)
+			}
+		},
+	},
+	{name: "kv", create: openKVBackend(data.KVOptions{})},
+	{name: "kv-sync", create: openKVBackend(data.KVOptions{SyncWrites: true})},
+}
+
+func openKVBackend(options data.KVOptions) func(b *testing.B, dir string) *dataService.DataService {
+	return func(b *testing.B, dir string) *dataService.DataService {
+		kv, err := data.OpenKV(filepath.Join(dir, "lists.kv"), options)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { kv.Close() })
+		lists, err := dataService.NewStoredDataService(data.NewKVStore(kv))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return lists
+	}
+}
+
+// withoutLogs drops the log lines of every change for the rest of the benchmark, so that writing them isn't measured.
+func withoutLogs(b *testing.B) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(previous) })
+}
+
+// fillList gives lists a list with size items. The items are restored in one go, as creating them one at a time would
+// take a sync of the file each with some backends.
+func fillList(b *testing.B, lists *dataService.DataService, size int) data.TodoList {
+	filled := dataService.NewDataService()
+	list, _ := filled.CreateTodoList(owner, "Groceries")
+	for i := range size {
+		if err := filled.CreateTodoItem(owner, list.Id, fmt.Sprint("Item ", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := lists.Restore(filled.Snapshot()); err != nil {
+		b.Fatal(err)
+	}
+	return list
+}
+
+// BenchmarkStore_CreateItem adds an item to a list that is already big and keeps the change.
+func BenchmarkStore_CreateItem(b *testing.B) {
+	withoutLogs(b)
+	for _, size := range listSizes {
+		for _, backend := range backends {
+			b.Run(fmt.Sprintf("%s/items=%d", backend.name, size), func(b *testing.B) {
+				dir := b.TempDir()
+				lists := backend.create(b, dir)
+				list := fillList(b, lists, size)
+
+				b.ResetTimer()
+				for range b.N {
+					if err := lists.CreateTodoItem(owner, list.Id, "Milk"); err != nil {
+						b.Fatal(err)
+					}
+					if backend.save != nil {
+						backend.save(b, dir, lists)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkStore_CompleteItem marks an item of a big list as complete and keeps the change.
+func BenchmarkStore_CompleteItem(b *testing.B) {
+	withoutLogs(b)
+	for _, size := range listSizes {
+		for _, backend := range backends {
+			b.Run(fmt.Sprintf("%s/items=%d", backend.name, size), func(b *testing.B) {
+				dir := b.TempDir()
+				lists := backend.create(b, dir)
+				list := fillList(b, lists, size)
+
+				b.ResetTimer()
+				for i := range b.N {
+					if err := lists.MarkItemAsComplete(owner, list.Id, i%size); err != nil {
+						b.Fatal(err)
+					}
+					if backend.save != nil {
+						backend.save(b, dir, lists)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkStore_Load loads a big list the way a server starting up does.
+func BenchmarkStore_Load(b *testing.B) {
+	withoutLogs(b)
+	for _, size := range listSizes {
+		b.Run(fmt.Sprintf("json/items=%d", size), func(b *testing.B) {
+			dir := b.TempDir()
+			lists := dataService.NewDataService()
+			fillList(b, lists, size)
+			backends[1].save(b, dir, lists)
+
+			b.ResetTimer()
+			for range b.N {
+				archive, err := persist.Load(dir)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := dataService.NewDataService().Restore(archive.Tenants[tenants.DefaultTenant]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("kv/items=%d", size), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "lists.kv")
+			kv, err := data.OpenKV(path, data.KVOptions{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			lists, _ := dataService.NewStoredDataService(data.NewKVStore(kv))
+			fillList(b, lists, size)
+			kv.Close()
+
+			b.ResetTimer()
+			for range b.N {
+				kv, err := data.OpenKV(path, data.KVOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := dataService.NewStoredDataService(data.NewKVStore(kv)); err != nil {
+					b.Fatal(err)
+				}
+				kv.Close()
+			}
+		})
+	}
+}
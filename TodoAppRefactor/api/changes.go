@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/auth"
+	"todoApp/events"
+	"todoApp/logging"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+)
+
+// MaxChangesWait is the longest a poll of the changes feed is held open, longer waits are cut to it.
+const MaxChangesWait = time.Minute
+
+// ChangesHandler is the changes feed for clients that can't hold a stream open. It returns the changes to the
+// caller's lists after the sequence number given by the 'since' query parameter, optionally only those to the list
+// given by 'list'. If there are none and 'wait' is given, e.g. '30s', it waits up to that long for one before answering.
+// Like the event stream it reads the tenant's hub directly, so cursors count the changes of the caller's tenant, and
+// only returns changes made while the caller was a member.
+func ChangesHandler(hubs *events.Hubs, dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var since uint64
+		if value := query.Get("since"); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				responses.WriteError(w, http.StatusBadRequest, "invalid since parameter")
+				return
+			}
+			since = parsed
+		}
+		var wait time.Duration
+		if value := query.Get("wait"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < 0 {
+				responses.WriteError(w, http.StatusBadRequest, "invalid wait parameter")
+				return
+			}
+			wait = min(parsed, MaxChangesWait)
+		}
+		listId, ok := listFilter(w, r, dataService)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		principal, _ := auth.PrincipalFrom(ctx)
+		changes, cursor, complete := hubs.For(tenants.From(ctx)).Wait(ctx, since, func(event events.Event) bool {
+			return (listId == 0 || event.ListId == listId) && visibleTo(principal.UserId, event)
+		})
+		if !complete {
+			logging.FromContext(ctx).Debug("changes polled past the replay buffer", "since", since)
+		}
+		if changes == nil {
+			changes = []events.Event{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(contracts.ChangesContract{Changes: changes, Cursor: cursor, Reset: !complete})
+	}
+}
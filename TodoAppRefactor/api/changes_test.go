@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/events"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+)
+
+// pollChanges polls the changes feed as the given user.
+func pollChanges(t *testing.T, handler http.Handler, userId int, query string) contracts.ChangesContract {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/todoapp/changes"+query, nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{UserId: userId}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var changes contracts.ChangesContract
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code. Got: %v Want: %v", rr.Code, http.StatusOK)
+	} else if err := json.NewDecoder(rr.Body).Decode(&changes); err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestChangesHandler_ReturnsChangesAfterCursor(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	handler := ChangesHandler(hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	list, _ := service.CreateTodoList(alice, "Groceries")
+	service.CreateTodoItem(alice, list.Id, "Hidden")
+	service.AddListMember(alice, list.Id, 2, data.RoleViewer)
+	service.CreateTodoItem(alice, list.Id, "Milk")
+
+	changes := pollChanges(t, handler, 2, "")
+	if len(changes.Changes) != 2 || changes.Changes[0].Type != events.MemberAdded || changes.Changes[1].Item.Name != "Milk" || changes.Cursor != 4 || changes.Reset {
+		t.Errorf("Changes from before Bob was a member should be skipped. Got: %+v", changes)
+	}
+	changes = pollChanges(t, handler, 1, "?since=3")
+	if len(changes.Changes) != 1 || changes.Changes[0].Seq != 4 || changes.Cursor != 4 {
+		t.Errorf("Unexpected changes after the cursor. Got: %+v", changes)
+	}
+	if changes = pollChanges(t, handler, 1, "?since=4"); len(changes.Changes) != 0 || changes.Cursor != 4 {
+		t.Errorf("Polling without waiting should answer straight away. Got: %+v", changes)
+	}
+}
+
+func TestChangesHandler_WaitsForChanges(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	handler := ChangesHandler(hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	list, _ := service.CreateTodoList(alice, "Groceries")
+	other, _ := service.CreateTodoList(alice, "Chores")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		service.CreateTodoItem(alice, other.Id, "Hoover")
+		service.CreateTodoItem(alice, list.Id, "Milk")
+	}()
+	changes := pollChanges(t, handler, 1, "?since=2&wait=5s&list="+strconv.Itoa(list.Id))
+	if len(changes.Changes) != 1 || changes.Changes[0].Item.Name != "Milk" || changes.Cursor != 4 {
+		t.Errorf("The poll should return the next change to the list. Got: %+v", changes)
+	}
+
+	start := time.Now()
+	if changes = pollChanges(t, handler, 1, "?since=4&wait=20ms"); len(changes.Changes) != 0 || changes.Cursor != 4 {
+		t.Errorf("The poll should time out without changes. Got: %+v", changes)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("The poll answered before the wait was up. Got: %v", elapsed)
+	}
+}
+
+func TestChangesHandler_ResetsWhenTooFarBehind(t *testing.T) {
+	hubs := events.NewHubs(2)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	handler := ChangesHandler(hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	for _, name := range []string{"Milk", "Eggs", "Bread", "Tea"} {
+		service.CreateTodoItem(alice, 0, name)
+	}
+
+	if changes := pollChanges(t, handler, 1, "?since=1"); !changes.Reset || changes.Cursor != 4 {
+		t.Errorf("Expected a reset. Got: %+v", changes)
+	}
+	if changes := pollChanges(t, handler, 1, "?since=40"); !changes.Reset || changes.Cursor != 4 || len(changes.Changes) != 0 {
+		t.Errorf("A cursor from before a restart should be reset. Got: %+v", changes)
+	}
+}
+
+func TestChangesHandler_CountsTenantsSeparately(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	other := dataService.NewDataService().PublishTo(hubs.For("team-a"), "team-a")
+	handler := ChangesHandler(hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	other.CreateTodoItem(alice, 0, "Hidden")
+	other.CreateTodoItem(alice, 0, "Hidden")
+	service.CreateTodoItem(alice, 0, "Milk")
+
+	if changes := pollChanges(t, handler, 1, ""); len(changes.Changes) != 1 || changes.Changes[0].Seq != 1 || changes.Cursor != 1 {
+		t.Errorf("Another tenant's changes should neither be returned nor move the cursor. Got: %+v", changes)
+	}
+}
+
+func TestChangesHandler_InvalidRequests(t *testing.T) {
+	handler := ChangesHandler(events.NewHubs(events.DefaultBufferSize), dataService.NewDataService())
+	testCases := []struct {
+		testName       string
+		path           string
+		expectedStatus int
+	}{
+		{"Testing an invalid cursor", "/todoapp/changes?since=-1", http.StatusBadRequest},
+		{"Testing an invalid wait", "/todoapp/changes?wait=30", http.StatusBadRequest},
+		{"Testing a negative wait", "/todoapp/changes?wait=-1s", http.StatusBadRequest},
+		{"Testing a list the caller isn't a member of", "/todoapp/changes?list=42", http.StatusNotFound},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if rr.Code != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, test.expectedStatus)
+			}
+		})
+	}
+}
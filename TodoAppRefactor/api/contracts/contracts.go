@@ -1,6 +1,11 @@
 package contracts
 
-import "todoApp/data"
+import (
+	"time"
+	"todoApp/data"
+	"todoApp/events"
+	"todoApp/validation"
+)
 
 type CreateContract struct {
 	Name string
@@ -18,3 +23,205 @@ type GetAllContract struct {
 type MarkItemAsCompleteContract struct {
 	Id int
 }
+
+// MoveContract is where to move an item to, after or before another item given by its ID.
+type MoveContract struct {
+	After  int `json:",omitempty"`
+	Before int `json:",omitempty"`
+}
+
+// MovedContract is the item moved and where it is now.
+type MovedContract struct {
+	Id    int
+	Index int
+}
+
+type QueueMetricsContract struct {
+	Depth    int
+	Capacity int
+	Rejected uint64
+}
+
+type HealthContract struct {
+	Status string
+	Checks map[string]string `json:",omitempty"`
+}
+
+type VersionContract struct {
+	Version   string
+	Commit    string
+	BuildTime string
+	Modified  bool
+	GoVersion string
+}
+
+type CreateListContract struct {
+	Name string
+}
+
+type CreateAPIKeyContract struct {
+	Name      string
+	Scope     string
+	ExpiresAt string `json:",omitempty"`
+}
+
+type APIKeyContract struct {
+	Id        string
+	Tenant    string
+	Name      string
+	Scope     string
+	Created   time.Time
+	ExpiresAt *time.Time `json:",omitempty"`
+	Revoked   bool
+}
+
+// CreatedAPIKeyContract is only returned when a key is created, it is the one time the secret is shown.
+type CreatedAPIKeyContract struct {
+	APIKeyContract
+	Key string
+}
+
+type CreateTokenContract struct {
+	Scope      string
+	TTLSeconds int `json:",omitempty"`
+}
+
+type TokenContract struct {
+	Token     string
+	TokenType string
+	Scope     string
+	ExpiresAt time.Time
+}
+
+type MemberContract struct {
+	UserId   int
+	Username string
+	Role     string
+}
+
+type AddMemberContract struct {
+	Username string
+	Role     string
+}
+
+type UpdateMemberContract struct {
+	Role string
+}
+
+// CreateWebhookContract registers a webhook. An empty Events sends every type of event.
+type CreateWebhookContract struct {
+	URL    string
+	Events []string `json:",omitempty"`
+}
+
+type WebhookContract struct {
+	Id      string
+	URL     string
+	Events  []string
+	Created time.Time
+}
+
+// CreatedWebhookContract is only returned when a webhook is created, it is the one time the signing secret is shown.
+type CreatedWebhookContract struct {
+	WebhookContract
+	Secret string
+}
+
+// ChangesContract answers a poll of the changes feed. Cursor is the 'since' to poll with next. When Reset is set some
+// changes after the cursor polled with were no longer kept, and the client should reload everything.
+type ChangesContract struct {
+	Changes []events.Event
+	Cursor  uint64
+	Reset   bool `json:",omitempty"`
+}
+
+// SyncContract uploads the mutations a client made offline. Token is the one returned by its last sync, empty the
+// first time. ClientId names the installation of the client, and stays the same across syncs.
+type SyncContract struct {
+	ClientId  string
+	Token     string `json:",omitempty"`
+	Mutations []MutationContract
+}
+
+// MutationContract is one offline change, see dataService.Mutation. Time is an RFC 3339 timestamp.
+type MutationContract struct {
+	Seq         uint64
+	Op          string
+	ItemId      int    `json:",omitempty"`
+	Ref         string `json:",omitempty"`
+	BaseVersion uint64 `json:",omitempty"`
+	Time        string
+	Name        *string `json:",omitempty"`
+	Complete    *bool   `json:",omitempty"`
+}
+
+type SyncResultContract struct {
+	Token     string
+	Reset     bool `json:",omitempty"`
+	Items     []SyncedItemContract
+	Created   map[string]int
+	Conflicts []ConflictContract
+}
+
+type SyncedItemContract struct {
+	Id       int
+	Name     string `json:",omitempty"`
+	Complete bool   `json:",omitempty"`
+	Index    int
+	Version  uint64
+	Deleted  bool `json:",omitempty"`
+}
+
+type ConflictContract struct {
+	Seq    uint64
+	ItemId int
+	Field  string `json:",omitempty"`
+	Reason string
+}
+
+// Types of the messages sent over the WebSocket, by clients and by the server respectively.
+const (
+	SocketSubscribe   = "subscribe"
+	SocketUnsubscribe = "unsubscribe"
+	SocketCommand     = "command"
+
+	SocketSubscribed   = "subscribed"
+	SocketUnsubscribed = "unsubscribed"
+	SocketEvent        = "event"
+	SocketPresence     = "presence"
+	SocketResult       = "result"
+	SocketError        = "error"
+)
+
+// Commands clients can send over the WebSocket.
+const (
+	SocketCreate   = "create"
+	SocketComplete = "complete"
+	SocketDelete   = "delete"
+)
+
+// SocketRequestContract is a message from a client on the WebSocket. Since is the sequence number of the last event
+// the client saw, when resubscribing. Id is echoed in the reply to a command.
+type SocketRequestContract struct {
+	Type    string
+	List    int
+	Since   uint64 `json:",omitempty"`
+	Id      string `json:",omitempty"`
+	Command string `json:",omitempty"`
+	Index   int    `json:",omitempty"`
+	Name    string `json:",omitempty"`
+}
+
+// SocketMessageContract is a message from the server on the WebSocket.
+type SocketMessageContract struct {
+	Type    string
+	List    int               `json:",omitempty"`
+	Seq     uint64            `json:",omitempty"`
+	Reset   bool              `json:",omitempty"`
+	Id      string            `json:",omitempty"`
+	Status  int               `json:",omitempty"`
+	Error   string            `json:",omitempty"`
+	Fields  validation.Errors `json:",omitempty"`
+	Event   *events.Event     `json:",omitempty"`
+	Viewers []events.Viewer   `json:",omitempty"`
+}
@@ -0,0 +1,175 @@
+package contracts
+
+import (
+	"math"
+	"strconv"
+	"time"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/events"
+	"todoApp/validation"
+)
+
+// Limits on the text fields of requests, in characters.
+const (
+	MaxItemNameLength   = 200
+	MaxListNameLength   = 100
+	MaxAPIKeyNameLength = 100
+	MaxUsernameLength   = 32
+	MaxCommandIdLength  = 64
+	MaxWebhookURLLength = 2048
+	MaxClientIdLength   = 64
+	MaxSyncRefLength    = 64
+	// MaxSyncMutations is the most mutations a client can upload in one sync, larger outboxes are sent in batches.
+	MaxSyncMutations = 500
+)
+
+var (
+	_ validation.Validator = (*CreateContract)(nil)
+	_ validation.Validator = (*CreateListContract)(nil)
+	_ validation.Validator = (*MoveContract)(nil)
+	_ validation.Validator = (*CreateAPIKeyContract)(nil)
+	_ validation.Validator = (*CreateTokenContract)(nil)
+	_ validation.Validator = (*AddMemberContract)(nil)
+	_ validation.Validator = (*UpdateMemberContract)(nil)
+	_ validation.Validator = (*SocketRequestContract)(nil)
+	_ validation.Validator = (*CreateWebhookContract)(nil)
+	_ validation.Validator = (*SyncContract)(nil)
+)
+
+var roles = []string{string(data.RoleViewer), string(data.RoleEditor), string(data.RoleOwner)}
+
+var eventTypes = []string{
+	string(events.ItemCreated), string(events.ItemUpdated), string(events.ItemCompleted), string(events.ItemDeleted),
+	string(events.ItemMoved),
+	string(events.ListCreated), string(events.MemberAdded), string(events.MemberUpdated), string(events.MemberRemoved),
+}
+
+func usernameChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'
+}
+
+func (contract *CreateContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("Name", &contract.Name, validation.Required(), validation.MaxLength(MaxItemNameLength), validation.Printable())
+	return errs
+}
+
+func (contract *CreateListContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("Name", &contract.Name, validation.Required(), validation.MaxLength(MaxListNameLength), validation.Printable())
+	return errs
+}
+
+func (contract *MoveContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.Int("After", contract.After, 0, math.MaxInt)
+	errs.Int("Before", contract.Before, 0, math.MaxInt)
+	if contract.After == 0 && contract.Before == 0 {
+		errs.Add("After", "or Before is required")
+	}
+	return errs
+}
+
+func (contract *CreateAPIKeyContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("Name", &contract.Name, validation.Required(), validation.MaxLength(MaxAPIKeyNameLength), validation.Printable())
+	errs.String("Scope", &contract.Scope, validation.Required(), validation.OneOf(string(auth.ScopeRead), string(auth.ScopeReadWrite)))
+	errs.String("ExpiresAt", &contract.ExpiresAt, validation.Timestamp(), validation.Future(time.Now))
+	return errs
+}
+
+// Validate allows an empty scope, which asks for the scope of the caller's credentials.
+func (contract *CreateTokenContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("Scope", &contract.Scope, validation.OneOf(string(auth.ScopeRead), string(auth.ScopeReadWrite)))
+	errs.Int("TTLSeconds", contract.TTLSeconds, 0, int(auth.MaxTokenTTL/time.Second))
+	return errs
+}
+
+func (contract *AddMemberContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("Username", &contract.Username, validation.Required(), validation.MaxLength(MaxUsernameLength),
+		validation.Chars("letters, digits, '.', '_' and '-'", usernameChar))
+	errs.String("Role", &contract.Role, validation.Required(), validation.OneOf(roles...))
+	return errs
+}
+
+func (contract *UpdateMemberContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("Role", &contract.Role, validation.Required(), validation.OneOf(roles...))
+	return errs
+}
+
+func (contract *SocketRequestContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("Type", &contract.Type, validation.Required(), validation.OneOf(SocketSubscribe, SocketUnsubscribe, SocketCommand))
+	if contract.Type != SocketCommand {
+		errs.Int("List", contract.List, 1, math.MaxInt)
+		return errs
+	}
+	errs.Int("List", contract.List, 0, math.MaxInt)
+	errs.String("Id", &contract.Id, validation.MaxLength(MaxCommandIdLength), validation.Printable())
+	errs.String("Command", &contract.Command, validation.Required(), validation.OneOf(SocketCreate, SocketComplete, SocketDelete))
+	if contract.Command == SocketCreate {
+		errs.String("Name", &contract.Name, validation.Required(), validation.MaxLength(MaxItemNameLength), validation.Printable())
+	} else {
+		errs.Int("Index", contract.Index, 0, math.MaxInt)
+	}
+	return errs
+}
+
+func (contract *CreateWebhookContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("URL", &contract.URL, validation.Required(), validation.MaxLength(MaxWebhookURLLength), validation.URL("http", "https"))
+	if len(contract.Events) > len(eventTypes) {
+		errs.Add("Events", "can't have more than "+strconv.Itoa(len(eventTypes))+" types")
+		return errs
+	}
+	for i := range contract.Events {
+		errs.String("Events["+strconv.Itoa(i)+"]", &contract.Events[i], validation.Required(), validation.OneOf(eventTypes...))
+	}
+	return errs
+}
+
+func (contract *SyncContract) Validate() validation.Errors {
+	var errs validation.Errors
+	errs.String("ClientId", &contract.ClientId, validation.Required(), validation.MaxLength(MaxClientIdLength), validation.Printable())
+	if _, err := strconv.ParseUint(contract.Token, 10, 64); contract.Token != "" && err != nil {
+		errs.Add("Token", "is not a sync token")
+	}
+	if len(contract.Mutations) > MaxSyncMutations {
+		errs.Add("Mutations", "can't have more than "+strconv.Itoa(MaxSyncMutations)+" mutations")
+		return errs
+	}
+
+	var lastSeq uint64
+	for i := range contract.Mutations {
+		mutation := &contract.Mutations[i]
+		field := "Mutations[" + strconv.Itoa(i) + "]."
+		if mutation.Seq <= lastSeq {
+			errs.Add(field+"Seq", "must be greater than 0 and the Seq before it")
+		}
+		lastSeq = mutation.Seq
+		errs.String(field+"Op", &mutation.Op, validation.Required(), validation.OneOf(data.SyncCreate, data.SyncUpdate, data.SyncDelete))
+		errs.String(field+"Time", &mutation.Time, validation.Required(), validation.Timestamp())
+		switch mutation.Op {
+		case data.SyncCreate:
+			errs.String(field+"Ref", &mutation.Ref, validation.Required(), validation.MaxLength(MaxSyncRefLength), validation.Printable())
+			if mutation.Name == nil {
+				errs.Add(field+"Name", "is required")
+			}
+		case data.SyncUpdate:
+			errs.Int(field+"ItemId", mutation.ItemId, 1, math.MaxInt)
+			if mutation.Name == nil && mutation.Complete == nil {
+				errs.Add(field+"Name", "or Complete is required")
+			}
+		case data.SyncDelete:
+			errs.Int(field+"ItemId", mutation.ItemId, 1, math.MaxInt)
+		}
+		if mutation.Name != nil {
+			errs.String(field+"Name", mutation.Name, validation.Required(), validation.MaxLength(MaxItemNameLength), validation.Printable())
+		}
+	}
+	return errs
+}
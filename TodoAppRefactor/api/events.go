@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"todoApp/api/responses"
+	"todoApp/auth"
+	"todoApp/events"
+	"todoApp/logging"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+)
+
+const (
+	// DefaultHeartbeat is how often an idle event stream is sent a comment, so proxies don't close it.
+	DefaultHeartbeat = 15 * time.Second
+	// sseRetry is how long browsers wait before reconnecting a dropped stream, in milliseconds.
+	sseRetry = 3000
+)
+
+// EventsHandler streams changes to the caller's lists as server-sent events, optionally only those to the list given
+// by the 'list' query parameter. Each event's ID is its sequence number, so a client reconnecting with the
+// Last-Event-ID header (or the 'lastEventId' query parameter) is sent what it missed from its tenant's replay buffer.
+// When what it missed is no longer buffered it is sent a 'reset' event first, telling it to reload everything.
+//
+// Streams read the hub directly rather than going through the RequestHandler, as they stay open indefinitely. Events
+// are only sent for changes to lists the caller was a member of when the change was made.
+func EventsHandler(hubs *events.Hubs, dataService dataService.IDataService, heartbeat time.Duration) http.HandlerFunc {
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeat
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		listId, ok := listFilter(w, r, dataService)
+		if !ok {
+			return
+		}
+		since, err := lastEventId(r)
+		if err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+
+		hub := hubs.For(tenants.From(ctx))
+		sub, replay, complete := hub.Subscribe(since, func(event events.Event) bool {
+			return listId == 0 || event.ListId == listId
+		})
+		defer sub.Close()
+
+		principal, _ := auth.PrincipalFrom(ctx)
+		visible := func(event events.Event) bool { return visibleTo(principal.UserId, event) }
+
+		controller := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+		if !complete {
+			logging.FromContext(ctx).Debug("event stream resumed past the replay buffer", "since", since)
+			fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", hub.Seq())
+		}
+		for _, event := range replay {
+			if visible(event) {
+				writeEvent(w, event)
+			}
+		}
+		controller.Flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, open := <-sub.Events():
+				if !open {
+					return
+				}
+				if visible(event) {
+					writeEvent(w, event)
+					if err := controller.Flush(); err != nil {
+						return
+					}
+				}
+			case <-ticker.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				if err := controller.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// visibleTo reports whether a user may be sent an event. It is decided by who the members of the list were when the
+// event was published, not when it is sent, so a new member isn't sent changes from before they joined. Members are
+// always told when they are added or removed.
+func visibleTo(userId int, event events.Event) bool {
+	return slices.Contains(event.Members, userId) || event.Member != nil && event.Member.UserId == userId
+}
+
+// listFilter reads the optional 'list' query parameter, checking the caller is a member of the list. It returns 0 when
+// there is no filter, and writes an error response if the parameter isn't valid.
+func listFilter(w http.ResponseWriter, r *http.Request, dataService dataService.IDataService) (int, bool) {
+	list := r.URL.Query().Get("list")
+	if list == "" {
+		return 0, true
+	}
+	listId, err := strconv.Atoi(list)
+	if err != nil {
+		responses.WriteError(w, http.StatusBadRequest, "invalid list id")
+		return 0, false
+	}
+	if _, err := dataService.GetListRole(r.Context(), listId); err != nil {
+		responses.WriteError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return 0, false
+	}
+	return listId, true
+}
+
+func writeEvent(w io.Writer, event events.Event) {
+	body, _ := json.Marshal(event)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, body)
+}
+
+// lastEventId returns the ID of the last event the client saw, or 0 if it hasn't seen any.
+func lastEventId(r *http.Request) (uint64, error) {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("lastEventId")
+	}
+	if id == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(id, 10, 64)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/events"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+)
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// readEvent reads the next event from a stream, skipping comments and the retry field.
+func readEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Stream ended before the next event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			event.data = value
+		case "":
+			if event.event != "" {
+				return event
+			}
+		}
+	}
+}
+
+// streamEvents opens an event stream as the given user, and returns a reader for it.
+func streamEvents(t *testing.T, server *httptest.Server, userId int, query, lastEventId string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/todoapp/events"+query, nil)
+	req.Header.Set("X-User", strconv.Itoa(userId))
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response. Got: %v %v", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func newEventsServer(t *testing.T, hubs *events.Hubs, service dataService.IDataService) *httptest.Server {
+	handler := EventsHandler(hubs, service, time.Minute)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := strconv.Atoi(r.Header.Get("X-User"))
+		ctx := auth.WithPrincipal(r.Context(), auth.Principal{UserId: userId})
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}))
+	t.Cleanup(func() {
+		hubs.Close()
+		server.Close()
+	})
+	return server
+}
+
+func TestEventsHandler_OnlyStreamsMembersLists(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	server := newEventsServer(t, hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	list, _ := service.CreateTodoList(alice, "Groceries")
+
+	stream := streamEvents(t, server, 2, "", "")
+	service.CreateTodoItem(alice, list.Id, "Hidden")
+	service.AddListMember(alice, list.Id, 2, data.RoleViewer)
+	service.CreateTodoItem(alice, list.Id, "Milk")
+
+	if event := readEvent(t, stream); event.event != string(events.MemberAdded) {
+		t.Errorf("Unexpected first event, changes from before Bob was a member should be skipped. Got: %v", event)
+	}
+	if event := readEvent(t, stream); event.event != string(events.ItemCreated) || !strings.Contains(event.data, `"Milk"`) {
+		t.Errorf("Unexpected second event. Got: %v", event)
+	}
+}
+
+func TestEventsHandler_ResumesFromLastEventId(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	server := newEventsServer(t, hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	list, _ := service.CreateTodoList(alice, "Groceries")
+	service.CreateTodoItem(alice, list.Id, "Milk")
+	service.CreateTodoItem(alice, list.Id, "Eggs")
+
+	stream := streamEvents(t, server, 1, "?list="+strconv.Itoa(list.Id), "2")
+	if event := readEvent(t, stream); event.id != "3" || !strings.Contains(event.data, `"Eggs"`) {
+		t.Errorf("Unexpected replayed event. Got: %v", event)
+	}
+	service.MarkItemAsComplete(alice, list.Id, 0)
+	if event := readEvent(t, stream); event.id != "4" || event.event != string(events.ItemCompleted) {
+		t.Errorf("Unexpected live event. Got: %v", event)
+	}
+}
+
+func TestEventsHandler_ResetsWhenTooFarBehind(t *testing.T) {
+	hubs := events.NewHubs(2)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	server := newEventsServer(t, hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	for _, name := range []string{"Milk", "Eggs", "Bread", "Tea"} {
+		service.CreateTodoItem(alice, 0, name)
+	}
+
+	stream := streamEvents(t, server, 1, "", "1")
+	if event := readEvent(t, stream); event.event != "reset" || event.id != "4" {
+		t.Errorf("Expected a reset event. Got: %v", event)
+	}
+}
+
+func TestEventsHandler_InvalidRequests(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	handler := EventsHandler(hubs, dataService.NewDataService(), time.Minute)
+	testCases := []struct {
+		testName       string
+		path           string
+		lastEventId    string
+		expectedStatus int
+	}{
+		{"Testing an invalid list id", "/todoapp/events?list=abc", "", http.StatusBadRequest},
+		{"Testing a list the caller isn't a member of", "/todoapp/events?list=42", "", http.StatusNotFound},
+		{"Testing an invalid Last-Event-ID", "/todoapp/events", "abc", http.StatusBadRequest},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.lastEventId != "" {
+				req.Header.Set("Last-Event-ID", test.lastEventId)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, test.expectedStatus)
+			}
+		})
+	}
+}
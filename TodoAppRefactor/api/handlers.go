@@ -1,39 +1,63 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"todoApp/api/contracts"
 	"todoApp/api/responses"
+	"todoApp/backup"
+	"todoApp/logging"
+	"todoApp/replication"
 	dataService "todoApp/services"
+	"todoApp/tenants"
 )
 
+// Every command carries the context of the request that issued it, so that the RequestHandler and data service log
+// with the same request ID as the handler, and the time it was queued so that the wait can be measured.
 type CreateCommand struct {
-	Item contracts.CreateContract
-	Resp chan responses.CreateRes
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	Item   contracts.CreateContract
+	Resp   chan responses.CreateRes
 }
 
 type GetCommand struct {
-	Id   int
-	Resp chan responses.GetRes
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	Id     int
+	Resp   chan responses.GetRes
 }
 
 type GetAllCommand struct {
-	Resp chan responses.GetAllRes
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	Resp   chan responses.GetAllRes
 }
 
 type MarkAsCompleteCommand struct {
-	Id   int
-	Resp chan responses.MarkAsCompleteRes
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	Id     int
+	Resp   chan responses.MarkAsCompleteRes
 }
 
 type DeleteCommand struct {
-	Id   int
-	Resp chan responses.DeleteRes
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	Id     int
+	Resp   chan responses.DeleteRes
 }
 
 var (
@@ -54,20 +78,120 @@ func RequestHandler(dataService dataService.IDataService, wg *sync.WaitGroup, st
 	for {
 		select {
 		case cmd := <-createCh:
-			err := dataService.CreateTodoItem(cmd.Item.Name)
+			observeQueueWait("create", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "create", "list", cmd.ListId)
+			if err := authorize(cmd.Ctx, dataService, "create", cmd.ListId); err != nil {
+				cmd.Resp <- responses.CreateRes{Error: err}
+				continue
+			}
+			err := dataService.CreateTodoItem(cmd.Ctx, cmd.ListId, cmd.Item.Name)
+			if err == nil {
+				recordItemChange(cmd.Ctx, dataService, "create", cmd.ListId, nil, lastItem(cmd.Ctx, dataService, cmd.ListId))
+			}
 			cmd.Resp <- responses.CreateRes{Error: err}
 		case cmd := <-getCh:
-			item, err := dataService.GetTodoItem(cmd.Id)
+			observeQueueWait("get", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "get", "list", cmd.ListId, "index", cmd.Id)
+			item, err := dataService.GetTodoItem(cmd.Ctx, cmd.ListId, cmd.Id)
 			cmd.Resp <- responses.GetRes{Item: item, Error: err}
 		case cmd := <-getAllCh:
-			items := dataService.GetAllTodoItems()
-			cmd.Resp <- responses.GetAllRes{Items: items}
+			observeQueueWait("getAll", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "getAll", "list", cmd.ListId)
+			items, err := dataService.GetAllTodoItems(cmd.Ctx, cmd.ListId)
+			cmd.Resp <- responses.GetAllRes{Items: items, Error: err}
 		case cmd := <-markAsCompleteCh:
-			err := dataService.MarkItemAsComplete(cmd.Id)
+			observeQueueWait("markAsComplete", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "markAsComplete", "list", cmd.ListId, "index", cmd.Id)
+			if err := authorize(cmd.Ctx, dataService, "markAsComplete", cmd.ListId); err != nil {
+				cmd.Resp <- responses.MarkAsCompleteRes{Error: err}
+				continue
+			}
+			before := itemAt(cmd.Ctx, dataService, cmd.ListId, cmd.Id)
+			err := dataService.MarkItemAsComplete(cmd.Ctx, cmd.ListId, cmd.Id)
+			if err == nil {
+				recordItemChange(cmd.Ctx, dataService, "markAsComplete", cmd.ListId, before, itemAt(cmd.Ctx, dataService, cmd.ListId, cmd.Id))
+			}
 			cmd.Resp <- responses.MarkAsCompleteRes{Error: err}
 		case cmd := <-deleteCh:
-			err := dataService.DeleteTodoItem(cmd.Id)
+			observeQueueWait("delete", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "delete", "list", cmd.ListId, "index", cmd.Id)
+			if err := authorize(cmd.Ctx, dataService, "delete", cmd.ListId); err != nil {
+				cmd.Resp <- responses.DeleteRes{Error: err}
+				continue
+			}
+			before := itemAt(cmd.Ctx, dataService, cmd.ListId, cmd.Id)
+			err := dataService.DeleteTodoItem(cmd.Ctx, cmd.ListId, cmd.Id)
+			if err == nil {
+				recordItemChange(cmd.Ctx, dataService, "delete", cmd.ListId, before, nil)
+			}
 			cmd.Resp <- responses.DeleteRes{Error: err}
+		case cmd := <-moveCh:
+			observeQueueWait("move", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "move", "list", cmd.ListId, "item", cmd.ItemId)
+			cmd.Resp <- moveCommand(dataService, cmd)
+		case cmd := <-createListCh:
+			observeQueueWait("createList", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "createList")
+			list, err := dataService.CreateTodoList(cmd.Ctx, cmd.List.Name)
+			if err == nil {
+				record(cmd.Ctx, "createList", list.Id, 0, nil, list)
+			}
+			cmd.Resp <- responses.CreateListRes{List: list, Error: err}
+		case cmd := <-getListsCh:
+			observeQueueWait("getLists", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "getLists")
+			lists := dataService.GetTodoLists(cmd.Ctx)
+			cmd.Resp <- responses.GetListsRes{Lists: lists}
+		case cmd := <-getMembersCh:
+			observeQueueWait("getMembers", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "getMembers", "list", cmd.ListId)
+			members, err := dataService.GetListMembers(cmd.Ctx, cmd.ListId)
+			cmd.Resp <- responses.GetMembersRes{Members: members, Error: err}
+		case cmd := <-addMemberCh:
+			observeQueueWait("addMember", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "addMember", "list", cmd.ListId, "user", cmd.UserId)
+			if err := authorize(cmd.Ctx, dataService, "addMember", cmd.ListId); err != nil {
+				cmd.Resp <- responses.AddMemberRes{Error: err}
+				continue
+			}
+			err := dataService.AddListMember(cmd.Ctx, cmd.ListId, cmd.UserId, cmd.Role)
+			if err == nil {
+				recordMemberChange(cmd.Ctx, dataService, "addMember", cmd.ListId, nil, memberOf(cmd.Ctx, dataService, cmd.ListId, cmd.UserId))
+			}
+			cmd.Resp <- responses.AddMemberRes{Error: err}
+		case cmd := <-updateMemberCh:
+			observeQueueWait("updateMember", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "updateMember", "list", cmd.ListId, "user", cmd.UserId)
+			if err := authorize(cmd.Ctx, dataService, "updateMember", cmd.ListId); err != nil {
+				cmd.Resp <- responses.UpdateMemberRes{Error: err}
+				continue
+			}
+			before := memberOf(cmd.Ctx, dataService, cmd.ListId, cmd.UserId)
+			err := dataService.UpdateListMember(cmd.Ctx, cmd.ListId, cmd.UserId, cmd.Role)
+			if err == nil {
+				recordMemberChange(cmd.Ctx, dataService, "updateMember", cmd.ListId, before, memberOf(cmd.Ctx, dataService, cmd.ListId, cmd.UserId))
+			}
+			cmd.Resp <- responses.UpdateMemberRes{Error: err}
+		case cmd := <-removeMemberCh:
+			observeQueueWait("removeMember", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "removeMember", "list", cmd.ListId, "user", cmd.UserId)
+			if err := authorize(cmd.Ctx, dataService, "removeMember", cmd.ListId); err != nil {
+				cmd.Resp <- responses.RemoveMemberRes{Error: err}
+				continue
+			}
+			before := memberOf(cmd.Ctx, dataService, cmd.ListId, cmd.UserId)
+			err := dataService.RemoveListMember(cmd.Ctx, cmd.ListId, cmd.UserId)
+			if err == nil {
+				recordMemberChange(cmd.Ctx, dataService, "removeMember", cmd.ListId, before, nil)
+			}
+			cmd.Resp <- responses.RemoveMemberRes{Error: err}
+		case cmd := <-syncCh:
+			observeQueueWait("sync", cmd.Queued)
+			logging.FromContext(cmd.Ctx).Debug("dispatching command", "command", "sync", "list", cmd.ListId, "mutations", len(cmd.Batch.Mutations))
+			cmd.Resp <- syncCommand(dataService, cmd)
+		case cmd := <-pingCh:
+			observeQueueWait("ping", cmd.Queued)
+			cmd.Resp <- responses.PingRes{}
 		case <-stopCh:
 			return
 		}
@@ -81,13 +205,26 @@ func CreateHandler(dataService dataService.IDataService) http.HandlerFunc {
 			return
 		}
 
+		listId, listErr := listIdFrom(r)
+		if listErr != nil {
+			http.Error(w, listErr.Error(), http.StatusBadRequest)
+			return
+		}
+
 		var todoItemName contracts.CreateContract
-		json.NewDecoder(r.Body).Decode(&todoItemName)
+		if !decodeRequest(w, r, &todoItemName) {
+			return
+		}
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
 		respCh := make(chan responses.CreateRes)
-		createCh <- CreateCommand{Item: todoItemName, Resp: respCh}
+		createCh <- CreateCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, Item: todoItemName, Resp: respCh}
 		resp := <-respCh
 		if resp.Error != nil {
-			http.Error(w, resp.Error.Error(), http.StatusInternalServerError)
+			http.Error(w, resp.Error.Error(), errorStatus(resp.Error, http.StatusInternalServerError))
 			return
 		}
 
@@ -98,13 +235,23 @@ func CreateHandler(dataService dataService.IDataService) http.HandlerFunc {
 
 func GetHandler(dataService dataService.IDataService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		listId, listErr := listIdFrom(r)
+		if listErr != nil {
+			http.Error(w, listErr.Error(), http.StatusBadRequest)
+			return
+		}
 		indexStr := strings.TrimPrefix(r.URL.Path, "/todoapp/item/")
 		if index, convErr := strconv.Atoi(indexStr); convErr == nil {
+			if !queue.acquire() {
+				queue.reject(w)
+				return
+			}
+			defer queue.release()
 			respCh := make(chan responses.GetRes)
-			getCh <- GetCommand{Id: index, Resp: respCh}
+			getCh <- GetCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, Id: index, Resp: respCh}
 			resp := <-respCh
 			if resp.Error != nil {
-				http.Error(w, resp.Error.Error(), http.StatusNotFound)
+				http.Error(w, resp.Error.Error(), errorStatus(resp.Error, http.StatusNotFound))
 				return
 			} else {
 				jsonRes := resp.Item
@@ -118,9 +265,23 @@ func GetHandler(dataService dataService.IDataService) http.HandlerFunc {
 
 func GetAllHandler(dataService dataService.IDataService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		listId, listErr := listIdFrom(r)
+		if listErr != nil {
+			http.Error(w, listErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
 		respCh := make(chan responses.GetAllRes)
-		getAllCh <- GetAllCommand{Resp: respCh}
+		getAllCh <- GetAllCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, Resp: respCh}
 		resp := <-respCh
+		if resp.Error != nil {
+			http.Error(w, resp.Error.Error(), http.StatusNotFound)
+			return
+		}
 
 		jsonRes := resp.Items
 		json.NewEncoder(w).Encode(jsonRes)
@@ -129,14 +290,24 @@ func GetAllHandler(dataService dataService.IDataService) http.HandlerFunc {
 
 func MarkItemAsCompleteHandler(dataService dataService.IDataService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		listId, listErr := listIdFrom(r)
+		if listErr != nil {
+			http.Error(w, listErr.Error(), http.StatusBadRequest)
+			return
+		}
 		indexStr := strings.TrimPrefix(r.URL.Path, "/todoapp/item/")
 		if index, convErr := strconv.Atoi(indexStr); convErr == nil {
+			if !queue.acquire() {
+				queue.reject(w)
+				return
+			}
+			defer queue.release()
 			respCh := make(chan responses.MarkAsCompleteRes)
-			markAsCompleteCh <- MarkAsCompleteCommand{Id: index, Resp: respCh}
+			markAsCompleteCh <- MarkAsCompleteCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, Id: index, Resp: respCh}
 			resp := <-respCh
 
 			if resp.Error != nil {
-				http.Error(w, resp.Error.Error(), http.StatusInternalServerError)
+				http.Error(w, resp.Error.Error(), errorStatus(resp.Error, http.StatusInternalServerError))
 				return
 			}
 
@@ -150,13 +321,23 @@ func MarkItemAsCompleteHandler(dataService dataService.IDataService) http.Handle
 
 func DeleteHandler(dataService dataService.IDataService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		listId, listErr := listIdFrom(r)
+		if listErr != nil {
+			http.Error(w, listErr.Error(), http.StatusBadRequest)
+			return
+		}
 		indexStr := strings.TrimPrefix(r.URL.Path, "/todoapp/item/")
 		if index, convErr := strconv.Atoi(indexStr); convErr == nil {
+			if !queue.acquire() {
+				queue.reject(w)
+				return
+			}
+			defer queue.release()
 			respCh := make(chan responses.DeleteRes)
-			deleteCh <- DeleteCommand{Id: index, Resp: respCh}
+			deleteCh <- DeleteCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, Id: index, Resp: respCh}
 			resp := <-respCh
 			if resp.Error != nil {
-				http.Error(w, resp.Error.Error(), http.StatusInternalServerError)
+				http.Error(w, resp.Error.Error(), errorStatus(resp.Error, http.StatusInternalServerError))
 				return
 			}
 
@@ -167,3 +348,39 @@ func DeleteHandler(dataService dataService.IDataService) http.HandlerFunc {
 		}
 	}
 }
+
+// listIdFrom reads the optional 'list' query parameter. Requests without one act on the caller's default list.
+func listIdFrom(r *http.Request) (int, error) {
+	listStr := r.URL.Query().Get("list")
+	if listStr == "" {
+		return 0, nil
+	}
+	listId, err := strconv.Atoi(listStr)
+	if err != nil || listId < 0 {
+		return 0, errors.New("invalid list parameter")
+	}
+	return listId, nil
+}
+
+// errorStatus picks the status code for an error from the data service, falling back to the given status for errors
+// that don't have a specific one.
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, dataService.ErrListNotFound), errors.Is(err, dataService.ErrMemberNotFound), errors.Is(err, tenants.ErrUnknownTenant),
+		errors.Is(err, dataService.ErrItemIdNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrForbidden), errors.Is(err, tenants.ErrItemQuotaExceeded):
+		return http.StatusForbidden
+	case errors.Is(err, dataService.ErrAlreadyMember), errors.Is(err, dataService.ErrListCreator), errors.Is(err, dataService.ErrAnchorNotFound),
+		errors.Is(err, backup.ErrReplicated):
+		return http.StatusConflict
+	case errors.Is(err, dataService.ErrInvalidRole), errors.Is(err, dataService.ErrInvalidMove):
+		return http.StatusBadRequest
+	case errors.Is(err, replication.ErrNoLeader), errors.Is(err, replication.ErrNotCommitted):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, dataService.ErrNotSaved):
+		return http.StatusInternalServerError
+	default:
+		return fallback
+	}
+}
@@ -12,27 +12,33 @@ import (
 	"testing"
 	"todoApp/api/contracts"
 	apiMocks "todoApp/api/mocks"
+	"todoApp/api/responses"
 	"todoApp/data"
+	"todoApp/validation"
 )
 
-var (
-	mockDataService = apiMocks.NewMockDataService()
-	stopCh          = make(chan struct{})
-)
+var mockDataService = apiMocks.NewMockDataService()
 
-func RequestHandlerSetup() {
+// RequestHandlerSetup starts a RequestHandler for a single test. The returned function stops it and waits for it to
+// exit, so that it can't pick up commands sent by the next test.
+func RequestHandlerSetup() func() {
 	var wg sync.WaitGroup
+	stopCh := make(chan struct{})
 	wg.Add(1)
 	go RequestHandler(mockDataService, &wg, stopCh)
+	return func() {
+		close(stopCh)
+		wg.Wait()
+	}
 }
 
 func TestCreateHandler_ValidName(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	request := "todoapp/item/"
 	newItem := contracts.CreateContract{Name: "Test Item"}
 	newItemJson, _ := json.Marshal(newItem)
 	expectedRes, _ := json.Marshal(contracts.GetContract{Name: newItem.Name, Complete: false})
-	RequestHandlerSetup()
 
 	req, err := http.NewRequest(http.MethodPost, request, bytes.NewBuffer(newItemJson))
 	if err != nil {
@@ -52,12 +58,12 @@ func TestCreateHandler_ValidName(t *testing.T) {
 }
 
 func TestCreateHandler_InvalidName(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	request := "todoapp/item/"
-	newItem := contracts.CreateContract{Name: ""}
+	newItem := contracts.CreateContract{Name: "  "}
 	newItemJson, _ := json.Marshal(newItem)
-	expectedRes := "name cannot be empty"
-	RequestHandlerSetup()
+	expectedField := validation.FieldError{Field: "Name", Message: "is required"}
 
 	req, err := http.NewRequest(http.MethodPost, request, bytes.NewBuffer(newItemJson))
 	if err != nil {
@@ -69,19 +75,59 @@ func TestCreateHandler_InvalidName(t *testing.T) {
 	handler := CreateHandler(mockDataService)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusInternalServerError)
-	} else if strings.TrimSpace(rr.Body.String()) != string(expectedRes) {
-		t.Errorf("handler returned unexpected body. Got: %v Want: %v", strings.TrimSpace(rr.Body.String()), string(expectedRes))
+	var envelope responses.ErrorEnvelope
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusUnprocessableEntity)
+	} else if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil || len(envelope.Error.Fields) != 1 || envelope.Error.Fields[0] != expectedField {
+		t.Errorf("handler returned unexpected field errors. Got: %v Want: %v", envelope.Error.Fields, expectedField)
+	}
+}
+
+func TestCreateHandler_InvalidBody(t *testing.T) {
+	testCases := []struct {
+		testName       string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedField  string
+	}{
+		{"Testing malformed JSON", "application/json", `{"Name":`, http.StatusBadRequest, ""},
+		{"Testing an empty body", "application/json", "", http.StatusBadRequest, ""},
+		{"Testing an unknown field", "application/json", `{"Name":"Milk","Priority":1}`, http.StatusBadRequest, "Priority"},
+		{"Testing a field of the wrong type", "application/json", `{"Name":7}`, http.StatusBadRequest, "Name"},
+		{"Testing trailing data", "application/json", `{"Name":"Milk"}{"Name":"Eggs"}`, http.StatusBadRequest, ""},
+		{"Testing a name that is too long", "application/json", `{"Name":"` + strings.Repeat("a", contracts.MaxItemNameLength+1) + `"}`, http.StatusUnprocessableEntity, "Name"},
+		{"Testing a name with control characters", "application/json", `{"Name":"Milk\u0007"}`, http.StatusUnprocessableEntity, "Name"},
+		{"Testing a name with combining characters", "application/json", `{"Name":"Cafe\u0301"}`, http.StatusUnprocessableEntity, "Name"},
+		{"Testing a body that is too large", "application/json", `{"Name":"` + strings.Repeat("a", int(maxBodyBytes)) + `"}`, http.StatusRequestEntityTooLarge, ""},
+		{"Testing a body that isn't JSON", "text/plain", `Milk`, http.StatusUnsupportedMediaType, ""},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/todoapp/item/", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			rr := httptest.NewRecorder()
+			CreateHandler(mockDataService).ServeHTTP(rr, req)
+
+			var envelope responses.ErrorEnvelope
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, test.expectedStatus)
+			} else if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+				t.Errorf("handler did not return an error envelope: %v", err)
+			} else if test.expectedField != "" && (len(envelope.Error.Fields) != 1 || envelope.Error.Fields[0].Field != test.expectedField) {
+				t.Errorf("handler returned unexpected field errors. Got: %v Want an error for: %v", envelope.Error.Fields, test.expectedField)
+			}
+		})
 	}
 }
 
 func TestGetHandler_ValidRequest(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	request := "/todoapp/item/0"
 	expectedValue := contracts.GetContract{Name: "MockItem", Complete: false}
 	expectedJson, _ := json.Marshal(expectedValue)
-	RequestHandlerSetup()
 
 	req, err := http.NewRequest(http.MethodGet, request, nil)
 	if err != nil {
@@ -100,7 +146,8 @@ func TestGetHandler_ValidRequest(t *testing.T) {
 }
 
 func TestGetHandler_InvalidRequest(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	testcases := []struct {
 		testName       string
 		request        string
@@ -110,7 +157,6 @@ func TestGetHandler_InvalidRequest(t *testing.T) {
 		{"Testing invalid index", "/todoapp/item/10", 404, "item at specified index does not exist"},
 		{"Testing invalid type", "todoapp/item/index", 400, "invalid request parameter type"},
 	}
-	RequestHandlerSetup()
 
 	for _, test := range testcases {
 		t.Run(test.testName, func(t *testing.T) {
@@ -133,7 +179,8 @@ func TestGetHandler_InvalidRequest(t *testing.T) {
 }
 
 func TestGetAllHandler(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	request := "/todoapp/items/"
 	expectedValue := contracts.GetAllContract{TodoItems: []data.TodoItem{
 		{Name: "TodoItem1", Complete: true},
@@ -141,7 +188,6 @@ func TestGetAllHandler(t *testing.T) {
 		{Name: "TodoItem3", Complete: true},
 	}}
 	expectedJson, _ := json.Marshal(expectedValue.TodoItems)
-	RequestHandlerSetup()
 
 	req, err := http.NewRequest(http.MethodGet, request, nil)
 	if err != nil {
@@ -160,9 +206,9 @@ func TestGetAllHandler(t *testing.T) {
 }
 
 func TestMarkItemAsCompleteHandler_ValidRequest(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	request := "/todoapp/item/0"
-	RequestHandlerSetup()
 
 	req, err := http.NewRequest(http.MethodPut, request, nil)
 	if err != nil {
@@ -179,7 +225,8 @@ func TestMarkItemAsCompleteHandler_ValidRequest(t *testing.T) {
 }
 
 func TestMarkItemAsCompleteHandler_InvalidRequest(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	testCases := []struct {
 		testName       string
 		request        string
@@ -189,7 +236,6 @@ func TestMarkItemAsCompleteHandler_InvalidRequest(t *testing.T) {
 		{"Testing invalid index", "/todoapp/item/100", 500, "item at specified index does not exist"},
 		{"Testing invalid request type", "/todoapp/item/index", 400, "invalid request parameter type"},
 	}
-	RequestHandlerSetup()
 
 	for _, test := range testCases {
 		req, err := http.NewRequest(http.MethodPut, test.request, nil)
@@ -210,9 +256,9 @@ func TestMarkItemAsCompleteHandler_InvalidRequest(t *testing.T) {
 }
 
 func TestDeleteHandler_ValidRequest(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	request := "/todoapp/item/0"
-	RequestHandlerSetup()
 
 	req, err := http.NewRequest(http.MethodDelete, request, nil)
 	if err != nil {
@@ -229,7 +275,8 @@ func TestDeleteHandler_ValidRequest(t *testing.T) {
 }
 
 func TestDeleteHandler_InvalidRequest(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	testCases := []struct {
 		testName       string
 		request        string
@@ -239,7 +286,6 @@ func TestDeleteHandler_InvalidRequest(t *testing.T) {
 		{"Testing invalid index", "/todoapp/item/100", 500, "item at specified index does not exist"},
 		{"Testing invalid request type", "/todoapp/item/index", 400, "invalid request parameter type"},
 	}
-	RequestHandlerSetup()
 
 	for _, test := range testCases {
 		req, err := http.NewRequest(http.MethodDelete, test.request, nil)
@@ -260,10 +306,10 @@ func TestDeleteHandler_InvalidRequest(t *testing.T) {
 }
 
 func TestConcurrentAPICalls(t *testing.T) {
-	defer close(stopCh)
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
 	var wg sync.WaitGroup
 	concurrentRequests := 100
-	RequestHandlerSetup()
 
 	for i := 0; i < concurrentRequests; i++ {
 		wg.Add(1)
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/buildInfo"
+	dataService "todoApp/services"
+)
+
+const DefaultReadinessTimeout = 2 * time.Second
+
+// PingCommand makes a round trip through the RequestHandler without touching the data service, to show that the
+// RequestHandler goroutine is still picking up commands.
+type PingCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	Resp   chan responses.PingRes
+}
+
+var pingCh = make(chan PingCommand)
+
+// Ping sends a PingCommand to the RequestHandler and waits for the reply, giving up when ctx is done. Pings do not
+// take a slot in the command queue, so a busy server still reports that its RequestHandler is alive.
+func Ping(ctx context.Context) error {
+	respCh := make(chan responses.PingRes, 1)
+	select {
+	case pingCh <- PingCommand{Ctx: ctx, Queued: time.Now(), Resp: respCh}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case resp := <-respCh:
+		return resp.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(contracts.HealthContract{Status: "ok"})
+	}
+}
+
+// ReadyzHandler reports whether the RequestHandler is responsive and the data store can be read and written. Each
+// check has to finish within the timeout, otherwise it is reported as failed.
+func ReadyzHandler(checker dataService.IHealthChecker, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		checks := map[string]func(context.Context) error{
+			"requestHandler": Ping,
+			"store":          checker.CheckStore,
+		}
+		res := contracts.HealthContract{Status: "ok", Checks: map[string]string{}}
+		for name, check := range checks {
+			if err := runCheck(ctx, check); err != nil {
+				res.Status = "unavailable"
+				res.Checks[name] = err.Error()
+			} else {
+				res.Checks[name] = "ok"
+			}
+		}
+
+		if res.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func runCheck(ctx context.Context, check func(context.Context) error) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- check(ctx) }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func VersionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := buildInfo.Get()
+		json.NewEncoder(w).Encode(contracts.VersionContract{
+			Version:   info.Version,
+			Commit:    info.Commit,
+			BuildTime: info.BuildTime,
+			Modified:  info.Modified,
+			GoVersion: info.GoVersion,
+		})
+	}
+}
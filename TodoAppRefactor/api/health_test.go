@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"todoApp/api/contracts"
+)
+
+func TestHealthzHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	HealthzHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusOK)
+	}
+}
+
+func TestReadyzHandler_Ready(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+
+	rr := httptest.NewRecorder()
+	ReadyzHandler(mockDataService, time.Second).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var res contracts.HealthContract
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusOK)
+	} else if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Errorf("handler returned an invalid body: %s", err.Error())
+	} else if res.Checks["requestHandler"] != "ok" || res.Checks["store"] != "ok" {
+		t.Errorf("handler returned unexpected checks. Got: %v", res.Checks)
+	}
+}
+
+func TestReadyzHandler_RequestHandlerNotRunning(t *testing.T) {
+	rr := httptest.NewRecorder()
+	ReadyzHandler(mockDataService, 20*time.Millisecond).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var res contracts.HealthContract
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusServiceUnavailable)
+	} else if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Errorf("handler returned an invalid body: %s", err.Error())
+	} else if res.Checks["requestHandler"] == "ok" {
+		t.Error("request handler check should have failed")
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	VersionHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	var res contracts.VersionContract
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Errorf("handler returned an invalid body: %s", err.Error())
+	} else if res.Version == "" || res.GoVersion == "" {
+		t.Errorf("handler returned incomplete build information. Got: %+v", res)
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/auth"
+	"todoApp/logging"
+	"todoApp/tenants"
+)
+
+// API keys and tokens are account state rather than todo data, so their handlers work on the stores directly instead of
+// going through the RequestHandler.
+
+// CreateAPIKeyHandler creates an API key for the caller. Bearer tokens can't be used to create one, otherwise a token
+// could be turned into a key that outlives it.
+func CreateAPIKeyHandler(keys *auth.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFrom(r.Context())
+		if principal.Method == auth.MethodToken {
+			responses.WriteError(w, http.StatusForbidden, "bearer tokens can't be used to create API keys")
+			return
+		}
+
+		var newKey contracts.CreateAPIKeyContract
+		if !decodeRequest(w, r, &newKey) {
+			return
+		}
+		var expiresAt time.Time
+		if newKey.ExpiresAt != "" {
+			parsed, _ := time.Parse(time.RFC3339, newKey.ExpiresAt)
+			expiresAt = parsed.UTC()
+		}
+
+		key, secret, err := keys.CreateAPIKey(principal.UserId, tenants.From(r.Context()), newKey.Name, auth.Scope(newKey.Scope), expiresAt)
+		if err != nil {
+			responses.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		logging.FromContext(r.Context()).Info("created api key", "user", principal.UserId, "key", key.Id, "scope", key.Scope, "tenant", key.Tenant)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(contracts.CreatedAPIKeyContract{APIKeyContract: apiKeyContract(key), Key: secret})
+	}
+}
+
+func GetAPIKeysHandler(keys *auth.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFrom(r.Context())
+
+		result := []contracts.APIKeyContract{}
+		for _, key := range keys.GetAPIKeys(principal.UserId) {
+			result = append(result, apiKeyContract(key))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func RevokeAPIKeyHandler(keys *auth.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFrom(r.Context())
+
+		id := r.PathValue("id")
+		if err := keys.RevokeAPIKey(principal.UserId, id); err != nil {
+			if errors.Is(err, auth.ErrAPIKeyNotFound) {
+				responses.WriteError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			responses.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logging.FromContext(r.Context()).Info("revoked api key", "user", principal.UserId, "key", id)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CreateTokenHandler exchanges the caller's session or API key for a short-lived bearer token. The token can't have a
+// wider scope than the credentials used to ask for it and is bound to the tenant it was requested for. Tokens can't be
+// exchanged for new ones, so a token lasts no longer than its TTL, and none can be issued once the session has ended or
+// the key has been revoked.
+func CreateTokenHandler(issuer *auth.TokenIssuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFrom(r.Context())
+		if principal.Method == auth.MethodToken {
+			responses.WriteError(w, http.StatusForbidden, "bearer tokens can't be exchanged for new tokens")
+			return
+		}
+
+		request := contracts.CreateTokenContract{Scope: string(principal.Scope)}
+		if r.ContentLength != 0 && !decodeRequest(w, r, &request) {
+			return
+		}
+		if request.Scope == "" {
+			request.Scope = string(principal.Scope)
+		}
+
+		scope := auth.Scope(request.Scope)
+		if !principal.Scope.Allows(scope) {
+			responses.WriteError(w, http.StatusForbidden, "requested scope is wider than the caller's")
+			return
+		}
+
+		principal.Tenant = tenants.From(r.Context())
+		token, claims, err := issuer.IssueToken(principal, scope, time.Duration(request.TTLSeconds)*time.Second)
+		if err != nil {
+			responses.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(contracts.TokenContract{
+			Token:     token,
+			TokenType: "Bearer",
+			Scope:     string(claims.Scope),
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		})
+	}
+}
+
+func apiKeyContract(key auth.APIKey) contracts.APIKeyContract {
+	contract := contracts.APIKeyContract{
+		Id:      key.Id,
+		Tenant:  key.Tenant,
+		Name:    key.Name,
+		Scope:   string(key.Scope),
+		Created: key.Created,
+		Revoked: key.Revoked,
+	}
+	if !key.ExpiresAt.IsZero() {
+		expiresAt := key.ExpiresAt
+		contract.ExpiresAt = &expiresAt
+	}
+	return contract
+}
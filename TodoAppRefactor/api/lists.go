@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	dataService "todoApp/services"
+)
+
+type CreateListCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	List   contracts.CreateListContract
+	Resp   chan responses.CreateListRes
+}
+
+type GetListsCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	Resp   chan responses.GetListsRes
+}
+
+var (
+	createListCh = make(chan CreateListCommand)
+	getListsCh   = make(chan GetListsCommand)
+)
+
+func CreateListHandler(dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var newList contracts.CreateListContract
+		if !decodeRequest(w, r, &newList) {
+			return
+		}
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
+		respCh := make(chan responses.CreateListRes)
+		createListCh <- CreateListCommand{Ctx: r.Context(), Queued: time.Now(), List: newList, Resp: respCh}
+		resp := <-respCh
+		if resp.Error != nil {
+			http.Error(w, resp.Error.Error(), errorStatus(resp.Error, http.StatusBadRequest))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp.List)
+	}
+}
+
+func GetListsHandler(dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
+		respCh := make(chan responses.GetListsRes)
+		getListsCh <- GetListsCommand{Ctx: r.Context(), Queued: time.Now(), Resp: respCh}
+		resp := <-respCh
+
+		json.NewEncoder(w).Encode(resp.Lists)
+	}
+}
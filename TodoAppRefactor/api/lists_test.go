@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"todoApp/api/contracts"
+	"todoApp/data"
+)
+
+func TestCreateListHandler(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+	testCases := []struct {
+		testName       string
+		name           string
+		expectedStatus int
+	}{
+		{"Testing valid name", "Groceries", http.StatusCreated},
+		{"Testing empty name", "", http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			body, _ := json.Marshal(contracts.CreateListContract{Name: test.name})
+			req, err := http.NewRequest(http.MethodPost, "/todoapp/lists/", bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			CreateListHandler(mockDataService).ServeHTTP(rr, req)
+
+			var list data.TodoList
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, test.expectedStatus)
+			} else if status == http.StatusCreated {
+				if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || list.Name != test.name {
+					t.Errorf("handler returned unexpected body. Got: %v", list)
+				}
+			}
+		})
+	}
+}
+
+func TestGetListsHandler(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+	expectedJson, _ := json.Marshal(mockDataService.GetTodoLists(context.Background()))
+
+	req, err := http.NewRequest(http.MethodGet, "/todoapp/lists/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	GetListsHandler(mockDataService).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusOK)
+	} else if strings.TrimSpace(rr.Body.String()) != string(expectedJson) {
+		t.Errorf("handler returned unexpected body. Got: %v Want: %v", strings.TrimSpace(rr.Body.String()), string(expectedJson))
+	}
+}
+
+func TestGetAllHandler_InvalidListParameter(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+
+	req, err := http.NewRequest(http.MethodGet, "/todoapp/items/?list=abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	GetAllHandler(mockDataService).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusBadRequest)
+	} else if strings.TrimSpace(rr.Body.String()) != "invalid list parameter" {
+		t.Errorf("handler returned unexpected body. Got: %v", strings.TrimSpace(rr.Body.String()))
+	}
+}
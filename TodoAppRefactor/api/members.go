@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/data"
+	"todoApp/users"
+)
+
+type GetMembersCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	Resp   chan responses.GetMembersRes
+}
+
+type AddMemberCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	UserId int
+	Role   data.Role
+	Resp   chan responses.AddMemberRes
+}
+
+type UpdateMemberCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	UserId int
+	Role   data.Role
+	Resp   chan responses.UpdateMemberRes
+}
+
+type RemoveMemberCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	UserId int
+	Resp   chan responses.RemoveMemberRes
+}
+
+var (
+	getMembersCh   = make(chan GetMembersCommand)
+	addMemberCh    = make(chan AddMemberCommand)
+	updateMemberCh = make(chan UpdateMemberCommand)
+	removeMemberCh = make(chan RemoveMemberCommand)
+)
+
+// The member routes are '/todoapp/lists/{id}/members' and '/todoapp/lists/{id}/members/{userId}'. The data service
+// only knows members by ID, so usernames are looked up in the user service.
+
+func GetMembersHandler(userService *users.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, err := pathId(r, "id")
+		if err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid list id")
+			return
+		}
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
+		respCh := make(chan responses.GetMembersRes)
+		getMembersCh <- GetMembersCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, Resp: respCh}
+		resp := <-respCh
+		if resp.Error != nil {
+			responses.WriteError(w, errorStatus(resp.Error, http.StatusInternalServerError), resp.Error.Error())
+			return
+		}
+
+		members := []contracts.MemberContract{}
+		for _, member := range resp.Members {
+			contract := contracts.MemberContract{UserId: member.UserId, Role: string(member.Role)}
+			if user, exists := userService.GetUser(member.UserId); exists {
+				contract.Username = user.Username
+			}
+			members = append(members, contract)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(members)
+	}
+}
+
+// AddMemberHandler shares a list with the user named in the request.
+func AddMemberHandler(userService *users.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, err := pathId(r, "id")
+		if err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid list id")
+			return
+		}
+		var newMember contracts.AddMemberContract
+		if !decodeRequest(w, r, &newMember) {
+			return
+		}
+		user, exists := userService.GetUserByName(newMember.Username)
+		if !exists {
+			responses.WriteError(w, http.StatusNotFound, "user does not exist")
+			return
+		}
+
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
+		respCh := make(chan responses.AddMemberRes)
+		addMemberCh <- AddMemberCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, UserId: user.Id, Role: data.Role(newMember.Role), Resp: respCh}
+		resp := <-respCh
+		if resp.Error != nil {
+			responses.WriteError(w, errorStatus(resp.Error, http.StatusInternalServerError), resp.Error.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(contracts.MemberContract{UserId: user.Id, Username: user.Username, Role: newMember.Role})
+	}
+}
+
+func UpdateMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, listErr := pathId(r, "id")
+		userId, userErr := pathId(r, "userId")
+		if listErr != nil || userErr != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid list or user id")
+			return
+		}
+		var update contracts.UpdateMemberContract
+		if !decodeRequest(w, r, &update) {
+			return
+		}
+
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
+		respCh := make(chan responses.UpdateMemberRes)
+		updateMemberCh <- UpdateMemberCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, UserId: userId, Role: data.Role(update.Role), Resp: respCh}
+		resp := <-respCh
+		if resp.Error != nil {
+			responses.WriteError(w, errorStatus(resp.Error, http.StatusInternalServerError), resp.Error.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func RemoveMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, listErr := pathId(r, "id")
+		userId, userErr := pathId(r, "userId")
+		if listErr != nil || userErr != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid list or user id")
+			return
+		}
+
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
+		respCh := make(chan responses.RemoveMemberRes)
+		removeMemberCh <- RemoveMemberCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, UserId: userId, Resp: respCh}
+		resp := <-respCh
+		if resp.Error != nil {
+			responses.WriteError(w, errorStatus(resp.Error, http.StatusInternalServerError), resp.Error.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// pathId reads a non-negative integer wildcard from the request path.
+func pathId(r *http.Request, name string) (int, error) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || id < 0 {
+		return 0, errors.New("invalid " + name)
+	}
+	return id, nil
+}
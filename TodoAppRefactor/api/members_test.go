@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"todoApp/api/contracts"
+	"todoApp/users"
+)
+
+func TestMain(m *testing.M) {
+	users.UseCheapHashing()
+	os.Exit(m.Run())
+}
+
+func TestGetMembersHandler(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+	userService := users.NewUserService()
+	userService.Register("alice", "password123")
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /todoapp/lists/{id}/members", GetMembersHandler(userService))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todoapp/lists/1/members", nil))
+
+	var members []contracts.MemberContract
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusOK)
+	} else if err := json.NewDecoder(rr.Body).Decode(&members); err != nil || len(members) != 2 || members[0].Username != "alice" {
+		t.Errorf("handler returned unexpected body. Got: %v", members)
+	}
+}
+
+func TestAddMemberHandler(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+	userService := users.NewUserService()
+	userService.Register("bob", "password123")
+
+	testCases := []struct {
+		testName       string
+		path           string
+		member         contracts.AddMemberContract
+		expectedStatus int
+	}{
+		{"Testing sharing an owned list", "/todoapp/lists/1/members", contracts.AddMemberContract{Username: "bob", Role: "editor"}, http.StatusCreated},
+		{"Testing an unknown user", "/todoapp/lists/1/members", contracts.AddMemberContract{Username: "nobody", Role: "editor"}, http.StatusNotFound},
+		{"Testing an unknown role", "/todoapp/lists/1/members", contracts.AddMemberContract{Username: "bob", Role: "admin"}, http.StatusUnprocessableEntity},
+		{"Testing sharing as a viewer", "/todoapp/lists/3/members", contracts.AddMemberContract{Username: "bob", Role: "editor"}, http.StatusForbidden},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /todoapp/lists/{id}/members", AddMemberHandler(userService))
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			body, _ := json.Marshal(test.member)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, test.path, bytes.NewBuffer(body)))
+
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, test.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestUpdateAndRemoveMemberHandlers(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+
+	mux := http.NewServeMux()
+	mux.Handle("PUT /todoapp/lists/{id}/members/{userId}", UpdateMemberHandler())
+	mux.Handle("DELETE /todoapp/lists/{id}/members/{userId}", RemoveMemberHandler())
+	testCases := []struct {
+		testName       string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Testing changing a role", http.MethodPut, "/todoapp/lists/1/members/2", `{"Role":"viewer"}`, http.StatusNoContent},
+		{"Testing changing a role as a viewer", http.MethodPut, "/todoapp/lists/3/members/2", `{"Role":"viewer"}`, http.StatusForbidden},
+		{"Testing removing a member", http.MethodDelete, "/todoapp/lists/1/members/2", "", http.StatusNoContent},
+		{"Testing removing a member as a viewer", http.MethodDelete, "/todoapp/lists/3/members/2", "", http.StatusForbidden},
+		{"Testing an invalid user id", http.MethodDelete, "/todoapp/lists/1/members/bob", "", http.StatusBadRequest},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(test.method, test.path, bytes.NewBufferString(test.body)))
+
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, test.expectedStatus)
+			}
+		})
+	}
+}
+
+// TestAuthorization_ViewerCannotChangeItems checks the item handlers go through the central authorization check.
+func TestAuthorization_ViewerCannotChangeItems(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+
+	testCases := []struct {
+		testName string
+		method   string
+		path     string
+		handler  http.Handler
+	}{
+		{"Testing creating an item", http.MethodPost, "/todoapp/item/?list=3", CreateHandler(mockDataService)},
+		{"Testing marking an item as complete", http.MethodPut, "/todoapp/item/0?list=3", MarkItemAsCompleteHandler(mockDataService)},
+		{"Testing deleting an item", http.MethodDelete, "/todoapp/item/0?list=3", DeleteHandler(mockDataService)},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			test.handler.ServeHTTP(rr, httptest.NewRequest(test.method, test.path, bytes.NewBufferString(`{"Name":"Item"}`)))
+
+			if status := rr.Code; status != http.StatusForbidden {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusForbidden)
+			}
+		})
+	}
+}
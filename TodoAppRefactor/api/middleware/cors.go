@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"todoApp/api/responses"
+)
+
+// CORSConfig says which other origins browsers may let call the API, and how.
+type CORSConfig struct {
+	// AllowedOrigins are exact origins such as 'https://app.example.com', origins with a wildcard subdomain such as
+	// 'https://*.example.com', or '*' for any origin. No origins turns CORS off.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts on other origins are allowed to read.
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies with cross-origin requests. It can't be used with the '*' origin.
+	AllowCredentials bool
+	MaxAge           time.Duration
+	// PathPrefix limits CORS to requests under the path, other requests are passed on untouched.
+	PathPrefix string
+	// RouteMethods, if set, returns the methods that have a route for the request's path, so preflights are only
+	// allowed for methods that will be served.
+	RouteMethods func(r *http.Request) []string
+}
+
+// CORS adds the headers browsers need to let scripts on the allowed origins call the API, and answers preflight
+// requests itself so they never reach the routes. Preflights from origins that aren't allowed, or for methods or headers
+// that aren't, are rejected with a 403. Other requests from origins that aren't allowed are served without CORS headers,
+// so the browser doesn't let the calling script see the response.
+func CORS(config CORSConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(config.AllowedOrigins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !strings.HasPrefix(r.URL.Path, config.PathPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			allowed := config.AllowsOrigin(origin)
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+				config.preflight(w, r, origin, allowed)
+				return
+			}
+
+			if allowed {
+				config.setOrigin(header, origin)
+				if len(config.ExposedHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (config CORSConfig) preflight(w http.ResponseWriter, r *http.Request, origin string, allowed bool) {
+	if !allowed {
+		responses.WriteError(w, http.StatusForbidden, "origin not allowed")
+		return
+	}
+
+	methods := config.AllowedMethods
+	if config.RouteMethods != nil {
+		routeMethods := config.RouteMethods(r)
+		methods = slices.DeleteFunc(slices.Clone(methods), func(method string) bool {
+			return !slices.Contains(routeMethods, method)
+		})
+	}
+	if !slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+		responses.WriteError(w, http.StatusForbidden, "method not allowed")
+		return
+	}
+	for _, requested := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		requested = strings.TrimSpace(requested)
+		if requested != "" && !slices.ContainsFunc(config.AllowedHeaders, func(allowed string) bool { return strings.EqualFold(allowed, requested) }) {
+			responses.WriteError(w, http.StatusForbidden, "header not allowed: "+requested)
+			return
+		}
+	}
+
+	header := w.Header()
+	config.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(config.AllowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(config.AllowedHeaders, ", "))
+	}
+	if config.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (config CORSConfig) setOrigin(header http.Header, origin string) {
+	if slices.Contains(config.AllowedOrigins, "*") && !config.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// AllowsOrigin reports whether scripts on origin may call the API.
+func (config CORSConfig) AllowsOrigin(origin string) bool {
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) || matchesWildcard(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWildcard matches an origin against a pattern with a '*' in place of one or more subdomains, e.g.
+// 'https://*.example.com' matches 'https://app.example.com' and 'https://a.b.example.com' but not
+// 'https://example.com' or 'https://evil.com/.example.com'.
+func matchesWildcard(pattern, origin string) bool {
+	prefix, suffix, found := strings.Cut(strings.ToLower(pattern), "*")
+	if !found {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(subdomain, "/:@?#*") && !strings.HasPrefix(subdomain, ".") && !strings.HasSuffix(subdomain, ".")
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"todoApp/metrics"
+)
+
+var (
+	httpRequests = metrics.Default.NewCounterVec("todoapp_http_requests_total",
+		"Number of HTTP requests served, by method, route and status.", "method", "route", "status")
+	httpRequestDuration = metrics.Default.NewHistogramVec("todoapp_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by method, route and status.", metrics.DefaultBuckets, "method", "route", "status")
+)
+
+// Metrics counts requests and records their latency, labelled by the route rather than the raw path so that the
+// number of series stays bounded.
+func Metrics() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := Wrap(w)
+			next.ServeHTTP(rec, r)
+
+			route, status := Route(r), strconv.Itoa(rec.Status())
+			httpRequests.With(r.Method, route, status).Inc()
+			httpRequestDuration.With(r.Method, route, status).Observe(time.Since(start).Seconds())
+		})
+	}
+}
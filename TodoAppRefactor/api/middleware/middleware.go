@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+	"todoApp/api/responses"
+	"todoApp/logging"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in the given middleware. The first middleware in the list is the outermost, so it sees the request
+// first and the response last.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+type requestInfoKey struct{}
+
+// RequestInfo is shared between the middleware handling a request. It is filled in as the request passes through
+// the chain so that outer middleware can see what happened further in.
+type RequestInfo struct {
+	Route string
+}
+
+// Info returns the RequestInfo for the request that ctx belongs to, or nil if the request did not pass through RequestID.
+func Info(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// RequestID makes sure every request has an ID. A valid ID sent by the client in the X-Request-ID header is kept,
+// otherwise a new one is generated. The ID is echoed in the response and attached to the request context so that
+// everything handling the request logs with it. This should be the outermost middleware.
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := logging.WithRequestID(r.Context(), id)
+			ctx = context.WithValue(ctx, requestInfoKey{}, &RequestInfo{})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Router records the pattern matched by mux so that outer middleware can report on the route rather than the raw
+// path. It should wrap the mux directly, with nothing in between.
+func Router(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if info := Info(r.Context()); info != nil {
+				info.Route = r.Pattern
+			}
+		}()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Recover turns a panic in a handler into a 500 response using the error envelope, logging the panic and stack.
+func Recover() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := Wrap(w)
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				logging.FromContext(r.Context()).Error("panic serving request", "error", err, "stack", string(debug.Stack()))
+				if !rec.WroteHeader() {
+					responses.WriteError(rec, http.StatusInternalServerError, "internal server error")
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// AccessLog writes one log line per request once it has been served.
+func AccessLog() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := Wrap(w)
+			next.ServeHTTP(rec, r)
+
+			logging.FromContext(r.Context()).LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("route", Route(r)),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.Status()),
+				slog.Duration("latency", time.Since(start)),
+				slog.Int64("bytes", rec.BytesWritten()),
+				slog.String("remote", r.RemoteAddr),
+			)
+		})
+	}
+}
+
+// Route returns the pattern that served r, or "unmatched" if no route has been recorded.
+func Route(r *http.Request) string {
+	if info := Info(r.Context()); info != nil && info.Route != "" {
+		return info.Route
+	}
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	return "unmatched"
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ResponseRecorder wraps a ResponseWriter to keep track of the status code and number of bytes written.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// Wrap returns w as a ResponseRecorder, reusing it if w already is one.
+func Wrap(w http.ResponseWriter) *ResponseRecorder {
+	if rec, ok := w.(*ResponseRecorder); ok {
+		return rec
+	}
+	return &ResponseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rec *ResponseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *ResponseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *ResponseRecorder) Status() int {
+	return rec.status
+}
+
+func (rec *ResponseRecorder) BytesWritten() int64 {
+	return rec.bytes
+}
+
+func (rec *ResponseRecorder) WroteHeader() bool {
+	return rec.wroteHeader
+}
+
+func (rec *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *ResponseRecorder) Flush() {
+	rec.wroteHeader = true
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := rec.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
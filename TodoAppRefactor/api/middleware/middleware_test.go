@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"todoApp/api/responses"
+	"todoApp/logging"
+)
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestChain_Order(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), mark("first"), mark("second"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := strings.Join(order, ","); got != "first,second,handler" {
+		t.Errorf("middleware ran in the wrong order. Got: %v Want: %v", got, "first,second,handler")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	testCases := []struct {
+		testName   string
+		incomingID string
+		keepID     bool
+	}{
+		{"Testing with a valid incoming ID", "abc-123", true},
+		{"Testing without an incoming ID", "", false},
+		{"Testing with an invalid incoming ID", "has spaces in it", false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			var seenID string
+			handler := RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seenID = logging.RequestID(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.incomingID != "" {
+				req.Header.Set(RequestIDHeader, test.incomingID)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if seenID == "" {
+				t.Fatal("request context did not carry a request ID")
+			} else if rr.Header().Get(RequestIDHeader) != seenID {
+				t.Errorf("response header does not match the context. Got: %v Want: %v", rr.Header().Get(RequestIDHeader), seenID)
+			} else if test.keepID && seenID != test.incomingID {
+				t.Errorf("incoming request ID was not kept. Got: %v Want: %v", seenID, test.incomingID)
+			} else if !test.keepID && seenID == test.incomingID {
+				t.Error("incoming request ID should have been replaced")
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	captureLogs(t)
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	}), RequestID(), Recover())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var envelope responses.ErrorEnvelope
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusInternalServerError)
+	} else if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+		t.Errorf("handler did not return an error envelope: %s", err.Error())
+	} else if envelope.Error.Status != http.StatusInternalServerError {
+		t.Errorf("error envelope has wrong status. Got: %v Want: %v", envelope.Error.Status, http.StatusInternalServerError)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /todoapp/item/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	})
+	handler := Chain(Router(mux), RequestID(), AccessLog(), Recover())
+
+	req := httptest.NewRequest(http.MethodGet, "/todoapp/item/3", nil)
+	req.Header.Set(RequestIDHeader, "log-test")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line struct {
+		Msg       string
+		Method    string
+		Route     string
+		Status    int
+		Bytes     int
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("access log line is not valid JSON: %s", err.Error())
+	}
+
+	if line.Msg != "request" || line.Method != http.MethodGet || line.Route != "GET /todoapp/item/{id}" ||
+		line.Status != http.StatusTeapot || line.Bytes != 5 || line.RequestID != "log-test" {
+		t.Errorf("access log line has unexpected values. Got: %s", logs.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /todoapp/item/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := Chain(Router(mux), RequestID(), Metrics())
+
+	counter := httpRequests.With(http.MethodDelete, "DELETE /todoapp/item/", "404")
+	before := counter.Value()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/todoapp/item/7", nil))
+
+	if got := counter.Value() - before; got != 1 {
+		t.Errorf("request was not counted against its route. Got: %v Want: %v", got, 1)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), SecurityHeaders())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := map[string]string{
+		"Content-Security-Policy": ContentSecurityPolicy,
+		"X-Content-Type-Options":  "nosniff",
+		"Referrer-Policy":         "strict-origin-when-cross-origin",
+		"X-Frame-Options":         "DENY",
+	}
+	for header, value := range expected {
+		if got := rr.Header().Get(header); got != value {
+			t.Errorf("Unexpected %s header. Got: %q, Expected: %q", header, got, value)
+		}
+	}
+	if !strings.Contains(rr.Header().Get("Content-Security-Policy"), "frame-ancestors 'none'") {
+		t.Error("content security policy allows framing")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	config := CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.todo.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{RequestIDHeader},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+		PathPrefix:       "/todoapp/",
+		RouteMethods: func(r *http.Request) []string {
+			return []string{http.MethodGet, http.MethodPost}
+		},
+	}
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), CORS(config))
+
+	testCases := []struct {
+		testName        string
+		method          string
+		path            string
+		origin          string
+		requestMethod   string
+		requestHeaders  string
+		expectedStatus  int
+		expectedOrigin  string
+		expectedMethods string
+	}{
+		{"Testing a request from an allowed origin", http.MethodGet, "/todoapp/items/", "https://app.example.com", "", "", http.StatusTeapot, "https://app.example.com", ""},
+		{"Testing a request from a wildcard subdomain", http.MethodGet, "/todoapp/items/", "https://team-a.todo.example.com", "", "", http.StatusTeapot, "https://team-a.todo.example.com", ""},
+		{"Testing a request from another origin", http.MethodGet, "/todoapp/items/", "https://evil.example.com", "", "", http.StatusTeapot, "", ""},
+		{"Testing a request outside the path prefix", http.MethodGet, "/login", "https://app.example.com", "", "", http.StatusTeapot, "", ""},
+		{"Testing a preflight", http.MethodOptions, "/todoapp/item/", "https://app.example.com", http.MethodPost, "content-type, authorization", http.StatusNoContent, "https://app.example.com", "GET, POST"},
+		{"Testing a preflight from another origin", http.MethodOptions, "/todoapp/item/", "https://evil.example.com", http.MethodPost, "", http.StatusForbidden, "", ""},
+		{"Testing a preflight for a method without a route", http.MethodOptions, "/todoapp/item/", "https://app.example.com", http.MethodDelete, "", http.StatusForbidden, "", ""},
+		{"Testing a preflight for a method that isn't allowed", http.MethodOptions, "/todoapp/item/", "https://app.example.com", http.MethodPut, "", http.StatusForbidden, "", ""},
+		{"Testing a preflight for a header that isn't allowed", http.MethodOptions, "/todoapp/item/", "https://app.example.com", http.MethodPost, "X-Secret", http.StatusForbidden, "", ""},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.Header.Set("Origin", test.origin)
+			if test.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", test.requestMethod)
+			}
+			if test.requestHeaders != "" {
+				req.Header.Set("Access-Control-Request-Headers", test.requestHeaders)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, test.expectedStatus)
+			}
+			if origin := rr.Header().Get("Access-Control-Allow-Origin"); origin != test.expectedOrigin {
+				t.Errorf("Unexpected allowed origin. Got: %q, Expected: %q", origin, test.expectedOrigin)
+			}
+			if methods := rr.Header().Get("Access-Control-Allow-Methods"); methods != test.expectedMethods {
+				t.Errorf("Unexpected allowed methods. Got: %q, Expected: %q", methods, test.expectedMethods)
+			}
+			if test.expectedOrigin != "" && rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+				t.Error("credentials were not allowed")
+			}
+		})
+	}
+}
+
+func TestCORS_AnyOrigin(t *testing.T) {
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), CORS(CORSConfig{AllowedOrigins: []string{"*"}}))
+
+	req := httptest.NewRequest(http.MethodGet, "/todoapp/items/", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if origin := rr.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Unexpected allowed origin. Got: %q, Expected: %q", origin, "*")
+	}
+}
+
+func TestMatchesWildcard(t *testing.T) {
+	testCases := []struct {
+		origin   string
+		expected bool
+	}{
+		{"https://app.example.com", true},
+		{"https://a.b.example.com", true},
+		{"https://APP.Example.com", true},
+		{"https://example.com", false},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://evil.com/.example.com", false},
+		{"https://user@app.example.com", false},
+		{"https://.example.com", false},
+	}
+
+	for _, test := range testCases {
+		if got := matchesWildcard("https://*.example.com", test.origin); got != test.expected {
+			t.Errorf("Unexpected match for %s. Got: %v, Expected: %v", test.origin, got, test.expected)
+		}
+	}
+}
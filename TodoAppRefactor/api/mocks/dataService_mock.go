@@ -1,8 +1,10 @@
 package apiMocks
 
 import (
+	"context"
 	"errors"
 	"todoApp/data"
+	services "todoApp/services"
 	"todoApp/utils/stringUtils"
 )
 
@@ -12,7 +14,7 @@ func NewMockDataService() *mockDataService {
 	return &mockDataService{}
 }
 
-func (dataService *mockDataService) CreateTodoItem(name string) error {
+func (dataService *mockDataService) CreateTodoItem(ctx context.Context, listId int, name string) error {
 	if stringUtils.IsEmptyOrWhitespace(name) {
 		return errors.New("name cannot be empty")
 	} else {
@@ -20,7 +22,7 @@ func (dataService *mockDataService) CreateTodoItem(name string) error {
 	}
 }
 
-func (dataService *mockDataService) GetTodoItem(index int) (data.TodoItem, error) {
+func (dataService *mockDataService) GetTodoItem(ctx context.Context, listId int, index int) (data.TodoItem, error) {
 	switch index {
 	case 0:
 		todoItem := data.TodoItem{Name: "MockItem", Complete: false}
@@ -30,15 +32,15 @@ func (dataService *mockDataService) GetTodoItem(index int) (data.TodoItem, error
 	}
 }
 
-func (dataService *mockDataService) GetAllTodoItems() []data.TodoItem {
+func (dataService *mockDataService) GetAllTodoItems(ctx context.Context, listId int) ([]data.TodoItem, error) {
 	return []data.TodoItem{
 		{Name: "TodoItem1", Complete: true},
 		{Name: "TodoItem2", Complete: false},
 		{Name: "TodoItem3", Complete: true},
-	}
+	}, nil
 }
 
-func (dataService *mockDataService) MarkItemAsComplete(index int) error {
+func (dataService *mockDataService) MarkItemAsComplete(ctx context.Context, listId int, index int) error {
 	switch index {
 	case 0:
 		return nil
@@ -47,7 +49,7 @@ func (dataService *mockDataService) MarkItemAsComplete(index int) error {
 	}
 }
 
-func (dataService *mockDataService) DeleteTodoItem(index int) error {
+func (dataService *mockDataService) DeleteTodoItem(ctx context.Context, listId int, index int) error {
 	switch index {
 	case 0:
 		return nil
@@ -55,3 +57,93 @@ func (dataService *mockDataService) DeleteTodoItem(index int) error {
 		return errors.New("item at specified index does not exist")
 	}
 }
+
+func (dataService *mockDataService) CreateTodoList(ctx context.Context, name string) (data.TodoList, error) {
+	if stringUtils.IsEmptyOrWhitespace(name) {
+		return data.TodoList{}, errors.New("name cannot be empty")
+	} else {
+		return data.TodoList{Id: 2, Name: name}, nil
+	}
+}
+
+func (dataService *mockDataService) GetTodoLists(ctx context.Context) []data.TodoList {
+	return []data.TodoList{
+		{Id: 1, Name: "My List"},
+		{Id: 2, Name: "MockList"},
+	}
+}
+
+func (dataService *mockDataService) GetDefaultListId(ctx context.Context) (int, error) {
+	return 1, nil
+}
+
+func (dataService *mockDataService) CheckStore(ctx context.Context) error {
+	return nil
+}
+
+// GetListRole makes the caller a viewer of list 3 and an owner of every other list.
+func (dataService *mockDataService) GetListRole(ctx context.Context, listId int) (data.Role, error) {
+	switch listId {
+	case 3:
+		return data.RoleViewer, nil
+	default:
+		return data.RoleOwner, nil
+	}
+}
+
+func (dataService *mockDataService) GetListMembers(ctx context.Context, listId int) ([]data.Member, error) {
+	return []data.Member{
+		{UserId: 1, Role: data.RoleOwner},
+		{UserId: 2, Role: data.RoleViewer},
+	}, nil
+}
+
+func (dataService *mockDataService) AddListMember(ctx context.Context, listId int, userId int, role data.Role) error {
+	if !role.Valid() {
+		return errors.New("role must be 'owner', 'editor' or 'viewer'")
+	}
+	return nil
+}
+
+func (dataService *mockDataService) UpdateListMember(ctx context.Context, listId int, userId int, role data.Role) error {
+	if !role.Valid() {
+		return errors.New("role must be 'owner', 'editor' or 'viewer'")
+	}
+	return nil
+}
+
+func (dataService *mockDataService) RemoveListMember(ctx context.Context, listId int, userId int) error {
+	return nil
+}
+
+// Sync reports every update as superseded by a concurrent change, and sends back the whole list.
+func (dataService *mockDataService) Sync(ctx context.Context, listId int, batch data.SyncBatch) (data.SyncResult, error) {
+	result := data.SyncResult{Token: 1, Reset: true, Created: map[string]int{}, Conflicts: []data.Conflict{}}
+	for _, mutation := range batch.Mutations {
+		switch mutation.Op {
+		case data.SyncCreate:
+			result.Created[mutation.Ref] = 4
+		case data.SyncUpdate:
+			result.Conflicts = append(result.Conflicts, data.Conflict{Seq: mutation.Seq, ItemId: mutation.ItemId, Reason: data.ConflictSuperseded})
+		}
+	}
+	items, _ := dataService.GetAllTodoItems(ctx, listId)
+	for index, item := range items {
+		result.Items = append(result.Items, data.SyncedItem{TodoItem: item, Index: index})
+	}
+	return result, nil
+}
+
+// MoveTodoItem knows of items 1 to 3 in every list, and moves them to the start.
+func (dataService *mockDataService) MoveTodoItem(ctx context.Context, listId int, itemId int, after int, before int) (int, error) {
+	switch {
+	case after == 0 && before == 0 || after == itemId || before == itemId:
+		return 0, services.ErrInvalidMove
+	case itemId < 1 || itemId > 3:
+		return 0, services.ErrItemIdNotFound
+	case after > 3 || before > 3:
+		return 0, services.ErrAnchorNotFound
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/data"
+	dataService "todoApp/services"
+)
+
+type MoveCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	ItemId int
+	After  int
+	Before int
+	Resp   chan responses.MoveRes
+}
+
+var moveCh = make(chan MoveCommand)
+
+// placement is where an item was before and after a move, as recorded in the audit log.
+type placement struct {
+	Index int
+}
+
+// MoveHandler is the route items are reordered with, 'POST /todoapp/item/{id}/move'. Unlike the other item routes the
+// item is named by its ID, as are the items it is moved after or before, so that a move made from a page that is out of
+// date still puts it where the user dropped it. Like them it takes the 'list' query parameter.
+func MoveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, listErr := listIdFrom(r)
+		if listErr != nil {
+			http.Error(w, listErr.Error(), http.StatusBadRequest)
+			return
+		}
+		itemId, err := pathId(r, "id")
+		if err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid item id")
+			return
+		}
+		var request contracts.MoveContract
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
+		respCh := make(chan responses.MoveRes)
+		moveCh <- MoveCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, ItemId: itemId, After: request.After, Before: request.Before, Resp: respCh}
+		resp := <-respCh
+		if resp.Error != nil {
+			responses.WriteError(w, errorStatus(resp.Error, http.StatusInternalServerError), resp.Error.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contracts.MovedContract{Id: itemId, Index: resp.Index})
+	}
+}
+
+// moveCommand carries out a move on the RequestHandler goroutine and audits it.
+func moveCommand(dataService dataService.IDataService, cmd MoveCommand) responses.MoveRes {
+	if err := authorize(cmd.Ctx, dataService, "move", cmd.ListId); err != nil {
+		return responses.MoveRes{Error: err}
+	}
+	items, _ := dataService.GetAllTodoItems(cmd.Ctx, cmd.ListId)
+	from := slices.IndexFunc(items, func(item data.TodoItem) bool { return item.Id == cmd.ItemId })
+	to, err := dataService.MoveTodoItem(cmd.Ctx, cmd.ListId, cmd.ItemId, cmd.After, cmd.Before)
+	if err != nil {
+		return responses.MoveRes{Error: err}
+	}
+	record(cmd.Ctx, "move", resolveListId(cmd.Ctx, dataService, cmd.ListId), cmd.ItemId, placement{Index: from}, placement{Index: to})
+	return responses.MoveRes{Index: to}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"todoApp/api/contracts"
+)
+
+func TestMoveHandler(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /todoapp/item/{id}/move", MoveHandler())
+	testCases := []struct {
+		testName       string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Testing moving an item", "/todoapp/item/2/move", `{"Before":1}`, http.StatusOK},
+		{"Testing an item that doesn't exist", "/todoapp/item/9/move", `{"After":1}`, http.StatusNotFound},
+		{"Testing an anchor that doesn't exist", "/todoapp/item/2/move", `{"After":9}`, http.StatusConflict},
+		{"Testing moving an item next to itself", "/todoapp/item/2/move", `{"After":2}`, http.StatusBadRequest},
+		{"Testing without an anchor", "/todoapp/item/2/move", `{}`, http.StatusUnprocessableEntity},
+		{"Testing a negative anchor", "/todoapp/item/2/move", `{"Before":-1}`, http.StatusUnprocessableEntity},
+		{"Testing an invalid item id", "/todoapp/item/milk/move", `{"After":1}`, http.StatusBadRequest},
+		{"Testing moving as a viewer", "/todoapp/item/2/move?list=3", `{"After":1}`, http.StatusForbidden},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, test.expectedStatus)
+			}
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+			var moved contracts.MovedContract
+			if err := json.NewDecoder(rr.Body).Decode(&moved); err != nil || moved.Id != 2 || moved.Index != 0 {
+				t.Errorf("handler returned unexpected body. Got: %+v", moved)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/metrics"
+)
+
+const (
+	DefaultQueueDepth = 256
+	DefaultRetryAfter = time.Second
+)
+
+// The command queue limits how many handlers can be waiting on the RequestHandler at once. A handler must take a slot
+// before submitting its command and gives it back once the response has been received. When every slot is taken the
+// request is shed straight away rather than parking another goroutine on the command channels.
+type commandQueue struct {
+	slots      chan struct{}
+	retryAfter time.Duration
+	rejected   atomic.Uint64
+}
+
+var queue = newCommandQueue(DefaultQueueDepth, DefaultRetryAfter)
+
+func newCommandQueue(depth int, retryAfter time.Duration) *commandQueue {
+	if depth < 1 {
+		depth = 1
+	}
+	return &commandQueue{slots: make(chan struct{}, depth), retryAfter: retryAfter}
+}
+
+// ConfigureQueue replaces the command queue. It must be called before any handlers start serving requests.
+func ConfigureQueue(depth int, retryAfter time.Duration) {
+	queue = newCommandQueue(depth, retryAfter)
+}
+
+// QueueDepth returns the number of commands currently waiting on or being processed by the RequestHandler.
+func QueueDepth() int {
+	return len(queue.slots)
+}
+
+// QueueCapacity returns the maximum number of commands allowed in the queue.
+func QueueCapacity() int {
+	return cap(queue.slots)
+}
+
+// RejectedRequests returns the number of requests shed because the queue was full.
+func RejectedRequests() uint64 {
+	return queue.rejected.Load()
+}
+
+func (q *commandQueue) acquire() bool {
+	select {
+	case q.slots <- struct{}{}:
+		return true
+	default:
+		q.rejected.Add(1)
+		return false
+	}
+}
+
+func (q *commandQueue) release() {
+	<-q.slots
+}
+
+func (q *commandQueue) reject(w http.ResponseWriter) {
+	seconds := int(q.retryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	responses.WriteError(w, http.StatusServiceUnavailable, "server is busy, please retry later")
+}
+
+func QueueMetricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(contracts.QueueMetricsContract{
+			Depth:    QueueDepth(),
+			Capacity: QueueCapacity(),
+			Rejected: RejectedRequests(),
+		})
+	}
+}
+
+var queueWait = metrics.Default.NewHistogramVec("todoapp_queue_wait_seconds",
+	"Time commands spend waiting in the queue before the RequestHandler picks them up.", metrics.DefaultBuckets, "command")
+
+func init() {
+	metrics.Default.NewGaugeFunc("todoapp_queue_depth", "Number of commands currently in the command queue.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(QueueDepth())}}
+	})
+	metrics.Default.NewGaugeFunc("todoapp_queue_capacity", "Maximum number of commands allowed in the command queue.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(QueueCapacity())}}
+	})
+	metrics.Default.NewCounterFunc("todoapp_queue_rejected_total", "Number of requests rejected because the command queue was full.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(RejectedRequests())}}
+	})
+}
+
+func observeQueueWait(command string, queued time.Time) {
+	queueWait.With(command).Observe(time.Since(queued).Seconds())
+}
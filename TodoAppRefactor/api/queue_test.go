@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+)
+
+func TestQueue_RejectsWhenFull(t *testing.T) {
+	ConfigureQueue(1, 2*time.Second)
+	defer ConfigureQueue(DefaultQueueDepth, DefaultRetryAfter)
+	rejectedBefore := RejectedRequests()
+
+	// With no RequestHandler running the first request holds the only slot until one is started
+	var blocked sync.WaitGroup
+	blocked.Add(1)
+	go func() {
+		defer blocked.Done()
+		req, _ := http.NewRequest(http.MethodGet, "/todoapp/items/", nil)
+		GetAllHandler(mockDataService).ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	for QueueDepth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/todoapp/items/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	GetAllHandler(mockDataService).ServeHTTP(rr, req)
+
+	var envelope responses.ErrorEnvelope
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusServiceUnavailable)
+	} else if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("handler returned wrong Retry-After header. Got: %v Want: %v", retryAfter, "2")
+	} else if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+		t.Errorf("handler did not return an error envelope: %s", err.Error())
+	} else if envelope.Error.Status != http.StatusServiceUnavailable {
+		t.Errorf("error envelope has wrong status. Got: %v Want: %v", envelope.Error.Status, http.StatusServiceUnavailable)
+	}
+
+	if rejected := RejectedRequests() - rejectedBefore; rejected != 1 {
+		t.Errorf("unexpected number of rejected requests. Got: %v Want: %v", rejected, 1)
+	}
+
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+	blocked.Wait()
+
+	if depth := QueueDepth(); depth != 0 {
+		t.Errorf("queue was not drained. Got depth: %v Want: %v", depth, 0)
+	}
+}
+
+func TestQueueMetricsHandler(t *testing.T) {
+	ConfigureQueue(8, DefaultRetryAfter)
+	defer ConfigureQueue(DefaultQueueDepth, DefaultRetryAfter)
+
+	req, err := http.NewRequest(http.MethodGet, "/todoapp/queue", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	QueueMetricsHandler().ServeHTTP(rr, req)
+
+	var metrics contracts.QueueMetricsContract
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusOK)
+	} else if err := json.NewDecoder(rr.Body).Decode(&metrics); err != nil {
+		t.Errorf("handler returned an invalid body: %s", err.Error())
+	} else if metrics.Capacity != 8 || metrics.Depth != 0 {
+		t.Errorf("handler returned unexpected metrics. Got: %+v", metrics)
+	}
+}
@@ -1,6 +1,11 @@
 package responses
 
-import "todoApp/data"
+import (
+	"encoding/json"
+	"net/http"
+	"todoApp/data"
+	"todoApp/validation"
+)
 
 type CreateRes struct {
 	Error error
@@ -13,6 +18,7 @@ type GetRes struct {
 
 type GetAllRes struct {
 	Items []data.TodoItem
+	Error error
 }
 
 type MarkAsCompleteRes struct {
@@ -22,3 +28,67 @@ type MarkAsCompleteRes struct {
 type DeleteRes struct {
 	Error error
 }
+
+type MoveRes struct {
+	Index int
+	Error error
+}
+
+type ErrorEnvelope struct {
+	Error ErrorBody
+}
+
+type ErrorBody struct {
+	Status  int
+	Message string
+	// Fields lists every rule the request broke, when it was rejected by validation.
+	Fields validation.Errors `json:",omitempty"`
+}
+
+// WriteError writes an error response using the standard JSON error envelope.
+func WriteError(w http.ResponseWriter, status int, message string) {
+	WriteFieldErrors(w, status, message, nil)
+}
+
+// WriteFieldErrors writes an error response using the standard JSON error envelope, listing the fields that were invalid.
+func WriteFieldErrors(w http.ResponseWriter, status int, message string, fields validation.Errors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorEnvelope{Error: ErrorBody{Status: status, Message: message, Fields: fields}})
+}
+
+type CreateListRes struct {
+	List  data.TodoList
+	Error error
+}
+
+type GetListsRes struct {
+	Lists []data.TodoList
+}
+
+type PingRes struct {
+	Error error
+}
+
+type GetMembersRes struct {
+	Members []data.Member
+	Error   error
+}
+
+type AddMemberRes struct {
+	Error error
+}
+
+type UpdateMemberRes struct {
+	Error error
+}
+
+type RemoveMemberRes struct {
+	Error error
+}
+
+type SyncRes struct {
+	Result data.SyncResult
+	Error  error
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/auth"
+	"todoApp/events"
+	"todoApp/logging"
+	"todoApp/ratelimit"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+	"todoApp/websocket"
+)
+
+const (
+	// maxSubscriptions is how many lists one connection can subscribe to at once.
+	maxSubscriptions = 32
+	// socketBuffer is how many messages can wait to be written to a connection before it is considered too slow.
+	socketBuffer  = 64
+	socketTimeout = 10 * time.Second
+)
+
+// SocketConfig is what the WebSocket endpoint needs to serve connections.
+type SocketConfig struct {
+	Hubs        *events.Hubs
+	Presence    *events.Presence
+	DataService dataService.IDataService
+	Upgrader    websocket.Upgrader
+	// Writes and Quota, if set, limit commands the same way the write routes are: by the client's write budget and by
+	// the tenant's request quota.
+	Writes *ratelimit.Limiter
+	Quota  func(tenant string) (bool, time.Duration)
+	// Heartbeat is how often connections are pinged. Connections that haven't sent anything, not even a pong, for two
+	// heartbeats are closed.
+	Heartbeat time.Duration
+}
+
+// SocketHandler upgrades the request to a WebSocket. Clients subscribe to lists to be sent their events and who else
+// is viewing them, and send item commands, which go through the command queue and RequestHandler like the HTTP
+// handlers' commands, so are authorized and audited the same way. Messages are the JSON contracts
+// SocketRequestContract and SocketMessageContract.
+func SocketHandler(config SocketConfig) http.HandlerFunc {
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = DefaultHeartbeat
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := config.Upgrader.Upgrade(w, r)
+		if err != nil {
+			logging.FromContext(r.Context()).Debug("websocket handshake failed", "error", err)
+			return
+		}
+		conn.SetIdleTimeout(2 * config.Heartbeat)
+
+		ctx, cancel := context.WithCancel(r.Context())
+		principal, _ := auth.PrincipalFrom(ctx)
+		s := &socket{
+			config:        config,
+			conn:          conn,
+			ctx:           ctx,
+			cancel:        cancel,
+			principal:     principal,
+			tenant:        tenants.From(ctx),
+			hub:           config.Hubs.For(tenants.From(ctx)),
+			client:        ratelimit.ClientKey(r),
+			out:           make(chan contracts.SocketMessageContract, socketBuffer),
+			readerDone:    make(chan struct{}),
+			subscriptions: map[int]subscription{},
+		}
+		logging.FromContext(ctx).Info("websocket opened", "remote", conn.RemoteAddr().String())
+
+		s.wg.Add(1)
+		go s.write()
+		err = s.read()
+		close(s.readerDone)
+		cancel()
+		s.wg.Wait()
+		conn.Close()
+		logging.FromContext(ctx).Info("websocket closed", "reason", err.Error())
+	}
+}
+
+type socket struct {
+	config    SocketConfig
+	conn      *websocket.Conn
+	ctx       context.Context
+	cancel    context.CancelFunc
+	principal auth.Principal
+	tenant    string
+	hub       *events.Hub
+	client    string
+	out       chan contracts.SocketMessageContract
+	// readerDone is closed once the client has stopped sending, so the writer knows the closing handshake is over.
+	readerDone    chan struct{}
+	subscriptions map[int]subscription
+	wg            sync.WaitGroup
+}
+
+// subscription is done once the client unsubscribes or the subscription ends by itself.
+type subscription struct {
+	context.Context
+	cancel context.CancelFunc
+}
+
+// read handles messages from the client until the connection is closed.
+func (s *socket) read() error {
+	for {
+		_, payload, err := s.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var request contracts.SocketRequestContract
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&request); err != nil {
+			s.send(contracts.SocketMessageContract{Type: contracts.SocketError, Status: http.StatusBadRequest, Error: "invalid message: " + err.Error()})
+			continue
+		}
+		if errs := request.Validate(); errs != nil {
+			s.send(contracts.SocketMessageContract{Type: contracts.SocketError, Id: request.Id, Status: http.StatusUnprocessableEntity,
+				Error: "invalid message", Fields: errs})
+			continue
+		}
+
+		switch request.Type {
+		case contracts.SocketSubscribe:
+			s.subscribe(request)
+		case contracts.SocketUnsubscribe:
+			if subscription, ok := s.subscriptions[request.List]; ok {
+				subscription.cancel()
+				delete(s.subscriptions, request.List)
+			}
+		case contracts.SocketCommand:
+			s.send(s.command(request))
+		}
+		if s.ctx.Err() != nil {
+			return s.ctx.Err()
+		}
+	}
+}
+
+// write sends queued messages and pings to the client, and closes the connection once the socket is cancelled.
+func (s *socket) write() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.config.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case message := <-s.out:
+			payload, _ := json.Marshal(message)
+			s.conn.SetWriteDeadline(time.Now().Add(socketTimeout))
+			err = s.conn.WriteMessage(websocket.TextMessage, payload)
+		case <-ticker.C:
+			s.conn.SetWriteDeadline(time.Now().Add(socketTimeout))
+			err = s.conn.Ping()
+		case <-s.hub.Done():
+			s.closeWith(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-s.ctx.Done():
+			// Unless the client closed the connection, it is being dropped for falling behind and can reconnect
+			s.closeWith(websocket.CloseGoingAway, "reconnect to resume")
+			return
+		}
+		if err != nil {
+			s.cancel()
+			s.conn.Close()
+			return
+		}
+	}
+}
+
+// closeWith starts the closing handshake, and gives the client a moment to finish it before dropping the connection.
+func (s *socket) closeWith(code int, reason string) {
+	s.conn.SetWriteDeadline(time.Now().Add(socketTimeout))
+	if err := s.conn.WriteClose(code, reason); err != nil && !errors.Is(err, websocket.ErrClosed) {
+		s.conn.Close()
+		return
+	}
+	select {
+	case <-s.readerDone:
+	case <-time.After(socketTimeout):
+		s.conn.Close()
+	}
+}
+
+// send queues a message for the client, giving up if the connection closes first.
+func (s *socket) send(message contracts.SocketMessageContract) bool {
+	select {
+	case s.out <- message:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// subscribe starts sending the client a list's events and presence, after replaying the events it missed.
+func (s *socket) subscribe(request contracts.SocketRequestContract) {
+	fail := func(status int, message string) {
+		s.send(contracts.SocketMessageContract{Type: contracts.SocketError, List: request.List, Status: status, Error: message})
+	}
+	// Subscriptions that ended by themselves, e.g. when the client was removed from the list, are forgotten here
+	for listId, subscription := range s.subscriptions {
+		if subscription.Err() != nil {
+			delete(s.subscriptions, listId)
+		}
+	}
+	if _, subscribed := s.subscriptions[request.List]; subscribed {
+		fail(http.StatusConflict, "already subscribed")
+		return
+	}
+	if len(s.subscriptions) >= maxSubscriptions {
+		fail(http.StatusTooManyRequests, "too many subscriptions")
+		return
+	}
+	if _, err := s.config.DataService.GetListRole(s.ctx, request.List); err != nil {
+		fail(errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	listId := request.List
+	sub, replay, complete := s.hub.Subscribe(request.Since, func(event events.Event) bool {
+		return event.ListId == listId
+	})
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.subscriptions[listId] = subscription{Context: ctx, cancel: cancel}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		defer sub.Close()
+		s.forward(ctx, listId, sub, replay, complete)
+	}()
+}
+
+// forward sends the client a list's events until it unsubscribes, is removed from the list or falls too far behind,
+// in which case the connection is closed and the client resubscribes from the last event it saw.
+func (s *socket) forward(ctx context.Context, listId int, sub *events.Subscription, replay []events.Event, complete bool) {
+	if !s.send(contracts.SocketMessageContract{Type: contracts.SocketSubscribed, List: listId, Seq: s.hub.Seq(), Reset: !complete}) {
+		return
+	}
+	for _, event := range replay {
+		if visibleTo(s.principal.UserId, event) && !s.send(contracts.SocketMessageContract{Type: contracts.SocketEvent, List: listId, Event: &event}) {
+			return
+		}
+	}
+
+	leave := s.config.Presence.Join(s.tenant, listId, events.Viewer{UserId: s.principal.UserId, Username: s.principal.Username},
+		func(viewers []events.Viewer) {
+			select {
+			case s.out <- contracts.SocketMessageContract{Type: contracts.SocketPresence, List: listId, Viewers: viewers}:
+			default:
+				s.cancel()
+			}
+		})
+	defer leave()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, open := <-sub.Events():
+			if !open {
+				s.cancel()
+				return
+			}
+			if !visibleTo(s.principal.UserId, event) {
+				continue
+			}
+			if !s.send(contracts.SocketMessageContract{Type: contracts.SocketEvent, List: listId, Event: &event}) {
+				return
+			}
+			if event.Type == events.MemberRemoved && event.Member.UserId == s.principal.UserId {
+				s.send(contracts.SocketMessageContract{Type: contracts.SocketUnsubscribed, List: listId})
+				return
+			}
+		}
+	}
+}
+
+// command sends an item command to the RequestHandler and returns the reply for the client.
+func (s *socket) command(request contracts.SocketRequestContract) contracts.SocketMessageContract {
+	fail := func(status int, message string) contracts.SocketMessageContract {
+		return contracts.SocketMessageContract{Type: contracts.SocketError, Id: request.Id, List: request.List, Status: status, Error: message}
+	}
+	if !s.principal.Scope.Allows(auth.ScopeReadWrite) {
+		return fail(http.StatusForbidden, "credentials do not grant the '"+string(auth.ScopeReadWrite)+"' scope")
+	}
+	if !s.config.Writes.Allow(s.client).Allowed {
+		return fail(http.StatusTooManyRequests, "rate limit exceeded")
+	}
+	if s.config.Quota != nil {
+		if ok, _ := s.config.Quota(s.tenant); !ok {
+			return fail(http.StatusTooManyRequests, "the tenant has reached its request quota")
+		}
+	}
+	if !queue.acquire() {
+		return fail(http.StatusServiceUnavailable, "server is busy, please retry later")
+	}
+	defer queue.release()
+
+	status := http.StatusOK
+	var err error
+	switch request.Command {
+	case contracts.SocketCreate:
+		respCh := make(chan responses.CreateRes)
+		createCh <- CreateCommand{Ctx: s.ctx, Queued: time.Now(), ListId: request.List, Item: contracts.CreateContract{Name: request.Name}, Resp: respCh}
+		err = (<-respCh).Error
+		status = http.StatusCreated
+	case contracts.SocketComplete:
+		respCh := make(chan responses.MarkAsCompleteRes)
+		markAsCompleteCh <- MarkAsCompleteCommand{Ctx: s.ctx, Queued: time.Now(), ListId: request.List, Id: request.Index, Resp: respCh}
+		err = (<-respCh).Error
+	case contracts.SocketDelete:
+		respCh := make(chan responses.DeleteRes)
+		deleteCh <- DeleteCommand{Ctx: s.ctx, Queued: time.Now(), ListId: request.List, Id: request.Index, Resp: respCh}
+		err = (<-respCh).Error
+	}
+	if err != nil {
+		return fail(errorStatus(err, http.StatusInternalServerError), err.Error())
+	}
+	return contracts.SocketMessageContract{Type: contracts.SocketResult, Id: request.Id, List: request.List, Status: status}
+}
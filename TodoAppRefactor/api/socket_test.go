@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/events"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+	"todoApp/websocket"
+)
+
+// newSocketServer serves the WebSocket endpoint for a data service that publishes to hubs, with a RequestHandler
+// running the commands. Callers say who they are with the X-User and X-Scope headers.
+func newSocketServer(t *testing.T, hubs *events.Hubs, service dataService.IDataService) *httptest.Server {
+	var wg sync.WaitGroup
+	stopCh := make(chan struct{})
+	wg.Add(1)
+	go RequestHandler(service, &wg, stopCh)
+
+	handler := SocketHandler(SocketConfig{Hubs: hubs, Presence: events.NewPresence(), DataService: service, Heartbeat: time.Minute})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := strconv.Atoi(r.Header.Get("X-User"))
+		principal := auth.Principal{UserId: userId, Username: "user" + strconv.Itoa(userId), Scope: auth.Scope(r.Header.Get("X-Scope"))}
+		handler.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}))
+	t.Cleanup(func() {
+		hubs.Close()
+		server.Close()
+		close(stopCh)
+		wg.Wait()
+	})
+	return server
+}
+
+func dialSocket(t *testing.T, server *httptest.Server, userId int, scope auth.Scope) *websocket.Conn {
+	t.Helper()
+	header := http.Header{"X-User": {strconv.Itoa(userId)}, "X-Scope": {string(scope)}}
+	conn, _, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/todoapp/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetIdleTimeout(5 * time.Second)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func sendSocket(t *testing.T, conn *websocket.Conn, message string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectSocket reads messages until one of the given type arrives, skipping the others.
+func expectSocket(t *testing.T, conn *websocket.Conn, messageType string) contracts.SocketMessageContract {
+	t.Helper()
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Connection ended waiting for a %v message: %v", messageType, err)
+		}
+		var message contracts.SocketMessageContract
+		if err := json.Unmarshal(payload, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Type == messageType {
+			return message
+		}
+	}
+}
+
+func TestSocketHandler_SubscribeAndCommand(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	server := newSocketServer(t, hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	list, _ := service.CreateTodoList(alice, "Stand-up")
+	service.AddListMember(alice, list.Id, 2, data.RoleEditor)
+	subscribe := `{"Type":"subscribe","List":` + strconv.Itoa(list.Id) + `}`
+
+	aliceConn := dialSocket(t, server, 1, auth.ScopeReadWrite)
+	sendSocket(t, aliceConn, subscribe)
+	if message := expectSocket(t, aliceConn, contracts.SocketSubscribed); message.List != list.Id || message.Seq != 2 {
+		t.Errorf("Unexpected subscribed message. Got: %v", message)
+	}
+	expectSocket(t, aliceConn, contracts.SocketPresence)
+
+	bobConn := dialSocket(t, server, 2, auth.ScopeReadWrite)
+	sendSocket(t, bobConn, subscribe)
+	expectSocket(t, bobConn, contracts.SocketSubscribed)
+	if message := expectSocket(t, aliceConn, contracts.SocketPresence); len(message.Viewers) != 2 || message.Viewers[1].Username != "user2" {
+		t.Errorf("Alice should see Bob join. Got: %v", message.Viewers)
+	}
+
+	sendSocket(t, bobConn, `{"Type":"command","Id":"c1","Command":"create","List":`+strconv.Itoa(list.Id)+`,"Name":" Milk "}`)
+	if message := expectSocket(t, bobConn, contracts.SocketResult); message.Id != "c1" || message.Status != http.StatusCreated {
+		t.Errorf("Unexpected result. Got: %v", message)
+	}
+	if message := expectSocket(t, aliceConn, contracts.SocketEvent); message.Event.Type != events.ItemCreated ||
+		message.Event.Item.Name != "Milk" || message.Event.ActorId != 2 {
+		t.Errorf("Alice should be sent Bob's change. Got: %v", message.Event)
+	}
+
+	bobConn.WriteClose(websocket.CloseNormal, "")
+	if message := expectSocket(t, aliceConn, contracts.SocketPresence); len(message.Viewers) != 1 || message.Viewers[0].UserId != 1 {
+		t.Errorf("Alice should see Bob leave. Got: %v", message.Viewers)
+	}
+}
+
+func TestSocketHandler_Resubscribe(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	server := newSocketServer(t, hubs, service)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	list, _ := service.CreateTodoList(alice, "Stand-up")
+	service.CreateTodoItem(alice, list.Id, "Milk")
+	service.CreateTodoItem(alice, list.Id, "Eggs")
+
+	conn := dialSocket(t, server, 1, auth.ScopeRead)
+	sendSocket(t, conn, `{"Type":"subscribe","List":`+strconv.Itoa(list.Id)+`,"Since":2}`)
+	expectSocket(t, conn, contracts.SocketSubscribed)
+	if message := expectSocket(t, conn, contracts.SocketEvent); message.Event.Seq != 3 || message.Event.Item.Name != "Eggs" {
+		t.Errorf("Unexpected replayed event. Got: %v", message.Event)
+	}
+}
+
+func TestSocketHandler_Errors(t *testing.T) {
+	hubs := events.NewHubs(events.DefaultBufferSize)
+	service := dataService.NewDataService().PublishTo(hubs.For(tenants.DefaultTenant), tenants.DefaultTenant)
+	server := newSocketServer(t, hubs, service)
+	conn := dialSocket(t, server, 1, auth.ScopeRead)
+
+	testCases := []struct {
+		testName       string
+		message        string
+		expectedStatus int
+	}{
+		{"Testing invalid JSON", `{"Type":`, http.StatusBadRequest},
+		{"Testing an unknown field", `{"Type":"subscribe","List":1,"Colour":"red"}`, http.StatusBadRequest},
+		{"Testing an unknown type", `{"Type":"shout","List":1}`, http.StatusUnprocessableEntity},
+		{"Testing a create without a name", `{"Type":"command","Command":"create"}`, http.StatusUnprocessableEntity},
+		{"Testing a list the caller isn't a member of", `{"Type":"subscribe","List":42}`, http.StatusNotFound},
+		{"Testing a command with read-only credentials", `{"Type":"command","Command":"create","Name":"Milk"}`, http.StatusForbidden},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			sendSocket(t, conn, test.message)
+			if message := expectSocket(t, conn, contracts.SocketError); message.Status != test.expectedStatus {
+				t.Errorf("Unexpected status. Got: %v Want: %v (%v)", message.Status, test.expectedStatus, message.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/data"
+	dataService "todoApp/services"
+)
+
+type SyncCommand struct {
+	Ctx    context.Context
+	Queued time.Time
+	ListId int
+	Batch  data.SyncBatch
+	Resp   chan responses.SyncRes
+}
+
+var syncCh = make(chan SyncCommand)
+
+// SyncHandler is the route offline clients sync a list with, 'POST /todoapp/lists/{id}/sync'. They upload the
+// mutations they made since they last synced along with the token from then, and are sent back what changed on the
+// server, the IDs of the items they created and any conflicts. Uploading mutations needs the editor role, any member
+// can sync without any to catch up.
+func SyncHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, err := pathId(r, "id")
+		if err != nil {
+			responses.WriteError(w, http.StatusBadRequest, "invalid list id")
+			return
+		}
+		var request contracts.SyncContract
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+		batch := data.SyncBatch{ClientId: request.ClientId}
+		if request.Token != "" {
+			batch.Since, _ = strconv.ParseUint(request.Token, 10, 64)
+		}
+		for _, mutation := range request.Mutations {
+			at, _ := time.Parse(time.RFC3339, mutation.Time)
+			batch.Mutations = append(batch.Mutations, data.Mutation{
+				Seq:         mutation.Seq,
+				Op:          mutation.Op,
+				ItemId:      mutation.ItemId,
+				Ref:         mutation.Ref,
+				BaseVersion: mutation.BaseVersion,
+				Time:        at,
+				Name:        mutation.Name,
+				Complete:    mutation.Complete,
+			})
+		}
+
+		if !queue.acquire() {
+			queue.reject(w)
+			return
+		}
+		defer queue.release()
+		respCh := make(chan responses.SyncRes)
+		syncCh <- SyncCommand{Ctx: r.Context(), Queued: time.Now(), ListId: listId, Batch: batch, Resp: respCh}
+		resp := <-respCh
+		if resp.Error != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(resp.Error, dataService.ErrEmptyName) || errors.Is(resp.Error, dataService.ErrUnknownOperation) {
+				status = http.StatusBadRequest
+			}
+			responses.WriteError(w, errorStatus(resp.Error, status), resp.Error.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(syncResultContract(resp.Result))
+	}
+}
+
+// syncCommand carries out a sync on the RequestHandler goroutine, auditing every change it made.
+func syncCommand(dataService dataService.IDataService, cmd SyncCommand) responses.SyncRes {
+	if len(cmd.Batch.Mutations) > 0 {
+		if err := authorize(cmd.Ctx, dataService, "sync", cmd.ListId); err != nil {
+			return responses.SyncRes{Error: err}
+		}
+	}
+	result, err := dataService.Sync(cmd.Ctx, cmd.ListId, cmd.Batch)
+	if err != nil {
+		return responses.SyncRes{Error: err}
+	}
+	for _, change := range result.Changes {
+		recordItemChange(cmd.Ctx, dataService, "sync."+change.Op, cmd.ListId, change.Before, change.After)
+	}
+	return responses.SyncRes{Result: result}
+}
+
+func syncResultContract(result data.SyncResult) contracts.SyncResultContract {
+	contract := contracts.SyncResultContract{
+		Token:     strconv.FormatUint(result.Token, 10),
+		Reset:     result.Reset,
+		Items:     []contracts.SyncedItemContract{},
+		Created:   result.Created,
+		Conflicts: []contracts.ConflictContract{},
+	}
+	for _, item := range result.Items {
+		contract.Items = append(contract.Items, contracts.SyncedItemContract{
+			Id:       item.Id,
+			Name:     item.Name,
+			Complete: item.Complete,
+			Index:    item.Index,
+			Version:  item.Version,
+			Deleted:  item.Deleted,
+		})
+	}
+	for _, conflict := range result.Conflicts {
+		contract.Conflicts = append(contract.Conflicts, contracts.ConflictContract(conflict))
+	}
+	return contract
+}
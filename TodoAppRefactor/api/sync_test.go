@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"todoApp/api/contracts"
+)
+
+func TestSyncHandler(t *testing.T) {
+	stopRequestHandler := RequestHandlerSetup()
+	defer stopRequestHandler()
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /todoapp/lists/{id}/sync", SyncHandler())
+	testCases := []struct {
+		testName       string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Testing a batch of mutations", "/todoapp/lists/1/sync", `{"ClientId":"phone","Token":"1","Mutations":[
+			{"Seq":1,"Op":"create","Ref":"tmp-1","Name":"Tea","Time":"2026-01-01T12:00:00Z"},
+			{"Seq":2,"Op":"update","ItemId":1,"BaseVersion":1,"Complete":true,"Time":"2026-01-01T12:00:00Z"}]}`, http.StatusOK},
+		{"Testing catching up as a viewer", "/todoapp/lists/3/sync", `{"ClientId":"phone"}`, http.StatusOK},
+		{"Testing mutations as a viewer", "/todoapp/lists/3/sync", `{"ClientId":"phone","Mutations":[
+			{"Seq":1,"Op":"delete","ItemId":1,"Time":"2026-01-01T12:00:00Z"}]}`, http.StatusForbidden},
+		{"Testing without a client ID", "/todoapp/lists/1/sync", `{}`, http.StatusUnprocessableEntity},
+		{"Testing an invalid token", "/todoapp/lists/1/sync", `{"ClientId":"phone","Token":"yesterday"}`, http.StatusUnprocessableEntity},
+		{"Testing mutations out of order", "/todoapp/lists/1/sync", `{"ClientId":"phone","Mutations":[
+			{"Seq":2,"Op":"delete","ItemId":1,"Time":"2026-01-01T12:00:00Z"},
+			{"Seq":1,"Op":"delete","ItemId":2,"Time":"2026-01-01T12:00:00Z"}]}`, http.StatusUnprocessableEntity},
+		{"Testing a mutation without a time", "/todoapp/lists/1/sync", `{"ClientId":"phone","Mutations":[
+			{"Seq":1,"Op":"delete","ItemId":1}]}`, http.StatusUnprocessableEntity},
+		{"Testing an update that changes nothing", "/todoapp/lists/1/sync", `{"ClientId":"phone","Mutations":[
+			{"Seq":1,"Op":"update","ItemId":1,"Time":"2026-01-01T12:00:00Z"}]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v, Body: %s", status, test.expectedStatus, rr.Body)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/todoapp/lists/1/sync", strings.NewReader(testCases[0].body)))
+	var result contracts.SyncResultContract
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Token != "1" || !result.Reset || result.Created["tmp-1"] != 4 || len(result.Items) == 0 {
+		t.Errorf("handler returned unexpected body. Got: %+v", result)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Seq != 2 || result.Conflicts[0].Reason != "superseded" {
+		t.Errorf("handler returned unexpected conflicts. Got: %+v", result.Conflicts)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"todoApp/api/responses"
+	"todoApp/logging"
+	"todoApp/tenants"
+	"todoApp/users"
+)
+
+// Tenant membership is account state, like the API keys, so its handlers work on the user service directly. The routes
+// are '/admin/tenants/{tenant}/members/{username}' and name the tenant in the path, so an admin can manage the members
+// of tenants they don't belong to.
+
+// AddTenantMemberHandler makes a user a member of a tenant.
+func AddTenantMemberHandler(userService *users.UserService, router *tenants.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, user, ok := tenantMember(w, r, userService, router)
+		if !ok {
+			return
+		}
+		if err := userService.JoinTenant(user.Id, tenant); err != nil {
+			responses.WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		record(r.Context(), "addTenantMember", 0, 0, nil, map[string]any{"Tenant": tenant, "UserId": user.Id})
+		logging.FromContext(r.Context()).Info("added tenant member", "member_tenant", tenant, "user", user.Id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RemoveTenantMemberHandler stops a user being a member of a tenant. Their lists in the tenant are kept.
+func RemoveTenantMemberHandler(userService *users.UserService, router *tenants.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, user, ok := tenantMember(w, r, userService, router)
+		if !ok {
+			return
+		}
+		if err := userService.LeaveTenant(user.Id, tenant); err != nil {
+			responses.WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		record(r.Context(), "removeTenantMember", 0, 0, map[string]any{"Tenant": tenant, "UserId": user.Id}, nil)
+		logging.FromContext(r.Context()).Info("removed tenant member", "member_tenant", tenant, "user", user.Id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// tenantMember looks up the tenant and user named in the path, answering with a 404 if either doesn't exist.
+func tenantMember(w http.ResponseWriter, r *http.Request, userService *users.UserService, router *tenants.Router) (string, users.User, bool) {
+	tenant := r.PathValue("tenant")
+	if !router.Exists(tenant) {
+		responses.WriteError(w, http.StatusNotFound, tenants.ErrUnknownTenant.Error())
+		return "", users.User{}, false
+	}
+	user, exists := userService.GetUserByName(r.PathValue("username"))
+	if !exists {
+		responses.WriteError(w, http.StatusNotFound, users.ErrUserNotFound.Error())
+		return "", users.User{}, false
+	}
+	return tenant, user, true
+}
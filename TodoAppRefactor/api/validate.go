@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"todoApp/api/responses"
+	"todoApp/logging"
+	"todoApp/validation"
+)
+
+var maxBodyBytes int64 = validation.DefaultMaxBodyBytes
+
+// ConfigureMaxBodyBytes sets the largest request body the handlers read. It must be called before any handlers start
+// serving requests.
+func ConfigureMaxBodyBytes(max int64) {
+	maxBodyBytes = max
+}
+
+// decodeRequest reads and validates the JSON body of r into contract. If the body isn't valid it writes the error
+// response, listing every invalid field, and returns false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, contract validation.Validator) bool {
+	problem := validation.DecodeJSON(w, r, contract, maxBodyBytes)
+	if problem == nil {
+		return true
+	}
+	logging.FromContext(r.Context()).Debug("rejected request body", "path", r.URL.Path, "status", problem.Status, "error", problem.Error())
+	responses.WriteFieldErrors(w, problem.Status, problem.Message, problem.Fields)
+	return false
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"todoApp/api/contracts"
+	"todoApp/api/responses"
+	"todoApp/auth"
+	"todoApp/events"
+	"todoApp/logging"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+	"todoApp/webhooks"
+)
+
+// The webhook routes are under '/todoapp/lists/{id}/webhooks' and only the list's owners may use them. Webhooks are
+// kept by the webhook service rather than the data service, so like API keys their handlers work on it directly, only
+// asking the data service for the caller's role.
+
+func GetWebhooksHandler(service *webhooks.Service, dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, ok := authorizeWebhooks(w, r, dataService)
+		if !ok {
+			return
+		}
+
+		result := []contracts.WebhookContract{}
+		for _, hook := range service.Webhooks(tenants.From(r.Context()), listId) {
+			result = append(result, webhookContract(hook))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// CreateWebhookHandler registers a webhook for the list. Its deliveries are sent on behalf of the caller, so they stop
+// if the caller is removed from the list.
+func CreateWebhookHandler(service *webhooks.Service, dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, ok := authorizeWebhooks(w, r, dataService)
+		if !ok {
+			return
+		}
+		var newHook contracts.CreateWebhookContract
+		if !decodeRequest(w, r, &newHook) {
+			return
+		}
+		types := make([]events.Type, len(newHook.Events))
+		for i, name := range newHook.Events {
+			types[i] = events.Type(name)
+		}
+
+		principal, _ := auth.PrincipalFrom(r.Context())
+		hook, secret, err := service.Create(tenants.From(r.Context()), listId, principal.UserId, newHook.URL, types)
+		if err != nil {
+			responses.WriteError(w, webhookErrorStatus(err), err.Error())
+			return
+		}
+		contract := webhookContract(hook)
+		record(r.Context(), "createWebhook", listId, 0, nil, contract)
+		logging.FromContext(r.Context()).Info("created webhook", "list", listId, "webhook", hook.Id)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(contracts.CreatedWebhookContract{WebhookContract: contract, Secret: secret})
+	}
+}
+
+func DeleteWebhookHandler(service *webhooks.Service, dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, ok := authorizeWebhooks(w, r, dataService)
+		if !ok {
+			return
+		}
+
+		tenant, id := tenants.From(r.Context()), r.PathValue("webhookId")
+		var before *contracts.WebhookContract
+		for _, hook := range service.Webhooks(tenant, listId) {
+			if hook.Id == id {
+				contract := webhookContract(hook)
+				before = &contract
+			}
+		}
+		if err := service.Delete(tenant, listId, id); err != nil {
+			responses.WriteError(w, webhookErrorStatus(err), err.Error())
+			return
+		}
+		record(r.Context(), "deleteWebhook", listId, 0, before, nil)
+		logging.FromContext(r.Context()).Info("deleted webhook", "list", listId, "webhook", id)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetWebhookDeliveriesHandler returns the most recent delivery attempts of a webhook, newest first.
+func GetWebhookDeliveriesHandler(service *webhooks.Service, dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, ok := authorizeWebhooks(w, r, dataService)
+		if !ok {
+			return
+		}
+
+		deliveries, err := service.Deliveries(tenants.From(r.Context()), listId, r.PathValue("webhookId"))
+		if err != nil {
+			responses.WriteError(w, webhookErrorStatus(err), err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// GetDeadLettersHandler returns the events that couldn't be delivered to a webhook, newest first.
+func GetDeadLettersHandler(service *webhooks.Service, dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, ok := authorizeWebhooks(w, r, dataService)
+		if !ok {
+			return
+		}
+
+		deadLetters, err := service.DeadLetters(tenants.From(r.Context()), listId, r.PathValue("webhookId"))
+		if err != nil {
+			responses.WriteError(w, webhookErrorStatus(err), err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deadLetters)
+	}
+}
+
+// RedeliverHandler sends a dead letter again. The redelivery happens in the background, so it answers 202.
+func RedeliverHandler(service *webhooks.Service, dataService dataService.IDataService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listId, ok := authorizeWebhooks(w, r, dataService)
+		if !ok {
+			return
+		}
+
+		id, deliveryId := r.PathValue("webhookId"), r.PathValue("deliveryId")
+		if err := service.Redeliver(tenants.From(r.Context()), listId, id, deliveryId); err != nil {
+			responses.WriteError(w, webhookErrorStatus(err), err.Error())
+			return
+		}
+		logging.FromContext(r.Context()).Info("redelivering dead letter", "list", listId, "webhook", id, "delivery", deliveryId)
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// authorizeWebhooks reads the list ID from the path and checks the caller owns the list, writing an error response if
+// not.
+func authorizeWebhooks(w http.ResponseWriter, r *http.Request, dataService dataService.IDataService) (int, bool) {
+	listId, err := pathId(r, "id")
+	if err != nil {
+		responses.WriteError(w, http.StatusBadRequest, "invalid list id")
+		return 0, false
+	}
+	if err := authorize(r.Context(), dataService, "manageWebhooks", listId); err != nil {
+		responses.WriteError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return 0, false
+	}
+	return listId, true
+}
+
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, webhooks.ErrWebhookNotFound), errors.Is(err, webhooks.ErrDeadLetterNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, webhooks.ErrTooManyWebhooks):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func webhookContract(hook webhooks.Webhook) contracts.WebhookContract {
+	contract := contracts.WebhookContract{Id: hook.Id, URL: hook.URL, Events: []string{}, Created: hook.Created}
+	for _, eventType := range hook.Events {
+		contract.Events = append(contract.Events, string(eventType))
+	}
+	return contract
+}
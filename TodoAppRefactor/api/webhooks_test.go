@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"todoApp/api/contracts"
+	"todoApp/webhooks"
+)
+
+func TestWebhookHandlers(t *testing.T) {
+	service := webhooks.NewService(webhooks.Config{})
+	mux := http.NewServeMux()
+	mux.Handle("GET /todoapp/lists/{id}/webhooks", GetWebhooksHandler(service, mockDataService))
+	mux.Handle("POST /todoapp/lists/{id}/webhooks", CreateWebhookHandler(service, mockDataService))
+	mux.Handle("DELETE /todoapp/lists/{id}/webhooks/{webhookId}", DeleteWebhookHandler(service, mockDataService))
+	mux.Handle("GET /todoapp/lists/{id}/webhooks/{webhookId}/deliveries", GetWebhookDeliveriesHandler(service, mockDataService))
+	mux.Handle("GET /todoapp/lists/{id}/webhooks/{webhookId}/dead-letters", GetDeadLettersHandler(service, mockDataService))
+	mux.Handle("POST /todoapp/lists/{id}/webhooks/{webhookId}/dead-letters/{deliveryId}/retry", RedeliverHandler(service, mockDataService))
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := serve(http.MethodPost, "/todoapp/lists/1/webhooks", `{"URL":"https://example.com/hook","Events":["item.created"]}`)
+	var created contracts.CreatedWebhookContract
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code. Got: %v Want: %v", rr.Code, http.StatusCreated)
+	} else if err := json.NewDecoder(rr.Body).Decode(&created); err != nil || created.Id == "" || created.Secret == "" || created.Events[0] != "item.created" {
+		t.Fatalf("handler returned unexpected body. Got: %+v", created)
+	}
+
+	testCases := []struct {
+		testName       string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Testing a URL that isn't http", http.MethodPost, "/todoapp/lists/1/webhooks", `{"URL":"ftp://example.com"}`, http.StatusUnprocessableEntity},
+		{"Testing an unknown event type", http.MethodPost, "/todoapp/lists/1/webhooks", `{"URL":"https://example.com","Events":["item.renamed"]}`, http.StatusUnprocessableEntity},
+		{"Testing creating as a viewer", http.MethodPost, "/todoapp/lists/3/webhooks", `{"URL":"https://example.com"}`, http.StatusForbidden},
+		{"Testing listing as a viewer", http.MethodGet, "/todoapp/lists/3/webhooks", "", http.StatusForbidden},
+		{"Testing listing", http.MethodGet, "/todoapp/lists/1/webhooks", "", http.StatusOK},
+		{"Testing deliveries", http.MethodGet, "/todoapp/lists/1/webhooks/" + created.Id + "/deliveries", "", http.StatusOK},
+		{"Testing dead letters", http.MethodGet, "/todoapp/lists/1/webhooks/" + created.Id + "/dead-letters", "", http.StatusOK},
+		{"Testing another list's webhook", http.MethodGet, "/todoapp/lists/2/webhooks/" + created.Id + "/deliveries", "", http.StatusNotFound},
+		{"Testing an unknown dead letter", http.MethodPost, "/todoapp/lists/1/webhooks/" + created.Id + "/dead-letters/abc/retry", "", http.StatusNotFound},
+		{"Testing deleting", http.MethodDelete, "/todoapp/lists/1/webhooks/" + created.Id, "", http.StatusNoContent},
+		{"Testing deleting twice", http.MethodDelete, "/todoapp/lists/1/webhooks/" + created.Id, "", http.StatusNotFound},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if rr := serve(test.method, test.path, test.body); rr.Code != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, test.expectedStatus)
+			}
+		})
+	}
+
+	var remaining []contracts.WebhookContract
+	json.NewDecoder(serve(http.MethodGet, "/todoapp/lists/1/webhooks", "").Body).Decode(&remaining)
+	if len(remaining) != 0 {
+		t.Errorf("The deleted webhook is still listed. Got: %+v", remaining)
+	}
+}
@@ -0,0 +1,284 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrLogClosed = errors.New("the audit log is closed")
+
+// Entry records a single change. Every entry holds the hash of the one before it, so changing or removing an entry
+// breaks the chain from that point on.
+type Entry struct {
+	Seq       uint64
+	Time      time.Time
+	Tenant    string
+	ActorId   int
+	Actor     string
+	Operation string
+	ListId    int
+	ItemId    int             `json:",omitempty"`
+	Before    json.RawMessage `json:",omitempty"`
+	After     json.RawMessage `json:",omitempty"`
+	RequestId string          `json:",omitempty"`
+	PrevHash  string
+	Hash      string
+}
+
+// Filter selects entries. Zero values match everything, From and To are inclusive.
+type Filter struct {
+	Tenant string
+	Actor  string
+	ListId int
+	ItemId int
+	From   time.Time
+	To     time.Time
+}
+
+func (filter Filter) matches(entry Entry) bool {
+	return (filter.Tenant == "" || entry.Tenant == filter.Tenant) &&
+		(filter.Actor == "" || entry.Actor == filter.Actor) &&
+		(filter.ListId == 0 || entry.ListId == filter.ListId) &&
+		(filter.ItemId == 0 || entry.ItemId == filter.ItemId) &&
+		(filter.From.IsZero() || !entry.Time.Before(filter.From)) &&
+		(filter.To.IsZero() || !entry.Time.After(filter.To))
+}
+
+// Head is the last entry of the log at some moment. The chain only proves that entries weren't changed, a log that has
+// lost its last entries, or been replaced by a new one, is still a valid chain. Checking it against a head recorded
+// earlier shows whether the entries up to that head are still there.
+type Head struct {
+	Seq  uint64
+	Hash string
+}
+
+// Verification is the result of checking the hash chain.
+type Verification struct {
+	Valid   bool
+	Entries int
+	// Head is the hash of the last entry. Recording it somewhere else makes it possible to tell if the whole log
+	// has been rewritten.
+	Head     string
+	BrokenAt *uint64 `json:",omitempty"`
+	Error    string  `json:",omitempty"`
+}
+
+// Log is an append-only, hash-chained audit log. Entries can be added and read, but not changed or removed. The log
+// itself only holds the head, entries are read back from its storage when they are queried or verified.
+type Log struct {
+	head    Head
+	storage storage
+	now     func() time.Time
+	mu      sync.RWMutex
+}
+
+// storage is where a log keeps its entries, oldest first.
+type storage interface {
+	// append adds the entry to the end. written reports whether it was added, as it can be when an error is returned
+	// for something done afterwards.
+	append(entry Entry) (written bool, err error)
+	// each calls fn with every entry, oldest first, stopping at the first error.
+	each(fn func(Entry) error) error
+	// anchor is a head recorded apart from the entries, which they must still reach. It is zero if there isn't one.
+	anchor() Head
+	close() error
+}
+
+// NewLog creates a log that is only kept in memory, and so starts empty every time the server does.
+func NewLog() *Log {
+	return &Log{storage: &memoryStorage{}, now: time.Now}
+}
+
+// Append fills in the entry's sequence number, time and hashes and adds it to the end of the log. It fails without
+// adding the entry if its Before or After isn't valid JSON, or if it can't be written to the log's storage. An error
+// with a filled in entry means the entry was added, but its hash couldn't be recorded as the head.
+func (log *Log) Append(entry Entry) (Entry, error) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	entry.Seq = log.head.Seq + 1
+	entry.Time = log.now().UTC()
+	entry.PrevHash = log.head.Hash
+	var err error
+	if entry.Hash, err = hash(entry); err != nil {
+		return Entry{}, err
+	}
+	written, err := log.storage.append(entry)
+	if !written {
+		return Entry{}, err
+	}
+	log.head = Head{Seq: entry.Seq, Hash: entry.Hash}
+	return entry, err
+}
+
+// Entries returns the entries matching the filter, oldest first.
+func (log *Log) Entries(filter Filter) ([]Entry, error) {
+	log.mu.RLock()
+	defer log.mu.RUnlock()
+
+	entries := []Entry{}
+	err := log.storage.each(func(entry Entry) error {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Verify recomputes every hash and checks that each entry points at the one before it, and that the log still ends at
+// the last entry added to it. A log kept in a file is also checked against the head recorded beside it, which shows
+// whether entries were removed from the end of the file or the file was replaced while the server was stopped.
+func (log *Log) Verify() Verification {
+	return log.VerifyHead(Head{})
+}
+
+// VerifyHead verifies the log like Verify, and also checks that it still holds a head recorded earlier, e.g. the
+// head an earlier verification returned.
+func (log *Log) VerifyHead(head Head) Verification {
+	log.mu.RLock()
+	defer log.mu.RUnlock()
+
+	checks := []headCheck{
+		{log.head, "added last"},
+		{log.storage.anchor(), "recorded beside the log"},
+		{head, "given"},
+	}
+	hashes := map[uint64]string{}
+	for _, check := range checks {
+		hashes[check.Seq] = ""
+	}
+	result := log.verify(hashes)
+	for _, check := range checks {
+		if !result.Valid {
+			break
+		}
+		check.apply(&result, hashes)
+	}
+	return result
+}
+
+// headCheck is a head the log has to reach, and where it came from.
+type headCheck struct {
+	Head
+	source string
+}
+
+// apply marks the result as broken unless the log reaches the head, the entry at it having the head's hash. hashes are
+// the hashes of the entries, by sequence number. Every log reaches the zero head.
+func (check headCheck) apply(result *Verification, hashes map[uint64]string) {
+	var seq uint64
+	switch {
+	case check.Seq == 0:
+		return
+	case check.Seq > uint64(result.Entries):
+		seq = uint64(result.Entries) + 1
+		result.Error = fmt.Sprintf("the log ends at entry %d, before the head %s at entry %d", result.Entries, check.source, check.Seq)
+	case hashes[check.Seq] != check.Hash:
+		seq = check.Seq
+		result.Error = fmt.Sprintf("entry %d doesn't match the head %s", check.Seq, check.source)
+	default:
+		return
+	}
+	result.Valid = false
+	result.BrokenAt = &seq
+	result.Head = ""
+}
+
+// verify reads every entry back from the log's storage and checks the chain, noting the hashes of the entries whose
+// sequence numbers are in hashes.
+func (log *Log) verify(hashes map[uint64]string) Verification {
+	result := Verification{Valid: true}
+	broken := func(seq uint64, format string, args ...any) {
+		result.Valid = false
+		result.BrokenAt = &seq
+		result.Error = fmt.Sprintf(format, args...)
+	}
+
+	prevHash := ""
+	err := log.storage.each(func(entry Entry) error {
+		result.Entries++
+		seq := uint64(result.Entries)
+		if _, wanted := hashes[seq]; wanted {
+			hashes[seq] = entry.Hash
+		}
+		if !result.Valid {
+			return nil
+		}
+
+		switch expected, err := hash(entry); {
+		case entry.Seq != seq:
+			broken(seq, "entry %d has sequence number %d", seq, entry.Seq)
+		case entry.PrevHash != prevHash:
+			broken(seq, "entry %d does not follow the entry before it", seq)
+		case err != nil:
+			broken(seq, "entry %d can't be hashed: %v", seq, err)
+		case entry.Hash != expected:
+			broken(seq, "entry %d has been modified", seq)
+		}
+		prevHash = entry.Hash
+		return nil
+	})
+	if err != nil && result.Valid {
+		broken(uint64(result.Entries)+1, "entry %d can't be read: %v", result.Entries+1, err)
+	}
+	if result.Valid {
+		result.Head = prevHash
+	}
+	return result
+}
+
+// Close closes the log's storage. No more entries can be added, and those of a log kept in a file can't be read.
+func (log *Log) Close() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	return log.storage.close()
+}
+
+// hash is the SHA-256 of the entry's JSON encoding without its own hash. The field order of the JSON encoding is fixed,
+// so the same entry always hashes the same. It fails if the entry's Before or After isn't valid JSON.
+func hash(entry Entry) (string, error) {
+	entry.Hash = ""
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// memoryStorage keeps the entries of a log that is only kept in memory.
+type memoryStorage struct {
+	entries []Entry
+}
+
+func (storage *memoryStorage) append(entry Entry) (bool, error) {
+	storage.entries = append(storage.entries, entry)
+	return true, nil
+}
+
+func (storage *memoryStorage) each(fn func(Entry) error) error {
+	for _, entry := range storage.entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (storage *memoryStorage) anchor() Head {
+	return Head{}
+}
+
+func (storage *memoryStorage) close() error {
+	return nil
+}
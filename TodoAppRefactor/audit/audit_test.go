@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLog() (*Log, *time.Time) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	log := NewLog()
+	log.now = func() time.Time { return now }
+	return log, &now
+}
+
+func TestAppendAndFilter(t *testing.T) {
+	log, now := newTestLog()
+	first, _ := log.Append(Entry{Tenant: "default", Actor: "alice", Operation: "create", ListId: 1, ItemId: 4, After: json.RawMessage(`{"Id":4}`)})
+	*now = now.Add(time.Hour)
+	log.Append(Entry{Tenant: "default", Actor: "bob", Operation: "delete", ListId: 1, ItemId: 2})
+	*now = now.Add(time.Hour)
+	log.Append(Entry{Tenant: "team-a", Actor: "alice", Operation: "create", ListId: 2, ItemId: 7})
+
+	if first.Seq != 1 || first.PrevHash != "" || first.Hash == "" {
+		t.Errorf("first entry was not filled in correctly. Got: %+v", first)
+	}
+
+	testCases := []struct {
+		testName     string
+		filter       Filter
+		expectedSeqs []uint64
+	}{
+		{"Testing without a filter", Filter{}, []uint64{1, 2, 3}},
+		{"Testing by tenant", Filter{Tenant: "default"}, []uint64{1, 2}},
+		{"Testing by actor", Filter{Actor: "alice"}, []uint64{1, 3}},
+		{"Testing by item", Filter{ItemId: 2}, []uint64{2}},
+		{"Testing by time range", Filter{From: first.Time.Add(time.Hour), To: first.Time.Add(time.Hour)}, []uint64{2}},
+		{"Testing from a time", Filter{From: first.Time.Add(30 * time.Minute)}, []uint64{2, 3}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			entries, err := log.Entries(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var seqs []uint64
+			for _, entry := range entries {
+				seqs = append(seqs, entry.Seq)
+			}
+			if len(seqs) != len(test.expectedSeqs) {
+				t.Fatalf("Unexpected entries. Got: %v, Expected: %v", seqs, test.expectedSeqs)
+			}
+			for i := range seqs {
+				if seqs[i] != test.expectedSeqs[i] {
+					t.Errorf("Unexpected entries. Got: %v, Expected: %v", seqs, test.expectedSeqs)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	log, _ := newTestLog()
+	for _, actor := range []string{"alice", "bob", "carol"} {
+		log.Append(Entry{Actor: actor, Operation: "create", ListId: 1})
+	}
+
+	stored := log.storage.(*memoryStorage)
+	if result := log.Verify(); !result.Valid || result.Entries != 3 || result.Head != stored.entries[2].Hash {
+		t.Fatalf("an untouched log failed verification. Got: %+v", result)
+	}
+
+	stored.entries[1].Actor = "mallory"
+	if result := log.Verify(); result.Valid || result.BrokenAt == nil || *result.BrokenAt != 2 {
+		t.Errorf("a modified entry was not detected. Got: %+v", result)
+	}
+
+	// Rehashing the modified entry still breaks the link from the entry after it.
+	stored.entries[1].Hash, _ = hash(stored.entries[1])
+	if result := log.Verify(); result.Valid || *result.BrokenAt != 3 {
+		t.Errorf("a rehashed entry was not detected. Got: %+v", result)
+	}
+
+	stored.entries[1] = stored.entries[2]
+	stored.entries = stored.entries[:2]
+	if result := log.Verify(); result.Valid || *result.BrokenAt != 2 {
+		t.Errorf("a removed entry was not detected. Got: %+v", result)
+	}
+
+	// The log has to end at the last entry added to it
+	stored.entries = stored.entries[:1]
+	if result := log.Verify(); result.Valid || *result.BrokenAt != 2 {
+		t.Errorf("entries removed from the end were not detected. Got: %+v", result)
+	}
+}
+
+func TestAppend_InvalidJSON(t *testing.T) {
+	log, _ := newTestLog()
+	if _, err := log.Append(Entry{Actor: "alice", Operation: "create", After: json.RawMessage(`{"Id":`)}); err == nil {
+		t.Error("An entry that can't be hashed was added")
+	}
+	if entry, err := log.Append(Entry{Actor: "alice", Operation: "create"}); err != nil || entry.Seq != 1 {
+		t.Errorf("The log didn't carry on after an entry that couldn't be hashed. Got: %+v, %v", entry, err)
+	}
+}
+
+// openTestLog opens the log kept in dir, closing it when the test finishes.
+func openTestLog(t *testing.T, dir string) *Log {
+	log, err := OpenLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func TestOpenLog(t *testing.T) {
+	dir := t.TempDir()
+	log := openTestLog(t, dir)
+	for _, actor := range []string{"alice", "bob", "carol"} {
+		if _, err := log.Append(Entry{Actor: actor, Operation: "create", ListId: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head := log.Verify()
+	log.Close()
+	if _, err := log.Append(Entry{Actor: "dave"}); err != ErrLogClosed {
+		t.Errorf("An entry was added to a closed log. Got: %v", err)
+	}
+
+	// An entry cut short by a crash is dropped, and the log carries on after the last whole one
+	path := filepath.Join(dir, FileName)
+	contents, _ := os.ReadFile(path)
+	os.WriteFile(path, append(contents, `{"Seq":4,"Act`...), 0o600)
+	reopened := openTestLog(t, dir)
+	if result := reopened.Verify(); !result.Valid || result.Entries != 3 || result.Head != head.Head {
+		t.Fatalf("Unexpected log after reopening it. Got: %+v, Expected: %+v", result, head)
+	}
+	if entry, err := reopened.Append(Entry{Actor: "dave", Operation: "create", ListId: 1}); err != nil || entry.Seq != 4 || entry.PrevHash != head.Head {
+		t.Errorf("The log didn't carry on from its last entry. Got: %+v, %v", entry, err)
+	}
+	reopened.Close()
+	log = openTestLog(t, dir)
+	if result := log.Verify(); !result.Valid || result.Entries != 4 {
+		t.Errorf("Unexpected log after reopening it again. Got: %+v", result)
+	}
+
+	// Entries are read back from the file when they are needed, so a change made to it while the log is open is seen
+	if entries, err := log.Entries(Filter{Actor: "bob"}); err != nil || len(entries) != 1 || entries[0].Seq != 2 {
+		t.Errorf("Unexpected entries read from the file. Got: %+v, %v", entries, err)
+	}
+	contents, _ = os.ReadFile(path)
+	os.WriteFile(path, bytes.Replace(contents, []byte(`"bob"`), []byte(`"eve"`), 1), 0o600)
+	if result := log.Verify(); result.Valid || result.BrokenAt == nil || *result.BrokenAt != 2 {
+		t.Errorf("An entry changed in the file wasn't detected. Got: %+v", result)
+	}
+	log.Close()
+
+	os.WriteFile(path, []byte("not an entry\n"), 0o600)
+	if _, err := OpenLog(dir); !errors.Is(err, ErrCorruptLog) {
+		t.Errorf("A log with an entry that can't be read was opened. Got: %v", err)
+	}
+}
+
+func TestOpenLog_DetectsRemovedEntries(t *testing.T) {
+	testCases := []struct {
+		testName string
+		// damage changes the lines of the log file while the log is closed
+		damage         func(lines []string) []string
+		expectedBroken uint64
+	}{
+		{"Testing a log cut short", func(lines []string) []string { return lines[:2] }, 3},
+		{"Testing a log that was started again", func(lines []string) []string { return nil }, 1},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			log := openTestLog(t, dir)
+			for _, actor := range []string{"alice", "bob", "carol"} {
+				log.Append(Entry{Actor: actor, Operation: "create", ListId: 1})
+			}
+			log.Close()
+
+			path := filepath.Join(dir, FileName)
+			contents, _ := os.ReadFile(path)
+			lines := test.damage(strings.SplitAfter(strings.TrimSuffix(string(contents), "\n"), "\n"))
+			os.WriteFile(path, []byte(strings.Join(lines, "")), 0o600)
+
+			// The chain that is left is intact, but doesn't reach the head recorded beside it. Entries added since don't
+			// hide that, the head is only moved on while the log holds it.
+			log = openTestLog(t, dir)
+			if result := log.Verify(); result.Valid || result.BrokenAt == nil || *result.BrokenAt != test.expectedBroken {
+				t.Errorf("Removed entries weren't detected. Got: %+v", result)
+			}
+			for range 2 {
+				log.Append(Entry{Actor: "mallory", Operation: "create", ListId: 1})
+				log.Append(Entry{Actor: "mallory", Operation: "create", ListId: 1})
+				log.Close()
+				log = openTestLog(t, dir)
+				if result := log.Verify(); result.Valid {
+					t.Errorf("Removed entries were hidden by adding new ones. Got: %+v", result)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyHead(t *testing.T) {
+	log, _ := newTestLog()
+	log.Append(Entry{Actor: "alice", Operation: "create", ListId: 1})
+	recorded := log.Verify()
+	log.Append(Entry{Actor: "bob", Operation: "create", ListId: 1})
+
+	if result := log.VerifyHead(Head{Seq: 1, Hash: recorded.Head}); !result.Valid {
+		t.Errorf("A log holding the head failed verification. Got: %+v", result)
+	}
+
+	// A log only kept in memory starts again when the server does
+	restarted, _ := newTestLog()
+	restarted.Append(Entry{Actor: "mallory", Operation: "create", ListId: 1})
+	if result := restarted.VerifyHead(Head{Seq: 1, Hash: recorded.Head}); result.Valid || *result.BrokenAt != 1 {
+		t.Errorf("A log that was started again passed verification. Got: %+v", result)
+	}
+	if result := restarted.VerifyHead(Head{Seq: 2, Hash: recorded.Head}); result.Valid || *result.BrokenAt != 2 {
+		t.Errorf("A log that was cut short passed verification. Got: %+v", result)
+	}
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// FileName is the file in the audit directory that entries are appended to, one JSON object per line, and
+	// HeadFileName the file the head of the log is recorded in after every entry.
+	FileName     = "audit.log"
+	HeadFileName = "audit.head"
+)
+
+var ErrCorruptLog = errors.New("the audit log file holds an entry that can't be read")
+
+// logFile keeps a log's entries in a file, reading them back from it each time they are needed. recorded is the head
+// last written to the head file. It is only moved on while the log still holds it, so a log that was cut short or
+// replaced while the server was stopped keeps failing verification after new entries are added to it.
+type logFile struct {
+	file     *os.File
+	size     int64
+	headPath string
+	recorded Head
+	anchored bool
+	closed   bool
+}
+
+// OpenLog opens the log kept in dir, creating it if it doesn't exist yet. An entry cut short by a crash while it was
+// being written is dropped, the head was only recorded once an entry was written whole. Only the last entry is kept
+// in memory, to carry on the chain from.
+func OpenLog(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	headPath := filepath.Join(dir, HeadFileName)
+	recorded, err := readHead(headPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", headPath, err)
+	}
+
+	path := filepath.Join(dir, FileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	var head Head
+	anchored := recorded.Seq == 0
+	size, err := scan(file, func(entry Entry) error {
+		head = Head{Seq: head.Seq + 1, Hash: entry.Hash}
+		anchored = anchored || head == recorded
+		return nil
+	})
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	storage := &logFile{file: file, size: size, headPath: headPath, recorded: recorded, anchored: anchored}
+	return &Log{head: head, storage: storage, now: time.Now}, nil
+}
+
+// scan reads the entries in r, one per line, calling fn with each. A last line without a newline is an entry cut short
+// while it was being written and is left out. It returns the size of the lines read.
+func scan(r io.Reader, fn func(Entry) error) (int64, error) {
+	var size int64
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		encoded, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		} else if err != nil {
+			return 0, err
+		}
+		var entry Entry
+		if err := json.Unmarshal(bytes.TrimSpace(encoded), &entry); err != nil {
+			return 0, fmt.Errorf("%w: line %d: %w", ErrCorruptLog, line, err)
+		}
+		if err := fn(entry); err != nil {
+			return 0, err
+		}
+		size += int64(len(encoded))
+	}
+}
+
+// readHead reads the head file, returning a zero head if there isn't one yet.
+func readHead(path string) (Head, error) {
+	var head Head
+	encoded, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return head, nil
+	} else if err != nil {
+		return head, err
+	}
+	if err := json.Unmarshal(encoded, &head); err != nil {
+		return head, fmt.Errorf("%w: %w", ErrCorruptLog, err)
+	}
+	return head, nil
+}
+
+// append writes the entry to the end of the file and waits for it to reach the disk before recording it as the head.
+// A write that fails is cut off again, so the next entry starts on a line of its own. written reports whether the entry
+// is in the file, as it can be when only recording the head failed. The head lags behind until the next entry then,
+// which verification allows for.
+func (logFile *logFile) append(entry Entry) (written bool, err error) {
+	if logFile.closed {
+		return false, ErrLogClosed
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+	encoded = append(encoded, '\n')
+	if _, err = logFile.file.Write(encoded); err == nil {
+		err = logFile.file.Sync()
+	}
+	if err != nil {
+		logFile.file.Truncate(logFile.size)
+		logFile.file.Seek(logFile.size, io.SeekStart)
+		return false, err
+	}
+	logFile.size += int64(len(encoded))
+
+	if !logFile.anchored {
+		return true, nil
+	}
+	head := Head{Seq: entry.Seq, Hash: entry.Hash}
+	if err := writeHead(logFile.headPath, head); err != nil {
+		return true, fmt.Errorf("entry %d was written but not recorded as the head: %w", entry.Seq, err)
+	}
+	logFile.recorded = head
+	return true, nil
+}
+
+// writeHead replaces the head file atomically, through a temporary file in the same directory.
+func writeHead(path string, head Head) error {
+	encoded, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(encoded); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (logFile *logFile) each(fn func(Entry) error) error {
+	if logFile.closed {
+		return ErrLogClosed
+	}
+	_, err := scan(io.NewSectionReader(logFile.file, 0, logFile.size), fn)
+	return err
+}
+
+func (logFile *logFile) anchor() Head {
+	return logFile.recorded
+}
+
+func (logFile *logFile) close() error {
+	if logFile.closed {
+		return nil
+	}
+	logFile.closed = true
+	return logFile.file.Close()
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"todoApp/utils/stringUtils"
+)
+
+type Scope string
+
+const (
+	ScopeRead      Scope = "read"
+	ScopeReadWrite Scope = "read-write"
+)
+
+// Allows reports whether a caller with this scope may perform something that needs the required scope.
+func (scope Scope) Allows(required Scope) bool {
+	switch scope {
+	case ScopeReadWrite:
+		return true
+	case ScopeRead:
+		return required == ScopeRead
+	default:
+		return false
+	}
+}
+
+func (scope Scope) Valid() bool {
+	return scope == ScopeRead || scope == ScopeReadWrite
+}
+
+const apiKeyPrefix = "tdk_"
+
+var (
+	ErrInvalidScope   = errors.New("scope must be 'read' or 'read-write'")
+	ErrAPIKeyNotFound = errors.New("api key does not exist")
+)
+
+// APIKey is a long-lived credential for scripts and automation. Only a hash of the secret is kept, the secret itself
+// is shown once when the key is created. A key can only be used with the tenant it was created for.
+type APIKey struct {
+	Id        string
+	UserId    int
+	Tenant    string
+	Name      string
+	Scope     Scope
+	Created   time.Time
+	ExpiresAt time.Time
+	Revoked   bool
+	hash      []byte
+}
+
+func (key APIKey) expired(now time.Time) bool {
+	return !key.ExpiresAt.IsZero() && now.After(key.ExpiresAt)
+}
+
+type APIKeyStore struct {
+	keys map[string]APIKey
+	mu   sync.RWMutex
+}
+
+func NewAPIKeyStore() *APIKeyStore {
+	return &APIKeyStore{keys: map[string]APIKey{}}
+}
+
+// CreateAPIKey creates a key for the user to use with a tenant and returns it along with its secret. A zero expiresAt
+// means the key doesn't expire.
+func (store *APIKeyStore) CreateAPIKey(userId int, tenant string, name string, scope Scope, expiresAt time.Time) (APIKey, string, error) {
+	if stringUtils.IsEmptyOrWhitespace(name) {
+		return APIKey{}, "", errors.New("name cannot be empty")
+	}
+	if !scope.Valid() {
+		return APIKey{}, "", ErrInvalidScope
+	}
+
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return APIKey{}, "", err
+	}
+
+	id := hex.EncodeToString(idBytes)
+	secret := apiKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+	hash := sha256.Sum256([]byte(secret))
+	key := APIKey{
+		Id:        id,
+		UserId:    userId,
+		Tenant:    tenant,
+		Name:      name,
+		Scope:     scope,
+		Created:   time.Now().UTC(),
+		ExpiresAt: expiresAt,
+		hash:      hash[:],
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.keys[id] = key
+	return key, secret, nil
+}
+
+// GetAPIKeys returns the user's keys, including revoked ones, oldest first.
+func (store *APIKeyStore) GetAPIKeys(userId int) []APIKey {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, key := range store.keys {
+		if key.UserId == userId {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b APIKey) int { return a.Created.Compare(b.Created) })
+	return keys
+}
+
+// RevokeAPIKey stops a key from being used. Keys belonging to other users are reported as not existing.
+func (store *APIKeyStore) RevokeAPIKey(userId int, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key, exists := store.keys[id]
+	if !exists || key.UserId != userId {
+		return ErrAPIKeyNotFound
+	}
+	key.Revoked = true
+	store.keys[id] = key
+	return nil
+}
+
+// VerifyAPIKey returns the key a secret belongs to, if it is valid, not revoked and not expired.
+func (store *APIKeyStore) VerifyAPIKey(secret string) (APIKey, bool) {
+	id, _, found := strings.Cut(strings.TrimPrefix(secret, apiKeyPrefix), "_")
+	if !strings.HasPrefix(secret, apiKeyPrefix) || !found {
+		return APIKey{}, false
+	}
+
+	store.mu.RLock()
+	key, exists := store.keys[id]
+	store.mu.RUnlock()
+	if !exists {
+		return APIKey{}, false
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], key.hash) != 1 || key.Revoked || key.expired(time.Now()) {
+		return APIKey{}, false
+	}
+	return key, true
+}
+
+func isAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, apiKeyPrefix)
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"todoApp/api/middleware"
+	"todoApp/api/responses"
+	"todoApp/logging"
+	"todoApp/users"
+)
+
+const (
+	SessionCookieName = "todoapp_session"
+	APIKeyHeader      = "X-API-Key"
+	// CSRFHeader and CSRFField carry the session's CSRF token on requests made by scripts and forms respectively.
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+)
+
+// How the caller of a request proved who they are.
+const (
+	MethodSession = "session"
+	MethodAPIKey  = "apiKey"
+	MethodToken   = "token"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserId    int
+	Username  string
+	SessionId string
+	// CSRFToken is only set for callers using a session cookie.
+	CSRFToken string
+	APIKeyId  string
+	Method    string
+	Scope     Scope
+	// Tenant is only set for callers using a bearer token or API key, which can only be used with the tenant it was
+	// issued for.
+	Tenant string
+	Admin  bool
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the authenticated caller of the request that ctx belongs to, if there is one.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Authenticator works out who is making a request. Requests without credentials are passed on anonymously, routes
+// that need a caller are wrapped with RequireUser, RequireScope, RequireAdmin or RequireLogin.
+type Authenticator struct {
+	Users    *users.UserService
+	Sessions *users.SessionStore
+	APIKeys  *APIKeyStore
+	Tokens   *TokenIssuer
+	// Admins are the usernames of the users allowed to use the routes wrapped with RequireAdmin.
+	Admins []string
+}
+
+// Authenticate attaches the caller to the request context. Callers are identified by a bearer token or API key in the
+// Authorization header, an API key in the X-API-Key header or the session cookie, in that order. Credentials that are
+// presented but aren't valid are rejected with a 401 rather than treated as anonymous.
+func (authenticator *Authenticator) Authenticate() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, presented, ok := authenticator.fromCredentials(r)
+			if presented && !ok {
+				logging.FromContext(r.Context()).Debug("rejected credentials", "path", r.URL.Path)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				responses.WriteError(w, http.StatusUnauthorized, "invalid or expired credentials")
+				return
+			}
+			if !presented {
+				principal, ok = authenticator.fromSession(r)
+			}
+			if ok {
+				principal.Admin = slices.Contains(authenticator.Admins, principal.Username)
+				r = r.WithContext(WithPrincipal(r.Context(), principal))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fromCredentials looks for a token or API key on the request. presented reports whether there was one at all.
+func (authenticator *Authenticator) fromCredentials(r *http.Request) (principal Principal, presented bool, ok bool) {
+	credential := r.Header.Get(APIKeyHeader)
+	if scheme, value, found := strings.Cut(r.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		credential = strings.TrimSpace(value)
+	}
+	if credential == "" {
+		return Principal{}, false, false
+	}
+
+	if isAPIKey(credential) {
+		if authenticator.APIKeys == nil {
+			return Principal{}, true, false
+		}
+		key, valid := authenticator.APIKeys.VerifyAPIKey(credential)
+		if !valid {
+			return Principal{}, true, false
+		}
+		user, exists := authenticator.Users.GetUser(key.UserId)
+		if !exists {
+			return Principal{}, true, false
+		}
+		return Principal{UserId: user.Id, Username: user.Username, APIKeyId: key.Id, Method: MethodAPIKey, Scope: key.Scope, Tenant: key.Tenant}, true, true
+	}
+
+	if authenticator.Tokens == nil {
+		return Principal{}, true, false
+	}
+	claims, err := authenticator.Tokens.VerifyToken(credential)
+	if err != nil {
+		return Principal{}, true, false
+	}
+	return Principal{UserId: claims.Subject, Username: claims.Username, Method: MethodToken, Scope: claims.Scope, Tenant: claims.Tenant}, true, true
+}
+
+func (authenticator *Authenticator) fromSession(r *http.Request) (Principal, bool) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return Principal{}, false
+	}
+	session, ok := authenticator.Sessions.GetSession(cookie.Value)
+	if !ok {
+		return Principal{}, false
+	}
+	user, ok := authenticator.Users.GetUser(session.UserId)
+	if !ok {
+		return Principal{}, false
+	}
+	return Principal{
+		UserId:    user.Id,
+		Username:  user.Username,
+		SessionId: session.Id,
+		CSRFToken: session.CSRFToken,
+		Method:    MethodSession,
+		Scope:     ScopeReadWrite,
+	}, true
+}
+
+// CheckCSRF rejects changes made with a session cookie unless they carry the session's CSRF token, in the X-CSRF-Token
+// header or the csrf_token form field, with a 403. Browsers attach the cookie to requests started by other sites, but
+// those sites can't read the token from our pages. Callers using tokens or API keys aren't affected, browsers never send
+// those on their own.
+func CheckCSRF() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFrom(r.Context())
+			if !ok || principal.Method != MethodSession || isSafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := r.Header.Get(CSRFHeader)
+			if token == "" && isForm(r) {
+				token = r.PostFormValue(CSRFField)
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(principal.CSRFToken)) != 1 {
+				logging.FromContext(r.Context()).Info("rejected request without a valid CSRF token", "path", r.URL.Path)
+				responses.WriteError(w, http.StatusForbidden, "missing or invalid CSRF token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == http.MethodTrace
+}
+
+// isForm reports whether the body of the request is a form, so reading the token from it doesn't consume a JSON body.
+func isForm(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return strings.HasPrefix(contentType, "application/x-www-form-urlencoded") || strings.HasPrefix(contentType, "multipart/form-data")
+}
+
+// RequireUser rejects requests without an authenticated caller with a 401.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFrom(r.Context()); !ok {
+			responses.WriteError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope rejects requests without an authenticated caller with a 401, and callers whose credentials don't
+// grant the scope with a 403.
+func RequireScope(scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFrom(r.Context())
+		if !ok {
+			responses.WriteError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if !principal.Scope.Allows(scope) {
+			responses.WriteError(w, http.StatusForbidden, "credentials do not grant the '"+string(scope)+"' scope")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin rejects requests without an authenticated caller with a 401, and callers who aren't admins with a 403.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFrom(r.Context())
+		if !ok {
+			responses.WriteError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if !principal.Admin {
+			responses.WriteError(w, http.StatusForbidden, "admin access required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireLogin redirects requests without an authenticated caller to the login page, remembering where they were going.
+func RequireLogin(loginPath string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFrom(r.Context()); !ok {
+			logging.FromContext(r.Context()).Debug("redirecting to login", "path", r.URL.Path)
+			http.Redirect(w, r, loginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetSessionCookie gives the browser the session ID. The cookie can't be read by scripts and isn't sent on
+// cross-site requests.
+func SetSessionCookie(w http.ResponseWriter, session users.Session, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    session.Id,
+		Path:     "/",
+		Expires:  session.Expires,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearSessionCookie(w http.ResponseWriter, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
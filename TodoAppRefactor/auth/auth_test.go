@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"todoApp/users"
+)
+
+func TestMain(m *testing.M) {
+	users.UseCheapHashing()
+	os.Exit(m.Run())
+}
+
+func setupAuthenticator(t *testing.T) (*Authenticator, users.Session) {
+	authenticator := &Authenticator{Users: users.NewUserService(), Sessions: users.NewSessionStore(time.Hour)}
+	user, err := authenticator.Users.Register("alice", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := authenticator.Sessions.CreateSession(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator, session
+}
+
+func TestAuthenticate(t *testing.T) {
+	authenticator, session := setupAuthenticator(t)
+	testCases := []struct {
+		testName     string
+		cookie       *http.Cookie
+		expectedUser string
+	}{
+		{"Testing a valid session cookie", &http.Cookie{Name: SessionCookieName, Value: session.Id}, "alice"},
+		{"Testing an unknown session", &http.Cookie{Name: SessionCookieName, Value: "made-up"}, ""},
+		{"Testing without a cookie", nil, ""},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			var seenUser string
+			handler := authenticator.Authenticate()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if principal, ok := PrincipalFrom(r.Context()); ok {
+					seenUser = principal.Username
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.cookie != nil {
+				req.AddCookie(test.cookie)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if seenUser != test.expectedUser {
+				t.Errorf("Unexpected principal. Got: %q, Expected: %q", seenUser, test.expectedUser)
+			}
+		})
+	}
+}
+
+func TestRequireUser(t *testing.T) {
+	handler := RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todoapp/items/", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/todoapp/items/", nil)
+	handler.ServeHTTP(rr, req.WithContext(WithPrincipal(req.Context(), Principal{UserId: 1})))
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	handler := RequireLogin("/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/?list=2", nil))
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, http.StatusSeeOther)
+	} else if location := rr.Header().Get("Location"); location != "/login?next=%2F%3Flist%3D2" {
+		t.Errorf("handler redirected to the wrong location. Got: %v", location)
+	}
+}
+
+func TestSetSessionCookie(t *testing.T) {
+	rr := httptest.NewRecorder()
+	SetSessionCookie(rr, users.Session{Id: "abc", Expires: time.Now().Add(time.Hour)}, true)
+
+	cookie := rr.Result().Cookies()[0]
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie is missing security attributes. Got: %+v", cookie)
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	testCases := []struct {
+		scope    Scope
+		required Scope
+		expected bool
+	}{
+		{ScopeReadWrite, ScopeReadWrite, true},
+		{ScopeReadWrite, ScopeRead, true},
+		{ScopeRead, ScopeRead, true},
+		{ScopeRead, ScopeReadWrite, false},
+		{"", ScopeRead, false},
+	}
+
+	for _, test := range testCases {
+		if got := test.scope.Allows(test.required); got != test.expected {
+			t.Errorf("Unexpected result for %q allowing %q. Got: %v, Expected: %v", test.scope, test.required, got, test.expected)
+		}
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	store := NewAPIKeyStore()
+	key, secret, err := store.CreateAPIKey(1, "default", "ci", ScopeRead, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if verified, ok := store.VerifyAPIKey(secret); !ok || verified.Id != key.Id || verified.Scope != ScopeRead {
+		t.Errorf("a valid key was not verified. Got: %+v, %v", verified, ok)
+	}
+	if _, ok := store.VerifyAPIKey(secret + "x"); ok {
+		t.Error("a key with the wrong secret was verified")
+	}
+	if err := store.RevokeAPIKey(2, key.Id); err != ErrAPIKeyNotFound {
+		t.Errorf("another user was able to revoke the key. Got: %v", err)
+	}
+	if err := store.RevokeAPIKey(1, key.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.VerifyAPIKey(secret); ok {
+		t.Error("a revoked key was verified")
+	}
+	if keys := store.GetAPIKeys(1); len(keys) != 1 || !keys[0].Revoked {
+		t.Errorf("revoked key was not listed as revoked. Got: %+v", keys)
+	}
+
+	_, expiredSecret, _ := store.CreateAPIKey(1, "default", "old", ScopeReadWrite, time.Now().Add(-time.Minute))
+	if _, ok := store.VerifyAPIKey(expiredSecret); ok {
+		t.Error("an expired key was verified")
+	}
+	if _, _, err := store.CreateAPIKey(1, "default", "bad", "admin", time.Time{}); err != ErrInvalidScope {
+		t.Errorf("a key was created with an unknown scope. Got: %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	issuer := NewTokenIssuer([]byte("0123456789abcdef0123456789abcdef"))
+	principal := Principal{UserId: 1, Username: "alice", Scope: ScopeReadWrite}
+
+	token, _, err := issuer.IssueToken(principal, ScopeRead, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := issuer.VerifyToken(token)
+	if err != nil || claims.Subject != 1 || claims.Scope != ScopeRead {
+		t.Errorf("a valid token was not verified. Got: %+v, %v", claims, err)
+	}
+
+	// A second issuer with the same key can verify tokens without sharing any state.
+	if _, err := NewTokenIssuer([]byte("0123456789abcdef0123456789abcdef")).VerifyToken(token); err != nil {
+		t.Errorf("token could not be verified with the same key. Got: %v", err)
+	}
+	if _, err := NewTokenIssuer([]byte("another key another key another!")).VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("token was verified with a different key. Got: %v", err)
+	}
+
+	tampered := strings.Replace(token, token[strings.Index(token, ".")+1:strings.LastIndex(token, ".")], "eyJzdWIiOjJ9", 1)
+	if _, err := issuer.VerifyToken(tampered); err != ErrInvalidToken {
+		t.Errorf("a tampered token was verified. Got: %v", err)
+	}
+
+	issuer.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := issuer.VerifyToken(token); err != ErrExpiredToken {
+		t.Errorf("an expired token was verified. Got: %v", err)
+	}
+
+	readOnly := Principal{UserId: 1, Scope: ScopeRead}
+	if _, _, err := issuer.IssueToken(readOnly, ScopeReadWrite, time.Minute); err == nil {
+		t.Error("a read-only caller was issued a read-write token")
+	}
+}
+
+func TestAuthenticate_Credentials(t *testing.T) {
+	authenticator, _ := setupAuthenticator(t)
+	authenticator.APIKeys = NewAPIKeyStore()
+	authenticator.Tokens = NewTokenIssuer(NewRandomKey())
+	alice, _ := authenticator.Users.GetUserByName("alice")
+
+	_, secret, _ := authenticator.APIKeys.CreateAPIKey(alice.Id, "team-a", "ci", ScopeRead, time.Time{})
+	token, _, _ := authenticator.Tokens.IssueToken(Principal{UserId: alice.Id, Username: "alice", Scope: ScopeReadWrite}, ScopeReadWrite, time.Minute)
+
+	testCases := []struct {
+		testName       string
+		header         string
+		value          string
+		expectedStatus int
+		expectedMethod string
+		expectedScope  Scope
+	}{
+		{"Testing an API key in the X-API-Key header", APIKeyHeader, secret, http.StatusOK, MethodAPIKey, ScopeRead},
+		{"Testing an API key as a bearer token", "Authorization", "Bearer " + secret, http.StatusOK, MethodAPIKey, ScopeRead},
+		{"Testing a bearer token", "Authorization", "Bearer " + token, http.StatusOK, MethodToken, ScopeReadWrite},
+		{"Testing an unknown API key", APIKeyHeader, "tdk_0000_nope", http.StatusUnauthorized, "", ""},
+		{"Testing a malformed bearer token", "Authorization", "Bearer nope", http.StatusUnauthorized, "", ""},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			var seen Principal
+			handler := authenticator.Authenticate()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen, _ = PrincipalFrom(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/todoapp/items/", nil)
+			req.Header.Set(test.header, test.value)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, test.expectedStatus)
+			}
+			if seen.Method != test.expectedMethod || seen.Scope != test.expectedScope {
+				t.Errorf("Unexpected principal. Got: %+v", seen)
+			}
+			if seen.Method == MethodAPIKey && seen.Tenant != "team-a" {
+				t.Errorf("An API key wasn't bound to the tenant it was created for. Got: %q", seen.Tenant)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := RequireScope(ScopeReadWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testCases := []struct {
+		testName       string
+		principal      *Principal
+		expectedStatus int
+	}{
+		{"Testing without a caller", nil, http.StatusUnauthorized},
+		{"Testing a read-only caller", &Principal{UserId: 1, Scope: ScopeRead}, http.StatusForbidden},
+		{"Testing a read-write caller", &Principal{UserId: 1, Scope: ScopeReadWrite}, http.StatusOK},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/todoapp/item/", nil)
+			if test.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), *test.principal))
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, test.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	authenticator, session := setupAuthenticator(t)
+	authenticator.Admins = []string{"alice"}
+	handler := authenticator.Authenticate()(RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/tenants/team-a/members/bob", nil)
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session.Id})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code for an admin. Got: %v Want: %v", rr.Code, http.StatusOK)
+	}
+
+	authenticator.Admins = nil
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code for a user who isn't an admin. Got: %v Want: %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	authenticator, session := setupAuthenticator(t)
+	authenticator.Tokens = NewTokenIssuer(NewRandomKey())
+	alice, _ := authenticator.Users.GetUserByName("alice")
+	token, _, _ := authenticator.Tokens.IssueToken(Principal{UserId: alice.Id, Username: "alice", Scope: ScopeReadWrite}, ScopeReadWrite, time.Minute)
+	handler := authenticator.Authenticate()(CheckCSRF()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	withSession := func(req *http.Request) *http.Request {
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session.Id})
+		return req
+	}
+	formRequest := func(csrfToken string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(CSRFField+"="+csrfToken))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return withSession(req)
+	}
+	headerRequest := func(csrfToken string) *http.Request {
+		req := httptest.NewRequest(http.MethodDelete, "/todoapp/item/0", nil)
+		req.Header.Set(CSRFHeader, csrfToken)
+		return withSession(req)
+	}
+	bearerRequest := httptest.NewRequest(http.MethodDelete, "/todoapp/item/0", nil)
+	bearerRequest.Header.Set("Authorization", "Bearer "+token)
+
+	testCases := []struct {
+		testName       string
+		req            *http.Request
+		expectedStatus int
+	}{
+		{"Testing a read with a session", withSession(httptest.NewRequest(http.MethodGet, "/", nil)), http.StatusOK},
+		{"Testing a change with the token in the header", headerRequest(session.CSRFToken), http.StatusOK},
+		{"Testing a change with the token in the form", formRequest(session.CSRFToken), http.StatusOK},
+		{"Testing a change without a token", withSession(httptest.NewRequest(http.MethodPost, "/todoapp/item/", nil)), http.StatusForbidden},
+		{"Testing a change with the wrong token", headerRequest("nope"), http.StatusForbidden},
+		{"Testing a form with the wrong token", formRequest("nope"), http.StatusForbidden},
+		{"Testing a change with a bearer token", bearerRequest, http.StatusOK},
+		{"Testing an anonymous change", httptest.NewRequest(http.MethodPost, "/login", nil), http.StatusOK},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, test.req)
+			if rr.Code != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, test.expectedStatus)
+			}
+		})
+	}
+}
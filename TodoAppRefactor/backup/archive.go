@@ -0,0 +1,271 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"todoApp/data"
+	"todoApp/migrate"
+	dataService "todoApp/services"
+	"todoApp/users"
+)
+
+const (
+	// Format names the archives written by this package, and FormatVersion is the version of their layout. Archives
+	// from a newer version are refused rather than half understood.
+	Format        = "todoapp-backup"
+	FormatVersion = 1
+	// MaxArchiveBytes caps how much an archive may hold once decompressed, so a small upload can't expand to fill the
+	// server's memory.
+	MaxArchiveBytes = 512 << 20
+	manifestName    = "manifest.json"
+	usersName       = "users.json"
+	tenantsDir      = "tenants/"
+)
+
+var ErrInvalidArchive = errors.New("invalid backup archive")
+
+// migrations upgrade the contents of archives written before the latest schema version.
+var migrations = migrate.Default
+
+// Archive is what a backup holds: every user and the data of every tenant, as of Created.
+type Archive struct {
+	Created time.Time
+	Users   users.Snapshot
+	Tenants map[string]data.Snapshot
+}
+
+// Manifest is the first file of an archive. It lists every other file with its size and SHA-256 checksum, so that a
+// damaged or tampered archive is refused before anything is restored from it. Version is the version of the archive's
+// layout, and SchemaVersion that of the users and lists in it.
+type Manifest struct {
+	Format        string
+	Version       int
+	SchemaVersion int `json:",omitempty"`
+	Created       time.Time
+	Tenants       []string
+	Files         []File
+}
+
+type File struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// Write writes an archive as a gzip-compressed tar file: the manifest, then the users, then a file for each tenant.
+func Write(w io.Writer, archive Archive) error {
+	manifest := Manifest{
+		Format:        Format,
+		Version:       FormatVersion,
+		SchemaVersion: migrations.Latest(),
+		Created:       archive.Created.UTC(),
+		Tenants:       slices.Sorted(maps.Keys(archive.Tenants)),
+	}
+	files := map[string][]byte{}
+	add := func(name string, value any) error {
+		encoded, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(encoded)
+		files[name] = encoded
+		manifest.Files = append(manifest.Files, File{Name: name, Size: int64(len(encoded)), SHA256: hex.EncodeToString(sum[:])})
+		return nil
+	}
+	if err := add(usersName, archive.Users); err != nil {
+		return err
+	}
+	for _, tenant := range manifest.Tenants {
+		if err := add(tenantsDir+tenant+".json", archive.Tenants[tenant]); err != nil {
+			return err
+		}
+	}
+	encodedManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	compressed := gzip.NewWriter(w)
+	tw := tar.NewWriter(compressed)
+	write := func(name string, contents []byte) error {
+		header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(contents)), ModTime: manifest.Created, Format: tar.FormatPAX}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(contents)
+		return err
+	}
+	if err := write(manifestName, encodedManifest); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if err := write(file.Name, files[file.Name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+// Read reads and checks an archive. It is refused if it isn't a backup archive, is from a newer version, has files
+// missing, extra or not matching their checksums, or holds data that couldn't have been backed up. The contents of
+// archives from an older schema version are migrated to the latest.
+func Read(r io.Reader) (Archive, Manifest, error) {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidArchive, fmt.Sprintf(format, args...))
+	}
+
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return Archive{}, Manifest{}, fmt.Errorf("%w: not gzip compressed: %w", ErrInvalidArchive, err)
+	}
+	tr := tar.NewReader(compressed)
+	var remaining int64 = MaxArchiveBytes
+	next := func() (string, []byte, error) {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return "", nil, err
+		} else if err != nil {
+			return "", nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		if header.Size > remaining {
+			return "", nil, invalid("more than %d bytes once decompressed", MaxArchiveBytes)
+		}
+		contents, err := io.ReadAll(io.LimitReader(tr, header.Size))
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		remaining -= int64(len(contents))
+		return header.Name, contents, nil
+	}
+
+	name, contents, err := next()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Archive{}, Manifest{}, err
+	}
+	if name != manifestName {
+		return Archive{}, Manifest{}, invalid("the first file isn't the manifest")
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(contents, &manifest); err != nil || manifest.Format != Format {
+		return Archive{}, Manifest{}, invalid("the manifest isn't a %s manifest", Format)
+	}
+	if manifest.Version < 1 || manifest.Version > FormatVersion {
+		return Archive{}, manifest, invalid("version %d isn't supported, the newest this server reads is %d", manifest.Version, FormatVersion)
+	}
+
+	expected := map[string]File{}
+	for _, file := range manifest.Files {
+		expected[file.Name] = file
+	}
+	files := map[string][]byte{}
+	for {
+		name, contents, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return Archive{}, manifest, err
+		}
+		file, listed := expected[name]
+		if !listed || files[name] != nil {
+			return Archive{}, manifest, invalid("%s isn't in the manifest", name)
+		}
+		sum := sha256.Sum256(contents)
+		if int64(len(contents)) != file.Size || hex.EncodeToString(sum[:]) != file.SHA256 {
+			return Archive{}, manifest, invalid("%s doesn't match its checksum", name)
+		}
+		files[name] = contents
+	}
+	for name := range expected {
+		if files[name] == nil {
+			return Archive{}, manifest, invalid("%s is missing", name)
+		}
+	}
+
+	// Archives written before schema versions were recorded are at the first one
+	manifest.SchemaVersion = max(manifest.SchemaVersion, migrate.BaseVersion)
+	if manifest.SchemaVersion != migrations.Latest() {
+		if err := upgrade(files, manifest); err != nil {
+			return Archive{}, manifest, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+	}
+
+	archive := Archive{Created: manifest.Created, Tenants: map[string]data.Snapshot{}}
+	if err := decode(files[usersName], &archive.Users); err != nil {
+		return Archive{}, manifest, invalid("%s: %v", usersName, err)
+	}
+	if err := users.ValidateSnapshot(archive.Users); err != nil {
+		return Archive{}, manifest, invalid("%v", err)
+	}
+	for _, tenant := range manifest.Tenants {
+		name := tenantsDir + tenant + ".json"
+		var snapshot data.Snapshot
+		if err := decode(files[name], &snapshot); err != nil {
+			return Archive{}, manifest, invalid("%s: %v", name, err)
+		}
+		if err := dataService.ValidateSnapshot(snapshot); err != nil {
+			return Archive{}, manifest, invalid("tenant %s: %v", tenant, err)
+		}
+		archive.Tenants[tenant] = snapshot
+	}
+	return archive, manifest, nil
+}
+
+// upgrade migrates the files of an archive from its schema version to the latest, in place.
+func upgrade(files map[string][]byte, manifest Manifest) error {
+	tenants := map[string]any{}
+	for _, tenant := range manifest.Tenants {
+		snapshot, err := migrate.Decode(files[tenantsDir+tenant+".json"])
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+		tenants[tenant] = snapshot
+	}
+	users, err := migrate.Decode(files[usersName])
+	if err != nil {
+		return fmt.Errorf("%s: %w", usersName, err)
+	}
+	state := map[string]any{"Users": users, "Tenants": tenants}
+	if _, err := migrations.Upgrade(state, manifest.SchemaVersion); err != nil {
+		return err
+	}
+
+	if files[usersName], err = json.Marshal(state["Users"]); err != nil {
+		return err
+	}
+	tenants, _ = state["Tenants"].(map[string]any)
+	for _, tenant := range manifest.Tenants {
+		if files[tenantsDir+tenant+".json"], err = json.Marshal(tenants[tenant]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode decodes a file of an archive, refusing fields the snapshot doesn't have.
+func decode(contents []byte, value any) error {
+	if contents == nil {
+		return errors.New("missing")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(value)
+}
+
+// FileName is the name an archive created at t is saved as. Names sort in the order the archives were created.
+func FileName(t time.Time) string {
+	return Format + "-" + strings.ReplaceAll(t.UTC().Format("20060102T150405.000Z"), ".", "") + ".tar.gz"
+}
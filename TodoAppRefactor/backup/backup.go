@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+	"todoApp/logging"
+	"todoApp/tenants"
+	"todoApp/users"
+)
+
+// Mode is how a restore treats the data the server already has.
+type Mode string
+
+const (
+	// Replace throws away the server's data and replaces it with the archive's.
+	Replace Mode = "replace"
+	// Merge adds what the archive has that the server doesn't, changing nothing the server already has.
+	Merge Mode = "merge"
+)
+
+var (
+	ErrUnknownMode = errors.New("restore mode must be 'replace' or 'merge'")
+	ErrReplicated  = errors.New("restoring isn't supported on a replicated node, restore a standalone server and start the cluster from it")
+)
+
+func (mode Mode) Valid() bool {
+	return mode == Replace || mode == Merge
+}
+
+// Source is what is backed up and restored: the users, and the lists of every tenant.
+type Source struct {
+	Users *users.UserService
+	Lists *tenants.Router
+	// Replicated is set when the lists are replicated across a cluster. Restoring would change one node's data behind
+	// the replication log's back, so it is refused.
+	Replicated bool
+}
+
+// Report is what a restore did.
+type Report struct {
+	Mode    Mode
+	Created time.Time
+	// Tenants are the tenants whose data was restored, and SkippedTenants those the archive has but the server
+	// doesn't serve.
+	Tenants        []string
+	SkippedTenants []string `json:",omitempty"`
+	// Users, Lists and Items count what was added by a merge, or what there is after a replace.
+	Users int
+	Lists int
+	Items int
+}
+
+// Snapshot takes an archive of the source. The lists of every tenant are copied at the same moment.
+func (source Source) Snapshot() Archive {
+	return Archive{Created: time.Now().UTC(), Users: source.Users.Snapshot(), Tenants: source.Lists.Snapshot()}
+}
+
+// Restore restores an archive into the source. Users are restored before lists, so that a list is never restored
+// without its members.
+func (source Source) Restore(ctx context.Context, archive Archive, mode Mode) (Report, error) {
+	if !mode.Valid() {
+		return Report{}, ErrUnknownMode
+	}
+	if source.Replicated {
+		return Report{}, ErrReplicated
+	}
+
+	report := Report{Mode: mode, Created: archive.Created, Tenants: []string{}}
+	for _, tenant := range slices.Sorted(maps.Keys(archive.Tenants)) {
+		if !source.Lists.Exists(tenant) {
+			report.SkippedTenants = append(report.SkippedTenants, tenant)
+		} else {
+			report.Tenants = append(report.Tenants, tenant)
+		}
+	}
+
+	switch mode {
+	case Replace:
+		if err := source.Users.Restore(archive.Users); err != nil {
+			return Report{}, err
+		}
+		report.Users = len(archive.Users.Users)
+		for _, tenant := range report.Tenants {
+			snapshot := archive.Tenants[tenant]
+			if err := source.Lists.Restore(tenant, snapshot); err != nil {
+				return Report{}, err
+			}
+			report.Lists += len(snapshot.Lists)
+			for _, list := range snapshot.Lists {
+				report.Items += len(list.Items)
+			}
+		}
+	case Merge:
+		added, err := source.Users.Merge(archive.Users)
+		if err != nil {
+			return Report{}, err
+		}
+		report.Users = added
+		for _, tenant := range report.Tenants {
+			lists, items, err := source.Lists.Merge(ctx, tenant, archive.Tenants[tenant])
+			if err != nil {
+				return Report{}, err
+			}
+			report.Lists += lists
+			report.Items += items
+		}
+	}
+	logging.FromContext(ctx).Info("backup restored", "mode", mode, "created", archive.Created, "tenants", report.Tenants,
+		"skipped_tenants", report.SkippedTenants, "users", report.Users, "lists", report.Lists, "items", report.Items)
+	return report, nil
+}
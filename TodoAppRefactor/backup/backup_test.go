@@ -0,0 +1,313 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"todoApp/auth"
+	"todoApp/migrate"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+	"todoApp/users"
+)
+
+func TestMain(m *testing.M) {
+	users.UseCheapHashing()
+	os.Exit(m.Run())
+}
+
+// testSource returns a source with one user, Alice, who has a list in the default tenant and another in team-a.
+func testSource(t *testing.T) Source {
+	router := tenants.NewRouter(func(namespace string) *dataService.DataService { return dataService.NewDataService() }, tenants.Quota{})
+	router.AddTenant("team-a")
+	userService := users.NewUserService()
+	user, err := userService.Register("alice", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: user.Id, Username: user.Username})
+	for _, tenant := range []string{tenants.DefaultTenant, "team-a"} {
+		ctx := tenants.WithTenant(alice, tenant)
+		list, _ := router.CreateTodoList(ctx, "Groceries")
+		router.CreateTodoItem(ctx, list.Id, "Milk")
+		router.CreateTodoItem(ctx, list.Id, "Eggs")
+	}
+	return Source{Users: userService, Lists: router}
+}
+
+func encode(t *testing.T, archive Archive) []byte {
+	var buf bytes.Buffer
+	if err := Write(&buf, archive); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// rewrite decompresses an archive, passes every file through edit, and compresses it again. Files edit returns nil for
+// are left out.
+func rewrite(t *testing.T, archive []byte, edit func(name string, contents []byte) []byte) []byte {
+	compressed, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(compressed)
+	var buf bytes.Buffer
+	recompressed := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(recompressed)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		contents, _ := io.ReadAll(tr)
+		if contents = edit(header.Name, contents); contents == nil {
+			continue
+		}
+		header.Size = int64(len(contents))
+		tw.WriteHeader(header)
+		tw.Write(contents)
+	}
+	tw.Close()
+	recompressed.Close()
+	return buf.Bytes()
+}
+
+func TestArchive_RoundTrip(t *testing.T) {
+	source := testSource(t)
+	archive := source.Snapshot()
+
+	read, manifest, err := Read(bytes.NewReader(encode(t, archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != FormatVersion || !slices.Equal(manifest.Tenants, []string{"default", "team-a"}) || len(manifest.Files) != 3 {
+		t.Errorf("Unexpected manifest. Got: %+v", manifest)
+	}
+	if !read.Created.Equal(archive.Created) || !reflect.DeepEqual(read.Users, archive.Users) {
+		t.Errorf("Unexpected users. Got: %+v, Expected: %+v", read.Users, archive.Users)
+	}
+	for tenant, snapshot := range archive.Tenants {
+		encoded, _ := json.Marshal(snapshot)
+		decoded, _ := json.Marshal(read.Tenants[tenant])
+		if !bytes.Equal(encoded, decoded) {
+			t.Errorf("Tenant %s changed in the archive. Got: %s, Expected: %s", tenant, decoded, encoded)
+		}
+	}
+}
+
+func TestRead_RejectsInvalidArchives(t *testing.T) {
+	valid := encode(t, testSource(t).Snapshot())
+	editManifest := func(edit func(manifest *Manifest)) []byte {
+		return rewrite(t, valid, func(name string, contents []byte) []byte {
+			if name != manifestName {
+				return contents
+			}
+			var manifest Manifest
+			json.Unmarshal(contents, &manifest)
+			edit(&manifest)
+			contents, _ = json.Marshal(manifest)
+			return contents
+		})
+	}
+
+	testCases := []struct {
+		testName string
+		archive  []byte
+	}{
+		{"Testing a file that isn't gzip compressed", []byte("not an archive")},
+		{"Testing a tampered file", rewrite(t, valid, func(name string, contents []byte) []byte {
+			if name == usersName {
+				return bytes.Replace(contents, []byte("alice"), []byte("mallory"), 1)
+			}
+			return contents
+		})},
+		{"Testing a missing file", rewrite(t, valid, func(name string, contents []byte) []byte {
+			if name == tenantsDir+"team-a.json" {
+				return nil
+			}
+			return contents
+		})},
+		{"Testing a missing manifest", rewrite(t, valid, func(name string, contents []byte) []byte {
+			if name == manifestName {
+				return nil
+			}
+			return contents
+		})},
+		{"Testing a file that isn't in the manifest", editManifest(func(manifest *Manifest) { manifest.Files = manifest.Files[:2] })},
+		{"Testing a newer format version", editManifest(func(manifest *Manifest) { manifest.Version = FormatVersion + 1 })},
+		{"Testing another format", editManifest(func(manifest *Manifest) { manifest.Format = "other" })},
+		{"Testing a truncated archive", valid[:len(valid)/2]},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if _, _, err := Read(bytes.NewReader(test.archive)); !errors.Is(err, ErrInvalidArchive) {
+				t.Errorf("An invalid archive was accepted. Got: %v", err)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	original := testSource(t)
+	archive := original.Snapshot()
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+	teamA := tenants.WithTenant(alice, "team-a")
+
+	// The server has moved on since the backup: Bob has registered and Alice has deleted the eggs and added tea
+	target := original
+	target.Users.Register("bob", "password123")
+	lists := target.Lists.GetTodoLists(teamA)
+	listId := lists[len(lists)-1].Id
+	target.Lists.DeleteTodoItem(teamA, listId, 1)
+	target.Lists.CreateTodoItem(teamA, listId, "Tea")
+
+	report, err := target.Restore(context.Background(), archive, Merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Users != 0 || report.Lists != 0 || report.Items != 0 || !slices.Equal(report.Tenants, []string{"default", "team-a"}) {
+		t.Errorf("Nothing should have been merged. Got: %+v", report)
+	}
+
+	report, err = target.Restore(context.Background(), archive, Replace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Users != 1 {
+		t.Errorf("Unexpected report. Got: %+v", report)
+	}
+	if _, ok := target.Users.GetUserByName("bob"); ok {
+		t.Error("A user registered after the backup survived replacing the data with it")
+	}
+	items, _ := target.Lists.GetAllTodoItems(teamA, listId)
+	if len(items) != 2 || items[0].Name != "Milk" || items[1].Name != "Eggs" {
+		t.Errorf("Unexpected items after replacing the data. Got: %v", items)
+	}
+
+	// Tenants the server doesn't serve are skipped
+	other := Source{Users: users.NewUserService(), Lists: tenants.NewRouter(func(string) *dataService.DataService { return dataService.NewDataService() }, tenants.Quota{})}
+	if report, _ := other.Restore(context.Background(), archive, Replace); !slices.Equal(report.SkippedTenants, []string{"team-a"}) {
+		t.Errorf("Unexpected skipped tenants. Got: %v", report.SkippedTenants)
+	}
+
+	target.Replicated = true
+	if _, err := target.Restore(context.Background(), archive, Replace); err != ErrReplicated {
+		t.Errorf("Restoring a replicated node should be refused. Got: %v", err)
+	}
+	if _, err := original.Restore(context.Background(), archive, "overwrite"); err != ErrUnknownMode {
+		t.Errorf("An unknown mode should be refused. Got: %v", err)
+	}
+}
+
+func TestScheduler_WritesAndRotates(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a backup"), 0o600)
+	source := testSource(t)
+	scheduler, err := NewScheduler(source, dir, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		scheduler.Run()
+		// Backups are named to the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 3 || !slices.Contains(names, "notes.txt") {
+		t.Fatalf("Expected two backups and the notes. Got: %v", names)
+	}
+
+	file, err := os.Open(filepath.Join(dir, names[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, _, err := Read(file); err != nil {
+		t.Errorf("A scheduled backup can't be read: %v", err)
+	}
+}
+
+func TestFileName_SortsByTime(t *testing.T) {
+	earlier := time.Date(2026, 9, 30, 23, 59, 59, 0, time.UTC)
+	later := time.Date(2026, 10, 1, 0, 0, 0, 1e6, time.FixedZone("CEST", 2*60*60))
+	if name := FileName(earlier); name != "todoapp-backup-20260930T235959000Z.tar.gz" {
+		t.Errorf("Unexpected file name. Got: %s", name)
+	}
+	if FileName(earlier) >= FileName(later.Add(3*time.Hour)) {
+		t.Error("File names don't sort in the order the backups were taken")
+	}
+}
+
+func TestRead_MigratesOlderSchemas(t *testing.T) {
+	previous := migrations
+	t.Cleanup(func() { migrations = previous })
+
+	// The archive is written before version 2, which shouts the items' names, and before archives recorded their
+	// schema version
+	migrations = migrate.NewRegistry()
+	older := rewrite(t, encode(t, testSource(t).Snapshot()), func(name string, contents []byte) []byte {
+		if name == manifestName {
+			var manifest map[string]any
+			json.Unmarshal(contents, &manifest)
+			delete(manifest, "SchemaVersion")
+			contents, _ = json.Marshal(manifest)
+		}
+		return contents
+	})
+
+	migrations = migrate.NewRegistry()
+	migrations.Register(migrate.Migration{Version: 2, Description: "shout item names", Up: func(state map[string]any) error {
+		return migrate.EachItem(state, func(item map[string]any) error {
+			item["Name"] = strings.ToUpper(item["Name"].(string))
+			return nil
+		})
+	}})
+	archive, manifest, err := Read(bytes.NewReader(older))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := archive.Tenants["team-a"].Lists
+	if items := lists[len(lists)-1].Items; manifest.SchemaVersion != 1 || len(items) != 2 || items[0].Name != "MILK" {
+		t.Errorf("The archive wasn't migrated. Got: version %d, %v", manifest.SchemaVersion, items)
+	}
+	newer := encode(t, archive)
+	if manifest := readManifest(t, newer); manifest.SchemaVersion != 2 {
+		t.Errorf("New archives should be written at the latest version. Got: %d", manifest.SchemaVersion)
+	}
+
+	// Archives from a newer server are refused
+	migrations = migrate.NewRegistry()
+	if _, _, err := Read(bytes.NewReader(newer)); !errors.Is(err, migrate.ErrNewerVersion) {
+		t.Errorf("An archive from a newer version was read. Got: %v", err)
+	}
+}
+
+func readManifest(t *testing.T, archive []byte) Manifest {
+	var manifest Manifest
+	rewrite(t, archive, func(name string, contents []byte) []byte {
+		if name == manifestName {
+			json.Unmarshal(contents, &manifest)
+		}
+		return contents
+	})
+	return manifest
+}
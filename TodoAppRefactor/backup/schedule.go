@@ -0,0 +1,148 @@
+package backup
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"todoApp/metrics"
+)
+
+// DefaultKeep is how many scheduled backups are kept when the number isn't configured.
+const DefaultKeep = 7
+
+var scheduledBackups = metrics.Default.NewCounterVec("todoapp_scheduled_backups_total",
+	"Scheduled backups, by result: written or failed.", "result")
+
+// Scheduler writes a backup to a directory at a fixed interval, deleting the oldest once there are more than it keeps.
+type Scheduler struct {
+	source   Source
+	dir      string
+	interval time.Duration
+	keep     int
+
+	stop    chan struct{}
+	once    sync.Once
+	workers sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler writing backups of source to dir every interval, keeping the newest 'keep' of them.
+// The directory is created if it doesn't exist.
+func NewScheduler(source Source, dir string, interval time.Duration, keep int) (*Scheduler, error) {
+	if interval <= 0 {
+		return nil, errors.New("the backup interval must be positive")
+	}
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Scheduler{source: source, dir: dir, interval: interval, keep: keep, stop: make(chan struct{})}, nil
+}
+
+// Start writes backups until Close is called. The first is written one interval after Start.
+func (scheduler *Scheduler) Start() {
+	scheduler.workers.Add(1)
+	go func() {
+		defer scheduler.workers.Done()
+		ticker := time.NewTicker(scheduler.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				scheduler.Run()
+			case <-scheduler.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops writing backups, waiting for one being written to finish.
+func (scheduler *Scheduler) Close() {
+	scheduler.once.Do(func() { close(scheduler.stop) })
+	scheduler.workers.Wait()
+}
+
+// Run writes a backup and rotates the directory now. Failures are logged rather than returned, as there is no one to
+// return them to.
+func (scheduler *Scheduler) Run() {
+	path, err := WriteFile(scheduler.dir, scheduler.source.Snapshot())
+	if err != nil {
+		scheduledBackups.With("failed").Inc()
+		slog.Error("error writing scheduled backup", "dir", scheduler.dir, "error", err)
+		return
+	}
+	scheduledBackups.With("written").Inc()
+	slog.Info("scheduled backup written", "path", path)
+
+	removed, err := Rotate(scheduler.dir, scheduler.keep)
+	if err != nil {
+		slog.Error("error removing old backups", "dir", scheduler.dir, "error", err)
+	}
+	for _, path := range removed {
+		slog.Info("old backup removed", "path", path)
+	}
+}
+
+// WriteFile writes an archive to a file in dir named by FileName, returning its path. The archive is written to a
+// temporary file first and renamed into place, so a backup that fails half way never looks like a complete one.
+func WriteFile(dir string, archive Archive) (string, error) {
+	path := filepath.Join(dir, FileName(archive.Created))
+	file, err := os.CreateTemp(dir, ".backup-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	if err := Write(file, archive); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(file.Name(), path)
+}
+
+// Rotate deletes all but the newest 'keep' backups in dir, returning the paths of those it deleted. Only files named
+// by FileName are counted, so nothing else in the directory is touched.
+func Rotate(dir string, keep int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, Format+"-") && strings.HasSuffix(name, ".tar.gz") {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= keep {
+		return nil, nil
+	}
+
+	// Names sort in the order the backups were written, oldest first
+	slices.Sort(backups)
+	var removed []string
+	var errs []error
+	for _, name := range backups[:len(backups)-keep] {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, path)
+	}
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package buildInfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// These are set at build time, for example:
+//
+//	go build -ldflags "-X todoApp/buildInfo.Version=1.2.0 -X todoApp/buildInfo.Commit=$(git rev-parse HEAD)"
+//
+// Anything left empty is filled in from the build information embedded by the Go toolchain where possible.
+var (
+	Version   string
+	Commit    string
+	BuildTime string
+)
+
+type Info struct {
+	Version   string
+	Commit    string
+	BuildTime string
+	Modified  bool
+	GoVersion string
+}
+
+// Get returns the build metadata for the running binary.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
@@ -0,0 +1,149 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCheckInterval is how often the certificate files are checked for changes.
+const DefaultCheckInterval = 5 * time.Second
+
+// Reloader serves the certificate in a pair of PEM files, picking up new files without a restart. The files are only
+// checked during handshakes, at most once per check interval, and reloaded when either has been modified. A
+// certificate that fails to load is logged and the previous one kept, so a half written renewal can't take the server
+// down.
+type Reloader struct {
+	certFile      string
+	keyFile       string
+	checkInterval time.Duration
+
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+	now         func() time.Time
+	mu          sync.Mutex
+}
+
+// NewReloader loads the certificate and key, failing if they can't be used.
+func NewReloader(certFile, keyFile string, checkInterval time.Duration) (*Reloader, error) {
+	reloader := &Reloader{certFile: certFile, keyFile: keyFile, checkInterval: checkInterval, now: time.Now}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	reloader.lastCheck = reloader.now()
+	return reloader, nil
+}
+
+// GetCertificate is used as the GetCertificate callback of a tls.Config.
+func (reloader *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	if now := reloader.now(); now.Sub(reloader.lastCheck) >= reloader.checkInterval {
+		reloader.lastCheck = now
+		if reloader.changed() {
+			if err := reloader.load(); err != nil {
+				slog.Error("error reloading certificate, keeping the previous one", "cert", reloader.certFile, "error", err)
+			} else {
+				slog.Info("reloaded certificate", "cert", reloader.certFile)
+			}
+		}
+	}
+	return reloader.cert, nil
+}
+
+// changed reports whether either file has been modified since the certificate was loaded. The caller must hold the
+// lock.
+func (reloader *Reloader) changed() bool {
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		return false
+	}
+	return !certModTime.Equal(reloader.certModTime) || !keyModTime.Equal(reloader.keyModTime)
+}
+
+// load reads both files. The caller must hold the lock, or be the constructor.
+func (reloader *Reloader) load() error {
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	reloader.cert = &cert
+	reloader.certModTime = certModTime
+	reloader.keyModTime = keyModTime
+	return nil
+}
+
+func (reloader *Reloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(reloader.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(reloader.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// SelfSigned generates a certificate for the given host names and IP addresses, signed by its own key. It is only
+// meant for development: browsers will warn about it, and it is regenerated every time the server starts.
+func SelfSigned(hosts []string, validFor time.Duration) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		return tls.Certificate{}, errors.New("a self-signed certificate needs at least one host")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"todoApp development"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,101 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePair(t *testing.T, cert tls.Certificate, certFile, keyFile string, modTime time.Time) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+func TestSelfSigned(t *testing.T) {
+	cert, err := SelfSigned([]string{"localhost", "127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("certificate is not valid for %s: %v", host, err)
+		}
+	}
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err == nil {
+		t.Error("certificate is valid for a host it wasn't generated for")
+	}
+
+	if _, err := SelfSigned(nil, time.Hour); err == nil {
+		t.Error("generated a certificate without any hosts")
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	modTime := time.Now().Add(-time.Hour)
+
+	first, _ := SelfSigned([]string{"localhost"}, time.Hour)
+	writePair(t, first, certFile, keyFile, modTime)
+
+	reloader, err := NewReloader(certFile, keyFile, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+	reloader.lastCheck = now
+
+	serving := func() *tls.Certificate {
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	if serial := serving().Leaf.SerialNumber; serial.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Fatalf("Unexpected certificate. Got serial: %v, Expected: %v", serial, first.Leaf.SerialNumber)
+	}
+
+	second, _ := SelfSigned([]string{"localhost"}, time.Hour)
+	writePair(t, second, certFile, keyFile, modTime.Add(time.Minute))
+	if serial := serving().Leaf.SerialNumber; serial.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Error("files were checked before the check interval passed")
+	}
+
+	now = now.Add(time.Minute)
+	if serial := serving().Leaf.SerialNumber; serial.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Errorf("certificate was not reloaded. Got serial: %v, Expected: %v", serial, second.Leaf.SerialNumber)
+	}
+
+	os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	os.Chtimes(certFile, modTime.Add(2*time.Minute), modTime.Add(2*time.Minute))
+	now = now.Add(time.Minute)
+	if serial := serving().Leaf.SerialNumber; serial.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Error("a broken certificate replaced the one being served")
+	}
+}
+
+func TestNewReloader_MissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), time.Minute); err == nil {
+		t.Error("created a reloader without a certificate")
+	}
+}
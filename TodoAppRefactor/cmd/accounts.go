@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"todoApp/auth"
+	"todoApp/logging"
+	"todoApp/tenants"
+	"todoApp/users"
+)
+
+// Accounts serves the pages used to register, log in and log out of the web frontend.
+type Accounts struct {
+	Users         *users.UserService
+	Sessions      *users.SessionStore
+	Site          *Site
+	SecureCookies bool
+}
+
+type loginPage struct {
+	Next  string
+	Error string
+	// CSRFToken is only set for visitors who are already logged in, whose requests are checked for it.
+	CSRFToken string
+}
+
+func (accounts *Accounts) LoginPageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accounts.renderLogin(w, r, http.StatusOK, "")
+	}
+}
+
+func (accounts *Accounts) LoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := accounts.Users.Authenticate(r.PostFormValue("username"), r.PostFormValue("password"))
+		if err != nil {
+			logging.FromContext(r.Context()).Info("failed login", "username", r.PostFormValue("username"))
+			accounts.renderLogin(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		accounts.startSession(w, r, user)
+	}
+}
+
+// RegisterHandler creates an account in the default tenant, whichever tenant the request is for. Anyone can register,
+// so only an admin can add users to other tenants.
+func (accounts *Accounts) RegisterHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := accounts.Users.Register(r.PostFormValue("username"), r.PostFormValue("password"))
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, users.ErrUsernameTaken) {
+				status = http.StatusConflict
+			}
+			accounts.renderLogin(w, r, status, err.Error())
+			return
+		}
+		if err := accounts.Users.JoinTenant(user.Id, tenants.DefaultTenant); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logging.FromContext(r.Context()).Info("user registered", "user", user.Id)
+		accounts.startSession(w, r, user)
+	}
+}
+
+func (accounts *Accounts) LogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+			accounts.Sessions.DeleteSession(principal.SessionId)
+		}
+		auth.ClearSessionCookie(w, accounts.SecureCookies)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
+
+func (accounts *Accounts) startSession(w http.ResponseWriter, r *http.Request, user users.User) {
+	session, err := accounts.Sessions.CreateSession(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth.SetSessionCookie(w, session, accounts.SecureCookies)
+	http.Redirect(w, r, safeRedirect(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+func (accounts *Accounts) renderLogin(w http.ResponseWriter, r *http.Request, status int, message string) {
+	page := loginPage{Next: safeRedirect(r.FormValue("next")), Error: message}
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		page.CSRFToken = principal.CSRFToken
+	}
+	if err := accounts.Site.Render(w, status, "login.html", page); err != nil {
+		logging.FromContext(r.Context()).Error("error rendering page", "page", "login.html", "error", err)
+	}
+}
+
+// safeRedirect only allows redirecting to a path on this site, so the login form can't be used as an open redirect.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
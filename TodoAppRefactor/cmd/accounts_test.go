@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"todoApp/api/contracts"
+	"todoApp/auth"
+	"todoApp/users"
+)
+
+func TestMain(m *testing.M) {
+	users.UseCheapHashing()
+	os.Exit(m.Run())
+}
+
+func newTestServer(t *testing.T, args ...string) *httptest.Server {
+	cfg, err := LoadConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site, err := NewSite(false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewHandler(cfg, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func noRedirectClient() *http.Client {
+	return &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+func TestRootHandler_RedirectsToLogin(t *testing.T) {
+	server := newTestServer(t)
+
+	resp, err := noRedirectClient().Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusSeeOther)
+	} else if location := resp.Header.Get("Location"); !strings.HasPrefix(location, "/login") {
+		t.Errorf("handler redirected to the wrong location. Got: %v", location)
+	}
+}
+
+func TestRegisterLoginAndLogout(t *testing.T) {
+	server := newTestServer(t)
+	client := noRedirectClient()
+
+	resp, err := client.PostForm(server.URL+"/register", url.Values{"username": {"carol"}, "password": {"password123"}, "next": {"/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("registering returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusSeeOther)
+	}
+
+	var sessionCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == auth.SessionCookieName {
+			sessionCookie = cookie
+		}
+	}
+	if sessionCookie == nil || !sessionCookie.HttpOnly {
+		t.Fatalf("registering did not set an HttpOnly session cookie. Got: %v", resp.Cookies())
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	req.AddCookie(sessionCookie)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("home page returned wrong status code with a session. Got: %v Want: %v", resp.StatusCode, http.StatusOK)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/logout", nil)
+	req.AddCookie(sessionCookie)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("logging out without a CSRF token returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusForbidden)
+	}
+
+	form := url.Values{auth.CSRFField: {csrfToken(t, sessionCookie)}}
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/logout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(sessionCookie)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	req.AddCookie(sessionCookie)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("session was still valid after logging out. Got: %v Want: %v", resp.StatusCode, http.StatusSeeOther)
+	}
+}
+
+func TestLogin_WrongPassword(t *testing.T) {
+	server := newTestServer(t)
+	Users.Register("dave", "password123")
+
+	resp, err := noRedirectClient().PostForm(server.URL+"/login", url.Values{"username": {"dave"}, "password": {"not it"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+// registerSession registers a user through the web frontend and returns their session cookie.
+func registerSession(t *testing.T, server *httptest.Server, username string) *http.Cookie {
+	resp, err := noRedirectClient().PostForm(server.URL+"/register", url.Values{"username": {username}, "password": {"password123"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == auth.SessionCookieName {
+			return cookie
+		}
+	}
+	t.Fatalf("registering did not set a session cookie. Got status: %v", resp.StatusCode)
+	return nil
+}
+
+// csrfToken returns the CSRF token of the session, which has to be sent with every change made using its cookie.
+func csrfToken(t *testing.T, sessionCookie *http.Cookie) string {
+	session, ok := Sessions.GetSession(sessionCookie.Value)
+	if !ok {
+		t.Fatal("session does not exist")
+	}
+	return session.CSRFToken
+}
+
+func TestAPIKeysAndTokens(t *testing.T) {
+	server := newTestServer(t)
+	sessionCookie := registerSession(t, server, "erin")
+
+	do := func(method, path, body string, setAuth func(*http.Request)) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if setAuth != nil {
+			setAuth(req)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	withSession := func(req *http.Request) {
+		req.AddCookie(sessionCookie)
+		req.Header.Set(auth.CSRFHeader, csrfToken(t, sessionCookie))
+	}
+
+	resp := do(http.MethodPost, "/todoapp/keys", `{"Name":"ci","Scope":"read"}`, withSession)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating a key returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusCreated)
+	}
+	var created contracts.CreatedAPIKeyContract
+	json.NewDecoder(resp.Body).Decode(&created)
+	withKey := func(req *http.Request) { req.Header.Set(auth.APIKeyHeader, created.Key) }
+
+	if resp := do(http.MethodGet, "/todoapp/keys", "", withKey); resp.StatusCode != http.StatusOK {
+		t.Errorf("read-only key could not read. Got: %v Want: %v", resp.StatusCode, http.StatusOK)
+	}
+	if resp := do(http.MethodPost, "/todoapp/keys", `{"Name":"more","Scope":"read-write"}`, withKey); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("read-only key was able to write. Got: %v Want: %v", resp.StatusCode, http.StatusForbidden)
+	}
+	if resp := do(http.MethodPost, "/todoapp/tokens", `{"Scope":"read-write"}`, withKey); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("read-only key was issued a read-write token. Got: %v Want: %v", resp.StatusCode, http.StatusForbidden)
+	}
+
+	resp = do(http.MethodPost, "/todoapp/tokens", "", withKey)
+	var token contracts.TokenContract
+	json.NewDecoder(resp.Body).Decode(&token)
+	if resp.StatusCode != http.StatusCreated || token.Scope != "read" {
+		t.Fatalf("exchanging a key for a token failed. Got: %v %+v", resp.StatusCode, token)
+	}
+	withToken := func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token.Token) }
+	if resp := do(http.MethodGet, "/todoapp/keys", "", withToken); resp.StatusCode != http.StatusOK {
+		t.Errorf("bearer token could not read. Got: %v Want: %v", resp.StatusCode, http.StatusOK)
+	}
+
+	resp = do(http.MethodPost, "/todoapp/tokens", "", withSession)
+	json.NewDecoder(resp.Body).Decode(&token)
+	if resp.StatusCode != http.StatusCreated || token.Scope != "read-write" {
+		t.Fatalf("exchanging a session for a token failed. Got: %v %+v", resp.StatusCode, token)
+	}
+	withToken = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token.Token) }
+	if resp := do(http.MethodPost, "/todoapp/tokens", "", withToken); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bearer token was exchanged for a new token. Got: %v Want: %v", resp.StatusCode, http.StatusForbidden)
+	}
+	if resp := do(http.MethodPost, "/todoapp/keys", `{"Name":"from-token","Scope":"read"}`, withToken); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bearer token was used to create an API key. Got: %v Want: %v", resp.StatusCode, http.StatusForbidden)
+	}
+
+	if resp := do(http.MethodDelete, "/todoapp/keys/"+created.Id, "", withSession); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("revoking a key returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusNoContent)
+	}
+	if resp := do(http.MethodGet, "/todoapp/keys", "", withKey); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("revoked key was still accepted. Got: %v Want: %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	testCases := []struct {
+		next     string
+		expected string
+	}{
+		{"/?list=2", "/?list=2"},
+		{"https://evil.example", "/"},
+		{"//evil.example", "/"},
+		{"/\\evil.example", "/"},
+		{"", "/"},
+	}
+
+	for _, test := range testCases {
+		if got := safeRedirect(test.next); got != test.expected {
+			t.Errorf("Unexpected redirect for %q. Got: %v, Expected: %v", test.next, got, test.expected)
+		}
+	}
+}
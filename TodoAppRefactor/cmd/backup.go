@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"todoApp/api/responses"
+	"todoApp/auth"
+	"todoApp/backup"
+)
+
+// client holds the flags the backup and restore commands share to reach a running server as an admin.
+type client struct {
+	server  string
+	apiKey  string
+	timeout time.Duration
+}
+
+func (c *client) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.server, "server", "http://localhost:8080", "base URL of the server")
+	fs.StringVar(&c.apiKey, "api-key", os.Getenv("TODOAPP_API_KEY"), "API key of an admin (defaults to $TODOAPP_API_KEY)")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Minute, "how long to wait for the server")
+}
+
+// post calls an admin route of the server, returning the body of a successful response.
+func (c *client) post(path string, contentType string, body io.Reader) ([]byte, error) {
+	if c.apiKey == "" {
+		return nil, errors.New("an admin's API key is needed, set -api-key or $TODOAPP_API_KEY")
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(c.server, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(auth.APIKeyHeader, c.apiKey)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := (&http.Client{Timeout: c.timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := io.ReadAll(io.LimitReader(resp.Body, backup.MaxArchiveBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var envelope responses.ErrorEnvelope
+		if json.Unmarshal(contents, &envelope) == nil && envelope.Error.Message != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, envelope.Error.Message)
+		}
+		return nil, errors.New(resp.Status)
+	}
+	return contents, nil
+}
+
+// BackupCommand runs 'todoApp backup', which downloads a backup from a running server, checks it and saves it. It
+// returns the exit code.
+func BackupCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	var c client
+	fs := flag.NewFlagSet("todoApp backup", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	c.register(fs)
+	out := fs.String("out", ".", "file to save the backup to, or a directory to save it in under a name with the time it was taken")
+	keep := fs.Int("keep", 0, "when -out is a directory, delete all but this many of the newest backups in it, 0 to keep them all")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	contents, err := c.post("/admin/backup", "", nil)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error taking backup:", err)
+		return 1
+	}
+	// Checking the archive before saving it means a saved backup is one that can be restored
+	archive, _, err := backup.Read(bytes.NewReader(contents))
+	if err != nil {
+		fmt.Fprintln(stderr, "Error checking backup:", err)
+		return 1
+	}
+
+	path, isDir := *out, false
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path, isDir = filepath.Join(path, backup.FileName(archive.Created)), true
+	}
+	if err := os.WriteFile(path, contents, 0o600); err != nil {
+		fmt.Fprintln(stderr, "Error saving backup:", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Saved backup of %d users and %d tenants to %s\n", len(archive.Users.Users), len(archive.Tenants), path)
+
+	if isDir && *keep > 0 {
+		removed, err := backup.Rotate(*out, *keep)
+		for _, path := range removed {
+			fmt.Fprintln(stdout, "Removed old backup", path)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, "Error removing old backups:", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// RestoreCommand runs 'todoApp restore', which checks a backup and restores it into a running server. It returns the
+// exit code.
+func RestoreCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	var c client
+	fs := flag.NewFlagSet("todoApp restore", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: todoApp restore [flags] <backup file>")
+		fs.PrintDefaults()
+	}
+	c.register(fs)
+	mode := fs.String("mode", string(backup.Replace), "'replace' to replace the server's data with the backup's, 'merge' to only add what the server doesn't have")
+	dryRun := fs.Bool("dry-run", false, "only check the backup and show what it holds, without contacting the server")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if !backup.Mode(*mode).Valid() {
+		fmt.Fprintln(stderr, backup.ErrUnknownMode)
+		return 2
+	}
+
+	contents, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, "Error reading backup:", err)
+		return 1
+	}
+	archive, manifest, err := backup.Read(bytes.NewReader(contents))
+	if err != nil {
+		fmt.Fprintln(stderr, "Error checking backup:", err)
+		return 1
+	}
+	if *dryRun {
+		fmt.Fprintf(stdout, "Backup taken %s, format version %d, is valid\n", archive.Created.Format(time.RFC3339), manifest.Version)
+		fmt.Fprintf(stdout, "  %d users\n", len(archive.Users.Users))
+		for _, tenant := range manifest.Tenants {
+			snapshot := archive.Tenants[tenant]
+			items := 0
+			for _, list := range snapshot.Lists {
+				items += len(list.Items)
+			}
+			fmt.Fprintf(stdout, "  tenant %s: %d lists, %d items\n", tenant, len(snapshot.Lists), items)
+		}
+		return 0
+	}
+
+	body, err := c.post("/admin/restore?mode="+*mode, "application/gzip", bytes.NewReader(contents))
+	if err != nil {
+		fmt.Fprintln(stderr, "Error restoring backup:", err)
+		return 1
+	}
+	var report backup.Report
+	if err := json.Unmarshal(body, &report); err != nil {
+		fmt.Fprintln(stderr, "Error reading the server's report:", err)
+		return 1
+	}
+	verb := "Restored"
+	if report.Mode == backup.Merge {
+		verb = "Merged, adding"
+	}
+	fmt.Fprintf(stdout, "%s %d users, %d lists and %d items into tenants %s\n", verb, report.Users, report.Lists, report.Items, strings.Join(report.Tenants, ", "))
+	if len(report.SkippedTenants) > 0 {
+		fmt.Fprintf(stdout, "Skipped tenants the server doesn't serve: %s\n", strings.Join(report.SkippedTenants, ", "))
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"todoApp/auth"
+	"todoApp/tenants"
+)
+
+func TestBackupAndRestoreCommands(t *testing.T) {
+	server := newTestServer(t, "-admins", "ivan")
+	registerSession(t, server, "ivan")
+	registerSession(t, server, "judy")
+	apiKey := func(username string) string {
+		user, _ := Users.GetUserByName(username)
+		_, secret, err := APIKeys.CreateAPIKey(user.Id, tenants.DefaultTenant, "backups", auth.ScopeReadWrite, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	admin := apiKey("ivan")
+	dir := t.TempDir()
+
+	var stdout, stderr bytes.Buffer
+	if code := BackupCommand([]string{"-server", server.URL, "-api-key", apiKey("judy"), "-out", dir}, &stdout, &stderr); code != 1 || !strings.Contains(stderr.String(), "403") {
+		t.Errorf("A user who isn't an admin took a backup. Got: %d, %s", code, stderr.String())
+	}
+	for range 2 {
+		stdout.Reset()
+		if code := BackupCommand([]string{"-server", server.URL, "-api-key", admin, "-out", dir, "-keep", "1"}, &stdout, &stderr); code != 0 {
+			t.Fatalf("Unexpected exit code %d: %s", code, stderr.String())
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "todoapp-backup-*.tar.gz"))
+	if len(backups) != 1 || !strings.Contains(stdout.String(), "Removed old backup") {
+		t.Fatalf("Expected the older backup to be rotated away. Got: %v, %s", backups, stdout.String())
+	}
+
+	stdout.Reset()
+	if code := RestoreCommand([]string{"-dry-run", backups[0]}, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "tenant default:") {
+		t.Errorf("Unexpected dry run. Got: %d, %s%s", code, stdout.String(), stderr.String())
+	}
+	stdout.Reset()
+	if code := RestoreCommand([]string{"-server", server.URL, "-api-key", admin, "-mode", "merge", backups[0]}, &stdout, &stderr); code != 0 || !strings.HasPrefix(stdout.String(), "Merged, adding 0 users") {
+		t.Errorf("Unexpected restore. Got: %d, %s%s", code, stdout.String(), stderr.String())
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.tar.gz")
+	os.WriteFile(corrupt, []byte("not a backup"), 0o600)
+	if code := RestoreCommand([]string{"-dry-run", corrupt}, &stdout, &stderr); code != 1 {
+		t.Errorf("An invalid backup passed the dry run. Got: %d", code)
+	}
+	if code := RestoreCommand([]string{"-mode", "overwrite", backups[0]}, &stdout, &stderr); code != 2 {
+		t.Errorf("An unknown mode was accepted. Got: %d", code)
+	}
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"todoApp/api"
+	"todoApp/api/middleware"
+	"todoApp/backup"
+	"todoApp/persist"
+	"todoApp/replication"
+	"todoApp/tenants"
+	"todoApp/users"
+	"todoApp/validation"
+	"todoApp/webhooks"
+)
+
+type Config struct {
+	Addr string
+	// TLSCert and TLSKey are PEM files to serve HTTPS with, reloaded when they change. DevTLS serves HTTPS with a
+	// self-signed certificate for localhost instead. RedirectAddr, if set, is where plain HTTP requests are accepted and
+	// redirected to HTTPS.
+	TLSCert      string
+	TLSKey       string
+	DevTLS       bool
+	RedirectAddr string
+
+	QueueDepth int
+	// MaxBodyBytes is the largest JSON request body the API reads.
+	MaxBodyBytes int64
+	RetryAfter   time.Duration
+
+	ReadinessTimeout time.Duration
+	// EventHeartbeat is how often idle event streams are sent a comment, and WebSockets a ping, to keep them open.
+	EventHeartbeat time.Duration
+
+	SessionTTL    time.Duration
+	SecureCookies bool
+
+	// TokenKey signs bearer tokens. When it is empty a random key is generated at startup, so tokens don't survive a
+	// restart and can't be verified by other instances.
+	TokenKey []byte
+	// Admins are the usernames of the users who can manage which tenants users are members of and read the audit log.
+	Admins []string
+
+	// Tenants are served alongside the default tenant, each with its own data. TenantHeader and BaseDomain configure
+	// how a request's tenant is resolved.
+	Tenants      []string
+	TenantHeader string
+	BaseDomain   string
+	TenantQuota  tenants.Quota
+
+	// AuditDir, when set, is where the audit log is kept so that it outlives the server, defaulting to DataDir. It is
+	// allowed with -peers, each node keeps the log of the changes made through it.
+	AuditDir string
+
+	// ReadLimit and WriteLimit are the rate limits of each client, identified by API key, user or IP. Buckets of clients
+	// that have been idle for RateLimitIdle are dropped.
+	ReadLimit     RateLimit
+	WriteLimit    RateLimit
+	RateLimitIdle time.Duration
+
+	// CORS lets browser clients on other origins call the '/todoapp' API.
+	CORS middleware.CORSConfig
+
+	// Webhooks says how hard list events are retried before they're parked as dead letters.
+	Webhooks webhooks.Config
+
+	// Replication, when it has peers, makes the server one node of a cluster that replicates list data, so that the
+	// app keeps working when a node goes down.
+	Replication replication.Config
+
+	// DataDir, when set, is where the users and list data are saved every SaveInterval and when the server shuts down,
+	// and loaded from when it starts. A state file from an older schema version is migrated at startup, unless
+	// AutoMigrate is off.
+	DataDir      string
+	SaveInterval time.Duration
+	AutoMigrate  bool
+
+	// Store is where list data is kept: StoreMemory, the default, or StoreKV, which keeps each tenant's lists in a
+	// key-value store file in KVDir, written before every change returns. KVDir defaults to DataDir. The users are
+	// still saved to the state file there, and the copy of the lists in it is ignored at startup. Replicated nodes
+	// rebuild their data from the leader, so StoreKV can't be used with -peers.
+	Store string
+	KVDir string
+
+	// BackupDir, when set, is where a backup is written every BackupInterval. Only the newest BackupKeep are kept.
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+
+	Dev    bool
+	WebDir string
+
+	LogFormat       string
+	LogLevel        string
+	ShutdownTimeout time.Duration
+}
+
+// Places list data can be kept, see Config.Store.
+const (
+	StoreMemory = "memory"
+	StoreKV     = "kv"
+)
+
+// RateLimit allows Rate requests per second with bursts of up to Burst. A rate of 0 means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// LoadConfig builds the server configuration from the command line arguments, falling back to defaults for
+// anything not provided.
+func LoadConfig(args []string) (Config, error) {
+	var cfg Config
+	fs := flag.NewFlagSet("todoApp", flag.ContinueOnError)
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "address for the server to listen on")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "PEM certificate file to serve HTTPS with, reloaded when it changes")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "PEM private key file for -tls-cert")
+	fs.BoolVar(&cfg.DevTLS, "dev-tls", false, "serve HTTPS with a self-signed certificate for localhost, generated at startup")
+	fs.StringVar(&cfg.RedirectAddr, "http-redirect-addr", "", "address to accept plain HTTP on and redirect it to HTTPS, e.g. :80")
+	fs.IntVar(&cfg.QueueDepth, "queue-depth", api.DefaultQueueDepth, "maximum number of commands waiting on the request handler")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", validation.DefaultMaxBodyBytes, "largest JSON request body the API accepts, in bytes")
+	fs.DurationVar(&cfg.RetryAfter, "retry-after", api.DefaultRetryAfter, "Retry-After sent to clients when the queue is full")
+	fs.DurationVar(&cfg.ReadinessTimeout, "readiness-timeout", api.DefaultReadinessTimeout, "how long /readyz waits for each check")
+	fs.DurationVar(&cfg.EventHeartbeat, "event-heartbeat", api.DefaultHeartbeat, "how often idle event streams and WebSockets are sent a keep-alive")
+	fs.DurationVar(&cfg.SessionTTL, "session-ttl", users.DefaultSessionTTL, "how long a login session lasts")
+	fs.BoolVar(&cfg.SecureCookies, "secure-cookies", true, "only send the session cookie over HTTPS (browsers make an exception for localhost)")
+	tokenKey := fs.String("token-key", os.Getenv("TODOAPP_TOKEN_KEY"), "hex encoded key, at least 32 bytes, used to sign bearer tokens (defaults to $TODOAPP_TOKEN_KEY)")
+	adminList := fs.String("admins", "", "comma separated usernames of the users with admin access")
+	tenantList := fs.String("tenants", "", "comma separated IDs of the tenants to serve, as well as the default tenant")
+	fs.StringVar(&cfg.TenantHeader, "tenant-header", tenants.DefaultHeader, "header naming the tenant of a request")
+	fs.StringVar(&cfg.BaseDomain, "base-domain", "", "resolve tenants from subdomains of this domain, e.g. team-a.<base-domain>")
+	fs.IntVar(&cfg.TenantQuota.MaxItems, "tenant-max-items", 10000, "maximum number of items each tenant can store, 0 for no limit")
+	fs.Float64Var(&cfg.TenantQuota.RequestsPerSecond, "tenant-rate", 100, "API requests per second allowed for each tenant, 0 for no limit")
+	fs.IntVar(&cfg.TenantQuota.Burst, "tenant-burst", 200, "API requests each tenant can make in a burst above its rate")
+	fs.StringVar(&cfg.AuditDir, "audit-dir", "", "directory to keep the audit log in (defaults to -data-dir), it is only kept in memory if neither is set")
+	fs.Float64Var(&cfg.ReadLimit.Rate, "rate-read", 20, "reads per second allowed for each client, 0 for no limit")
+	fs.IntVar(&cfg.ReadLimit.Burst, "rate-read-burst", 40, "reads each client can make in a burst above its rate")
+	fs.Float64Var(&cfg.WriteLimit.Rate, "rate-write", 5, "writes per second allowed for each client, 0 for no limit")
+	fs.IntVar(&cfg.WriteLimit.Burst, "rate-write-burst", 10, "writes each client can make in a burst above its rate")
+	fs.DurationVar(&cfg.RateLimitIdle, "rate-idle-timeout", 10*time.Minute, "how long a client's rate limit is remembered after its last request")
+	corsOrigins := fs.String("cors-origins", "", "comma separated origins allowed to call the API from a browser, e.g. https://app.example.com,https://*.example.com")
+	corsMethods := fs.String("cors-methods", "GET,POST,PUT,PATCH,DELETE", "comma separated methods browsers on the allowed origins may use")
+	corsHeaders := fs.String("cors-headers", "Content-Type,Authorization,X-API-Key,X-CSRF-Token,X-Request-ID", "comma separated request headers browsers on the allowed origins may send, as well as the tenant header")
+	fs.BoolVar(&cfg.CORS.AllowCredentials, "cors-credentials", false, "let browsers on the allowed origins send the session cookie")
+	fs.DurationVar(&cfg.CORS.MaxAge, "cors-max-age", 10*time.Minute, "how long browsers may cache the answer to a preflight request")
+	fs.IntVar(&cfg.Webhooks.MaxAttempts, "webhook-attempts", webhooks.DefaultMaxAttempts, "how many times a webhook delivery is tried before it's parked as a dead letter")
+	fs.DurationVar(&cfg.Webhooks.BaseDelay, "webhook-backoff", webhooks.DefaultBaseDelay, "wait before the first webhook retry, doubling with each attempt")
+	fs.DurationVar(&cfg.Webhooks.MaxDelay, "webhook-max-backoff", webhooks.DefaultMaxDelay, "longest wait between webhook retries")
+	fs.DurationVar(&cfg.Webhooks.Timeout, "webhook-timeout", webhooks.DefaultTimeout, "how long a webhook receiver has to answer")
+	fs.BoolVar(&cfg.Webhooks.AllowPrivate, "webhook-allow-private", false, "let webhooks call loopback and private network addresses")
+	fs.StringVar(&cfg.Replication.NodeId, "node-id", "", "ID of this node in the replication cluster")
+	peerList := fs.String("peers", "", "comma separated IDs and URLs of the other nodes in the replication cluster, e.g. b=http://10.0.0.2:8080,c=http://10.0.0.3:8080")
+	fs.StringVar(&cfg.Replication.Secret, "replication-secret", os.Getenv("TODOAPP_REPLICATION_SECRET"), "secret shared by the nodes of the replication cluster (defaults to $TODOAPP_REPLICATION_SECRET)")
+	fs.DurationVar(&cfg.Replication.HeartbeatInterval, "heartbeat-interval", replication.DefaultHeartbeatInterval, "how often the replication leader tells the other nodes it is still there")
+	fs.DurationVar(&cfg.Replication.ElectionTimeout, "election-timeout", replication.DefaultElectionTimeout, "how long nodes wait to hear from the replication leader before electing another")
+	fs.DurationVar(&cfg.Replication.CommitTimeout, "commit-timeout", replication.DefaultCommitTimeout, "how long a write waits for a majority of the replication cluster to confirm it")
+	fs.StringVar(&cfg.DataDir, "data-dir", "", "directory to save users and list data in and load them from at startup, nothing is saved if it isn't set")
+	fs.DurationVar(&cfg.SaveInterval, "save-interval", persist.DefaultSaveInterval, "how often the state is saved to -data-dir")
+	fs.BoolVar(&cfg.AutoMigrate, "auto-migrate", true, "migrate a state file from an older schema version at startup, rather than refusing to start")
+	fs.StringVar(&cfg.Store, "store", StoreMemory, "where list data is kept, either memory or kv")
+	fs.StringVar(&cfg.KVDir, "kv-dir", "", "directory of the key-value store files list data is kept in with -store=kv (defaults to -data-dir)")
+	fs.StringVar(&cfg.BackupDir, "backup-dir", "", "directory to write scheduled backups to, none are written if it isn't set")
+	fs.DurationVar(&cfg.BackupInterval, "backup-interval", 24*time.Hour, "how often a scheduled backup is written to -backup-dir")
+	fs.IntVar(&cfg.BackupKeep, "backup-keep", backup.DefaultKeep, "how many scheduled backups are kept in -backup-dir, older ones are deleted")
+	fs.BoolVar(&cfg.Dev, "dev", false, "serve the web frontend from disk and reload templates on every request")
+	fs.StringVar(&cfg.WebDir, "web-dir", "cmd/web", "directory the web frontend is read from in dev mode")
+	fs.StringVar(&cfg.LogFormat, "log-format", "text", "log output format, either text or json")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "minimum level to log (debug, info, warn, error)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for requests to finish when shutting down")
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *tokenKey != "" {
+		key, err := hex.DecodeString(*tokenKey)
+		if err != nil || len(key) < 32 {
+			return Config{}, errors.New("token key must be at least 32 bytes of hex")
+		}
+		cfg.TokenKey = key
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return Config{}, errors.New("-tls-cert and -tls-key must be set together")
+	}
+	if cfg.DevTLS && cfg.TLSCert != "" {
+		return Config{}, errors.New("-dev-tls can't be used with -tls-cert")
+	}
+	if cfg.RedirectAddr != "" && !cfg.TLS() {
+		return Config{}, errors.New("-http-redirect-addr needs -tls-cert or -dev-tls")
+	}
+	if cfg.EventHeartbeat <= 0 {
+		return Config{}, errors.New("-event-heartbeat must be positive")
+	}
+	if cfg.Webhooks.MaxAttempts <= 0 || cfg.Webhooks.BaseDelay <= 0 || cfg.Webhooks.Timeout <= 0 {
+		return Config{}, errors.New("-webhook-attempts, -webhook-backoff and -webhook-timeout must be positive")
+	}
+	if cfg.Webhooks.MaxDelay < cfg.Webhooks.BaseDelay {
+		return Config{}, errors.New("-webhook-max-backoff can't be less than -webhook-backoff")
+	}
+	peers, err := parsePeers(*peerList)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Replication.Peers = peers
+	if len(peers) > 0 {
+		if cfg.Replication.NodeId == "" || cfg.Replication.Secret == "" {
+			return Config{}, errors.New("-peers needs -node-id and -replication-secret")
+		}
+		if _, isPeer := peers[cfg.Replication.NodeId]; isPeer {
+			return Config{}, errors.New("-peers can't include this node's -node-id")
+		}
+	}
+	if cfg.Replication.HeartbeatInterval <= 0 || cfg.Replication.CommitTimeout <= 0 {
+		return Config{}, errors.New("-heartbeat-interval and -commit-timeout must be positive")
+	}
+	if cfg.Replication.ElectionTimeout < 2*cfg.Replication.HeartbeatInterval {
+		return Config{}, errors.New("-election-timeout must be at least twice -heartbeat-interval")
+	}
+	if cfg.DataDir != "" && len(peers) > 0 {
+		return Config{}, errors.New("-data-dir can't be used with -peers, replicated nodes rebuild their data from the leader")
+	}
+	switch cfg.Store {
+	case StoreMemory:
+	case StoreKV:
+		if cfg.KVDir == "" {
+			cfg.KVDir = cfg.DataDir
+		}
+		if cfg.KVDir == "" {
+			return Config{}, errors.New("-store=kv needs -kv-dir or -data-dir")
+		}
+		if len(peers) > 0 {
+			return Config{}, errors.New("-store=kv can't be used with -peers, replicated nodes rebuild their data from the leader")
+		}
+	default:
+		return Config{}, errors.New("-store must be either memory or kv")
+	}
+	if cfg.AuditDir == "" {
+		cfg.AuditDir = cfg.DataDir
+	}
+	if cfg.SaveInterval <= 0 {
+		return Config{}, errors.New("-save-interval must be positive")
+	}
+	if cfg.BackupInterval <= 0 || cfg.BackupKeep <= 0 {
+		return Config{}, errors.New("-backup-interval and -backup-keep must be positive")
+	}
+	cfg.CORS.AllowedOrigins = splitList(*corsOrigins)
+	cfg.CORS.AllowedMethods = splitList(*corsMethods)
+	cfg.CORS.AllowedHeaders = append(splitList(*corsHeaders), cfg.TenantHeader)
+	if cfg.CORS.AllowCredentials && slices.Contains(cfg.CORS.AllowedOrigins, "*") {
+		return Config{}, errors.New("-cors-credentials can't be used with the '*' origin")
+	}
+	cfg.Admins = splitList(*adminList)
+	cfg.Tenants = splitList(*tenantList)
+	return cfg, nil
+}
+
+// TLS reports whether the server serves HTTPS.
+func (cfg Config) TLS() bool {
+	return cfg.TLSCert != "" || cfg.DevTLS
+}
+
+// parsePeers parses the -peers flag, a comma separated list of node IDs and the base URLs they are reached at.
+func parsePeers(value string) (map[string]string, error) {
+	peers := map[string]string{}
+	for _, entry := range splitList(value) {
+		id, address, found := strings.Cut(entry, "=")
+		peer, err := url.Parse(address)
+		if !found || id == "" || err != nil || (peer.Scheme != "http" && peer.Scheme != "https") || peer.Host == "" {
+			return nil, fmt.Errorf("-peers entry %q must be an ID and an http or https URL, e.g. b=http://10.0.0.2:8080", entry)
+		}
+		peers[id] = strings.TrimSuffix(address, "/")
+	}
+	return peers, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
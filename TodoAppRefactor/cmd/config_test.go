@@ -0,0 +1,93 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadConfig_ReplicationFlags(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-node-id", "a", "-peers", "b=http://10.0.0.2:8080/, c=https://10.0.0.3", "-replication-secret", "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers := cfg.Replication.Peers; len(peers) != 2 || peers["b"] != "http://10.0.0.2:8080" || peers["c"] != "https://10.0.0.3" {
+		t.Errorf("Unexpected peers. Got: %v", peers)
+	}
+
+	testCases := []struct {
+		testName string
+		args     []string
+	}{
+		{"Testing peers without a node ID", []string{"-peers", "b=http://10.0.0.2:8080", "-replication-secret", "secret"}},
+		{"Testing peers without a secret", []string{"-node-id", "a", "-peers", "b=http://10.0.0.2:8080", "-replication-secret", ""}},
+		{"Testing a peer without a URL", []string{"-node-id", "a", "-peers", "b", "-replication-secret", "secret"}},
+		{"Testing a peer that isn't http", []string{"-node-id", "a", "-peers", "b=ftp://10.0.0.2", "-replication-secret", "secret"}},
+		{"Testing this node as a peer", []string{"-node-id", "a", "-peers", "a=http://10.0.0.1:8080", "-replication-secret", "secret"}},
+		{"Testing an election timeout shorter than the heartbeat", []string{"-heartbeat-interval", "1s", "-election-timeout", "1s"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if _, err := LoadConfig(test.args); err == nil {
+				t.Error("invalid replication flags were accepted")
+			}
+		})
+	}
+}
+
+func TestLoadConfig_BackupFlags(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-backup-dir", "/var/backups/todoapp", "-backup-interval", "6h", "-backup-keep", "28"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BackupDir != "/var/backups/todoapp" || cfg.BackupInterval != 6*time.Hour || cfg.BackupKeep != 28 {
+		t.Errorf("Unexpected backup config. Got: %s, %s, %d", cfg.BackupDir, cfg.BackupInterval, cfg.BackupKeep)
+	}
+	if _, err := LoadConfig([]string{"-backup-keep", "0"}); err == nil {
+		t.Error("keeping no backups was accepted")
+	}
+}
+
+func TestLoadConfig_DataDirFlags(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-data-dir", "/var/lib/todoapp", "-save-interval", "30s", "-auto-migrate=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DataDir != "/var/lib/todoapp" || cfg.SaveInterval != 30*time.Second || cfg.AutoMigrate {
+		t.Errorf("Unexpected data directory config. Got: %s, %s, %t", cfg.DataDir, cfg.SaveInterval, cfg.AutoMigrate)
+	}
+	if cfg.AuditDir != cfg.DataDir {
+		t.Errorf("Unexpected audit directory. Got: %s, Expected: %s", cfg.AuditDir, cfg.DataDir)
+	}
+	if cfg.Store != StoreMemory {
+		t.Errorf("Unexpected store. Got: %s", cfg.Store)
+	}
+	cfg, err = LoadConfig([]string{"-data-dir", "/var/lib/todoapp", "-store", "kv"})
+	if err != nil || cfg.KVDir != "/var/lib/todoapp" {
+		t.Errorf("Unexpected key-value store directory. Got: %s, %v", cfg.KVDir, err)
+	}
+	// Each node of a replication cluster keeps its own audit log
+	cfg, err = LoadConfig([]string{"-audit-dir", "/var/lib/todoapp/audit", "-node-id", "a", "-peers", "b=http://10.0.0.2:8080", "-replication-secret", "secret"})
+	if err != nil || cfg.AuditDir != "/var/lib/todoapp/audit" {
+		t.Errorf("Unexpected audit directory with replication. Got: %s, %v", cfg.AuditDir, err)
+	}
+
+	testCases := []struct {
+		testName string
+		args     []string
+	}{
+		{"Testing a data directory with replication", []string{"-data-dir", "/var/lib/todoapp", "-node-id", "a", "-peers", "b=http://10.0.0.2:8080", "-replication-secret", "secret"}},
+		{"Testing a save interval that isn't positive", []string{"-data-dir", "/var/lib/todoapp", "-save-interval", "0"}},
+		{"Testing an unknown store", []string{"-store", "disk"}},
+		{"Testing a key-value store without a directory", []string{"-store", "kv"}},
+		{"Testing a key-value store with replication", []string{"-store", "kv", "-kv-dir", "/var/lib/todoapp", "-node-id", "a", "-peers", "b=http://10.0.0.2:8080", "-replication-secret", "secret"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if _, err := LoadConfig(test.args); err == nil {
+				t.Error("invalid data directory flags were accepted")
+			}
+		})
+	}
+}
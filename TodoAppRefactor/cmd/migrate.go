@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+	"todoApp/backup"
+	"todoApp/persist"
+)
+
+// loadState migrates the state file in the data directory if it needs it, loads it, and returns a saver that keeps it
+// up to date. The server starts with no saved state if there isn't a state file yet.
+func loadState(cfg Config) (*persist.Saver, error) {
+	status, err := persist.Inspect(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(status.Pending) > 0 {
+		if !cfg.AutoMigrate {
+			return nil, fmt.Errorf("%w from version %d to %d, run 'todoApp migrate -data-dir %s'", persist.ErrNeedsMigration, status.SchemaVersion, status.Latest, cfg.DataDir)
+		}
+		result, err := persist.Migrate(cfg.DataDir, false)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("state file migrated", "from", status.SchemaVersion, "to", result.Latest, "migrations", len(result.Applied), "backup", result.Backup)
+	}
+
+	archive, err := persist.Load(cfg.DataDir)
+	switch {
+	case errors.Is(err, persist.ErrNoStateFile):
+		slog.Info("no state file yet, it will be created by the first save", "dir", cfg.DataDir)
+	case err != nil:
+		return nil, err
+	default:
+		if cfg.Store == StoreKV {
+			// The lists are in the key-value stores, and are only saved to the state file as a copy
+			archive.Tenants = nil
+		}
+		// A tenant the server doesn't serve would be dropped by the next save
+		for tenant := range archive.Tenants {
+			if !DataService.Exists(tenant) {
+				return nil, fmt.Errorf("the state has tenant %q, which isn't in -tenants", tenant)
+			}
+		}
+		report, err := backupSource().Restore(context.Background(), archive, backup.Replace)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("state loaded", "dir", cfg.DataDir, "saved", archive.Created, "users", report.Users, "lists", report.Lists, "items", report.Items)
+	}
+	return persist.NewSaver(backupSource(), cfg.DataDir, cfg.SaveInterval)
+}
+
+// MigrateCommand runs 'todoApp migrate', which reports the schema version of the state file in a data directory and
+// migrates it to the latest. It returns the exit code.
+func MigrateCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("todoApp migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("data-dir", "", "data directory of the server, as given to it with -data-dir")
+	statusOnly := fs.Bool("status", false, "only report the schema version and the migrations pending")
+	dryRun := fs.Bool("dry-run", false, "run the migrations and check the result without writing anything")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" {
+		fmt.Fprintln(stderr, "-data-dir is required")
+		return 2
+	}
+
+	status, err := persist.Inspect(*dir)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error reading state file:", err)
+		return 1
+	}
+	if !status.Exists {
+		fmt.Fprintf(stdout, "There is no state file at %s, nothing to migrate\n", status.Path)
+		return 0
+	}
+	fmt.Fprintf(stdout, "State file %s, saved %s\n", status.Path, status.Saved.Format(time.RFC3339))
+	fmt.Fprintf(stdout, "Schema version %d, the latest is %d\n", status.SchemaVersion, status.Latest)
+	if len(status.Pending) == 0 {
+		fmt.Fprintln(stdout, "The state file is up to date")
+		return 0
+	}
+	fmt.Fprintln(stdout, "Pending migrations:")
+	for _, migration := range status.Pending {
+		fmt.Fprintf(stdout, "  %d  %s\n", migration.Version, migration.Description)
+	}
+	if *statusOnly {
+		return 0
+	}
+
+	result, err := persist.Migrate(*dir, *dryRun)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error migrating state file:", err)
+		return 1
+	}
+	if *dryRun {
+		fmt.Fprintf(stdout, "Dry run: %s would take the state file to version %d, nothing was written\n", plural(len(result.Applied), "migration"), result.Latest)
+		return 0
+	}
+	fmt.Fprintf(stdout, "Migrated the state file to version %d, the previous one was copied to %s\n", result.Latest, result.Backup)
+	return 0
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprint(count, " ", noun, "s")
+}
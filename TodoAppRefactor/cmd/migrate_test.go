@@ -0,0 +1,28 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"todoApp/backup"
+	"todoApp/persist"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	dir := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	if code := MigrateCommand(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("The command ran without a data directory. Got: %d", code)
+	}
+	if code := MigrateCommand([]string{"-data-dir", dir}, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "nothing to migrate") {
+		t.Errorf("Unexpected output for a data directory without a state file. Got: %d, %s%s", code, stdout.String(), stderr.String())
+	}
+
+	if err := persist.Save(dir, backup.Archive{}); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if code := MigrateCommand([]string{"-data-dir", dir, "-status"}, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "up to date") {
+		t.Errorf("Unexpected status of a state file at the latest version. Got: %d, %s%s", code, stdout.String(), stderr.String())
+	}
+}
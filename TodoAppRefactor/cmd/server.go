@@ -1,87 +1,370 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"html/template"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"todoApp/api"
-	"todoApp/api/responses"
+	"todoApp/api/middleware"
+	"todoApp/audit"
+	"todoApp/auth"
+	"todoApp/backup"
+	"todoApp/data"
+	"todoApp/events"
+	"todoApp/logging"
+	"todoApp/metrics"
+	"todoApp/persist"
+	"todoApp/ratelimit"
+	"todoApp/replication"
 	dataService "todoApp/services"
+	"todoApp/tenants"
+	"todoApp/users"
+	"todoApp/webhooks"
+	"todoApp/websocket"
 )
 
 var (
 	wg          sync.WaitGroup
-	DataService = dataService.NewDataService()
+	Events      = events.NewHubs(events.DefaultBufferSize)
+	Presence    = events.NewPresence()
+	DataService = tenants.NewRouter(newDataService, tenants.Quota{})
+	Users       = users.NewUserService()
+	Sessions    = users.NewSessionStore(users.DefaultSessionTTL)
+	APIKeys     = auth.NewAPIKeyStore()
+	Webhooks    = webhooks.NewService(webhooks.Config{})
+	// Store is what list data is read and written through: DataService itself, or the replication node in front of it
+	// when the server is one node of a cluster.
+	Store   dataService.IDataService = DataService
+	Replica *replication.Node
 )
 
-func StartServer() {
-	http.Handle("/stylesheets/", http.StripPrefix("/stylesheets/", http.FileServer(http.Dir("cmd/web/stylesheets"))))
-	http.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.Dir("cmd/web/images"))))
+// newDataService creates the data service for a tenant, publishing its changes to the tenant's event hub.
+func newDataService(tenant string) *dataService.DataService {
+	return dataService.NewDataService().PublishTo(Events.For(tenant), tenant)
+}
+
+func init() {
+	metrics.RegisterRuntimeMetrics(metrics.Default)
+	metrics.Default.NewGaugeFunc("todoapp_items", "Number of todo items in the store, by state.", []string{"state"}, func() []metrics.Sample {
+		complete, incomplete := DataService.CountItems(context.Background())
+		return []metrics.Sample{
+			{LabelValues: []string{"complete"}, Value: float64(complete)},
+			{LabelValues: []string{"incomplete"}, Value: float64(incomplete)},
+		}
+	})
+}
+
+func StartServer(cfg Config) {
+	logger, err := logging.NewLogger(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Println("Error configuring logger:", err)
+		return
+	}
+	slog.SetDefault(logger)
 
+	site, err := NewSite(cfg.Dev, cfg.WebDir)
+	if err != nil {
+		slog.Error("error loading web frontend", "error", err)
+		return
+	}
+
+	Sessions = users.NewSessionStore(cfg.SessionTTL)
+	if cfg.TokenKey == nil {
+		slog.Warn("no token key configured, bearer tokens will stop working when the server restarts")
+	}
+	api.ConfigureQueue(cfg.QueueDepth, cfg.RetryAfter)
+	api.ConfigureMaxBodyBytes(cfg.MaxBodyBytes)
+	var stores *kvStores
+	if cfg.Store == StoreKV {
+		if stores, err = openKVStores(cfg.KVDir, data.DefaultKVOptions); err != nil {
+			slog.Error("error opening key-value stores", "dir", cfg.KVDir, "error", err)
+			return
+		}
+		DataService = tenants.NewRouter(stores.newDataService, tenants.Quota{})
+		Store = DataService
+	}
+	if len(cfg.Replication.Peers) > 0 {
+		Replica = replication.NewNode(cfg.Replication, DataService)
+		Store = Replica
+	}
 	stopCh := make(chan struct{})
 	wg.Add(1)
-	go api.RequestHandler(DataService, &wg, stopCh)
+	go api.RequestHandler(Store, &wg, stopCh)
+	Webhooks = webhooks.NewService(cfg.Webhooks)
+	Webhooks.Start(Events)
 
-	http.HandleFunc("/", RootHandler)
-	http.HandleFunc("GET /todoapp/item/", api.GetHandler(DataService))
-	http.HandleFunc("POST /todoapp/item/", api.CreateHandler(DataService))
-	http.HandleFunc("PUT /todoapp/item/", api.MarkItemAsCompleteHandler(DataService))
-	http.HandleFunc("DELETE /todoapp/item/", api.DeleteHandler(DataService))
-	http.HandleFunc("/todoapp/items/", api.GetAllHandler(DataService))
+	var auditLog *audit.Log
+	if cfg.AuditDir != "" {
+		if auditLog, err = audit.OpenLog(cfg.AuditDir); err != nil {
+			slog.Error("error opening audit log", "dir", cfg.AuditDir, "error", err)
+			return
+		}
+		api.UseAuditLog(auditLog)
+		if result := auditLog.Verify(); !result.Valid {
+			slog.Warn("audit log failed verification", "dir", cfg.AuditDir, "error", result.Error)
+		}
+	}
 
-	fmt.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		fmt.Println("Error starting server:", err)
+	handler, err := NewHandler(cfg, site)
+	if err != nil {
+		slog.Error("error configuring server", "error", err)
+		return
+	}
+	if stores != nil {
+		// Tenants are added by NewHandler, which opens their stores
+		if err := stores.Err(); err != nil {
+			slog.Error("error opening key-value stores", "dir", cfg.KVDir, "error", err)
+			stores.Close()
+			return
+		}
+		slog.Info("keeping list data in key-value stores", "dir", cfg.KVDir)
+	}
+	if Replica != nil {
+		// Tenants are added by NewHandler, and have to be there before the node applies anything from the leader's log
+		Replica.Start()
+		slog.Info("joined replication cluster", "node", cfg.Replication.NodeId, "peers", len(cfg.Replication.Peers))
+	}
+
+	var saver *persist.Saver
+	if cfg.DataDir != "" {
+		if saver, err = loadState(cfg); err != nil {
+			slog.Error("error loading state", "dir", cfg.DataDir, "error", err)
+			return
+		}
+		saver.Start()
+	}
+
+	var backups *backup.Scheduler
+	if cfg.BackupDir != "" {
+		backups, err = backup.NewScheduler(backupSource(), cfg.BackupDir, cfg.BackupInterval, cfg.BackupKeep)
+		if err != nil {
+			slog.Error("error configuring scheduled backups", "error", err)
+			return
+		}
+		backups.Start()
+		slog.Info("writing scheduled backups", "dir", cfg.BackupDir, "interval", cfg.BackupInterval, "keep", cfg.BackupKeep)
+	}
+
+	tlsConfig, err := NewTLSConfig(cfg)
+	if err != nil {
+		slog.Error("error configuring TLS", "error", err)
+		return
+	}
+	if tlsConfig == nil && cfg.SecureCookies {
+		slog.Warn("serving plain HTTP, session cookies will only be sent to localhost")
+	}
+
+	errorLog := slog.NewLogLogger(logger.Handler(), slog.LevelError)
+	server := &http.Server{
+		Addr:      cfg.Addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+		ErrorLog:  errorLog,
+	}
+	// Event streams stay open until their clients go away, so close them when shutting down
+	server.RegisterOnShutdown(Events.Close)
+
+	serverErr := make(chan error, 2)
+	go func() {
+		if tlsConfig == nil {
+			slog.Info("starting server", "addr", cfg.Addr)
+			serverErr <- server.ListenAndServe()
+			return
+		}
+		slog.Info("starting server", "addr", cfg.Addr, "tls", true)
+		serverErr <- server.ListenAndServeTLS("", "")
+	}()
+
+	var redirectServer *http.Server
+	if cfg.RedirectAddr != "" {
+		redirectServer = &http.Server{Addr: cfg.RedirectAddr, Handler: RedirectToHTTPS(cfg.Addr), ErrorLog: errorLog}
+		go func() {
+			slog.Info("redirecting HTTP to HTTPS", "addr", cfg.RedirectAddr)
+			serverErr <- redirectServer.ListenAndServe()
+		}()
 	}
 
 	// Clean-up for when 'ctrl+c' is pressed
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
-	<-sigs
-	fmt.Println("Server shutting down...")
-	close(stopCh)
+	select {
+	case err := <-serverErr:
+		slog.Error("error starting server", "error", err)
+	case <-sigs:
+		slog.Info("server shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("error shutting down server", "error", err)
+		}
+		if redirectServer != nil {
+			redirectServer.Shutdown(ctx)
+		}
+	}
 
+	close(stopCh)
 	wg.Wait()
-	fmt.Println("Server has shut down")
+	if backups != nil {
+		backups.Close()
+	}
+	if saver != nil {
+		if err := saver.Close(); err != nil {
+			slog.Error("error saving state", "dir", cfg.DataDir, "error", err)
+		} else {
+			slog.Info("state saved", "dir", cfg.DataDir)
+		}
+	}
+	if stores != nil {
+		if err := stores.Close(); err != nil {
+			slog.Error("error closing key-value stores", "dir", cfg.KVDir, "error", err)
+		}
+	}
+	if Replica != nil {
+		Replica.Close()
+	}
+	Webhooks.Close()
+	if auditLog != nil {
+		auditLog.Close()
+	}
+	slog.Info("server has shut down")
 }
 
-func RootHandler(w http.ResponseWriter, r *http.Request) {
-	t, err := template.ParseFiles("cmd/web/pages/home.html")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// NewHandler registers every route on a new mux and wraps it in the middleware shared by all requests.
+func NewHandler(cfg Config, site *Site) (http.Handler, error) {
+	DataService.SetQuota(cfg.TenantQuota)
+	for _, tenant := range cfg.Tenants {
+		if err := DataService.AddTenant(tenant); err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", tenant, err)
+		}
 	}
 
-	if data, err := RequestTodoItems(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	} else {
-		t.Execute(w, data)
+	limits := &ratelimit.ClientLimits{
+		Reads:  ratelimit.NewLimiter(cfg.ReadLimit.Rate, cfg.ReadLimit.Burst, cfg.RateLimitIdle),
+		Writes: ratelimit.NewLimiter(cfg.WriteLimit.Rate, cfg.WriteLimit.Burst, cfg.RateLimitIdle),
 	}
-}
 
-func RequestTodoItems() (responses.GetAllRes, error) {
-	resp, err := http.Get("http://localhost:8080/todoapp/items/")
-	if err != nil {
-		return responses.GetAllRes{}, err
+	mux := http.NewServeMux()
+	mux.Handle("/stylesheets/", http.StripPrefix("/stylesheets/", site.Static("stylesheets")))
+	mux.Handle("/images/", http.StripPrefix("/images/", site.Static("images")))
+	mux.Handle("/scripts/", http.StripPrefix("/scripts/", site.Static("scripts")))
+
+	accounts := &Accounts{Users: Users, Sessions: Sessions, Site: site, SecureCookies: cfg.SecureCookies}
+	mux.HandleFunc("GET /login", accounts.LoginPageHandler())
+	mux.Handle("POST /login", limits.Limit(accounts.LoginHandler()))
+	mux.Handle("POST /register", limits.Limit(accounts.RegisterHandler()))
+	mux.HandleFunc("POST /logout", accounts.LogoutHandler())
+	mux.Handle("/", auth.RequireLogin("/login", RootHandler(site, Store, Users)))
+
+	tokenKey := cfg.TokenKey
+	if tokenKey == nil {
+		tokenKey = auth.NewRandomKey()
 	}
-	defer resp.Body.Close()
+	tokens := auth.NewTokenIssuer(tokenKey)
 
-	if resp.StatusCode != http.StatusOK {
-		return responses.GetAllRes{}, fmt.Errorf("failed to retrieve todo items: %s", resp.Status)
+	read := func(handler http.Handler) http.Handler {
+		return auth.RequireScope(auth.ScopeRead, limits.Limit(DataService.LimitRequests(handler)))
+	}
+	write := func(handler http.Handler) http.Handler {
+		return auth.RequireScope(auth.ScopeReadWrite, limits.Limit(DataService.LimitRequests(handler)))
+	}
+	mux.Handle("GET /todoapp/item/", read(api.GetHandler(Store)))
+	mux.Handle("POST /todoapp/item/", write(api.CreateHandler(Store)))
+	mux.Handle("PUT /todoapp/item/", write(api.MarkItemAsCompleteHandler(Store)))
+	mux.Handle("DELETE /todoapp/item/", write(api.DeleteHandler(Store)))
+	mux.Handle("POST /todoapp/item/{id}/move", write(api.MoveHandler()))
+	mux.Handle("/todoapp/items/", read(api.GetAllHandler(Store)))
+	mux.Handle("GET /todoapp/lists/", read(api.GetListsHandler(Store)))
+	mux.Handle("POST /todoapp/lists/", write(api.CreateListHandler(Store)))
+	mux.Handle("GET /todoapp/lists/{id}/members", read(api.GetMembersHandler(Users)))
+	mux.Handle("POST /todoapp/lists/{id}/members", write(api.AddMemberHandler(Users)))
+	mux.Handle("PUT /todoapp/lists/{id}/members/{userId}", write(api.UpdateMemberHandler()))
+	mux.Handle("DELETE /todoapp/lists/{id}/members/{userId}", write(api.RemoveMemberHandler()))
+	mux.Handle("PUT /admin/tenants/{tenant}/members/{username}", auth.RequireAdmin(write(api.AddTenantMemberHandler(Users, DataService))))
+	mux.Handle("DELETE /admin/tenants/{tenant}/members/{username}", auth.RequireAdmin(write(api.RemoveTenantMemberHandler(Users, DataService))))
+	mux.Handle("POST /todoapp/lists/{id}/sync", write(api.SyncHandler()))
+	mux.Handle("GET /todoapp/lists/{id}/webhooks", read(api.GetWebhooksHandler(Webhooks, Store)))
+	mux.Handle("POST /todoapp/lists/{id}/webhooks", write(api.CreateWebhookHandler(Webhooks, Store)))
+	mux.Handle("DELETE /todoapp/lists/{id}/webhooks/{webhookId}", write(api.DeleteWebhookHandler(Webhooks, Store)))
+	mux.Handle("GET /todoapp/lists/{id}/webhooks/{webhookId}/deliveries", read(api.GetWebhookDeliveriesHandler(Webhooks, Store)))
+	mux.Handle("GET /todoapp/lists/{id}/webhooks/{webhookId}/dead-letters", read(api.GetDeadLettersHandler(Webhooks, Store)))
+	mux.Handle("POST /todoapp/lists/{id}/webhooks/{webhookId}/dead-letters/{deliveryId}/retry", write(api.RedeliverHandler(Webhooks, Store)))
+	mux.Handle("GET /todoapp/events", read(api.EventsHandler(Events, Store, cfg.EventHeartbeat)))
+	mux.Handle("GET /todoapp/changes", read(api.ChangesHandler(Events, Store)))
+	mux.Handle("GET /todoapp/ws", read(api.SocketHandler(api.SocketConfig{
+		Hubs:        Events,
+		Presence:    Presence,
+		DataService: Store,
+		Upgrader:    websocket.Upgrader{CheckOrigin: checkOrigin(cfg.CORS), MaxMessageBytes: cfg.MaxBodyBytes},
+		Writes:      limits.Writes,
+		Quota:       DataService.Allow,
+		Heartbeat:   cfg.EventHeartbeat,
+	})))
+	mux.Handle("GET /todoapp/audit", auth.RequireAdmin(read(api.AuditHandler(api.AuditLog()))))
+	mux.Handle("GET /todoapp/audit/verify", auth.RequireAdmin(read(api.AuditVerifyHandler(api.AuditLog()))))
+	mux.Handle("POST /admin/backup", auth.RequireAdmin(read(api.BackupHandler(backupSource()))))
+	mux.Handle("POST /admin/restore", auth.RequireAdmin(write(api.RestoreHandler(backupSource()))))
+	mux.Handle("GET /todoapp/keys", read(api.GetAPIKeysHandler(APIKeys)))
+	mux.Handle("POST /todoapp/keys", write(api.CreateAPIKeyHandler(APIKeys)))
+	mux.Handle("DELETE /todoapp/keys/{id}", write(api.RevokeAPIKeyHandler(APIKeys)))
+	mux.Handle("POST /todoapp/tokens", read(api.CreateTokenHandler(tokens)))
+	mux.HandleFunc("GET /todoapp/queue", api.QueueMetricsHandler())
+	mux.Handle("GET /metrics", metrics.Default.Handler())
+	mux.HandleFunc("GET /healthz", api.HealthzHandler())
+	mux.HandleFunc("GET /readyz", api.ReadyzHandler(DataService, cfg.ReadinessTimeout))
+	mux.HandleFunc("GET /version", api.VersionHandler())
+	if Replica != nil {
+		mux.Handle("/replication/", Replica.Handler())
 	}
 
-	var todoList responses.GetAllRes
-	err = json.NewDecoder(resp.Body).Decode(&todoList.Items)
-	if err != nil {
-		return responses.GetAllRes{}, err
+	authenticator := &auth.Authenticator{Users: Users, Sessions: Sessions, APIKeys: APIKeys, Tokens: tokens, Admins: cfg.Admins}
+	resolver := &tenants.Resolver{Router: DataService, Members: Users, Header: cfg.TenantHeader, BaseDomain: cfg.BaseDomain}
+	cors := cfg.CORS
+	cors.PathPrefix = "/todoapp/"
+	cors.ExposedHeaders = []string{middleware.RequestIDHeader, "Location", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"}
+	cors.RouteMethods = routeMethods(mux)
+	return middleware.Chain(middleware.Router(mux),
+		middleware.RequestID(),
+		middleware.AccessLog(),
+		middleware.Metrics(),
+		middleware.Recover(),
+		middleware.SecurityHeaders(),
+		middleware.CORS(cors),
+		authenticator.Authenticate(),
+		auth.CheckCSRF(),
+		resolver.Resolve(),
+	), nil
+}
+
+// backupSource is what backups are taken of and restored into. Restoring is refused on a node of a replication cluster.
+func backupSource() backup.Source {
+	return backup.Source{Users: Users, Lists: DataService, Replicated: Replica != nil}
+}
+
+// checkOrigin lets browsers open WebSockets from the server's own pages and from the origins allowed to call the API.
+func checkOrigin(cors middleware.CORSConfig) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		return websocket.SameOrigin(r) || cors.AllowsOrigin(r.Header.Get("Origin"))
 	}
+}
 
-	return todoList, nil
+// routeMethods returns the methods that mux has a method-specific route for at the path of a request. Requests with
+// any other method would only reach the catch-all page handler.
+func routeMethods(mux *http.ServeMux) func(r *http.Request) []string {
+	return func(r *http.Request) []string {
+		var methods []string
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			probe := &http.Request{Method: method, URL: r.URL, Host: r.Host}
+			if _, pattern := mux.Handler(probe); strings.HasPrefix(pattern, method+" ") {
+				methods = append(methods, method)
+			}
+		}
+		return methods
+	}
 }
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"todoApp/api"
+	"todoApp/api/contracts"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/tenants"
+	"todoApp/websocket"
+)
+
+// startRequestHandler runs the RequestHandler against DataService until the test finishes.
+func startRequestHandler(t *testing.T) {
+	var wg sync.WaitGroup
+	stopCh := make(chan struct{})
+	wg.Add(1)
+	go api.RequestHandler(DataService, &wg, stopCh)
+	t.Cleanup(func() {
+		close(stopCh)
+		wg.Wait()
+	})
+}
+
+func TestTenantIsolation(t *testing.T) {
+	server := newTestServer(t, "-tenants", "team-a,team-b", "-admins", "frank")
+	startRequestHandler(t)
+	sessionCookie := registerSession(t, server, "frank")
+	csrfToken := csrfToken(t, sessionCookie)
+
+	do := func(method, path, tenant, body string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.AddCookie(sessionCookie)
+		req.Header.Set(auth.CSRFHeader, csrfToken)
+		req.Header.Set(tenants.DefaultHeader, tenant)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	getItems := func(tenant string) []data.TodoItem {
+		var items []data.TodoItem
+		json.NewDecoder(do(http.MethodGet, "/todoapp/items/", tenant, "").Body).Decode(&items)
+		return items
+	}
+
+	// Registering only makes a user a member of the default tenant, an admin adds them to the others
+	if resp := do(http.MethodPost, "/todoapp/item/", "team-a", `{"Name":"Team A's item"}`); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("a user created an item in a tenant they aren't a member of. Got: %v Want: %v", resp.StatusCode, http.StatusForbidden)
+	}
+	for _, tenant := range []string{"team-a", "team-b"} {
+		if resp := do(http.MethodPut, "/admin/tenants/"+tenant+"/members/frank", "", ""); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("adding a tenant member returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusNoContent)
+		}
+	}
+	if resp := do(http.MethodPost, "/todoapp/item/", "team-a", `{"Name":"Team A's item"}`); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating an item returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	if items := getItems("team-a"); len(items) != 1 || items[0].Name != "Team A's item" {
+		t.Errorf("Team A's item is missing. Got: %v", items)
+	}
+	if items := getItems("team-b"); len(items) != 0 {
+		t.Errorf("Team B can see another tenant's items. Got: %v", items)
+	}
+	if items := getItems(""); len(items) != 0 {
+		t.Errorf("The default tenant can see another tenant's items. Got: %v", items)
+	}
+	if resp := do(http.MethodGet, "/todoapp/items/", "team-c", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("an unknown tenant returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusNotFound)
+	}
+
+	// API keys can only be used with the tenant they were created for
+	resp := do(http.MethodPost, "/todoapp/keys", "team-b", `{"Name":"ci","Scope":"read"}`)
+	var key contracts.CreatedAPIKeyContract
+	json.NewDecoder(resp.Body).Decode(&key)
+	withKey := func(tenant string) int {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/todoapp/items/", nil)
+		req.Header.Set(auth.APIKeyHeader, key.Key)
+		req.Header.Set(tenants.DefaultHeader, tenant)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := withKey("team-b"); status != http.StatusOK {
+		t.Errorf("a key couldn't be used with its own tenant. Got: %v Want: %v", status, http.StatusOK)
+	}
+	if status := withKey("team-a"); status != http.StatusForbidden {
+		t.Errorf("a key was used with another tenant. Got: %v Want: %v", status, http.StatusForbidden)
+	}
+
+	if resp := do(http.MethodDelete, "/admin/tenants/team-b/members/frank", "", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("removing a tenant member returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusNoContent)
+	}
+	if resp := do(http.MethodGet, "/todoapp/items/", "team-b", ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("a removed member could still use the tenant. Got: %v Want: %v", resp.StatusCode, http.StatusForbidden)
+	}
+	if status := withKey("team-b"); status != http.StatusForbidden {
+		t.Errorf("a removed member's key could still be used. Got: %v Want: %v", status, http.StatusForbidden)
+	}
+	if resp := do(http.MethodPut, "/admin/tenants/team-c/members/frank", "", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("adding a member to an unknown tenant returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	server := newTestServer(t, "-cors-origins", "https://*.example.com")
+
+	preflight := func(path, method string) *http.Response {
+		req, _ := http.NewRequest(http.MethodOptions, server.URL+path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", "Content-Type, X-Tenant-ID")
+		resp, err := noRedirectClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := preflight("/todoapp/lists/1/members/2", http.MethodDelete)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("preflight returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusNoContent)
+	}
+	if methods := resp.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(methods, "PUT, DELETE") || strings.Contains(methods, "PATCH") {
+		t.Errorf("Unexpected allowed methods. Got: %q", methods)
+	}
+	if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+		t.Errorf("Unexpected allowed origin. Got: %q", origin)
+	}
+
+	if resp := preflight("/todoapp/audit", http.MethodDelete); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("preflight for a method without a route returned wrong status code. Got: %v Want: %v", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestWebSocket_Origins(t *testing.T) {
+	server := newTestServer(t, "-cors-origins", "https://app.example.com")
+	startRequestHandler(t)
+	sessionCookie := registerSession(t, server, "grace")
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/todoapp/ws"
+
+	dial := func(origin string) (*websocket.Conn, *http.Response, error) {
+		header := http.Header{"Cookie": {sessionCookie.String()}, "Origin": {origin}}
+		return websocket.Dial(url, header)
+	}
+
+	conn, _, err := dial(server.URL)
+	if err != nil {
+		t.Fatalf("a page on the server's own origin couldn't connect: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"Type":"command","Id":"1","Command":"create","Name":"Socket item"}`))
+	_, payload, err := conn.ReadMessage()
+	var result contracts.SocketMessageContract
+	if err != nil || json.Unmarshal(payload, &result) != nil || result.Type != contracts.SocketResult || result.Status != http.StatusCreated {
+		t.Errorf("Unexpected reply to a command. Got: %s, %v", payload, err)
+	}
+
+	if conn, _, err := dial("https://app.example.com"); err != nil {
+		t.Errorf("an allowed origin couldn't connect: %v", err)
+	} else {
+		conn.Close()
+	}
+	if _, resp, err := dial("https://evil.example.net"); err != websocket.ErrBadHandshake || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("another origin should be refused. Got: %v, %v", resp, err)
+	}
+}
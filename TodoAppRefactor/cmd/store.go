@@ -0,0 +1,69 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"todoApp/data"
+	dataService "todoApp/services"
+)
+
+// kvStores keeps the list data of each tenant in a key-value store file of its own, named after the tenant, in dir.
+type kvStores struct {
+	dir     string
+	options data.KVOptions
+	kvs     []*data.KV
+	err     error
+	mu      sync.Mutex
+}
+
+// openKVStores prepares to keep tenants' list data in dir, creating it if it doesn't exist yet.
+func openKVStores(dir string, options data.KVOptions) (*kvStores, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &kvStores{dir: dir, options: options}, nil
+}
+
+// newDataService creates the data service for a tenant, kept in its store and publishing its changes to the tenant's
+// event hub. It is what the tenant router creates data services with, which can't fail, so a tenant whose store can't
+// be opened is given a data service kept in memory instead and the error is reported by Err.
+func (stores *kvStores) newDataService(tenant string) *dataService.DataService {
+	stores.mu.Lock()
+	defer stores.mu.Unlock()
+
+	path := filepath.Join(stores.dir, tenant+".kv")
+	kv, err := data.OpenKV(path, stores.options)
+	if err == nil {
+		var service *dataService.DataService
+		if service, err = dataService.NewStoredDataService(data.NewKVStore(kv)); err == nil {
+			stores.kvs = append(stores.kvs, kv)
+			return service.PublishTo(Events.For(tenant), tenant)
+		}
+		kv.Close()
+	}
+	stores.err = errors.Join(stores.err, fmt.Errorf("%s: %w", path, err))
+	return newDataService(tenant)
+}
+
+// Err returns why any tenant's store couldn't be opened.
+func (stores *kvStores) Err() error {
+	stores.mu.Lock()
+	defer stores.mu.Unlock()
+	return stores.err
+}
+
+// Close closes every tenant's store. Their data services can't be changed afterwards.
+func (stores *kvStores) Close() error {
+	stores.mu.Lock()
+	defer stores.mu.Unlock()
+
+	var err error
+	for _, kv := range stores.kvs {
+		err = errors.Join(err, kv.Close())
+	}
+	stores.kvs = nil
+	return err
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/tenants"
+)
+
+func TestKVStores(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*kvStores, *tenants.Router) {
+		stores, err := openKVStores(dir, data.KVOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		router := tenants.NewRouter(stores.newDataService, tenants.Quota{})
+		router.AddTenant("team-a")
+		if err := stores.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return stores, router
+	}
+	alice := auth.WithPrincipal(tenants.WithTenant(context.Background(), "team-a"), auth.Principal{UserId: 1})
+
+	stores, router := open()
+	list, _ := router.CreateTodoList(alice, "Groceries")
+	router.CreateTodoItem(alice, list.Id, "Milk")
+	if err := stores.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.CreateTodoItem(alice, list.Id, "Eggs"); err == nil {
+		t.Error("A change was made after the stores were closed")
+	}
+
+	// The lists are kept through a restart, in the file of their tenant
+	stores, router = open()
+	defer stores.Close()
+	if items, err := router.GetAllTodoItems(alice, list.Id); err != nil || len(items) != 1 || items[0].Name != "Milk" {
+		t.Errorf("Unexpected items after reopening the stores. Got: %v, %v", items, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.kv")); len(files) != 2 {
+		t.Errorf("Unexpected store files. Got: %v", files)
+	}
+
+	os.WriteFile(filepath.Join(dir, "team-b.kv"), []byte(`{"Format":"todoapp-state"}`), 0o600)
+	router.AddTenant("team-b")
+	if err := stores.Err(); !errors.Is(err, data.ErrNotKVFile) {
+		t.Errorf("A store that couldn't be opened wasn't reported. Got: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"todoApp/certs"
+)
+
+// devCertHosts are the names the self-signed certificate generated for -dev-tls is valid for.
+var devCertHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// NewTLSConfig builds the TLS configuration of the server, or returns nil when it serves plain HTTP.
+func NewTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch {
+	case cfg.TLSCert != "":
+		reloader, err := certs.NewReloader(cfg.TLSCert, cfg.TLSKey, certs.DefaultCheckInterval)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+	case cfg.DevTLS:
+		cert, err := certs.SelfSigned(devCertHosts, 30*24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		slog.Warn("serving HTTPS with a self-signed certificate, browsers will warn about it", "hosts", devCertHosts)
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	default:
+		return nil, nil
+	}
+	return tlsConfig, nil
+}
+
+// RedirectToHTTPS permanently redirects every request to the same host and path on the HTTPS port of httpsAddr.
+func RedirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	testCases := []struct {
+		testName  string
+		httpsAddr string
+		host      string
+		expected  string
+	}{
+		{"Testing the default HTTPS port", ":443", "todo.example.com", "https://todo.example.com/todoapp/items/?list=2"},
+		{"Testing a request naming the HTTP port", ":443", "todo.example.com:80", "https://todo.example.com/todoapp/items/?list=2"},
+		{"Testing another HTTPS port", ":8443", "localhost:8080", "https://localhost:8443/todoapp/items/?list=2"},
+		{"Testing an IPv6 host", ":8443", "[::1]", "https://[::1]:8443/todoapp/items/?list=2"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/todoapp/items/?list=2", nil)
+			req.Host = test.host
+			rr := httptest.NewRecorder()
+			RedirectToHTTPS(test.httpsAddr).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusPermanentRedirect {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, http.StatusPermanentRedirect)
+			}
+			if location := rr.Header().Get("Location"); location != test.expected {
+				t.Errorf("Unexpected redirect. Got: %v, Expected: %v", location, test.expected)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig_DevTLS(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-dev-tls"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := NewTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsConfig.Certificates[0].Leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request over TLS failed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewTLSConfig_PlainHTTP(t *testing.T) {
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig, err := NewTLSConfig(cfg); err != nil || tlsConfig != nil {
+		t.Errorf("Unexpected TLS config without any TLS flags. Got: %v, %v", tlsConfig, err)
+	}
+}
+
+func TestLoadConfig_TLSFlags(t *testing.T) {
+	testCases := []struct {
+		testName string
+		args     []string
+	}{
+		{"Testing a certificate without a key", []string{"-tls-cert", "cert.pem"}},
+		{"Testing a certificate and -dev-tls", []string{"-tls-cert", "cert.pem", "-tls-key", "key.pem", "-dev-tls"}},
+		{"Testing a redirect without TLS", []string{"-http-redirect-addr", ":80"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if _, err := LoadConfig(test.args); err == nil {
+				t.Error("invalid TLS flags were accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/logging"
+	dataService "todoApp/services"
+	"todoApp/users"
+)
+
+//go:embed web
+var embeddedWeb embed.FS
+
+// Site holds the web frontend's pages and static files. By default everything is served from the copy embedded in
+// the binary and the templates are parsed once. In dev mode files are read from disk instead and the templates are
+// re-parsed on every request, so changes show up without rebuilding.
+type Site struct {
+	files fs.FS
+	dev   bool
+
+	mu    sync.Mutex
+	pages *template.Template
+}
+
+// NewSite loads the web frontend. When dev is true the files are read from dir on disk.
+func NewSite(dev bool, dir string) (*Site, error) {
+	var files fs.FS
+	if dev {
+		files = os.DirFS(dir)
+	} else {
+		sub, err := fs.Sub(embeddedWeb, "web")
+		if err != nil {
+			return nil, err
+		}
+		files = sub
+	}
+
+	site := &Site{files: files, dev: dev}
+	pages, err := site.parse()
+	if err != nil {
+		return nil, err
+	}
+	site.pages = pages
+	return site, nil
+}
+
+func (site *Site) parse() (*template.Template, error) {
+	return template.ParseFS(site.files, "pages/*.html")
+}
+
+func (site *Site) templates() (*template.Template, error) {
+	if !site.dev {
+		return site.pages, nil
+	}
+
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	pages, err := site.parse()
+	if err != nil {
+		return nil, err
+	}
+	site.pages = pages
+	return pages, nil
+}
+
+// Render executes the named page into a buffer first, so a failing template doesn't leave a half written response.
+func (site *Site) Render(w http.ResponseWriter, status int, name string, data any) error {
+	pages, err := site.templates()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := pages.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Static serves the files in the named directory of the site.
+func (site *Site) Static(dir string) http.Handler {
+	sub, err := fs.Sub(site.files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(sub)
+}
+
+type homePage struct {
+	Username  string
+	CSRFToken string
+	Lists     []data.TodoList
+	List      data.TodoList
+	Role      data.Role
+	Items     []data.TodoItem
+	Members   []pageMember
+}
+
+type pageMember struct {
+	UserId   int
+	Username string
+	Role     data.Role
+}
+
+func (page homePage) CanEdit() bool {
+	return page.Role.Allows(data.RoleEditor)
+}
+
+func (page homePage) CanManageMembers() bool {
+	return page.Role.Allows(data.RoleOwner)
+}
+
+// RootHandler renders the list chosen by the 'list' query parameter, or the caller's default list, along with who it
+// is shared with.
+func RootHandler(site *Site, dataService dataService.IDataService, userService *users.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := homePage{Lists: dataService.GetTodoLists(r.Context())}
+		if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+			page.Username = principal.Username
+			page.CSRFToken = principal.CSRFToken
+		}
+
+		listId, err := strconv.Atoi(r.URL.Query().Get("list"))
+		if err != nil {
+			listId = page.Lists[0].Id
+		}
+		index := slices.IndexFunc(page.Lists, func(list data.TodoList) bool { return list.Id == listId })
+		if index < 0 {
+			http.Error(w, "list does not exist", http.StatusNotFound)
+			return
+		}
+		page.List = page.Lists[index]
+
+		if page.Items, err = dataService.GetAllTodoItems(r.Context(), page.List.Id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if page.Role, err = dataService.GetListRole(r.Context(), page.List.Id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		members, err := dataService.GetListMembers(r.Context(), page.List.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, member := range members {
+			pageMember := pageMember{UserId: member.UserId, Role: member.Role}
+			if user, exists := userService.GetUser(member.UserId); exists {
+				pageMember.Username = user.Username
+			}
+			page.Members = append(page.Members, pageMember)
+		}
+
+		if err := site.Render(w, http.StatusOK, "home.html", page); err != nil {
+			logging.FromContext(r.Context()).Error("error rendering page", "page", "home.html", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
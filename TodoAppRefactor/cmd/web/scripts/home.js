@@ -0,0 +1,380 @@
+const list = document.getElementById('list');
+const listId = list.dataset.listId;
+const ownerId = Number(list.dataset.ownerId);
+const canEdit = list.dataset.canEdit === 'true';
+const canManageMembers = list.dataset.canManageMembers === 'true';
+const csrfToken = document.querySelector('meta[name="csrf-token"]').content;
+const items = document.getElementById('items');
+const members = document.getElementById('members');
+
+// Every change is sent with the session's CSRF token, without it the server rejects the request.
+function send(url, method, body) {
+    const headers = { 'X-CSRF-Token': csrfToken };
+    if (body !== undefined) {
+        headers['Content-Type'] = 'application/json';
+    }
+    return fetch(url, {
+        method: method,
+        headers: headers,
+        body: body === undefined ? undefined : JSON.stringify(body)
+    }).then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text || response.statusText); });
+        }
+        return response;
+    });
+}
+
+// RENDERING
+// Items and members are rendered the same way as the server renders them into the page, so they can be added and
+// replaced in place as the list changes.
+function button(action, label, userId) {
+    const element = document.createElement('button');
+    element.dataset.action = action;
+    element.textContent = label;
+    if (userId !== undefined) {
+        element.dataset.userId = userId;
+    }
+    return element;
+}
+
+function renderItem(item) {
+    const element = document.createElement('li');
+    element.className = 'item';
+    element.dataset.itemId = item.Id;
+    const name = document.createElement(item.Complete ? 's' : 'span');
+    name.textContent = item.Name;
+    element.append(name);
+    if (canEdit) {
+        element.draggable = true;
+        if (!item.Complete) {
+            element.append(' ', button('complete', 'Mark as complete'));
+        }
+        element.append(' ', button('delete', 'Delete'));
+    }
+    return element;
+}
+
+function renderMember(member) {
+    const element = document.createElement('li');
+    element.dataset.userId = member.UserId;
+    element.append(`${member.Username} (${member.Role})`);
+    if (canManageMembers && member.UserId !== ownerId) {
+        element.append(' ', button('remove-member', 'Remove', member.UserId));
+    }
+    return element;
+}
+
+const itemElements = () => [...items.querySelectorAll('li.item')];
+const findItem = itemId => items.querySelector(`li.item[data-item-id="${itemId}"]`);
+
+// placeItem puts an item at index in the list, counting only the other items.
+function placeItem(element, index) {
+    const others = itemElements().filter(other => other !== element);
+    if (index < others.length) {
+        others[index].before(element);
+    } else if (others.length > 0) {
+        others[others.length - 1].after(element);
+    } else {
+        items.prepend(element);
+    }
+}
+
+// refreshItems renders the list's items again from the API, for when the page may have missed changes.
+function refreshItems() {
+    return fetch(`/todoapp/items/?list=${listId}`)
+    .then(response => response.json())
+    .then(latest => {
+        itemElements().forEach(element => element.remove());
+        latest.forEach((item, index) => placeItem(renderItem(item), index));
+    })
+    .catch(error => console.error('Error:', error));
+}
+
+// refreshMembers renders the list's members again from the API. Member events only carry the user's ID, the API adds
+// their username. A change to the viewer's own role changes which controls the page has, so only then is it reloaded.
+function refreshMembers() {
+    return fetch(`/todoapp/lists/${listId}/members`)
+    .then(response => response.json())
+    .then(latest => {
+        const own = latest.find(member => member.Username === list.dataset.username);
+        if (own && own.Role !== list.dataset.role) {
+            window.location.reload();
+            return;
+        }
+        members.querySelectorAll('li[data-user-id]').forEach(element => element.remove());
+        const rendered = latest.map(renderMember);
+        const input = members.querySelector('li:not([data-user-id])');
+        if (input) {
+            input.before(...rendered);
+        } else {
+            members.append(...rendered);
+        }
+    })
+    .catch(error => console.error('Error:', error));
+}
+
+// applyEvent changes the page the way the event changed the list. Events can be seen twice, when the page resubscribes
+// or has already refreshed, so applying one again leaves the page as it was.
+function applyEvent(event) {
+    switch (event.Type) {
+        case 'item.created':
+        case 'item.updated':
+        case 'item.completed': {
+            const element = renderItem(event.Item);
+            findItem(event.Item.Id)?.replaceWith(element);
+            placeItem(element, event.Index);
+            break;
+        }
+        case 'item.moved':
+            placeItem(findItem(event.Item.Id) ?? renderItem(event.Item), event.Index);
+            break;
+        case 'item.deleted':
+            findItem(event.Item.Id)?.remove();
+            break;
+        case 'member.added':
+        case 'member.updated':
+        case 'member.removed':
+            refreshMembers();
+            break;
+    }
+}
+
+// Changes made by the page itself are shown once their events come back over the WebSocket. Without one, the page
+// fetches what changed instead.
+let socket;
+let live = false;
+const afterChange = refresh => live ? undefined : refresh();
+
+// COMMANDS
+// While the page is subscribed, item changes are sent over the WebSocket as commands rather than as requests of their
+// own. Each is given an ID its reply is matched to, and is failed if the connection closes before the reply arrives.
+let lastCommand = 0;
+const pending = new Map();
+
+function command(name, fields) {
+    const id = `c${++lastCommand}`;
+    return new Promise((resolve, reject) => {
+        pending.set(id, { resolve, reject });
+        socket.send(JSON.stringify({ Type: 'command', Id: id, Command: name, List: Number(listId), ...fields }));
+    });
+}
+
+// settle completes the command a reply is for, reporting whether there was one.
+function settle(reply) {
+    const request = pending.get(reply.Id);
+    if (!request) {
+        return false;
+    }
+    pending.delete(reply.Id);
+    if (reply.Type === 'result') {
+        request.resolve(reply);
+    } else {
+        request.reject(new Error(reply.Error));
+    }
+    return true;
+}
+
+// changeItem sends an item change as a command, or as a request to the item routes when the page isn't subscribed.
+function changeItem(name, fields, url, method, body) {
+    if (live) {
+        return command(name, fields);
+    }
+    return send(url, method, body).then(refreshItems);
+}
+
+// ADD ITEM
+document.getElementById('addItemButton')?.addEventListener('click', function() {
+    const input = document.getElementById('itemInput');
+    changeItem('create', { Name: input.value }, `/todoapp/item/?list=${listId}`, 'POST', { name: input.value })
+    .then(() => input.value = '')
+    .catch(error => console.error('Error:', error));
+});
+
+// ADD LIST
+document.getElementById('addListButton').addEventListener('click', function() {
+    const listName = document.getElementById('listInput').value;
+    send('/todoapp/lists/', 'POST', { name: listName })
+    .then(response => response.json())
+    .then(created => window.location.assign(`/?list=${created.Id}`))
+    .catch(error => console.error('Error:', error));
+});
+
+// SHARE LIST
+document.getElementById('addMemberButton')?.addEventListener('click', function() {
+    const input = document.getElementById('memberInput');
+    const role = document.getElementById('roleInput').value;
+    send(`/todoapp/lists/${listId}/members`, 'POST', { username: input.value, role: role })
+    .then(() => {
+        input.value = '';
+        return afterChange(refreshMembers);
+    })
+    .catch(error => console.error('Error:', error));
+});
+
+// REMOVE MEMBER
+function removeMember(userId) {
+    send(`/todoapp/lists/${listId}/members/${userId}`, 'DELETE')
+    .then(() => afterChange(refreshMembers))
+    .catch(error => console.error('Error:', error));
+}
+
+// MARK AS COMPLETE
+// Items are addressed by their position in the list, read from the page when the button is clicked.
+function markAsComplete(index) {
+    changeItem('complete', { Index: index }, `/todoapp/item/${index}?list=${listId}`, 'PUT', { id: index })
+    .catch(error => console.error('Error:', error));
+}
+
+// REMOVE ITEM
+function deleteItem(index) {
+    changeItem('delete', { Index: index }, `/todoapp/item/${index}?list=${listId}`, 'DELETE', { id: index })
+    .catch(error => console.error('Error:', error));
+}
+
+// MOVE ITEM
+// Editors can drag items to reorder them. The item is sent with the ID of the item it was dropped after, or before if
+// it was dropped at the top, so the move lands in the right place even if the list changed since the page loaded. The
+// item is already where it was dropped, so only a failed move needs the list fetched again.
+function moveItem(itemId, anchor) {
+    send(`/todoapp/item/${itemId}/move?list=${listId}`, 'POST', anchor)
+    .catch(error => {
+        console.error('Error:', error);
+        refreshItems();
+    });
+}
+
+const isItem = element => element?.matches('li.item') ? element : undefined;
+let dragged, startedAfter;
+items.addEventListener('dragstart', function(event) {
+    dragged = isItem(event.target.closest('li'));
+    if (!dragged) {
+        return;
+    }
+    startedAfter = dragged.previousElementSibling;
+    dragged.classList.add('dragging');
+    event.dataTransfer.effectAllowed = 'move';
+});
+items.addEventListener('dragover', function(event) {
+    const target = isItem(event.target.closest('li'));
+    if (!dragged || !target) {
+        return;
+    }
+    event.preventDefault();
+    if (target === dragged) {
+        return;
+    }
+    const box = target.getBoundingClientRect();
+    if (event.clientY > box.top + box.height / 2) {
+        target.after(dragged);
+    } else {
+        target.before(dragged);
+    }
+});
+items.addEventListener('drop', function(event) {
+    event.preventDefault();
+});
+items.addEventListener('dragend', function() {
+    if (!dragged) {
+        return;
+    }
+    dragged.classList.remove('dragging');
+    const previous = isItem(dragged.previousElementSibling);
+    const next = isItem(dragged.nextElementSibling);
+    if (dragged.previousElementSibling !== startedAfter) {
+        if (previous) {
+            moveItem(dragged.dataset.itemId, { after: Number(previous.dataset.itemId) });
+        } else if (next) {
+            moveItem(dragged.dataset.itemId, { before: Number(next.dataset.itemId) });
+        }
+    }
+    dragged = undefined;
+});
+
+// The buttons next to items and members say what they do in data attributes, inline handlers aren't allowed by the
+// content security policy.
+document.addEventListener('click', function(event) {
+    const button = event.target.closest('button[data-action]');
+    if (!button) {
+        return;
+    }
+    const index = itemElements().indexOf(button.closest('li.item'));
+    switch (button.dataset.action) {
+        case 'complete':
+            markAsComplete(index);
+            break;
+        case 'delete':
+            deleteItem(index);
+            break;
+        case 'remove-member':
+            removeMember(button.dataset.userId);
+            break;
+    }
+});
+
+// PRESENCE
+// The people viewing the list are listed under its members, and the list is hidden while the page isn't subscribed.
+function showViewers(viewers) {
+    const element = document.getElementById('viewers');
+    element.hidden = viewers.length === 0;
+    element.querySelector('ul').replaceChildren(...viewers.map(viewer => {
+        const item = document.createElement('li');
+        item.textContent = viewer.Username;
+        return item;
+    }));
+}
+
+// LIVE UPDATES
+// The page subscribes to the list over a WebSocket and applies the changes made to it, by the page itself, by other
+// members or in other tabs, as their events arrive. If the connection drops it is reopened, picking up from the last
+// change seen, and if those changes are no longer kept the items and members are fetched again.
+if (listId && listId !== '0' && window.WebSocket) {
+    const scheme = window.location.protocol === 'https:' ? 'wss' : 'ws';
+    let lastSeq = 0;
+    const connect = () => {
+        socket = new WebSocket(`${scheme}://${window.location.host}/todoapp/ws`);
+        socket.addEventListener('open', () => socket.send(JSON.stringify({ Type: 'subscribe', List: Number(listId), Since: lastSeq })));
+        socket.addEventListener('message', message => {
+            const data = JSON.parse(message.data);
+            switch (data.Type) {
+                case 'subscribed':
+                    live = true;
+                    lastSeq = lastSeq || data.Seq;
+                    if (data.Reset) {
+                        refreshItems();
+                        refreshMembers();
+                    }
+                    break;
+                case 'event':
+                    lastSeq = data.Event.Seq;
+                    applyEvent(data.Event);
+                    break;
+                case 'presence':
+                    showViewers(data.Viewers);
+                    break;
+                case 'result':
+                    settle(data);
+                    break;
+                case 'error':
+                    if (!settle(data)) {
+                        console.error('Error:', data.Error);
+                    }
+                    break;
+                case 'unsubscribed':
+                    // The viewer was removed from the list
+                    window.location.assign('/');
+                    break;
+            }
+        });
+        socket.addEventListener('close', event => {
+            live = false;
+            showViewers([]);
+            pending.forEach(request => request.reject(new Error('the connection closed before the server replied')));
+            pending.clear();
+            if (event.code !== 1000) {
+                setTimeout(connect, 3000);
+            }
+        });
+    };
+    connect();
+}
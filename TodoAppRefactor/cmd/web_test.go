@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	apiMocks "todoApp/api/mocks"
+	"todoApp/auth"
+	"todoApp/users"
+)
+
+func TestRootHandler_RendersEmbeddedPage(t *testing.T) {
+	site, err := NewSite(false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userService := users.NewUserService()
+	userService.Register("alice", "password123")
+	userService.Register("bob", "password123")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{UserId: 1, Username: "alice", CSRFToken: "csrf-123"}))
+	rr := httptest.NewRecorder()
+	RootHandler(site, apiMocks.NewMockDataService(), userService).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, http.StatusOK)
+	}
+	for _, name := range []string{"alice", "MockList", "TodoItem1", "TodoItem2", "TodoItem3", "bob (viewer)"} {
+		if !strings.Contains(rr.Body.String(), name) {
+			t.Errorf("rendered page is missing item %s", name)
+		}
+	}
+	if !strings.Contains(rr.Body.String(), `content="csrf-123"`) {
+		t.Error("rendered page is missing the CSRF token")
+	}
+	for _, inline := range []string{"<script>", "onclick=", "style="} {
+		if strings.Contains(rr.Body.String(), inline) {
+			t.Errorf("rendered page uses %s, which the content security policy blocks", inline)
+		}
+	}
+}
+
+func TestSite_ServesEmbeddedStaticFiles(t *testing.T) {
+	site, err := NewSite(false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.StripPrefix("/stylesheets/", site.Static("stylesheets")).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stylesheets/home.css", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("static file was not served. Got: %v Want: %v", status, http.StatusOK)
+	} else if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/css") {
+		t.Errorf("static file served with wrong content type. Got: %v", contentType)
+	}
+}
+
+func TestSite_ServesEmbeddedScripts(t *testing.T) {
+	site, err := NewSite(false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.StripPrefix("/scripts/", site.Static("scripts")).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/scripts/home.js", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("script was not served. Got: %v Want: %v", status, http.StatusOK)
+	} else if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/javascript") {
+		t.Errorf("script served with wrong content type. Got: %v", contentType)
+	}
+}
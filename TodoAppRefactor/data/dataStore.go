@@ -1,10 +1,45 @@
 package data
 
+// TodoItem is addressed by its index in the list in the API, the ID stays the same when items before it are deleted.
 type TodoItem struct {
+	Id       int `json:",omitempty"`
 	Name     string
 	Complete bool
 }
 
+type TodoList struct {
+	Id      int
+	Name    string
+	OwnerId int
+}
+
+// Role is what a member of a list is allowed to do with it. Viewers can read the list, editors can also change its
+// items and owners can also manage its members.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+func (role Role) Valid() bool {
+	_, exists := roleRanks[role]
+	return exists
+}
+
+// Allows reports whether a member with this role can do something that needs the required role.
+func (role Role) Allows(required Role) bool {
+	return role.Valid() && roleRanks[role] >= roleRanks[required]
+}
+
+type Member struct {
+	UserId int
+	Role   Role
+}
+
 var DataStore = []TodoItem{
 	{Name: "Real Item 1", Complete: false},
 	{Name: "Real Item 2", Complete: false},
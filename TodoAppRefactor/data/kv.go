@@ -0,0 +1,599 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kvMagic starts every key-value store file, and says which version of the record format it was written with.
+const kvMagic = "todokv1\n"
+
+// Every record starts with the CRC-32C checksum of its payload and the payload's length. The payload is a run of
+// entries, each an op, the lengths of its key and value as uvarints, the key and the value.
+const (
+	recordHeaderSize = 8
+	opPut            = 1
+	opDelete         = 2
+)
+
+// compactRecordSize is roughly how big the records written by a compaction get, so a file is copied in a few large
+// writes without a single record having to be held in memory whole.
+const compactRecordSize = 1 << 20
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrKVClosed    = errors.New("the key-value store is closed")
+	ErrNotKVFile   = errors.New("not a key-value store file")
+	errTornRecord  = errors.New("the record is cut short")
+	errBadChecksum = errors.New("the record doesn't match its checksum")
+	errBadEntry    = errors.New("the record has a malformed entry")
+)
+
+// KVOptions tune a KV. The zero value syncs nothing and never compacts in the background.
+type KVOptions struct {
+	// SyncWrites makes every write wait for the file to reach the disk. Without it a write that has returned is only
+	// lost if the machine goes down, not if the process does.
+	SyncWrites bool
+	// CompactInterval is how often the store checks whether it is due compacting. It is only compacted when Compact is
+	// called if this is 0.
+	CompactInterval time.Duration
+	// A store is due compacting once CompactGarbage of its file is overwritten or deleted values, and the file is at
+	// least CompactMinSize bytes.
+	CompactGarbage float64
+	CompactMinSize int64
+}
+
+// DefaultKVOptions sync every write and compact a store once half of a file of at least 4 MiB is garbage.
+var DefaultKVOptions = KVOptions{SyncWrites: true, CompactInterval: time.Minute, CompactGarbage: 0.5, CompactMinSize: 4 << 20}
+
+// KV is an embedded key-value store kept in a single file. Every write is appended to the file as a record with a
+// CRC-32C checksum, and an in-memory hash index maps every key to where its latest value is in the file, so a read is
+// a single read of the file. Overwritten and deleted values stay in the file until it is compacted, which copies the
+// live values to a new file and swaps it in. The index is rebuilt by reading the file from the start when it is
+// opened. A record that is cut short or doesn't match its checksum, which is what a crash part way through a write
+// leaves, is dropped along with everything after it.
+type KV struct {
+	path    string
+	options KVOptions
+
+	mu          sync.RWMutex
+	file        *os.File
+	size        int64
+	index       kvIndex
+	compactions int
+	closed      bool
+
+	// compacting makes sure only one compaction runs at a time
+	compacting sync.Mutex
+	stop       chan struct{}
+	once       sync.Once
+	workers    sync.WaitGroup
+}
+
+// KVStats describe a KV's file. Garbage is the bytes of it taken by overwritten and deleted values and the records'
+// framing, which a compaction would free.
+type KVStats struct {
+	Keys        int
+	Size        int64
+	Garbage     int64
+	Compactions int
+}
+
+// location is where a key's value is in the file. span is the size of the key's whole entry, which is what the file
+// shrinks by once the value is overwritten and the file compacted.
+type location struct {
+	offset int64
+	size   int
+	span   int
+}
+
+// kvIndex maps keys to their latest values, and keeps count of the bytes those take in the file.
+type kvIndex struct {
+	locations map[string]location
+	live      int64
+}
+
+// entry is one write in a record, with where its value is in the file once it has been written.
+type entry struct {
+	op    byte
+	key   string
+	value []byte
+	location
+}
+
+// Batch is a set of writes made together: after a crash, either all of them are in the store or none of them are.
+type Batch struct {
+	entries []entry
+}
+
+func (batch *Batch) Put(key string, value []byte) {
+	batch.entries = append(batch.entries, entry{op: opPut, key: key, value: value})
+}
+
+func (batch *Batch) Delete(key string) {
+	batch.entries = append(batch.entries, entry{op: opDelete, key: key})
+}
+
+func (batch *Batch) Len() int {
+	return len(batch.entries)
+}
+
+// OpenKV opens the key-value store in the file at path, creating it if it doesn't exist, and starts compacting it in
+// the background if options.CompactInterval is set.
+func OpenKV(path string, options KVOptions) (*KV, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	kv := &KV{path: path, options: options, file: file, index: kvIndex{locations: map[string]location{}}, stop: make(chan struct{})}
+	if err := kv.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if options.CompactInterval > 0 {
+		kv.start()
+	}
+	return kv, nil
+}
+
+// recover rebuilds the index from the file. A record that is cut short or corrupt is dropped with everything after
+// it, as writes after it can't be told apart from garbage.
+func (kv *KV) recover() error {
+	info, err := kv.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		if _, err := kv.file.WriteAt([]byte(kvMagic), 0); err != nil {
+			return err
+		}
+		kv.size = int64(len(kvMagic))
+		return kv.file.Sync()
+	}
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(kv.file, 0, info.Size()), 1<<16)
+	magic := make([]byte, len(kvMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != kvMagic {
+		return fmt.Errorf("%w: %s", ErrNotKVFile, kv.path)
+	}
+	offset := int64(len(kvMagic))
+	for {
+		entries, size, err := readRecord(reader, offset, info.Size()-offset)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			slog.Warn("dropping the end of a key-value store that was cut short or is corrupt", "path", kv.path,
+				"offset", offset, "bytes", info.Size()-offset, "error", err)
+			if err := kv.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := kv.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		kv.index.apply(entries)
+		offset += size
+	}
+	kv.size = offset
+	return nil
+}
+
+// readRecord reads the record at offset in the file, with remaining bytes of the file left, and returns its entries and
+// its size. It returns io.EOF at the end of the file.
+func readRecord(reader io.Reader, offset int64, remaining int64) ([]entry, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); errors.Is(err, io.EOF) {
+		return nil, 0, io.EOF
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, 0, errTornRecord
+	} else if err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[4:]))
+	if length > remaining-recordHeaderSize {
+		return nil, 0, errTornRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, errTornRecord
+	}
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(header) {
+		return nil, 0, errBadChecksum
+	}
+	entries, err := decodeEntries(payload, offset+recordHeaderSize)
+	return entries, recordHeaderSize + length, err
+}
+
+// encodeRecord frames entries as a record.
+func encodeRecord(entries []entry) []byte {
+	size := recordHeaderSize
+	for _, e := range entries {
+		size += 1 + 2*binary.MaxVarintLen32 + len(e.key) + len(e.value)
+	}
+	record := make([]byte, recordHeaderSize, size)
+	for _, e := range entries {
+		record = append(record, e.op)
+		record = binary.AppendUvarint(record, uint64(len(e.key)))
+		record = binary.AppendUvarint(record, uint64(len(e.value)))
+		record = append(record, e.key...)
+		record = append(record, e.value...)
+	}
+	payload := record[recordHeaderSize:]
+	binary.BigEndian.PutUint32(record, crc32.Checksum(payload, castagnoli))
+	binary.BigEndian.PutUint32(record[4:], uint32(len(payload)))
+	return record
+}
+
+// decodeEntries reads the entries of a record's payload, which starts at offset in the file. The values aren't copied,
+// only where they are is recorded.
+func decodeEntries(payload []byte, offset int64) ([]entry, error) {
+	var entries []entry
+	for position := 0; position < len(payload); {
+		start := position
+		op := payload[position]
+		position++
+		keySize, n := binary.Uvarint(payload[position:])
+		if n <= 0 {
+			return nil, errBadEntry
+		}
+		position += n
+		valueSize, n := binary.Uvarint(payload[position:])
+		if n <= 0 || op != opPut && op != opDelete {
+			return nil, errBadEntry
+		}
+		if rest := uint64(len(payload) - position - n); keySize > rest || valueSize > rest-keySize {
+			return nil, errBadEntry
+		}
+		position += n
+		key := string(payload[position : position+int(keySize)])
+		position += int(keySize)
+		valueOffset := position
+		position += int(valueSize)
+		entries = append(entries, entry{op: op, key: key, location: location{offset: offset + int64(valueOffset), size: int(valueSize), span: position - start}})
+	}
+	return entries, nil
+}
+
+// apply points the index at the values of entries that have been written.
+func (index *kvIndex) apply(entries []entry) {
+	for _, e := range entries {
+		if previous, exists := index.locations[e.key]; exists {
+			index.live -= int64(previous.span)
+		}
+		if e.op == opDelete {
+			delete(index.locations, e.key)
+			continue
+		}
+		index.locations[e.key] = e.location
+		index.live += int64(e.span)
+	}
+}
+
+// Get returns the value of key, or ErrKeyNotFound.
+func (kv *KV) Get(key string) ([]byte, error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.closed {
+		return nil, ErrKVClosed
+	}
+	location, exists := kv.index.locations[key]
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	value := make([]byte, location.size)
+	if _, err := kv.file.ReadAt(value, location.offset); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (kv *KV) Put(key string, value []byte) error {
+	var batch Batch
+	batch.Put(key, value)
+	return kv.Write(&batch)
+}
+
+// Delete deletes key. Deleting a key that doesn't exist isn't an error.
+func (kv *KV) Delete(key string) error {
+	var batch Batch
+	batch.Delete(key)
+	return kv.Write(&batch)
+}
+
+// Write makes a batch of writes as a single record.
+func (kv *KV) Write(batch *Batch) error {
+	if len(batch.entries) == 0 {
+		return nil
+	}
+	record := encodeRecord(batch.entries)
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.closed {
+		return ErrKVClosed
+	}
+	if err := kv.append(record); err != nil {
+		// Don't leave half a record for the next write to follow
+		kv.file.Truncate(kv.size)
+		return err
+	}
+	entries, _ := decodeEntries(record[recordHeaderSize:], kv.size+recordHeaderSize)
+	kv.index.apply(entries)
+	kv.size += int64(len(record))
+	return nil
+}
+
+// append writes a record to the end of the file. The caller must hold the write lock.
+func (kv *KV) append(record []byte) error {
+	if _, err := kv.file.WriteAt(record, kv.size); err != nil {
+		return err
+	}
+	if kv.options.SyncWrites {
+		return kv.file.Sync()
+	}
+	return nil
+}
+
+// Scan calls fn with every key that starts with prefix and its value, in the order they are in the file, until fn
+// returns an error. The value is only valid until fn returns. Writes wait until the scan is done.
+func (kv *KV) Scan(prefix string, fn func(key string, value []byte) error) error {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.closed {
+		return ErrKVClosed
+	}
+	var keys []string
+	for key := range kv.index.locations {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Compare(kv.index.locations[a].offset, kv.index.locations[b].offset)
+	})
+
+	var value []byte
+	for _, key := range keys {
+		location := kv.index.locations[key]
+		value = slices.Grow(value[:0], location.size)[:location.size]
+		if _, err := kv.file.ReadAt(value, location.offset); err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns every key that starts with prefix, sorted.
+func (kv *KV) Keys(prefix string) []string {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	var keys []string
+	for key := range kv.index.locations {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (kv *KV) Stats() KVStats {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	return KVStats{
+		Keys:        len(kv.index.locations),
+		Size:        kv.size,
+		Garbage:     kv.size - int64(len(kvMagic)) - kv.index.live,
+		Compactions: kv.compactions,
+	}
+}
+
+// Sync waits for every write to reach the disk. Only needed without SyncWrites.
+func (kv *KV) Sync() error {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.closed {
+		return ErrKVClosed
+	}
+	return kv.file.Sync()
+}
+
+// Compact rewrites the file with only the latest value of every key. The live values are copied to a new file while
+// reads and writes carry on, then the writes made in the meantime are copied after them and the new file renamed over
+// the old one. A compaction that fails leaves the old file as it was.
+func (kv *KV) Compact() error {
+	kv.compacting.Lock()
+	defer kv.compacting.Unlock()
+
+	kv.mu.RLock()
+	if kv.closed {
+		kv.mu.RUnlock()
+		return ErrKVClosed
+	}
+	file, copiedTo := kv.file, kv.size
+	type live struct {
+		key string
+		location
+	}
+	values := make([]live, 0, len(kv.index.locations))
+	for key, location := range kv.index.locations {
+		values = append(values, live{key, location})
+	}
+	kv.mu.RUnlock()
+	// Reading the old file in order keeps the reads sequential
+	slices.SortFunc(values, func(a, b live) int { return cmp.Compare(a.offset, b.offset) })
+
+	compacted, err := os.CreateTemp(filepath.Dir(kv.path), "."+filepath.Base(kv.path)+"-*.compact")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(compacted.Name())
+	committed := false
+	defer func() {
+		if !committed {
+			compacted.Close()
+		}
+	}()
+
+	// The values are only read from the part of the old file that was written before the copy started, which is never
+	// written to again, so the old file can be read without holding the lock
+	writer := bufio.NewWriterSize(compacted, 1<<16)
+	writer.WriteString(kvMagic)
+	index := kvIndex{locations: make(map[string]location, len(values))}
+	size := int64(len(kvMagic))
+	var batch Batch
+	batchSize := 0
+	flush := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		record := encodeRecord(batch.entries)
+		if _, err := writer.Write(record); err != nil {
+			return err
+		}
+		entries, _ := decodeEntries(record[recordHeaderSize:], size+recordHeaderSize)
+		index.apply(entries)
+		size += int64(len(record))
+		batch, batchSize = Batch{}, 0
+		return nil
+	}
+	for _, value := range values {
+		contents := make([]byte, value.size)
+		if _, err := file.ReadAt(contents, value.offset); err != nil {
+			return err
+		}
+		batch.Put(value.key, contents)
+		if batchSize += value.span; batchSize >= compactRecordSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.closed {
+		return ErrKVClosed
+	}
+	if kv.size > copiedTo {
+		// Copy the records written since the copy started as they are, then index them where they are in the new file
+		tail := make([]byte, kv.size-copiedTo)
+		if _, err := kv.file.ReadAt(tail, copiedTo); err != nil {
+			return err
+		}
+		if _, err := compacted.Write(tail); err != nil {
+			return err
+		}
+		reader := bytes.NewReader(tail)
+		for offset := size; ; {
+			entries, recordSize, err := readRecord(reader, offset, size+int64(len(tail))-offset)
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return err
+			}
+			index.apply(entries)
+			offset += recordSize
+		}
+		size += int64(len(tail))
+	}
+	if err := compacted.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(compacted.Name(), kv.path); err != nil {
+		return err
+	}
+	committed = true
+	syncDir(filepath.Dir(kv.path))
+
+	kv.file.Close()
+	kv.file, kv.size, kv.index = compacted, size, index
+	kv.compactions++
+	return nil
+}
+
+// syncDir makes a rename in dir durable. Not every platform can sync a directory, so failing to is ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// dueCompacting reports whether enough of the file is garbage for it to be worth compacting.
+func (kv *KV) dueCompacting() bool {
+	stats := kv.Stats()
+	return kv.options.CompactGarbage > 0 && stats.Size >= kv.options.CompactMinSize &&
+		float64(stats.Garbage) >= kv.options.CompactGarbage*float64(stats.Size)
+}
+
+// start compacts the store every CompactInterval it is due it, until it is closed.
+func (kv *KV) start() {
+	kv.workers.Add(1)
+	go func() {
+		defer kv.workers.Done()
+		ticker := time.NewTicker(kv.options.CompactInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !kv.dueCompacting() {
+					continue
+				}
+				start := time.Now()
+				if err := kv.Compact(); err != nil {
+					slog.Error("error compacting key-value store", "path", kv.path, "error", err)
+				} else {
+					slog.Info("key-value store compacted", "path", kv.path, "size", kv.Stats().Size, "took", time.Since(start))
+				}
+			case <-kv.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops compacting the store in the background and closes its file, after syncing it.
+func (kv *KV) Close() error {
+	kv.once.Do(func() { close(kv.stop) })
+	kv.workers.Wait()
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.closed {
+		return nil
+	}
+	kv.closed = true
+	return errors.Join(kv.file.Sync(), kv.file.Close())
+}
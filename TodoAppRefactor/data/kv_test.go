@@ -0,0 +1,247 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openKV(t *testing.T, path string) *KV {
+	kv, err := OpenKV(path, KVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { kv.Close() })
+	return kv
+}
+
+func expectValue(t *testing.T, kv *KV, key string, expected string) {
+	t.Helper()
+	value, err := kv.Get(key)
+	if err != nil || string(value) != expected {
+		t.Errorf("Unexpected value of %s. Got: %q, %v, Expected: %q", key, value, err, expected)
+	}
+}
+
+func TestKV_ReadsAndWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lists.kv")
+	kv := openKV(t, path)
+	kv.Put("item/1", []byte("Milk"))
+	kv.Put("item/2", []byte("Eggs"))
+	kv.Put("item/1", []byte("Oat milk"))
+	kv.Delete("item/2")
+	var batch Batch
+	batch.Put("list/1", []byte("Groceries"))
+	batch.Put("item/3", []byte("Bread"))
+	batch.Delete("item/3")
+	if err := kv.Write(&batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both before and after the index is rebuilt from the file
+	for _, store := range []func() *KV{func() *KV { return kv }, func() *KV { return reopen(t, kv, path) }} {
+		store := store()
+		expectValue(t, store, "item/1", "Oat milk")
+		expectValue(t, store, "list/1", "Groceries")
+		for _, key := range []string{"item/2", "item/3"} {
+			if _, err := store.Get(key); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("A deleted key was found. Got: %v", err)
+			}
+		}
+		if keys := store.Keys("item/"); len(keys) != 1 || keys[0] != "item/1" {
+			t.Errorf("Unexpected keys. Got: %v", keys)
+		}
+	}
+}
+
+// reopen closes kv and opens the file at path again.
+func reopen(t *testing.T, kv *KV, path string) *KV {
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get("item/1"); !errors.Is(err, ErrKVClosed) {
+		t.Errorf("A closed store was read. Got: %v", err)
+	}
+	return openKV(t, path)
+}
+
+func TestKV_Recovers(t *testing.T) {
+	testCases := []struct {
+		testName string
+		// damage damages the file after the first size bytes, which hold the first write
+		damage func(contents []byte, size int64) []byte
+	}{
+		{"Testing a record cut short", func(contents []byte, size int64) []byte { return contents[:len(contents)-3] }},
+		{"Testing a header cut short", func(contents []byte, size int64) []byte { return append(contents[:size], 0, 0, 0) }},
+		{"Testing a record that doesn't match its checksum", func(contents []byte, size int64) []byte {
+			contents[len(contents)-1] ^= 0xff
+			return contents
+		}},
+		{"Testing a length running past the end of the file", func(contents []byte, size int64) []byte {
+			return append(contents[:size], 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff)
+		}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "lists.kv")
+			kv := openKV(t, path)
+			kv.Put("item/1", []byte("Milk"))
+			size := kv.Stats().Size
+			var batch Batch
+			batch.Put("item/1", []byte("Oat milk"))
+			batch.Put("item/2", []byte("Eggs"))
+			kv.Write(&batch)
+			kv.Close()
+
+			contents, _ := os.ReadFile(path)
+			os.WriteFile(path, test.damage(contents, size), 0o600)
+			kv = openKV(t, path)
+			if stats := kv.Stats(); stats.Keys != 1 || stats.Size != size {
+				t.Errorf("Unexpected store after recovering. Got: %+v, Expected %d bytes", stats, size)
+			}
+			// A damaged batch is dropped whole
+			expectValue(t, kv, "item/1", "Milk")
+			if _, err := kv.Get("item/2"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Part of a damaged batch was recovered. Got: %v", err)
+			}
+
+			kv.Put("item/3", []byte("Bread"))
+			expectValue(t, reopen(t, kv, path), "item/3", "Bread")
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	os.WriteFile(path, []byte(`{"Format":"todoapp-state"}`), 0o600)
+	if _, err := OpenKV(path, KVOptions{}); !errors.Is(err, ErrNotKVFile) {
+		t.Errorf("A file that isn't a key-value store was opened. Got: %v", err)
+	}
+}
+
+func TestKV_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lists.kv")
+	kv := openKV(t, path)
+	for round := range 20 {
+		for item := range 50 {
+			kv.Put(fmt.Sprint("item/", item), []byte(fmt.Sprint("Item ", item, " version ", round)))
+		}
+	}
+	for item := 25; item < 50; item++ {
+		kv.Delete(fmt.Sprint("item/", item))
+	}
+	before := kv.Stats()
+
+	// Writes made while the store is compacting are kept
+	var writers sync.WaitGroup
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for item := range 100 {
+			kv.Put(fmt.Sprint("new/", item), []byte("written while compacting"))
+		}
+	}()
+	if err := kv.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	writers.Wait()
+
+	after := kv.Stats()
+	if after.Compactions != 1 || after.Size >= before.Size/3 || after.Keys != 125 {
+		t.Errorf("Unexpected store after compacting. Got: %+v, before: %+v", after, before)
+	}
+	for _, store := range []func() *KV{func() *KV { return kv }, func() *KV { return reopen(t, kv, path) }} {
+		store := store()
+		expectValue(t, store, "item/0", "Item 0 version 19")
+		expectValue(t, store, "new/99", "written while compacting")
+		if _, err := store.Get("item/25"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("A deleted key came back after compacting. Got: %v", err)
+		}
+		if keys := store.Keys(""); len(keys) != 125 {
+			t.Errorf("Unexpected number of keys. Got: %d", len(keys))
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*")); len(leftovers) != 0 {
+		t.Errorf("Compacting left files behind. Got: %v", leftovers)
+	}
+}
+
+func TestKVStore_SavesAndLoads(t *testing.T) {
+	store := NewKVStore(openKV(t, filepath.Join(t.TempDir(), "lists.kv")))
+	if _, found, err := store.Load(); found || err != nil {
+		t.Errorf("An empty store loaded something. Got: %t, %v", found, err)
+	}
+
+	list := TodoList{Id: 1, Name: "Groceries", OwnerId: 7}
+	changes := Changes{
+		Meta:  StoreMeta{NextListId: 2, NextItemId: 4, Version: 3},
+		Lists: []ListRecord{{TodoList: list, Members: map[int]Role{7: RoleOwner}, Default: true}},
+		Items: []ItemRecord{
+			{ListId: 1, Id: 2, Item: &TodoItem{Id: 2, Name: "Eggs"}, Position: "a1"},
+			{ListId: 1, Id: 1, Item: &TodoItem{Id: 1, Name: "Milk"}, Position: "a0"},
+			{ListId: 1, Id: 3, Item: &TodoItem{Id: 3, Name: "Bread"}, Position: "a2"},
+		},
+		SyncedSeqs: []SyncedSeq{{UserId: 7, Client: "phone", ListId: 1, Seq: 5}},
+	}
+	if err := store.Save(changes); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting an item leaves its tombstone, and a record with neither is removed
+	tombstone := &ItemState{Version: 3, Deleted: true}
+	if err := store.Save(Changes{Meta: changes.Meta, Items: []ItemRecord{{ListId: 1, Id: 2, State: tombstone}, {ListId: 1, Id: 3}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, found, err := store.Load()
+	if err != nil || !found {
+		t.Fatalf("Unexpected load. Got: %t, %v", found, err)
+	}
+	if len(snapshot.Lists) != 1 || snapshot.DefaultLists[7] != 1 || snapshot.NextItemId != 4 || len(snapshot.SyncedSeqs) != 1 {
+		t.Fatalf("Unexpected snapshot. Got: %+v", snapshot)
+	}
+	if saved := snapshot.Lists[0]; len(saved.Items) != 1 || saved.Items[0].Name != "Milk" || saved.Positions[1] != "a0" || !saved.States[2].Deleted || len(saved.States) != 1 {
+		t.Errorf("Unexpected list. Got: %+v", saved)
+	}
+
+	if err := store.Save(Changes{Reset: true, Meta: StoreMeta{NextListId: 1, NextItemId: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, _, _ := store.Load(); len(snapshot.Lists) != 0 || len(snapshot.SyncedSeqs) != 0 {
+		t.Errorf("A reset left records behind. Got: %+v", snapshot)
+	}
+}
+
+func TestKVStore_Probe(t *testing.T) {
+	store := NewKVStore(openKV(t, filepath.Join(t.TempDir(), "lists.kv")))
+	for _, value := range []uint64{1, 2} {
+		if read, err := store.Probe(value); err != nil || read != value {
+			t.Errorf("Unexpected probe of %d. Got: %d, %v", value, read, err)
+		}
+	}
+	if _, found, err := store.Load(); found || err != nil {
+		t.Errorf("A store holding only a probe loaded something. Got: %t, %v", found, err)
+	}
+}
+
+func TestKV_CompactsInTheBackground(t *testing.T) {
+	kv, err := OpenKV(filepath.Join(t.TempDir(), "lists.kv"), KVOptions{CompactInterval: time.Millisecond, CompactGarbage: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	for round := range 10 {
+		kv.Put("item/1", []byte(fmt.Sprint("version ", round)))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for kv.Stats().Compactions == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := kv.Stats(); stats.Compactions == 0 || stats.Keys != 1 {
+		t.Errorf("The store wasn't compacted in the background. Got: %+v", stats)
+	}
+	expectValue(t, kv, "item/1", "version 9")
+}
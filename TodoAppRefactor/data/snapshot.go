@@ -0,0 +1,47 @@
+package data
+
+import "time"
+
+// Snapshot is everything a data service stores at one moment: its lists with their items, members and ordering keys,
+// what offline sync needs to merge changes to them, and the counters new IDs and versions are taken from.
+type Snapshot struct {
+	Lists []ListSnapshot
+	// DefaultLists are the IDs of the users' default lists, by user ID.
+	DefaultLists map[int]int
+	NextListId   int
+	NextItemId   int
+	Version      uint64
+	SyncedSeqs   []SyncedSeq `json:",omitempty"`
+}
+
+type ListSnapshot struct {
+	TodoList
+	Items   []TodoItem
+	Members map[int]Role
+	// Positions are the ordering keys of the items, by item ID.
+	Positions     map[int]string
+	States        map[int]ItemState `json:",omitempty"`
+	PrunedVersion uint64            `json:",omitempty"`
+}
+
+// ItemState is the merge state of an item: when each of its fields last changed, and whether it was deleted.
+type ItemState struct {
+	Version uint64
+	Fields  map[string]FieldStamp
+	Deleted bool   `json:",omitempty"`
+	Origin  string `json:",omitempty"`
+}
+
+type FieldStamp struct {
+	Version uint64
+	At      time.Time
+	Client  string `json:",omitempty"`
+}
+
+// SyncedSeq is the Seq of the last mutation applied for a sync client.
+type SyncedSeq struct {
+	UserId int
+	Client string
+	ListId int
+	Seq    uint64
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A data service is kept in a store as records: one for its counters, one per list, one per item and one per sync
+// client. A change only writes the records it touched, however big the rest of the list is.
+type StoreMeta struct {
+	NextListId int
+	NextItemId int
+	Version    uint64
+}
+
+// ListRecord is a list without its items. Default is set on the default list of its owner.
+type ListRecord struct {
+	TodoList
+	Members       map[int]Role
+	PrunedVersion uint64 `json:",omitempty"`
+	Default       bool   `json:",omitempty"`
+}
+
+// ItemRecord is an item of a list, with its ordering key and merge state. Deleted items only have a State, the
+// tombstone, and a record with neither an Item nor a State is deleted from the store.
+type ItemRecord struct {
+	ListId   int
+	Id       int
+	Item     *TodoItem  `json:",omitempty"`
+	Position string     `json:",omitempty"`
+	State    *ItemState `json:",omitempty"`
+}
+
+// Changes are the records that changed since a data service last wrote to its store, to be written together.
+type Changes struct {
+	// Reset throws away everything the store holds before the records are written, as when a snapshot is restored.
+	Reset      bool
+	Meta       StoreMeta
+	Lists      []ListRecord
+	Items      []ItemRecord
+	SyncedSeqs []SyncedSeq
+}
+
+const (
+	metaKey      = "meta"
+	listPrefix   = "list/"
+	itemPrefix   = "item/"
+	syncedPrefix = "synced/"
+	probeKey     = "probe"
+)
+
+var ErrCorruptStore = errors.New("the store holds a record that can't be read")
+
+// KVStore keeps a data service in a KV, each record under its own key.
+type KVStore struct {
+	kv *KV
+}
+
+func NewKVStore(kv *KV) *KVStore {
+	return &KVStore{kv: kv}
+}
+
+func listKey(id int) string {
+	return listPrefix + strconv.Itoa(id)
+}
+
+func itemKey(listId int, id int) string {
+	return itemPrefix + strconv.Itoa(listId) + "/" + strconv.Itoa(id)
+}
+
+// Load returns everything the store holds as a snapshot, and false if it holds nothing yet.
+func (store *KVStore) Load() (Snapshot, bool, error) {
+	var meta StoreMeta
+	encoded, err := store.kv.Get(metaKey)
+	if errors.Is(err, ErrKeyNotFound) {
+		return Snapshot{}, false, nil
+	} else if err != nil {
+		return Snapshot{}, false, err
+	}
+	if err := json.Unmarshal(encoded, &meta); err != nil {
+		return Snapshot{}, false, fmt.Errorf("%w: %s: %w", ErrCorruptStore, metaKey, err)
+	}
+
+	snapshot := Snapshot{DefaultLists: map[int]int{}, NextListId: meta.NextListId, NextItemId: meta.NextItemId, Version: meta.Version}
+	lists := map[int]*ListSnapshot{}
+	var items []ItemRecord
+	err = store.kv.Scan("", func(key string, value []byte) error {
+		var err error
+		switch {
+		case strings.HasPrefix(key, listPrefix):
+			var record ListRecord
+			if err = json.Unmarshal(value, &record); err == nil {
+				lists[record.Id] = &ListSnapshot{TodoList: record.TodoList, Members: record.Members, Positions: map[int]string{},
+					States: map[int]ItemState{}, PrunedVersion: record.PrunedVersion}
+				if record.Default {
+					snapshot.DefaultLists[record.OwnerId] = record.Id
+				}
+			}
+		case strings.HasPrefix(key, itemPrefix):
+			var record ItemRecord
+			if err = json.Unmarshal(value, &record); err == nil {
+				items = append(items, record)
+			}
+		case strings.HasPrefix(key, syncedPrefix):
+			var synced SyncedSeq
+			if err = json.Unmarshal(value, &synced); err == nil {
+				snapshot.SyncedSeqs = append(snapshot.SyncedSeqs, synced)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrCorruptStore, key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+
+	// Items are put back in their lists in the order of their ordering keys
+	slices.SortFunc(items, func(a, b ItemRecord) int { return cmp.Or(cmp.Compare(a.Position, b.Position), cmp.Compare(a.Id, b.Id)) })
+	for _, record := range items {
+		list, exists := lists[record.ListId]
+		if !exists {
+			return Snapshot{}, false, fmt.Errorf("%w: item %d is in list %d, which doesn't exist", ErrCorruptStore, record.Id, record.ListId)
+		}
+		if record.Item != nil {
+			list.Items = append(list.Items, *record.Item)
+			list.Positions[record.Id] = record.Position
+		}
+		if record.State != nil {
+			list.States[record.Id] = *record.State
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(lists)) {
+		snapshot.Lists = append(snapshot.Lists, *lists[id])
+	}
+	slices.SortFunc(snapshot.SyncedSeqs, func(a, b SyncedSeq) int {
+		return cmp.Or(cmp.Compare(a.UserId, b.UserId), cmp.Compare(a.ListId, b.ListId), cmp.Compare(a.Client, b.Client))
+	})
+	return snapshot, true, nil
+}
+
+// Save writes changes as a single batch, so a crash part way through leaves the store as it was before them.
+func (store *KVStore) Save(changes Changes) error {
+	var batch Batch
+	if changes.Reset {
+		for _, key := range store.kv.Keys("") {
+			batch.Delete(key)
+		}
+	}
+	put := func(key string, record any) error {
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		batch.Put(key, encoded)
+		return nil
+	}
+
+	if err := put(metaKey, changes.Meta); err != nil {
+		return err
+	}
+	for _, list := range changes.Lists {
+		if err := put(listKey(list.Id), list); err != nil {
+			return err
+		}
+	}
+	for _, item := range changes.Items {
+		if item.Item == nil && item.State == nil {
+			batch.Delete(itemKey(item.ListId, item.Id))
+		} else if err := put(itemKey(item.ListId, item.Id), item); err != nil {
+			return err
+		}
+	}
+	for _, synced := range changes.SyncedSeqs {
+		if err := put(fmt.Sprintf("%s%d/%d/%s", syncedPrefix, synced.UserId, synced.ListId, synced.Client), synced); err != nil {
+			return err
+		}
+	}
+	return store.kv.Write(&batch)
+}
+
+// Probe writes value to the store and reads it back, so that a store that can't be written or loses writes is found.
+// The probe isn't a record and isn't loaded.
+func (store *KVStore) Probe(value uint64) (uint64, error) {
+	if err := store.kv.Put(probeKey, []byte(strconv.FormatUint(value, 10))); err != nil {
+		return 0, err
+	}
+	read, err := store.kv.Get(probeKey)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(read), 10, 64)
+}
+
+func (store *KVStore) Close() error {
+	return store.kv.Close()
+}
@@ -0,0 +1,85 @@
+package data
+
+import "time"
+
+// Operations a client can make offline.
+const (
+	SyncCreate = "create"
+	SyncUpdate = "update"
+	SyncDelete = "delete"
+)
+
+// Reasons a mutation conflicted with changes made on the server since the client last synced.
+const (
+	// ConflictOverwritten means the mutation won, replacing a change made concurrently by someone else.
+	ConflictOverwritten = "overwritten"
+	// ConflictSuperseded means a change made concurrently by someone else won, and the mutation was dropped.
+	ConflictSuperseded = "superseded"
+	// ConflictDeleted means the item was deleted, and the mutation was dropped.
+	ConflictDeleted = "deleted"
+	// ConflictNotFound means the list never had the item.
+	ConflictNotFound = "notFound"
+)
+
+// Mutation is a change a client made to a list while it was offline.
+type Mutation struct {
+	// Seq numbers the mutations a client makes to a list, going up by at least one each time. Mutations at or below
+	// the highest Seq already applied are skipped, so a batch can be sent again if its response was lost.
+	Seq uint64
+	Op  string
+	// ItemId is the item updated or deleted. Items created offline don't have an ID yet, so a create carries a Ref
+	// chosen by the client instead, which is returned with the ID the item was given.
+	ItemId int
+	Ref    string
+	// BaseVersion is the version of the item the client last synced, telling apart the changes it had seen from
+	// those made concurrently.
+	BaseVersion uint64
+	// Time is when the change was made on the client. It decides which of two concurrent changes to a field wins.
+	Time time.Time
+	// Name and Complete are the fields the mutation sets, nil for those it doesn't change.
+	Name     *string
+	Complete *bool
+}
+
+// SyncBatch is what a client uploads when it syncs: the mutations it made since it last synced, and the token from
+// then, 0 the first time.
+type SyncBatch struct {
+	ClientId  string
+	Since     uint64
+	Mutations []Mutation
+}
+
+// SyncedItem is an item that changed since the client last synced. Deleted items only have their ID and Version.
+type SyncedItem struct {
+	TodoItem
+	Index   int
+	Version uint64
+	Deleted bool
+}
+
+type Conflict struct {
+	Seq    uint64
+	ItemId int
+	// Field is the field the conflict was over, or empty when it was over the whole item.
+	Field  string
+	Reason string
+}
+
+// ItemChange is a change a sync made to an item. Before is nil for creates and After is nil for deletes.
+type ItemChange struct {
+	Op     string
+	Before *TodoItem
+	After  *TodoItem
+}
+
+// SyncResult is the server's side of a sync. Token is what the client sends as Since next time. When Reset is set
+// Items is the whole list, which replaces the client's copy, otherwise it is the items changed since the client's
+// token, including by the client's own mutations.
+type SyncResult struct {
+	Token     uint64
+	Reset     bool
+	Items     []SyncedItem
+	Created   map[string]int
+	Conflicts []Conflict
+	Changes   []ItemChange
+}
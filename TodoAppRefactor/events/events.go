@@ -0,0 +1,230 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+	"todoApp/data"
+)
+
+// DefaultBufferSize is how many of the most recent events a hub keeps for clients catching up.
+const DefaultBufferSize = 1024
+
+// subscriberBuffer is how many events can wait for a subscriber before it is considered too slow and dropped.
+const subscriberBuffer = 64
+
+type Type string
+
+const (
+	ItemCreated   Type = "item.created"
+	ItemUpdated   Type = "item.updated"
+	ItemCompleted Type = "item.completed"
+	ItemDeleted   Type = "item.deleted"
+	ItemMoved     Type = "item.moved"
+	ListCreated   Type = "list.created"
+	MemberAdded   Type = "member.added"
+	MemberUpdated Type = "member.updated"
+	MemberRemoved Type = "member.removed"
+)
+
+// Event is a change made to a list. Item events carry the item and its position in the list, after the change for
+// creates, updates, completes and moves and before it for deletes.
+type Event struct {
+	Seq     uint64
+	Type    Type
+	Time    time.Time
+	Tenant  string
+	ListId  int
+	ActorId int
+	Item    *data.TodoItem `json:",omitempty"`
+	Index   *int           `json:",omitempty"`
+	List    *data.TodoList `json:",omitempty"`
+	Member  *data.Member   `json:",omitempty"`
+	// Members are the IDs of the users who were members of the list when the change was made, and so may be told
+	// about it.
+	Members []int `json:"-"`
+}
+
+// Hub is an in-process pub/sub hub. Every published event is given the next sequence number, kept in a bounded replay
+// buffer and sent to every subscriber it matches. Publishing never blocks: a subscriber that falls too far behind is
+// dropped, and can catch up from the replay buffer when it subscribes again.
+type Hub struct {
+	buffer      []Event
+	start       int
+	seq         uint64
+	subscribers map[*Subscription]struct{}
+	closed      bool
+	done        chan struct{}
+	now         func() time.Time
+	mu          sync.Mutex
+}
+
+func NewHub(bufferSize int) *Hub {
+	return &Hub{
+		buffer:      make([]Event, 0, max(bufferSize, 1)),
+		subscribers: map[*Subscription]struct{}{},
+		done:        make(chan struct{}),
+		now:         time.Now,
+	}
+}
+
+// Publish fills in the event's sequence number and time and sends it to the subscribers it matches.
+func (hub *Hub) Publish(event Event) Event {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.seq++
+	event.Seq = hub.seq
+	event.Time = hub.now().UTC()
+	if len(hub.buffer) < cap(hub.buffer) {
+		hub.buffer = append(hub.buffer, event)
+	} else {
+		hub.buffer[hub.start] = event
+		hub.start = (hub.start + 1) % len(hub.buffer)
+	}
+
+	for sub := range hub.subscribers {
+		if !sub.match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			hub.drop(sub)
+		}
+	}
+	return event
+}
+
+// Seq returns the sequence number of the last event published.
+func (hub *Hub) Seq() uint64 {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	return hub.seq
+}
+
+// Since returns the buffered events after seq that match. complete is false if some of the events after seq are no
+// longer buffered, or seq is ahead of the hub, e.g. a cursor from before a restart, and the caller has to start over.
+func (hub *Hub) Since(seq uint64, match func(Event) bool) (events []Event, complete bool) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	return hub.since(seq, match)
+}
+
+// Wait is Since for clients that poll. If there are no matching events after seq it waits for one to be published,
+// until ctx is done or the hub is closed. cursor is the sequence number of the last event looked at, matching or not,
+// for the caller to pass as seq next time.
+func (hub *Hub) Wait(ctx context.Context, seq uint64, match func(Event) bool) (events []Event, cursor uint64, complete bool) {
+	hub.mu.Lock()
+	events, complete = hub.since(seq, match)
+	if len(events) > 0 || !complete || hub.closed {
+		defer hub.mu.Unlock()
+		return events, hub.seq, complete
+	}
+	// Nothing matching is buffered after seq, so the events returned once one is published are the ones after the
+	// current sequence number
+	seq = hub.seq
+	sub := &Subscription{events: make(chan Event, 1), match: match, hub: hub}
+	hub.subscribers[sub] = struct{}{}
+	hub.mu.Unlock()
+	defer sub.Close()
+
+	select {
+	case <-ctx.Done():
+	case <-sub.Events():
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	events, complete = hub.since(seq, match)
+	return events, hub.seq, complete
+}
+
+// Subscribe starts sending matching events to a new subscription. Buffered events after since are returned rather than
+// sent, with complete reporting as in Since. Nothing published in between is missed or sent twice. A since of 0 starts
+// from the next event.
+func (hub *Hub) Subscribe(since uint64, match func(Event) bool) (sub *Subscription, replay []Event, complete bool) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	sub = &Subscription{events: make(chan Event, subscriberBuffer), match: match, hub: hub}
+	if hub.closed {
+		close(sub.events)
+		return sub, nil, true
+	}
+	hub.subscribers[sub] = struct{}{}
+	if since == 0 {
+		return sub, nil, true
+	}
+	replay, complete = hub.since(since, match)
+	return sub, replay, complete
+}
+
+// Close ends every subscription and refuses new ones, so long-running streams finish when the server shuts down.
+func (hub *Hub) Close() {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if !hub.closed {
+		close(hub.done)
+	}
+	hub.closed = true
+	for sub := range hub.subscribers {
+		hub.drop(sub)
+	}
+}
+
+// Done is closed when the hub is closed.
+func (hub *Hub) Done() <-chan struct{} {
+	return hub.done
+}
+
+// since returns the buffered events after seq. The caller must hold the lock.
+func (hub *Hub) since(seq uint64, match func(Event) bool) ([]Event, bool) {
+	if seq > hub.seq {
+		return nil, false
+	}
+	complete := len(hub.buffer) == 0 || hub.buffer[hub.start].Seq <= seq+1
+
+	var events []Event
+	for i := range hub.buffer {
+		event := hub.buffer[(hub.start+i)%len(hub.buffer)]
+		if event.Seq > seq && match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, complete
+}
+
+// drop removes a subscriber and closes its channel. The caller must hold the lock.
+func (hub *Hub) drop(sub *Subscription) {
+	if _, subscribed := hub.subscribers[sub]; subscribed {
+		delete(hub.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Subscription receives the events it matches until it is closed, it falls too far behind or the hub is closed.
+type Subscription struct {
+	events chan Event
+	match  func(Event) bool
+	hub    *Hub
+}
+
+// Events is closed when the subscription ends.
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+func (sub *Subscription) Close() {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+
+	sub.hub.drop(sub)
+}
+
+// All matches every event.
+func All(Event) bool {
+	return true
+}
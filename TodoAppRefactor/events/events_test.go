@@ -0,0 +1,220 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHub_PublishAndSubscribe(t *testing.T) {
+	hub := NewHub(8)
+	sub, replay, complete := hub.Subscribe(0, func(event Event) bool { return event.ListId == 1 })
+	defer sub.Close()
+	if len(replay) != 0 || !complete {
+		t.Errorf("A new subscription should have nothing to replay. Got: %v, %v", replay, complete)
+	}
+
+	hub.Publish(Event{Type: ItemCreated, ListId: 2})
+	published := hub.Publish(Event{Type: ItemCreated, ListId: 1})
+	if published.Seq != 2 || published.Time.IsZero() || hub.Seq() != 2 {
+		t.Errorf("Published event wasn't numbered. Got: %v", published)
+	}
+
+	select {
+	case event := <-sub.Events():
+		if event.Seq != 2 || event.ListId != 1 {
+			t.Errorf("Unexpected event. Got: %v", event)
+		}
+	default:
+		t.Fatal("The matching event wasn't sent to the subscriber")
+	}
+	select {
+	case event := <-sub.Events():
+		t.Errorf("An event the subscriber doesn't match was sent. Got: %v", event)
+	default:
+	}
+}
+
+func TestHub_Since(t *testing.T) {
+	hub := NewHub(3)
+	for range 5 {
+		hub.Publish(Event{Type: ItemCreated})
+	}
+
+	testCases := []struct {
+		testName         string
+		since            uint64
+		expectedSeqs     []uint64
+		expectedComplete bool
+	}{
+		{"Testing a cursor within the buffer", 3, []uint64{4, 5}, true},
+		{"Testing a cursor just before the buffer", 2, []uint64{3, 4, 5}, true},
+		{"Testing a cursor that fell out of the buffer", 1, []uint64{3, 4, 5}, false},
+		{"Testing an up to date cursor", 5, nil, true},
+		{"Testing a cursor ahead of the hub", 9, nil, false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			events, complete := hub.Since(test.since, All)
+			if complete != test.expectedComplete {
+				t.Errorf("Unexpected complete. Got: %v, Expected: %v", complete, test.expectedComplete)
+			}
+			if len(events) != len(test.expectedSeqs) {
+				t.Fatalf("Unexpected events. Got: %v, Expected sequence numbers: %v", events, test.expectedSeqs)
+			}
+			for i, event := range events {
+				if event.Seq != test.expectedSeqs[i] {
+					t.Errorf("Unexpected event. Got: %v, Expected sequence number: %v", event.Seq, test.expectedSeqs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHub_SubscribeReplays(t *testing.T) {
+	hub := NewHub(8)
+	hub.Publish(Event{Type: ItemCreated})
+	hub.Publish(Event{Type: ItemDeleted})
+
+	sub, replay, complete := hub.Subscribe(1, All)
+	defer sub.Close()
+	hub.Publish(Event{Type: ItemCompleted})
+
+	if len(replay) != 1 || replay[0].Type != ItemDeleted || !complete {
+		t.Errorf("Unexpected replay. Got: %v, %v", replay, complete)
+	}
+	if event := <-sub.Events(); event.Type != ItemCompleted {
+		t.Errorf("Unexpected event after the replay. Got: %v", event)
+	}
+}
+
+func TestHub_Wait(t *testing.T) {
+	hub := NewHub(8)
+	onList1 := func(event Event) bool { return event.ListId == 1 }
+	hub.Publish(Event{Type: ItemCreated, ListId: 1})
+	hub.Publish(Event{Type: ItemCreated, ListId: 2})
+
+	if events, cursor, complete := hub.Wait(context.Background(), 0, onList1); len(events) != 1 || cursor != 2 || !complete {
+		t.Errorf("Buffered events should be returned straight away. Got: %v, %v, %v", events, cursor, complete)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if events, cursor, complete := hub.Wait(ctx, 2, onList1); len(events) != 0 || cursor != 2 || !complete {
+		t.Errorf("Waiting should time out without events. Got: %v, %v, %v", events, cursor, complete)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		hub.Publish(Event{Type: ItemDeleted, ListId: 2})
+		hub.Publish(Event{Type: ItemDeleted, ListId: 1})
+	}()
+	events, cursor, complete := hub.Wait(context.Background(), 2, onList1)
+	if len(events) != 1 || events[0].Seq != 4 || cursor != 4 || !complete {
+		t.Errorf("Waiting should return the next matching event. Got: %v, %v, %v", events, cursor, complete)
+	}
+
+	if events, cursor, complete := hub.Wait(context.Background(), 9, onList1); len(events) != 0 || cursor != 4 || complete {
+		t.Errorf("A cursor ahead of the hub should be reset. Got: %v, %v, %v", events, cursor, complete)
+	}
+}
+
+func TestHub_DropsSlowSubscribers(t *testing.T) {
+	hub := NewHub(8)
+	sub, _, _ := hub.Subscribe(0, All)
+
+	for range subscriberBuffer + 1 {
+		hub.Publish(Event{Type: ItemCreated})
+	}
+
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Unexpected number of events before the subscriber was dropped. Got: %v, Expected: %v", received, subscriberBuffer)
+	}
+	sub.Close()
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := NewHub(8)
+	sub, _, _ := hub.Subscribe(0, All)
+
+	hub.Close()
+	hub.Close()
+	select {
+	case <-hub.Done():
+	default:
+		t.Error("Done should be closed with the hub")
+	}
+	if _, open := <-sub.Events(); open {
+		t.Error("Closing the hub should end its subscriptions")
+	}
+	late, _, _ := hub.Subscribe(0, All)
+	if _, open := <-late.Events(); open {
+		t.Error("Subscriptions to a closed hub should end straight away")
+	}
+	sub.Close()
+}
+
+func TestHubs(t *testing.T) {
+	hubs := NewHubs(8)
+	var watched []string
+	hubs.For("team-a").Publish(Event{Type: ItemCreated})
+	hubs.Watch(func(tenant string, hub *Hub) { watched = append(watched, tenant) })
+
+	teamB := hubs.For("team-b")
+	if event := teamB.Publish(Event{Type: ItemCreated}); event.Seq != 1 {
+		t.Errorf("Each tenant's events should be numbered separately. Got: %v", event.Seq)
+	}
+	if hubs.For("team-a") == teamB || hubs.For("team-b") != teamB {
+		t.Error("Each tenant should always get the same hub of its own")
+	}
+	if len(watched) != 2 || watched[0] != "team-a" || watched[1] != "team-b" {
+		t.Errorf("The watcher should see existing hubs and those created later. Got: %v", watched)
+	}
+
+	hubs.Close()
+	for _, tenant := range []string{"team-a", "team-c"} {
+		select {
+		case <-hubs.For(tenant).Done():
+		default:
+			t.Errorf("The hub of %s should be closed", tenant)
+		}
+	}
+}
+
+func TestPresence(t *testing.T) {
+	presence := NewPresence()
+	var seenByAlice, seenByBob []Viewer
+	alice := Viewer{UserId: 1, Username: "alice"}
+	bob := Viewer{UserId: 2, Username: "bob"}
+
+	leaveAlice := presence.Join("default", 1, alice, func(viewers []Viewer) { seenByAlice = viewers })
+	leaveBob := presence.Join("default", 1, bob, func(viewers []Viewer) { seenByBob = viewers })
+	leaveBobsOtherTab := presence.Join("default", 1, bob, func([]Viewer) {})
+	presence.Join("acme", 1, Viewer{UserId: 3, Username: "carol"}, func([]Viewer) {})
+
+	if len(seenByAlice) != 2 || seenByAlice[0] != alice || seenByAlice[1] != bob {
+		t.Errorf("Alice should see both viewers once. Got: %v", seenByAlice)
+	}
+	if len(seenByBob) != 2 {
+		t.Errorf("Bob should be told who is already viewing. Got: %v", seenByBob)
+	}
+
+	leaveBob()
+	leaveBob()
+	if viewers := presence.Viewers("default", 1); len(viewers) != 2 {
+		t.Errorf("Bob is still viewing in another tab. Got: %v", viewers)
+	}
+	leaveBobsOtherTab()
+	if len(seenByAlice) != 1 || seenByAlice[0] != alice {
+		t.Errorf("Alice should be told Bob left. Got: %v", seenByAlice)
+	}
+	leaveAlice()
+	if viewers := presence.Viewers("default", 1); len(viewers) != 0 {
+		t.Errorf("Nobody should be viewing. Got: %v", viewers)
+	}
+}
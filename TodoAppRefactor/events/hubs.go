@@ -0,0 +1,71 @@
+package events
+
+import (
+	"slices"
+	"sync"
+)
+
+// Hubs keeps a hub for each tenant, so every tenant's changes have sequence numbers and a replay buffer of their own.
+// A tenant's clients see no gaps in the sequence from other tenants' changes, and a busy tenant can't push another
+// tenant's changes out of the replay buffer before its clients have caught up.
+type Hubs struct {
+	bufferSize int
+	hubs       map[string]*Hub
+	watchers   []func(tenant string, hub *Hub)
+	closed     bool
+	mu         sync.Mutex
+}
+
+// NewHubs creates hubs that each keep the bufferSize most recent events of their tenant.
+func NewHubs(bufferSize int) *Hubs {
+	return &Hubs{bufferSize: bufferSize, hubs: map[string]*Hub{}}
+}
+
+// For returns the tenant's hub, creating it the first time the tenant is asked for. Hubs created after Close are
+// already closed.
+func (hubs *Hubs) For(tenant string) *Hub {
+	hubs.mu.Lock()
+	defer hubs.mu.Unlock()
+
+	if hub, exists := hubs.hubs[tenant]; exists {
+		return hub
+	}
+	hub := NewHub(hubs.bufferSize)
+	hubs.hubs[tenant] = hub
+	if hubs.closed {
+		hub.Close()
+		return hub
+	}
+	for _, watch := range hubs.watchers {
+		watch(tenant, hub)
+	}
+	return hub
+}
+
+// Watch calls watch with every tenant's hub, now for those that exist and later for each one as it is created, for
+// consumers of every tenant's events. It is called with the hubs locked, so it mustn't call back into them.
+func (hubs *Hubs) Watch(watch func(tenant string, hub *Hub)) {
+	hubs.mu.Lock()
+	defer hubs.mu.Unlock()
+
+	hubs.watchers = append(hubs.watchers, watch)
+	tenants := make([]string, 0, len(hubs.hubs))
+	for tenant := range hubs.hubs {
+		tenants = append(tenants, tenant)
+	}
+	slices.Sort(tenants)
+	for _, tenant := range tenants {
+		watch(tenant, hubs.hubs[tenant])
+	}
+}
+
+// Close closes every tenant's hub.
+func (hubs *Hubs) Close() {
+	hubs.mu.Lock()
+	defer hubs.mu.Unlock()
+
+	hubs.closed = true
+	for _, hub := range hubs.hubs {
+		hub.Close()
+	}
+}
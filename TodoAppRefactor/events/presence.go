@@ -0,0 +1,93 @@
+package events
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// Viewer is a user looking at a list.
+type Viewer struct {
+	UserId   int
+	Username string
+}
+
+// Presence tracks who is viewing each list. A user with the list open in several places is only listed once, until
+// they have left it everywhere.
+type Presence struct {
+	lists map[presenceKey]map[*viewing]struct{}
+	mu    sync.Mutex
+}
+
+type presenceKey struct {
+	tenant string
+	listId int
+}
+
+type viewing struct {
+	viewer Viewer
+	notify func([]Viewer)
+}
+
+func NewPresence() *Presence {
+	return &Presence{lists: map[presenceKey]map[*viewing]struct{}{}}
+}
+
+// Join records that viewer is viewing a list, until leave is called. notify is called with the list's viewers every
+// time they change, starting with them including the new viewer. It is called with the tracker locked, so must not
+// block or call back into it.
+func (presence *Presence) Join(tenant string, listId int, viewer Viewer, notify func([]Viewer)) (leave func()) {
+	presence.mu.Lock()
+	defer presence.mu.Unlock()
+
+	key := presenceKey{tenant: tenant, listId: listId}
+	if presence.lists[key] == nil {
+		presence.lists[key] = map[*viewing]struct{}{}
+	}
+	entry := &viewing{viewer: viewer, notify: notify}
+	presence.lists[key][entry] = struct{}{}
+	presence.notify(key)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			presence.mu.Lock()
+			defer presence.mu.Unlock()
+
+			delete(presence.lists[key], entry)
+			if len(presence.lists[key]) == 0 {
+				delete(presence.lists, key)
+				return
+			}
+			presence.notify(key)
+		})
+	}
+}
+
+// Viewers returns who is viewing a list, ordered by user ID.
+func (presence *Presence) Viewers(tenant string, listId int) []Viewer {
+	presence.mu.Lock()
+	defer presence.mu.Unlock()
+
+	return presence.viewers(presenceKey{tenant: tenant, listId: listId})
+}
+
+// viewers returns the distinct viewers of a list. The caller must hold the lock.
+func (presence *Presence) viewers(key presenceKey) []Viewer {
+	viewers := []Viewer{}
+	for entry := range presence.lists[key] {
+		if !slices.ContainsFunc(viewers, func(viewer Viewer) bool { return viewer.UserId == entry.viewer.UserId }) {
+			viewers = append(viewers, entry.viewer)
+		}
+	}
+	slices.SortFunc(viewers, func(a, b Viewer) int { return cmp.Compare(a.UserId, b.UserId) })
+	return viewers
+}
+
+// notify tells everyone viewing a list who is viewing it. The caller must hold the lock.
+func (presence *Presence) notify(key presenceKey) {
+	viewers := presence.viewers(key)
+	for entry := range presence.lists[key] {
+		entry.notify(viewers)
+	}
+}
@@ -1,7 +1,25 @@
 package main
 
-import server "todoApp/cmd"
+import (
+	"os"
+	server "todoApp/cmd"
+)
 
 func main() {
-	server.StartServer()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backup":
+			os.Exit(server.BackupCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "restore":
+			os.Exit(server.RestoreCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "migrate":
+			os.Exit(server.MigrateCommand(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
+	cfg, err := server.LoadConfig(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	server.StartServer(cfg)
 }
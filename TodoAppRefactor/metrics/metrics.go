@@ -0,0 +1,395 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are histogram buckets suited to request and storage latencies, in seconds.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry used by the application and served on /metrics.
+var Default = NewRegistry()
+
+type collector interface {
+	describe() (name, help, kind string)
+	collect(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics and writes them out in the Prometheus text exposition format.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (reg *Registry) register(c collector) {
+	name, _, _ := c.describe()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	reg.names[name] = true
+	reg.collectors = append(reg.collectors, c)
+}
+
+// WriteTo writes every registered metric to w, sorted by name.
+func (reg *Registry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	collectors := append([]collector(nil), reg.collectors...)
+	reg.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		a, _, _ := collectors[i].describe()
+		b, _, _ := collectors[j].describe()
+		return a < b
+	})
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		name, help, kind := c.describe()
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, kind)
+		c.collect(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.WriteTo(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) describe() (string, string, string) {
+	return d.name, d.help, d.kind
+}
+
+// series keeps one value per distinct combination of label values.
+type series[T any] struct {
+	mu          sync.RWMutex
+	values      map[string]*T
+	labelValues map[string][]string
+	create      func() *T
+}
+
+func (s *series[T]) with(d *desc, labelValues []string) *T {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	s.mu.RLock()
+	v, ok := s.values[key]
+	s.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok = s.values[key]; !ok {
+		v = s.create()
+		s.values[key] = v
+		s.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	return v
+}
+
+func (s *series[T]) each(fn func(labelValues []string, v *T)) {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s.mu.RLock()
+		v, labels := s.values[k], s.labelValues[k]
+		s.mu.RUnlock()
+		fn(labels, v)
+	}
+}
+
+func newSeries[T any](create func() *T) series[T] {
+	return series[T]{values: map[string]*T{}, labelValues: map[string][]string{}, create: create}
+}
+
+// atomicFloat is a float64 that can be updated concurrently.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+type Counter struct {
+	v atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increases the counter. Negative values are ignored, as counters can only go up.
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.Add(delta)
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.Load()
+}
+
+type CounterVec struct {
+	desc
+	series[Counter]
+}
+
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		series: newSeries(func() *Counter { return &Counter{} }),
+	}
+	reg.register(c)
+	return c
+}
+
+// With returns the counter for the given label values, creating it if needed.
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.series.with(&c.desc, labelValues)
+}
+
+func (c *CounterVec) collect(w *bufio.Writer) {
+	c.each(func(labelValues []string, v *Counter) {
+		writeSample(w, c.name, c.labels, labelValues, "", "", v.Value())
+	})
+}
+
+type Gauge struct {
+	v atomicFloat
+}
+
+func (g *Gauge) Set(v float64) {
+	g.v.Set(v)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.Add(delta)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.Load()
+}
+
+type GaugeVec struct {
+	desc
+	series[Gauge]
+}
+
+func (reg *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		desc:   desc{name: name, help: help, kind: "gauge", labels: labels},
+		series: newSeries(func() *Gauge { return &Gauge{} }),
+	}
+	reg.register(g)
+	return g
+}
+
+// With returns the gauge for the given label values, creating it if needed.
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.series.with(&g.desc, labelValues)
+}
+
+func (g *GaugeVec) collect(w *bufio.Writer) {
+	g.each(func(labelValues []string, v *Gauge) {
+		writeSample(w, g.name, g.labels, labelValues, "", "", v.Value())
+	})
+}
+
+type Histogram struct {
+	upperBounds []float64
+	counts      []atomic.Uint64
+	sum         atomicFloat
+	count       atomic.Uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.sum.Add(v)
+	h.count.Add(1)
+}
+
+type HistogramVec struct {
+	desc
+	series[Histogram]
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram. The buckets are the upper bounds of each bucket, a +Inf bucket is always added.
+func (reg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+	}
+	h.series = newSeries(func() *Histogram {
+		return &Histogram{upperBounds: buckets, counts: make([]atomic.Uint64, len(buckets))}
+	})
+	reg.register(h)
+	return h
+}
+
+// With returns the histogram for the given label values, creating it if needed.
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.series.with(&h.desc, labelValues)
+}
+
+func (h *HistogramVec) collect(w *bufio.Writer) {
+	h.each(func(labelValues []string, v *Histogram) {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += v.counts[i].Load()
+			writeSample(w, h.name+"_bucket", h.labels, labelValues, "le", formatFloat(bound), float64(cumulative))
+		}
+		count := v.count.Load()
+		writeSample(w, h.name+"_bucket", h.labels, labelValues, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, labelValues, "", "", v.sum.Load())
+		writeSample(w, h.name+"_count", h.labels, labelValues, "", "", float64(count))
+	})
+}
+
+// Sample is a single value reported by a function backed metric.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+type funcCollector struct {
+	desc
+	fn func() []Sample
+}
+
+func (f *funcCollector) collect(w *bufio.Writer) {
+	for _, sample := range f.fn() {
+		writeSample(w, f.name, f.labels, sample.LabelValues, "", "", sample.Value)
+	}
+}
+
+// NewGaugeFunc registers a gauge whose samples are produced by fn each time the registry is written.
+func (reg *Registry) NewGaugeFunc(name, help string, labels []string, fn func() []Sample) {
+	reg.register(&funcCollector{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, fn: fn})
+}
+
+// NewCounterFunc registers a counter whose samples are produced by fn each time the registry is written.
+func (reg *Registry) NewCounterFunc(name, help string, labels []string, fn func() []Sample) {
+	reg.register(&funcCollector{desc: desc{name: name, help: help, kind: "counter", labels: labels}, fn: fn})
+}
+
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, labelName, labelValues[i])
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(labelEscaper.Replace(value))
+	w.WriteByte('"')
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
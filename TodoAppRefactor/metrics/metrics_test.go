@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	reg := NewRegistry()
+	counter := reg.NewCounterVec("test_requests_total", "Requests served.", "route", "status")
+	gauge := reg.NewGaugeVec("test_in_flight", "Requests in flight.")
+	histogram := reg.NewHistogramVec("test_duration_seconds", "Request latency.", []float64{0.5, 0.1}, "route")
+	reg.NewGaugeFunc("test_items", "Items by state.", []string{"state"}, func() []Sample {
+		return []Sample{{LabelValues: []string{"complete"}, Value: 2}}
+	})
+
+	counter.With("/todoapp/item/", "200").Inc()
+	counter.With("/todoapp/item/", "200").Add(2)
+	counter.With("/todoapp/item/", "404").Inc()
+	gauge.With().Set(4)
+	gauge.With().Add(-1)
+	histogram.With("/").Observe(0.05)
+	histogram.With("/").Observe(0.3)
+	histogram.With("/").Observe(2)
+
+	expected := `# HELP test_duration_seconds Request latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/",le="0.1"} 1
+test_duration_seconds_bucket{route="/",le="0.5"} 2
+test_duration_seconds_bucket{route="/",le="+Inf"} 3
+test_duration_seconds_sum{route="/"} 2.35
+test_duration_seconds_count{route="/"} 3
+# HELP test_in_flight Requests in flight.
+# TYPE test_in_flight gauge
+test_in_flight 3
+# HELP test_items Items by state.
+# TYPE test_items gauge
+test_items{state="complete"} 2
+# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{route="/todoapp/item/",status="200"} 3
+test_requests_total{route="/todoapp/item/",status="404"} 1
+`
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("unexpected exposition output. Got:\n%s\nWant:\n%s", buf.String(), expected)
+	}
+}
+
+func TestRegistry_EscapesLabelValues(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounterVec("test_total", "Escaping.", "path").With("a\"b\\c\nd").Inc()
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+
+	expectedLine := `test_total{path="a\"b\\c\nd"} 1`
+	if !strings.Contains(buf.String(), expectedLine) {
+		t.Errorf("label value was not escaped correctly. Got:\n%s\nWant line: %s", buf.String(), expectedLine)
+	}
+}
+
+func TestRegistry_DuplicateNamePanics(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounterVec("test_total", "First.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a metric name twice should panic")
+		}
+	}()
+	reg.NewGaugeVec("test_total", "Second.")
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	reg := NewRegistry()
+	RegisterRuntimeMetrics(reg)
+
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("handler returned wrong content type. Got: %v", contentType)
+	}
+	for _, name := range []string{"go_goroutines", "go_memstats_alloc_bytes", "go_gc_cycles_total", "process_start_time_seconds"} {
+		if !strings.Contains(rr.Body.String(), "\n"+name+" ") {
+			t.Errorf("runtime metric %s is missing from the output", name)
+		}
+	}
+}
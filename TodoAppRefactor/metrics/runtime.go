@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"runtime"
+	"time"
+)
+
+// RegisterRuntimeMetrics adds Go runtime and process statistics to the registry.
+func RegisterRuntimeMetrics(reg *Registry) {
+	startTime := float64(time.Now().Unix())
+	reg.NewGaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", nil, func() []Sample {
+		return []Sample{{Value: startTime}}
+	})
+	reg.NewGaugeFunc("go_info", "Information about the Go environment.", []string{"version"}, func() []Sample {
+		return []Sample{{LabelValues: []string{runtime.Version()}, Value: 1}}
+	})
+	reg.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", nil, func() []Sample {
+		return []Sample{{Value: float64(runtime.NumGoroutine())}}
+	})
+
+	memStat := func(name, help string, value func(m *runtime.MemStats) float64) {
+		reg.NewGaugeFunc(name, help, nil, func() []Sample {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			return []Sample{{Value: value(&m)}}
+		})
+	}
+	memStat("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", func(m *runtime.MemStats) float64 { return float64(m.Alloc) })
+	memStat("go_memstats_heap_objects", "Number of allocated objects.", func(m *runtime.MemStats) float64 { return float64(m.HeapObjects) })
+	memStat("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", func(m *runtime.MemStats) float64 { return float64(m.HeapInuse) })
+	memStat("go_memstats_sys_bytes", "Number of bytes obtained from system.", func(m *runtime.MemStats) float64 { return float64(m.Sys) })
+	memStat("go_memstats_gc_cpu_fraction", "The fraction of this program's available CPU time used by the GC since the program started.", func(m *runtime.MemStats) float64 { return m.GCCPUFraction })
+
+	reg.NewCounterFunc("go_gc_cycles_total", "Number of completed GC cycles.", nil, func() []Sample {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return []Sample{{Value: float64(m.NumGC)}}
+	})
+	reg.NewCounterFunc("go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", nil, func() []Sample {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return []Sample{{Value: float64(m.PauseTotalNs) / float64(time.Second)}}
+	})
+}
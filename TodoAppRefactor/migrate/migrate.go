@@ -0,0 +1,135 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// BaseVersion is the schema version of state written before any migration was registered.
+const BaseVersion = 1
+
+var (
+	ErrNewerVersion = errors.New("the state was written by a newer version of the server")
+	ErrOlderVersion = errors.New("the state is from a schema version older than any this server can migrate")
+)
+
+// Migration upgrades stored state from the schema version before it to Version. Up is given the whole state decoded
+// as generic JSON, with numbers as json.Number, and changes it in place.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(state map[string]any) error
+}
+
+// Registry holds the migrations of a schema, in order.
+type Registry struct {
+	mu         sync.RWMutex
+	migrations []Migration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the migration to the latest version. Migrations must be registered in order, each taking the schema
+// to the version after the one before it, so Register panics if the migration's version isn't the next one.
+func (reg *Registry) Register(migration Migration) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if next := reg.latest() + 1; migration.Version != next || migration.Up == nil {
+		panic(fmt.Sprintf("migrate: migration %d registered when migration %d was expected", migration.Version, next))
+	}
+	reg.migrations = append(reg.migrations, migration)
+}
+
+// Latest is the schema version the server writes, the version of the last migration registered.
+func (reg *Registry) Latest() int {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	return reg.latest()
+}
+
+func (reg *Registry) latest() int {
+	if len(reg.migrations) == 0 {
+		return BaseVersion
+	}
+	return reg.migrations[len(reg.migrations)-1].Version
+}
+
+// Pending returns the migrations that take state at version 'from' to the latest version.
+func (reg *Registry) Pending(from int) ([]Migration, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	switch {
+	case from > reg.latest():
+		return nil, fmt.Errorf("%w: version %d, the newest this server knows is %d", ErrNewerVersion, from, reg.latest())
+	case from < BaseVersion:
+		return nil, fmt.Errorf("%w: version %d", ErrOlderVersion, from)
+	}
+	return append([]Migration(nil), reg.migrations[from-BaseVersion:]...), nil
+}
+
+// Upgrade runs the pending migrations on state at version 'from', returning those it ran. If one fails state is left
+// half migrated and should be thrown away.
+func (reg *Registry) Upgrade(state map[string]any, from int) ([]Migration, error) {
+	pending, err := reg.Pending(from)
+	if err != nil {
+		return nil, err
+	}
+	for _, migration := range pending {
+		if err := migration.Up(state); err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+	}
+	return pending, nil
+}
+
+// Decode decodes encoded JSON state for Upgrade. Numbers are kept as json.Number, so IDs and versions come out of a
+// migration exactly as they went in.
+func Decode(encoded []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var state map[string]any
+	if err := decoder.Decode(&state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// EachItem calls fn with every item of every list of every tenant in the state, for migrations that change items.
+func EachItem(state map[string]any, fn func(item map[string]any) error) error {
+	return EachList(state, func(list map[string]any) error {
+		items, _ := list["Items"].([]any)
+		for _, item := range items {
+			if item, ok := item.(map[string]any); ok {
+				if err := fn(item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// EachList calls fn with every list of every tenant in the state.
+func EachList(state map[string]any, fn func(list map[string]any) error) error {
+	tenants, _ := state["Tenants"].(map[string]any)
+	for _, tenant := range tenants {
+		tenant, _ := tenant.(map[string]any)
+		lists, _ := tenant["Lists"].([]any)
+		for _, list := range lists {
+			if list, ok := list.(map[string]any); ok {
+				if err := fn(list); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestRegistry_Register(t *testing.T) {
+	registry := NewRegistry()
+	if registry.Latest() != BaseVersion {
+		t.Errorf("An empty registry should be at the base version. Got: %d", registry.Latest())
+	}
+	registry.Register(Migration{Version: 2, Up: func(map[string]any) error { return nil }})
+
+	testCases := []struct {
+		testName  string
+		migration Migration
+	}{
+		{"Testing a version that is already registered", Migration{Version: 2, Up: func(map[string]any) error { return nil }}},
+		{"Testing a version that skips one", Migration{Version: 4, Up: func(map[string]any) error { return nil }}},
+		{"Testing a migration without a function", Migration{Version: 3}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("registering the migration should have panicked")
+				}
+			}()
+			registry.Register(test.migration)
+		})
+	}
+	if registry.Latest() != 2 {
+		t.Errorf("Unexpected latest version. Got: %d", registry.Latest())
+	}
+}
+
+func TestRegistry_Upgrade(t *testing.T) {
+	var ran []int
+	registry := NewRegistry()
+	registry.Register(Migration{Version: 2, Description: "give items a priority", Up: func(state map[string]any) error {
+		ran = append(ran, 2)
+		return EachItem(state, func(item map[string]any) error {
+			item["Priority"] = "normal"
+			return nil
+		})
+	}})
+	registry.Register(Migration{Version: 3, Description: "rename priority to urgency", Up: func(state map[string]any) error {
+		ran = append(ran, 3)
+		return EachItem(state, func(item map[string]any) error {
+			item["Urgency"] = item["Priority"]
+			delete(item, "Priority")
+			return nil
+		})
+	}})
+
+	state, err := Decode([]byte(`{"Tenants":{"default":{"Lists":[{"Id":1,"Items":[{"Id":9007199254740993,"Name":"Milk"}]}]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := registry.Upgrade(state, 1)
+	if err != nil || len(applied) != 2 || !slices.Equal(ran, []int{2, 3}) {
+		t.Fatalf("Unexpected migrations. Got: %v, %v, ran %v", applied, err, ran)
+	}
+	encoded, _ := json.Marshal(state)
+	if expected := `{"Tenants":{"default":{"Lists":[{"Id":1,"Items":[{"Id":9007199254740993,"Name":"Milk","Urgency":"normal"}]}]}}}`; string(encoded) != expected {
+		t.Errorf("Unexpected state. Got: %s, Expected: %s", encoded, expected)
+	}
+
+	if pending, err := registry.Pending(3); err != nil || len(pending) != 0 {
+		t.Errorf("The latest version needs no migrations. Got: %v, %v", pending, err)
+	}
+	if pending, _ := registry.Pending(2); len(pending) != 1 || pending[0].Version != 3 {
+		t.Errorf("Unexpected pending migrations. Got: %v", pending)
+	}
+	if _, err := registry.Upgrade(state, 4); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("State from a newer version should be refused. Got: %v", err)
+	}
+	if _, err := registry.Upgrade(state, 0); !errors.Is(err, ErrOlderVersion) {
+		t.Errorf("State from before the base version should be refused. Got: %v", err)
+	}
+}
+
+func TestRegistry_UpgradeFails(t *testing.T) {
+	registry := NewRegistry()
+	failure := errors.New("item has no name")
+	registry.Register(Migration{Version: 2, Description: "check names", Up: func(state map[string]any) error {
+		return EachItem(state, func(item map[string]any) error {
+			if item["Name"] == nil {
+				return failure
+			}
+			return nil
+		})
+	}})
+
+	state, _ := Decode([]byte(`{"Tenants":{"default":{"Lists":[{"Items":[{"Id":1}]}]}}}`))
+	if _, err := registry.Upgrade(state, 1); !errors.Is(err, failure) {
+		t.Errorf("Unexpected error. Got: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package migrate
+
+// Default holds the migrations of the state the server stores, in its data directory and in backups. The state is a
+// JSON object with the users under "Users", a users.Snapshot, and the data of every tenant under "Tenants", a
+// data.Snapshot by tenant ID.
+//
+// A field added to a stored type, e.g. data.TodoItem, only needs a migration if state written before it was added
+// shouldn't get the field's zero value. A field that is renamed, removed or changes meaning always does: stored state
+// is decoded strictly, so a field the types no longer have is refused rather than dropped. Migrations are registered
+// here in order, the first as version 2, e.g.
+//
+//	Default.Register(Migration{Version: 2, Description: "give items a priority", Up: func(state map[string]any) error {
+//		return EachItem(state, func(item map[string]any) error {
+//			item["Priority"] = "normal"
+//			return nil
+//		})
+//	}})
+//
+// Registered migrations are never changed or removed, as state at every earlier version may still be out there.
+var Default = NewRegistry()
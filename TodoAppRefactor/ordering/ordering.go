@@ -0,0 +1,120 @@
+// Package ordering generates fractional index keys: strings that sort in the order of the things they belong to, with
+// room for a new key between any two, so moving or inserting one thing never renumbers the others.
+package ordering
+
+import (
+	"errors"
+	"strings"
+)
+
+// digits are the base 62 digits of a key, in the order they sort in.
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var (
+	ErrInvalidKey     = errors.New("keys may only contain base 62 digits and can't end in '0'")
+	ErrKeysOutOfOrder = errors.New("the first key must sort before the second")
+)
+
+// Valid reports whether key is a key. A key is the digits after the point of a base 62 fraction between 0 and 1, so it
+// can't end in '0', which would leave no room for a key before it.
+func Valid(key string) bool {
+	if key == "" || key[len(key)-1] == '0' {
+		return false
+	}
+	for i := range len(key) {
+		if strings.IndexByte(digits, key[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// KeyBetween returns a key that sorts after a and before b. An empty a means the start and an empty b means the end,
+// so KeyBetween("", "") is the first key and KeyBetween(last, "") appends.
+func KeyBetween(a string, b string) (string, error) {
+	if a != "" && !Valid(a) || b != "" && !Valid(b) {
+		return "", ErrInvalidKey
+	}
+	if a != "" && b != "" && a >= b {
+		return "", ErrKeysOutOfOrder
+	}
+	if b == "" {
+		return after(a), nil
+	}
+	return midpoint(a, b), nil
+}
+
+// after returns a short key after a. Appending is the most common insert, so rather than halving the gap to the end
+// each time the first digit that isn't 'z' is bumped, and keys only grow by a digit every 61 appends.
+func after(a string) string {
+	for i := range len(a) {
+		if a[i] != 'z' {
+			return a[:i] + string(digits[strings.IndexByte(digits, a[i])+1])
+		}
+	}
+	if a == "" {
+		return string(digits[len(digits)/2])
+	}
+	return a + "1"
+}
+
+// midpoint returns the key halfway between a and b, where a is empty or before b and b isn't empty.
+func midpoint(a string, b string) string {
+	// Digits the keys share stay, with a padded with zeros
+	n := 0
+	for n < len(b) && digitAt(a, n) == b[n] {
+		n++
+	}
+	if n > 0 {
+		return b[:n] + midpoint(suffix(a, n), b[n:])
+	}
+
+	low := strings.IndexByte(digits, digitAt(a, 0))
+	high := strings.IndexByte(digits, b[0])
+	if high-low > 1 {
+		return string(digits[(low+high)/2])
+	}
+	// The first digits are next to each other. b's first digit on its own is between them if b has more digits,
+	// otherwise the key goes after the rest of a.
+	if len(b) > 1 {
+		return b[:1]
+	}
+	return string(digits[low]) + after(suffix(a, 1))
+}
+
+func digitAt(key string, i int) byte {
+	if i < len(key) {
+		return key[i]
+	}
+	return '0'
+}
+
+func suffix(key string, i int) string {
+	if i < len(key) {
+		return key[i:]
+	}
+	return ""
+}
+
+// Spread returns n keys in order, spaced evenly and as short as they can be. It's used to start a sequence, and to
+// shorten the keys of one that has had so many inserts in the same place that they have grown long.
+func Spread(n int) []string {
+	length, space := 1, uint64(len(digits))
+	for space <= uint64(n) {
+		length++
+		space *= uint64(len(digits))
+	}
+	step := space / uint64(n+1)
+
+	keys := make([]string, n)
+	for i := range keys {
+		value := uint64(i+1) * step
+		key := make([]byte, length)
+		for j := length - 1; j >= 0; j-- {
+			key[j] = digits[value%uint64(len(digits))]
+			value /= uint64(len(digits))
+		}
+		keys[i] = strings.TrimRight(string(key), "0")
+	}
+	return keys
+}
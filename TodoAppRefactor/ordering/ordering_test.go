@@ -0,0 +1,96 @@
+package ordering
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestKeyBetween(t *testing.T) {
+	testCases := []struct {
+		testName    string
+		a           string
+		b           string
+		expectedKey string
+	}{
+		{"Testing the first key", "", "", "V"},
+		{"Testing appending", "V", "", "W"},
+		{"Testing appending after 'z'", "zz", "", "zz1"},
+		{"Testing prepending", "", "V", "F"},
+		{"Testing prepending before a key starting with '0'", "", "01", "00V"},
+		{"Testing between keys far apart", "A", "a", "N"},
+		{"Testing between keys next to each other", "A", "B", "AV"},
+		{"Testing between a key and a longer one", "A", "B5", "B"},
+		{"Testing between keys sharing digits", "AB1", "AB2", "AB1V"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			key, err := KeyBetween(test.a, test.b)
+			if err != nil || key != test.expectedKey {
+				t.Errorf("Unexpected key. Got: %q, %v, Expected: %q", key, err, test.expectedKey)
+			}
+		})
+	}
+}
+
+func TestKeyBetween_InvalidKeys(t *testing.T) {
+	if _, err := KeyBetween("B", "A"); err != ErrKeysOutOfOrder {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrKeysOutOfOrder)
+	}
+	if _, err := KeyBetween("A", "A"); err != ErrKeysOutOfOrder {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrKeysOutOfOrder)
+	}
+	for _, key := range []string{"A0", "A-", "Ä"} {
+		if _, err := KeyBetween(key, ""); err != ErrInvalidKey {
+			t.Errorf("Unexpected error for %q. Got: %v, Expected: %v", key, err, ErrInvalidKey)
+		}
+	}
+}
+
+// TestKeyBetween_RandomInserts inserts keys at random places and checks they always sort in the order they were put in.
+func TestKeyBetween_RandomInserts(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	var keys []string
+	for range 2000 {
+		index := random.Intn(len(keys) + 1)
+		var a, b string
+		if index > 0 {
+			a = keys[index-1]
+		}
+		if index < len(keys) {
+			b = keys[index]
+		}
+		key, err := KeyBetween(a, b)
+		if err != nil || !Valid(key) || a != "" && key <= a || b != "" && key >= b {
+			t.Fatalf("Unexpected key between %q and %q. Got: %q, %v", a, b, key, err)
+		}
+		keys = slices.Insert(keys, index, key)
+	}
+	if !slices.IsSorted(keys) {
+		t.Errorf("Keys aren't in order")
+	}
+
+	// Appending stays short
+	key := ""
+	for range 10000 {
+		key, _ = KeyBetween(key, "")
+	}
+	if len(key) > 200 {
+		t.Errorf("Appended keys grew too long. Got: %d digits", len(key))
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{0, 1, 61, 62, 5000} {
+		keys := Spread(n)
+		if len(keys) != n || !slices.IsSorted(keys) || len(slices.Compact(slices.Clone(keys))) != n {
+			t.Errorf("Keys aren't distinct and in order for %d", n)
+		}
+		for _, key := range keys {
+			if !Valid(key) || len(key) > 3 {
+				t.Errorf("Unexpected key for %d. Got: %q", n, key)
+			}
+		}
+	}
+}
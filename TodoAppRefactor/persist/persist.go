@@ -0,0 +1,222 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"todoApp/backup"
+	"todoApp/data"
+	"todoApp/migrate"
+	dataService "todoApp/services"
+	"todoApp/users"
+)
+
+const (
+	// Format names the state files written by this package.
+	Format = "todoapp-state"
+	// FileName is the name of the state file in the data directory, and PreMigrationDir the directory in it where a
+	// copy of the state file is kept before it is migrated.
+	FileName        = "state.json"
+	PreMigrationDir = "pre-migration"
+)
+
+var (
+	ErrInvalidState    = errors.New("invalid state file")
+	ErrNeedsMigration  = errors.New("the state file is from an older schema version and needs migrating")
+	ErrNoStateFile     = errors.New("the data directory has no state file")
+	ErrMigrationFailed = errors.New("migrating the state file failed, it hasn't been changed")
+)
+
+// migrations upgrade state files written before the latest schema version.
+var migrations = migrate.Default
+
+// State is the state file: the users and the data of every tenant, as of Saved, at a schema version.
+type State struct {
+	Format        string
+	SchemaVersion int
+	Saved         time.Time
+	Users         users.Snapshot
+	Tenants       map[string]data.Snapshot
+}
+
+// header is the start of the state file, which says how to read the rest.
+type header struct {
+	Format        string
+	SchemaVersion int
+	Saved         time.Time
+}
+
+// Status is the schema version of a data directory's state file and the migrations it needs.
+type Status struct {
+	Path          string
+	Exists        bool
+	SchemaVersion int
+	Latest        int
+	Saved         time.Time
+	Pending       []migrate.Migration
+}
+
+// Result is what Migrate did, or would have done in a dry run.
+type Result struct {
+	Status
+	Applied []migrate.Migration
+	// Backup is where the state file was copied to before it was migrated.
+	Backup string
+	DryRun bool
+}
+
+func statePath(dir string) string {
+	return filepath.Join(dir, FileName)
+}
+
+// Inspect reports the schema version of the state file in dir and the migrations it needs.
+func Inspect(dir string) (Status, error) {
+	status := Status{Path: statePath(dir), Latest: migrations.Latest()}
+	encoded, err := os.ReadFile(status.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return status, nil
+	} else if err != nil {
+		return status, err
+	}
+	status.Exists = true
+
+	var head header
+	if err := json.Unmarshal(encoded, &head); err != nil || head.Format != Format {
+		return status, fmt.Errorf("%w: %s isn't a %s file", ErrInvalidState, status.Path, Format)
+	}
+	status.SchemaVersion = head.SchemaVersion
+	status.Saved = head.Saved
+	status.Pending, err = migrations.Pending(head.SchemaVersion)
+	return status, err
+}
+
+// Migrate brings the state file in dir up to the latest schema version. The file is copied into the PreMigrationDir
+// first, and the migrated state written to a temporary file and renamed over it, so a migration that fails leaves the
+// file as it was. The migrated state is checked the same way Load checks it before anything is written, so a dry run
+// shows whether the real thing would work without changing anything.
+func Migrate(dir string, dryRun bool) (Result, error) {
+	status, err := Inspect(dir)
+	result := Result{Status: status, DryRun: dryRun}
+	if err != nil || !status.Exists || len(status.Pending) == 0 {
+		return result, err
+	}
+
+	encoded, err := os.ReadFile(status.Path)
+	if err != nil {
+		return result, err
+	}
+	state, err := migrate.Decode(encoded)
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", ErrInvalidState, err)
+	}
+	if result.Applied, err = migrations.Upgrade(state, status.SchemaVersion); err != nil {
+		return result, fmt.Errorf("%w: %w", ErrMigrationFailed, err)
+	}
+	state["SchemaVersion"] = result.Latest
+	migrated, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", ErrMigrationFailed, err)
+	}
+	if _, err := decode(migrated); err != nil {
+		return result, fmt.Errorf("%w: %w", ErrMigrationFailed, err)
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	preMigration := filepath.Join(dir, PreMigrationDir)
+	if err := os.MkdirAll(preMigration, 0o700); err != nil {
+		return result, err
+	}
+	name := strings.TrimSuffix(FileName, ".json") + "-v" + strconv.Itoa(status.SchemaVersion) + "-" + time.Now().UTC().Format("20060102T150405Z") + ".json"
+	result.Backup = filepath.Join(preMigration, name)
+	if err := writeFile(result.Backup, encoded); err != nil {
+		return result, err
+	}
+	return result, writeFile(status.Path, migrated)
+}
+
+// Load reads the state file in dir, which must be at the latest schema version. It returns ErrNoStateFile if there
+// isn't one.
+func Load(dir string) (backup.Archive, error) {
+	status, err := Inspect(dir)
+	switch {
+	case err != nil:
+		return backup.Archive{}, err
+	case !status.Exists:
+		return backup.Archive{}, ErrNoStateFile
+	case len(status.Pending) > 0:
+		return backup.Archive{}, fmt.Errorf("%w: version %d, the latest is %d", ErrNeedsMigration, status.SchemaVersion, status.Latest)
+	}
+
+	encoded, err := os.ReadFile(status.Path)
+	if err != nil {
+		return backup.Archive{}, err
+	}
+	state, err := decode(encoded)
+	if err != nil {
+		return backup.Archive{}, err
+	}
+	return backup.Archive{Created: state.Saved, Users: state.Users, Tenants: state.Tenants}, nil
+}
+
+// Save writes an archive as the state file in dir, at the latest schema version. It is written to a temporary file
+// and renamed into place, so a save that fails half way leaves the previous state file.
+func Save(dir string, archive backup.Archive) error {
+	state := State{Format: Format, SchemaVersion: migrations.Latest(), Saved: archive.Created.UTC(), Users: archive.Users, Tenants: archive.Tenants}
+	encoded, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(statePath(dir), encoded)
+}
+
+// decode decodes and checks a state file. Fields the types don't have are refused, as they would be lost otherwise.
+func decode(encoded []byte) (State, error) {
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var state State
+	if err := decoder.Decode(&state); err != nil {
+		return State{}, fmt.Errorf("%w: %w", ErrInvalidState, err)
+	}
+	if err := users.ValidateSnapshot(state.Users); err != nil {
+		return State{}, fmt.Errorf("%w: %w", ErrInvalidState, err)
+	}
+	for _, tenant := range slices.Sorted(maps.Keys(state.Tenants)) {
+		if err := dataService.ValidateSnapshot(state.Tenants[tenant]); err != nil {
+			return State{}, fmt.Errorf("%w: tenant %s: %w", ErrInvalidState, tenant, err)
+		}
+	}
+	return state, nil
+}
+
+// writeFile writes a file atomically, through a temporary file in the same directory.
+func writeFile(path string, contents []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
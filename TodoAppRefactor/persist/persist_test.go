@@ -0,0 +1,215 @@
+package persist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"todoApp/auth"
+	"todoApp/backup"
+	"todoApp/migrate"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+	"todoApp/users"
+)
+
+func TestMain(m *testing.M) {
+	users.UseCheapHashing()
+	os.Exit(m.Run())
+}
+
+func newSource() backup.Source {
+	return backup.Source{
+		Users: users.NewUserService(),
+		Lists: tenants.NewRouter(func(string) *dataService.DataService { return dataService.NewDataService() }, tenants.Quota{}),
+	}
+}
+
+// savedState saves a state with Alice and her groceries in dir.
+func savedState(t *testing.T, dir string) backup.Source {
+	source := newSource()
+	user, _ := source.Users.Register("alice", "password123")
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: user.Id, Username: user.Username})
+	list, _ := source.Lists.CreateTodoList(alice, "Groceries")
+	source.Lists.CreateTodoItem(alice, list.Id, "Milk")
+	if err := Save(dir, source.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+// useMigrations replaces the migrations for the rest of the test.
+func useMigrations(t *testing.T, registry *migrate.Registry) {
+	previous := migrations
+	migrations = registry
+	t.Cleanup(func() { migrations = previous })
+}
+
+// renameNames registers version 2, which renames the items' 'Title' to 'Name', and rewrites the state file in dir as
+// version 1, with titles.
+func renameNames(t *testing.T, dir string) *migrate.Registry {
+	encoded, _ := os.ReadFile(filepath.Join(dir, FileName))
+	state, _ := migrate.Decode(encoded)
+	migrate.EachItem(state, func(item map[string]any) error {
+		item["Title"] = item["Name"]
+		delete(item, "Name")
+		return nil
+	})
+	state["SchemaVersion"] = 1
+	encoded, _ = json.Marshal(state)
+	os.WriteFile(filepath.Join(dir, FileName), encoded, 0o600)
+
+	registry := migrate.NewRegistry()
+	registry.Register(migrate.Migration{Version: 2, Description: "rename titles to names", Up: func(state map[string]any) error {
+		return migrate.EachItem(state, func(item map[string]any) error {
+			item["Name"] = item["Title"]
+			delete(item, "Title")
+			return nil
+		})
+	}})
+	return registry
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(dir); !errors.Is(err, ErrNoStateFile) {
+		t.Errorf("Unexpected error loading an empty directory. Got: %v", err)
+	}
+	source := savedState(t, dir)
+
+	archive, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newSource()
+	if _, err := restored.Restore(context.Background(), archive, backup.Replace); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Users.Authenticate("alice", "password123"); err != nil {
+		t.Errorf("Alice can't log in after loading the state: %v", err)
+	}
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+	lists := restored.Lists.GetTodoLists(alice)
+	items, _ := restored.Lists.GetAllTodoItems(alice, lists[len(lists)-1].Id)
+	if len(items) != 1 || items[0].Name != "Milk" {
+		t.Errorf("Unexpected items after loading the state. Got: %v", items)
+	}
+	if expected, _ := source.Lists.GetAllTodoItems(alice, lists[len(lists)-1].Id); expected[0] != items[0] {
+		t.Errorf("Unexpected item. Got: %v, Expected: %v", items[0], expected[0])
+	}
+
+	os.WriteFile(filepath.Join(dir, FileName), []byte(`{"Format":"todoapp-state","SchemaVersion":1,"Users":{"NextId":1},"Extra":true}`), 0o600)
+	if _, err := Load(dir); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("A state file with a field the types don't have was loaded. Got: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	savedState(t, dir)
+	useMigrations(t, renameNames(t, dir))
+	before, _ := os.ReadFile(filepath.Join(dir, FileName))
+
+	if _, err := Load(dir); !errors.Is(err, ErrNeedsMigration) {
+		t.Errorf("A state file that needs migrating was loaded. Got: %v", err)
+	}
+	status, err := Inspect(dir)
+	if err != nil || status.SchemaVersion != 1 || status.Latest != 2 || len(status.Pending) != 1 {
+		t.Errorf("Unexpected status. Got: %+v, %v", status, err)
+	}
+
+	result, err := Migrate(dir, true)
+	if err != nil || len(result.Applied) != 1 || result.Backup != "" {
+		t.Errorf("Unexpected dry run. Got: %+v, %v", result, err)
+	}
+	if after, _ := os.ReadFile(filepath.Join(dir, FileName)); string(after) != string(before) {
+		t.Error("A dry run changed the state file")
+	}
+
+	result, err = Migrate(dir, false)
+	if err != nil || len(result.Applied) != 1 {
+		t.Fatalf("Unexpected migration. Got: %+v, %v", result, err)
+	}
+	if copied, _ := os.ReadFile(result.Backup); string(copied) != string(before) {
+		t.Errorf("The state file wasn't copied before it was migrated. Got: %s", result.Backup)
+	}
+	archive, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := archive.Tenants[tenants.DefaultTenant].Lists
+	if items := lists[len(lists)-1].Items; len(items) != 1 || items[0].Name != "Milk" {
+		t.Errorf("Unexpected items after migrating. Got: %v", items)
+	}
+	if result, err := Migrate(dir, false); err != nil || len(result.Applied) != 0 {
+		t.Errorf("Migrating an up to date state file should do nothing. Got: %+v, %v", result, err)
+	}
+}
+
+func TestMigrate_Failures(t *testing.T) {
+	dir := t.TempDir()
+	savedState(t, dir)
+	renameNames(t, dir)
+	before, _ := os.ReadFile(filepath.Join(dir, FileName))
+
+	testCases := []struct {
+		testName string
+		up       func(state map[string]any) error
+	}{
+		{"Testing a migration that fails", func(state map[string]any) error { return errors.New("broken") }},
+		{"Testing a migration that leaves a field the types don't have", func(state map[string]any) error { return nil }},
+		{"Testing a migration that leaves invalid data", func(state map[string]any) error {
+			return migrate.EachItem(state, func(item map[string]any) error {
+				item["Name"] = item["Title"]
+				delete(item, "Title")
+				item["Id"] = -1
+				return nil
+			})
+		}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			registry := migrate.NewRegistry()
+			registry.Register(migrate.Migration{Version: 2, Up: test.up})
+			useMigrations(t, registry)
+			if _, err := Migrate(dir, false); !errors.Is(err, ErrMigrationFailed) {
+				t.Errorf("Unexpected error. Got: %v", err)
+			}
+			if after, _ := os.ReadFile(filepath.Join(dir, FileName)); string(after) != string(before) {
+				t.Error("A failed migration changed the state file")
+			}
+		})
+	}
+
+	// State from a newer server is never migrated or loaded
+	useMigrations(t, migrate.NewRegistry())
+	os.WriteFile(filepath.Join(dir, FileName), []byte(`{"Format":"todoapp-state","SchemaVersion":2}`), 0o600)
+	if _, err := Migrate(dir, false); !errors.Is(err, migrate.ErrNewerVersion) {
+		t.Errorf("State from a newer version was migrated. Got: %v", err)
+	}
+	if _, err := Load(dir); !errors.Is(err, migrate.ErrNewerVersion) {
+		t.Errorf("State from a newer version was loaded. Got: %v", err)
+	}
+}
+
+func TestSaver_SavesOnClose(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	source := newSource()
+	saver, err := NewSaver(source, dir, DefaultSaveInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saver.Start()
+	source.Users.Register("alice", "password123")
+	if err := saver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := Load(dir)
+	if err != nil || len(archive.Users.Users) != 1 {
+		t.Errorf("The state wasn't saved when the saver was closed. Got: %+v, %v", archive.Users, err)
+	}
+}
@@ -0,0 +1,77 @@
+package persist
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"todoApp/backup"
+	"todoApp/metrics"
+)
+
+// DefaultSaveInterval is how often the state is saved when the interval isn't configured.
+const DefaultSaveInterval = time.Minute
+
+var saves = metrics.Default.NewCounterVec("todoapp_state_saves_total",
+	"Saves of the state file, by result: saved or failed.", "result")
+
+// Saver saves a source's state to the state file in a data directory at a fixed interval, and once more when it is
+// closed. Changes made since the last save are lost if the server stops without closing it.
+type Saver struct {
+	source   backup.Source
+	dir      string
+	interval time.Duration
+
+	stop    chan struct{}
+	once    sync.Once
+	workers sync.WaitGroup
+}
+
+// NewSaver creates a saver of source's state to dir every interval. The directory is created if it doesn't exist.
+func NewSaver(source backup.Source, dir string, interval time.Duration) (*Saver, error) {
+	if interval <= 0 {
+		return nil, errors.New("the save interval must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Saver{source: source, dir: dir, interval: interval, stop: make(chan struct{})}, nil
+}
+
+// Start saves the state every interval until Close is called.
+func (saver *Saver) Start() {
+	saver.workers.Add(1)
+	go func() {
+		defer saver.workers.Done()
+		ticker := time.NewTicker(saver.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := saver.Save(); err != nil {
+					slog.Error("error saving state", "dir", saver.dir, "error", err)
+				}
+			case <-saver.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops saving the state every interval and saves it one last time.
+func (saver *Saver) Close() error {
+	saver.once.Do(func() { close(saver.stop) })
+	saver.workers.Wait()
+	return saver.Save()
+}
+
+// Save saves the state now.
+func (saver *Saver) Save() error {
+	if err := Save(saver.dir, saver.source.Snapshot()); err != nil {
+		saves.With("failed").Inc()
+		return err
+	}
+	saves.With("saved").Inc()
+	return nil
+}
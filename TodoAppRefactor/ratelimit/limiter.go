@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter gives every key its own bucket. Buckets that haven't been used for the idle timeout are evicted, so memory
+// only grows with the number of recently active keys. A bucket that has been idle for long enough to refill is the
+// same as a new one, so eviction doesn't let anyone past their limit as long as the idle timeout is at least burst/rate.
+type Limiter struct {
+	rate        float64
+	burst       int
+	idleTimeout time.Duration
+	buckets     map[string]*Bucket
+	lastSweep   time.Time
+	now         func() time.Time
+	mu          sync.Mutex
+}
+
+// NewLimiter creates a limiter allowing each key rate requests per second, with bursts of up to burst. A rate of 0 or
+// less disables the limiter.
+func NewLimiter(rate float64, burst int, idleTimeout time.Duration) *Limiter {
+	if rate > 0 {
+		idleTimeout = max(idleTimeout, seconds(float64(burst)/rate))
+	}
+	return &Limiter{
+		rate:        rate,
+		burst:       burst,
+		idleTimeout: idleTimeout,
+		buckets:     map[string]*Bucket{},
+		now:         time.Now,
+	}
+}
+
+func (limiter *Limiter) Enabled() bool {
+	return limiter != nil && limiter.rate > 0
+}
+
+// Allow takes a token from the key's bucket.
+func (limiter *Limiter) Allow(key string) Decision {
+	if !limiter.Enabled() {
+		return Decision{Allowed: true}
+	}
+
+	limiter.mu.Lock()
+	now := limiter.now()
+	if now.Sub(limiter.lastSweep) >= limiter.idleTimeout {
+		limiter.evictIdle(now)
+		limiter.lastSweep = now
+	}
+	bucket, exists := limiter.buckets[key]
+	if !exists {
+		bucket = newBucket(limiter.rate, limiter.burst, limiter.now)
+		limiter.buckets[key] = bucket
+	}
+	limiter.mu.Unlock()
+
+	return bucket.Take()
+}
+
+// Len returns the number of buckets currently held.
+func (limiter *Limiter) Len() int {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	return len(limiter.buckets)
+}
+
+// evictIdle removes buckets that haven't been used for the idle timeout. The caller must hold the lock.
+func (limiter *Limiter) evictIdle(now time.Time) {
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.idleSince()) >= limiter.idleTimeout {
+			delete(limiter.buckets, key)
+		}
+	}
+}
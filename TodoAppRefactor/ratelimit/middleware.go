@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"todoApp/api/responses"
+	"todoApp/auth"
+	"todoApp/logging"
+	"todoApp/metrics"
+)
+
+var rateLimited = metrics.Default.NewCounterVec("todoapp_rate_limited_total",
+	"Requests rejected by the per-client rate limits, by budget.", "budget")
+
+// ClientLimits rate limits each client separately, with one budget for reads and another for writes.
+type ClientLimits struct {
+	Reads  *Limiter
+	Writes *Limiter
+}
+
+// Limit takes a token from the client's read or write budget, depending on the method of the request. Responses carry
+// the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and requests over the limit are rejected with
+// a 429 and a Retry-After header.
+func (limits *ClientLimits) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget, limiter := "write", limits.Writes
+		if isRead(r.Method) {
+			budget, limiter = "read", limits.Reads
+		}
+		if !limiter.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := ClientKey(r)
+		decision := limiter.Allow(key)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(wholeSeconds(decision.Reset)))
+		if !decision.Allowed {
+			rateLimited.With(budget).Inc()
+			logging.FromContext(r.Context()).Info("rate limited", "client", key, "budget", budget)
+			w.Header().Set("Retry-After", strconv.Itoa(wholeSeconds(decision.RetryAfter)))
+			responses.WriteError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientKey identifies the client making a request: by API key if one was used, otherwise by user, otherwise by
+// remote IP.
+func ClientKey(r *http.Request) string {
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		if principal.APIKeyId != "" {
+			return "key:" + principal.APIKeyId
+		}
+		return "user:" + strconv.Itoa(principal.UserId)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func isRead(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func wholeSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"todoApp/auth"
+)
+
+func TestLimit(t *testing.T) {
+	limits := &ClientLimits{Reads: NewLimiter(1, 2, time.Minute), Writes: NewLimiter(1, 1, time.Minute)}
+	handler := limits.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(method string, principal *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/todoapp/item/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if principal != nil {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), *principal))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodPost, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("first write was rejected. Got: %v", rr.Code)
+	}
+	if rr.Header().Get("RateLimit-Limit") != "1" || rr.Header().Get("RateLimit-Remaining") != "0" || rr.Header().Get("RateLimit-Reset") != "1" {
+		t.Errorf("Unexpected rate limit headers. Got: %v", rr.Header())
+	}
+
+	rr = serve(http.MethodPost, nil)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("write over the limit was not rejected. Got: %v", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("Unexpected Retry-After. Got: %q, Expected: %q", rr.Header().Get("Retry-After"), "1")
+	}
+
+	if rr = serve(http.MethodGet, nil); rr.Code != http.StatusOK {
+		t.Errorf("reads were limited by the write budget. Got: %v", rr.Code)
+	}
+	if rr = serve(http.MethodPost, &auth.Principal{UserId: 1}); rr.Code != http.StatusOK {
+		t.Errorf("a user shared the budget of their IP. Got: %v", rr.Code)
+	}
+	if rr = serve(http.MethodPost, &auth.Principal{UserId: 1, APIKeyId: "k1"}); rr.Code != http.StatusOK {
+		t.Errorf("an API key shared the budget of its user. Got: %v", rr.Code)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		expected  string
+	}{
+		{name: "anonymous", expected: "ip:192.0.2.1"},
+		{name: "session", principal: &auth.Principal{UserId: 7, SessionId: "s"}, expected: "user:7"},
+		{name: "API key", principal: &auth.Principal{UserId: 7, APIKeyId: "k1"}, expected: "key:k1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.principal != nil {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), *tt.principal))
+			}
+			if key := ClientKey(req); key != tt.expected {
+				t.Errorf("Unexpected key. Got: %v, Expected: %v", key, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Decision is the outcome of asking a bucket for a token.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again, RetryAfter how long until the next token when the request
+	// wasn't allowed.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Bucket is a token bucket. It holds up to burst tokens and refills at rate tokens per second, each request that is
+// allowed takes one.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+// NewBucket creates a full bucket. A rate of 0 or less creates a bucket that allows everything.
+func NewBucket(rate float64, burst int) *Bucket {
+	return newBucket(rate, burst, time.Now)
+}
+
+func newBucket(rate float64, burst int, now func() time.Time) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now(), now: now}
+}
+
+// Allow takes a token if there is one. When there isn't, it returns how long until there will be.
+func (bucket *Bucket) Allow() (bool, time.Duration) {
+	decision := bucket.Take()
+	return decision.Allowed, decision.RetryAfter
+}
+
+// Take takes a token if there is one and reports the state of the bucket afterwards.
+func (bucket *Bucket) Take() Decision {
+	if bucket.rate <= 0 {
+		return Decision{Allowed: true, Limit: int(bucket.burst), Remaining: int(bucket.burst)}
+	}
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.refill()
+	decision := Decision{Limit: int(bucket.burst)}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - bucket.tokens) / bucket.rate)
+	}
+	decision.Remaining = int(bucket.tokens)
+	decision.Reset = seconds((bucket.burst - bucket.tokens) / bucket.rate)
+	return decision
+}
+
+// Remaining returns the number of whole tokens left in the bucket.
+func (bucket *Bucket) Remaining() int {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.refill()
+	return int(bucket.tokens)
+}
+
+// Burst returns the size of the bucket.
+func (bucket *Bucket) Burst() int {
+	return int(bucket.burst)
+}
+
+// idleSince returns when the bucket last had a token taken or was refilled.
+func (bucket *Bucket) idleSince() time.Time {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	return bucket.last
+}
+
+// refill adds the tokens earned since the bucket was last used. The caller must hold the lock.
+func (bucket *Bucket) refill() {
+	now := bucket.now()
+	bucket.tokens = min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
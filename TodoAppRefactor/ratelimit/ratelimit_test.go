@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	bucket := NewBucket(2, 3)
+	bucket.now = func() time.Time { return now }
+	bucket.last = now
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := bucket.Allow(); !ok {
+			t.Fatalf("request %d within the burst was not allowed", i+1)
+		}
+	}
+	ok, wait := bucket.Allow()
+	if ok {
+		t.Fatal("request over the burst was allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("Unexpected wait. Got: %v, Expected: %v", wait, 500*time.Millisecond)
+	}
+
+	now = now.Add(time.Second)
+	if remaining := bucket.Remaining(); remaining != 2 {
+		t.Errorf("Unexpected number of tokens after refilling. Got: %v, Expected: %v", remaining, 2)
+	}
+
+	now = now.Add(time.Hour)
+	if remaining := bucket.Remaining(); remaining != 3 {
+		t.Errorf("bucket refilled past its burst. Got: %v, Expected: %v", remaining, 3)
+	}
+}
+
+func TestBucket_Unlimited(t *testing.T) {
+	bucket := NewBucket(0, 1)
+	for i := 0; i < 100; i++ {
+		if ok, _ := bucket.Allow(); !ok {
+			t.Fatal("a bucket without a rate rejected a request")
+		}
+	}
+}
+
+func TestLimiter_SeparateKeys(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(1, 2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if decision := limiter.Allow("a"); !decision.Allowed {
+			t.Fatalf("request %d within the burst was not allowed", i+1)
+		}
+	}
+	decision := limiter.Allow("a")
+	if decision.Allowed {
+		t.Fatal("request over the burst was allowed")
+	}
+	if decision.Remaining != 0 || decision.RetryAfter != time.Second {
+		t.Errorf("Unexpected decision. Got: %+v, Expected 0 remaining and a retry after 1s", decision)
+	}
+
+	if decision := limiter.Allow("b"); !decision.Allowed || decision.Remaining != 1 || decision.Limit != 2 {
+		t.Errorf("another key shared the bucket. Got: %+v", decision)
+	}
+}
+
+func TestLimiter_EvictsIdleBuckets(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(1, 2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow("a")
+	limiter.Allow("a")
+	now = now.Add(30 * time.Second)
+	limiter.Allow("b")
+	if limiter.Len() != 2 {
+		t.Fatalf("Unexpected number of buckets. Got: %v, Expected: %v", limiter.Len(), 2)
+	}
+
+	now = now.Add(45 * time.Second)
+	limiter.Allow("b")
+	if limiter.Len() != 1 {
+		t.Errorf("idle bucket was not evicted. Got: %v buckets, Expected: %v", limiter.Len(), 1)
+	}
+}
+
+func TestLimiter_IdleTimeoutCoversRefill(t *testing.T) {
+	limiter := NewLimiter(0.1, 10, time.Second)
+	if limiter.idleTimeout != 100*time.Second {
+		t.Errorf("buckets could be evicted before refilling. Got: %v, Expected: %v", limiter.idleTimeout, 100*time.Second)
+	}
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	limiter := NewLimiter(0, 1, time.Minute)
+	for i := 0; i < 100; i++ {
+		if decision := limiter.Allow("a"); !decision.Allowed {
+			t.Fatal("a limiter without a rate rejected a request")
+		}
+	}
+	if limiter.Len() != 0 {
+		t.Errorf("a disabled limiter kept buckets. Got: %v", limiter.Len())
+	}
+}
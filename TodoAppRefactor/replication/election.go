@@ -0,0 +1,179 @@
+package replication
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// heartbeat is sent by the leader to every follower each HeartbeatInterval.
+type heartbeat struct {
+	Term     uint64
+	LeaderId string
+}
+
+// voteRequest asks for a node's vote in an election. LastSeq and LastTerm describe the end of the candidate's log,
+// as nodes only vote for candidates whose log is at least as up to date as their own. A majority of the cluster has
+// every committed entry, so the winner will have them too.
+type voteRequest struct {
+	Term        uint64
+	CandidateId string
+	LastSeq     uint64
+	LastTerm    uint64
+}
+
+// ack answers a heartbeat or vote request. Term tells a leader or candidate that has fallen behind to step down.
+type ack struct {
+	Term    uint64
+	Granted bool
+}
+
+// run keeps the node in the cluster: the leader sends heartbeats, and other nodes stand for election when they
+// haven't heard from a leader for an election timeout.
+func (node *Node) run() {
+	defer node.workers.Done()
+	ticker := time.NewTicker(node.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	timeout := node.electionTimeout()
+	for {
+		select {
+		case <-node.stop:
+			return
+		case <-ticker.C:
+		}
+
+		node.mu.Lock()
+		role, heard := node.role, node.heard
+		node.mu.Unlock()
+		switch {
+		case role == Leader:
+			node.sendHeartbeats()
+		case node.now().Sub(heard) >= timeout:
+			node.campaign()
+			timeout = node.electionTimeout()
+		}
+	}
+}
+
+// campaign stands for election in a new term, becoming leader if a majority of the cluster votes for the node.
+func (node *Node) campaign() {
+	node.mu.Lock()
+	node.term++
+	node.role = Candidate
+	node.votedFor = node.config.NodeId
+	node.leaderId = ""
+	node.heard = node.now()
+	request := voteRequest{Term: node.term, CandidateId: node.config.NodeId}
+	request.LastSeq, request.LastTerm = node.last()
+	node.notify()
+	node.mu.Unlock()
+
+	slog.Info("standing for replication leader", "node", node.config.NodeId, "term", request.Term)
+	acks := node.broadcast("/replication/vote", request)
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.role != Candidate || node.term != request.Term {
+		return
+	}
+	votes := 1
+	for _, reply := range acks {
+		if reply.Term > node.term {
+			node.stepDown(reply.Term, "")
+			return
+		}
+		if reply.Granted {
+			votes++
+		}
+	}
+	if votes < node.majority() {
+		return
+	}
+	node.role = Leader
+	node.leaderId = node.config.NodeId
+	node.matched = map[string]uint64{}
+	node.heard = node.now()
+	node.notify()
+	slog.Info("elected replication leader", "node", node.config.NodeId, "term", node.term, "votes", votes)
+}
+
+// sendHeartbeats tells every follower the leader is still there. A leader that hasn't heard back from a majority of
+// the cluster for an election timeout steps down, as the rest of the cluster may have elected another.
+func (node *Node) sendHeartbeats() {
+	node.mu.Lock()
+	beat := heartbeat{Term: node.term, LeaderId: node.config.NodeId}
+	node.mu.Unlock()
+
+	acks := node.broadcast("/replication/heartbeat", beat)
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.role != Leader || node.term != beat.Term {
+		return
+	}
+	answered := 1
+	for _, reply := range acks {
+		if reply.Term > node.term {
+			node.stepDown(reply.Term, "")
+			return
+		}
+		if reply.Granted {
+			answered++
+		}
+	}
+	if answered >= node.majority() {
+		node.heard = node.now()
+	} else if node.now().Sub(node.heard) >= node.config.ElectionTimeout {
+		slog.Warn("replication leader lost contact with a majority of the cluster", "node", node.config.NodeId, "term", node.term)
+		node.stepDown(node.term, "")
+	}
+}
+
+func (node *Node) serveHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var beat heartbeat
+	if !decode(w, r, &beat) {
+		return
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if beat.Term < node.term {
+		writeJSON(w, ack{Term: node.term})
+		return
+	}
+	if beat.Term > node.term || node.role != Follower || node.leaderId != beat.LeaderId {
+		if node.leaderId != beat.LeaderId {
+			slog.Info("following replication leader", "node", node.config.NodeId, "leader", beat.LeaderId, "term", beat.Term)
+		}
+		node.stepDown(beat.Term, beat.LeaderId)
+	}
+	node.heard = node.now()
+	writeJSON(w, ack{Term: node.term, Granted: true})
+}
+
+func (node *Node) serveVote(w http.ResponseWriter, r *http.Request) {
+	var request voteRequest
+	if !decode(w, r, &request) {
+		return
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if request.Term < node.term {
+		writeJSON(w, ack{Term: node.term})
+		return
+	}
+	if request.Term > node.term {
+		node.stepDown(request.Term, "")
+	}
+	lastSeq, lastTerm := node.last()
+	upToDate := request.LastTerm > lastTerm || request.LastTerm == lastTerm && request.LastSeq >= lastSeq
+	if !upToDate || node.votedFor != "" && node.votedFor != request.CandidateId {
+		writeJSON(w, ack{Term: node.term})
+		return
+	}
+	node.votedFor = request.CandidateId
+	node.heard = node.now()
+	writeJSON(w, ack{Term: node.term, Granted: true})
+}
@@ -0,0 +1,160 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/logging"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+)
+
+type op string
+
+const (
+	opCreateItem   op = "createItem"
+	opCompleteItem op = "completeItem"
+	opDeleteItem   op = "deleteItem"
+	opMoveItem     op = "moveItem"
+	opCreateList   op = "createList"
+	// opCreateDefaultList creates the caller's default list, which GetTodoLists does the first time they ask for their
+	// lists.
+	opCreateDefaultList op = "createDefaultList"
+	opAddMember         op = "addMember"
+	opUpdateMember      op = "updateMember"
+	opRemoveMember      op = "removeMember"
+	opSync              op = "sync"
+)
+
+// Entry is a write in the replicated log: the data service call to make, who made it, in which tenant and when. Only
+// the fields the call needs are set.
+type Entry struct {
+	Seq       uint64
+	Term      uint64
+	Time      time.Time
+	Op        op
+	Tenant    string
+	UserId    int             `json:",omitempty"`
+	RequestId string          `json:",omitempty"`
+	ListId    int             `json:",omitempty"`
+	Index     int             `json:",omitempty"`
+	ItemId    int             `json:",omitempty"`
+	After     int             `json:",omitempty"`
+	Before    int             `json:",omitempty"`
+	Name      string          `json:",omitempty"`
+	MemberId  int             `json:",omitempty"`
+	Role      data.Role       `json:",omitempty"`
+	Batch     *data.SyncBatch `json:",omitempty"`
+}
+
+// stamp records who is making the write and in which tenant.
+func (entry *Entry) stamp(ctx context.Context) {
+	entry.Tenant = tenants.From(ctx)
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		entry.UserId = principal.UserId
+	}
+	entry.RequestId = logging.RequestID(ctx)
+}
+
+// apply makes the write an entry records, as the user who made it and at the time the leader made it, so that every
+// node ends up with the same data.
+func apply(store Store, entry Entry) (any, error) {
+	ctx := dataService.WithTime(tenants.WithTenant(context.Background(), entry.Tenant), entry.Time)
+	if entry.UserId != 0 {
+		ctx = auth.WithPrincipal(ctx, auth.Principal{UserId: entry.UserId})
+	}
+	if entry.RequestId != "" {
+		ctx = logging.WithRequestID(ctx, entry.RequestId)
+	}
+
+	switch entry.Op {
+	case opCreateItem:
+		return nil, store.CreateTodoItem(ctx, entry.ListId, entry.Name)
+	case opCompleteItem:
+		return nil, store.MarkItemAsComplete(ctx, entry.ListId, entry.Index)
+	case opDeleteItem:
+		return nil, store.DeleteTodoItem(ctx, entry.ListId, entry.Index)
+	case opMoveItem:
+		return store.MoveTodoItem(ctx, entry.ListId, entry.ItemId, entry.After, entry.Before)
+	case opCreateList:
+		return store.CreateTodoList(ctx, entry.Name)
+	case opCreateDefaultList:
+		store.GetTodoLists(ctx)
+		return nil, nil
+	case opAddMember:
+		return nil, store.AddListMember(ctx, entry.ListId, entry.MemberId, entry.Role)
+	case opUpdateMember:
+		return nil, store.UpdateListMember(ctx, entry.ListId, entry.MemberId, entry.Role)
+	case opRemoveMember:
+		return nil, store.RemoveListMember(ctx, entry.ListId, entry.MemberId)
+	case opSync:
+		var batch data.SyncBatch
+		if entry.Batch != nil {
+			batch = *entry.Batch
+		}
+		return store.Sync(ctx, entry.ListId, batch)
+	default:
+		return nil, fmt.Errorf("unknown replicated operation %q", entry.Op)
+	}
+}
+
+// knownErrors are the errors a write can fail with. Errors sent back from the leader are turned back into them, so
+// callers on followers can tell them apart as they would on the leader.
+var knownErrors = []error{
+	dataService.ErrEmptyName,
+	dataService.ErrItemNotFound,
+	dataService.ErrListNotFound,
+	dataService.ErrInvalidRole,
+	dataService.ErrMemberNotFound,
+	dataService.ErrAlreadyMember,
+	dataService.ErrListCreator,
+	dataService.ErrUnknownOperation,
+	dataService.ErrItemIdNotFound,
+	dataService.ErrAnchorNotFound,
+	dataService.ErrInvalidMove,
+	tenants.ErrUnknownTenant,
+	tenants.ErrItemQuotaExceeded,
+	ErrNoLeader,
+	ErrNotCommitted,
+}
+
+func errorFrom(message string) error {
+	for _, err := range knownErrors {
+		if err.Error() == message {
+			return err
+		}
+	}
+	return errors.New(message)
+}
+
+// outcome is the result of applying an entry on the leader, as sent back to the follower it was forwarded from.
+type outcome struct {
+	Seq    uint64
+	Result json.RawMessage `json:",omitempty"`
+	Error  string          `json:",omitempty"`
+}
+
+func newOutcome(seq uint64, value any, err error) outcome {
+	result := outcome{Seq: seq}
+	if err != nil {
+		result.Error = err.Error()
+	} else if value != nil {
+		result.Result, _ = json.Marshal(value)
+	}
+	return result
+}
+
+// decode returns the error the write failed with, or decodes its result into value.
+func (result outcome) decode(value any) error {
+	if result.Error != "" {
+		return errorFrom(result.Error)
+	}
+	if value == nil || result.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(result.Result, value)
+}
@@ -0,0 +1,317 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+	"todoApp/logging"
+	dataService "todoApp/services"
+)
+
+const (
+	DefaultHeartbeatInterval = 500 * time.Millisecond
+	DefaultElectionTimeout   = 2 * time.Second
+	DefaultCommitTimeout     = 5 * time.Second
+	// SecretHeader carries the secret shared by the nodes of a cluster on every request they make to each other.
+	SecretHeader = "X-Replication-Secret"
+	// maxEntriesPerPoll is the most log entries a follower is sent at once, so one catching up from the start of a
+	// long log does so in several requests rather than one huge one.
+	maxEntriesPerPoll = 1000
+)
+
+var (
+	// ErrNoLeader is returned for writes made while the cluster is electing a leader, or the node can't reach it.
+	ErrNoLeader = errors.New("no replication leader is available, try again shortly")
+	// ErrNotCommitted is returned for writes a majority of the cluster didn't confirm in time. They may still be kept.
+	ErrNotCommitted = errors.New("the write was not confirmed by a majority of the cluster in time")
+)
+
+var (
+	_ dataService.IDataService   = (*Node)(nil)
+	_ dataService.IHealthChecker = (*Node)(nil)
+)
+
+// Store is the data a node replicates. Writes are applied to it in log order on every node, and it is thrown away
+// with Reset and rebuilt from the leader's log when a node finds it applied writes the leader doesn't have.
+type Store interface {
+	dataService.IDataService
+	dataService.IHealthChecker
+	Reset()
+}
+
+// Config names a node and the other nodes in its cluster.
+type Config struct {
+	NodeId string
+	// Peers are the base URLs of the other nodes, by node ID.
+	Peers  map[string]string
+	Secret string
+	// HeartbeatInterval is how often the leader tells followers it is still there, and the longest a follower's
+	// request for new log entries waits for one.
+	HeartbeatInterval time.Duration
+	// ElectionTimeout is how long followers wait to hear from a leader before electing a new one, and how long a leader
+	// keeps leading without hearing back from a majority. The actual wait is randomized between it and twice it, so
+	// that nodes rarely stand for election at the same time.
+	ElectionTimeout time.Duration
+	// CommitTimeout is how long a write waits for a majority of the cluster to confirm it.
+	CommitTimeout time.Duration
+}
+
+type Role string
+
+const (
+	Follower  Role = "follower"
+	Candidate Role = "candidate"
+	Leader    Role = "leader"
+)
+
+// Status is what a node knows about the cluster.
+type Status struct {
+	NodeId   string
+	Role     Role
+	Term     uint64
+	LeaderId string `json:",omitempty"`
+	LastSeq  uint64
+	// Matched is, on the leader, how much of its log each follower is known to have applied.
+	Matched map[string]uint64 `json:",omitempty"`
+}
+
+// Node replicates writes to its store across a cluster. One node is elected leader, Raft style: it numbers every
+// write, applies it and keeps it in its log, which the other nodes, its followers, pull from it and apply in the same
+// order. A write is confirmed once a majority of the cluster has applied it. Followers serve reads from their own
+// store and forward writes to the leader. Like the rest of the app's state the log is kept in memory, so a node that
+// restarts catches up from the start of the leader's log.
+type Node struct {
+	config   Config
+	store    Store
+	client   *http.Client
+	log      []Entry
+	term     uint64
+	votedFor string
+	role     Role
+	leaderId string
+	// heard is when a follower last heard from the leader, or when the leader last heard back from a majority.
+	heard   time.Time
+	matched map[string]uint64
+	// changed is closed and replaced whenever the node's state or log changes, waking everything waiting on it.
+	changed chan struct{}
+	stop    chan struct{}
+	workers sync.WaitGroup
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func NewNode(config Config, store Store) *Node {
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.ElectionTimeout <= config.HeartbeatInterval {
+		config.ElectionTimeout = max(DefaultElectionTimeout, 4*config.HeartbeatInterval)
+	}
+	if config.CommitTimeout <= 0 {
+		config.CommitTimeout = DefaultCommitTimeout
+	}
+	return &Node{
+		config:  config,
+		store:   store,
+		client:  &http.Client{},
+		role:    Follower,
+		matched: map[string]uint64{},
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+// Start joins the cluster, following its leader or standing for election if there isn't one.
+func (node *Node) Start() {
+	node.mu.Lock()
+	node.heard = node.now()
+	node.mu.Unlock()
+
+	node.workers.Add(2)
+	go node.run()
+	go node.follow()
+}
+
+// Close leaves the cluster. The rest of it elects a new leader if this node was leading.
+func (node *Node) Close() {
+	node.mu.Lock()
+	select {
+	case <-node.stop:
+	default:
+		close(node.stop)
+	}
+	node.mu.Unlock()
+	node.workers.Wait()
+}
+
+func (node *Node) Status() Status {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	status := Status{NodeId: node.config.NodeId, Role: node.role, Term: node.term, LeaderId: node.leaderId, LastSeq: uint64(len(node.log))}
+	if node.role == Leader {
+		status.Matched = maps.Clone(node.matched)
+	}
+	return status
+}
+
+// write appends entry to the leader's log, applying it, and waits for it to be committed, forwarding it to the leader
+// if this node isn't leading. The result of applying it is decoded into result, if it isn't nil.
+func (node *Node) write(ctx context.Context, entry Entry, result any) error {
+	entry.stamp(ctx)
+
+	node.mu.Lock()
+	leading := node.role == Leader
+	leaderId := node.leaderId
+	node.mu.Unlock()
+
+	var answer outcome
+	var err error
+	switch {
+	case leading:
+		answer, err = node.lead(entry)
+	case leaderId != "":
+		answer, err = node.forward(ctx, leaderId, entry)
+		if err == nil {
+			node.catchUp(ctx, answer.Seq)
+		}
+	default:
+		err = ErrNoLeader
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("replicated write failed", "op", entry.Op, "error", err)
+		return err
+	}
+	return answer.decode(result)
+}
+
+// lead appends entry to the log and applies it, then waits for a majority of the cluster to do the same. Entries are
+// logged whether or not applying them fails, so that followers fail the same way.
+func (node *Node) lead(entry Entry) (outcome, error) {
+	node.mu.Lock()
+	if node.role != Leader {
+		node.mu.Unlock()
+		return outcome{}, ErrNoLeader
+	}
+	entry.Seq = uint64(len(node.log)) + 1
+	entry.Term = node.term
+	entry.Time = node.now().UTC()
+	value, err := apply(node.store, entry)
+	node.log = append(node.log, entry)
+	node.notify()
+	node.mu.Unlock()
+
+	return newOutcome(entry.Seq, value, err), node.awaitCommit(entry.Seq, entry.Term)
+}
+
+// awaitCommit waits until a majority of the cluster has applied the log up to seq.
+func (node *Node) awaitCommit(seq uint64, term uint64) error {
+	timeout := time.NewTimer(node.config.CommitTimeout)
+	defer timeout.Stop()
+	for {
+		node.mu.Lock()
+		committed := node.commitSeq() >= seq
+		leading := node.role == Leader && node.term == term
+		changed := node.changed
+		node.mu.Unlock()
+
+		switch {
+		case committed:
+			return nil
+		case !leading:
+			return ErrNotCommitted
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return ErrNotCommitted
+		case <-node.stop:
+			return ErrNotCommitted
+		}
+	}
+}
+
+// catchUp waits for a follower to apply the log up to seq, so that the caller can read what they just wrote. Their
+// write has already been committed, so it isn't an error if that takes too long.
+func (node *Node) catchUp(ctx context.Context, seq uint64) {
+	timeout := time.NewTimer(node.config.CommitTimeout)
+	defer timeout.Stop()
+	for {
+		node.mu.Lock()
+		applied := uint64(len(node.log)) >= seq
+		changed := node.changed
+		node.mu.Unlock()
+
+		if applied {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			logging.FromContext(ctx).Warn("follower is behind the leader, the write may not be visible here yet", "seq", seq)
+			return
+		case <-ctx.Done():
+			return
+		case <-node.stop:
+			return
+		}
+	}
+}
+
+// commitSeq returns the highest sequence number a majority of the cluster has applied. The caller must hold the lock.
+func (node *Node) commitSeq() uint64 {
+	applied := []uint64{uint64(len(node.log))}
+	for id := range node.config.Peers {
+		applied = append(applied, node.matched[id])
+	}
+	slices.Sort(applied)
+	slices.Reverse(applied)
+	return applied[node.majority()-1]
+}
+
+// majority is how many nodes, this one included, make a majority of the cluster.
+func (node *Node) majority() int {
+	return (len(node.config.Peers)+1)/2 + 1
+}
+
+// last returns the sequence number and term of the last entry in the log. The caller must hold the lock.
+func (node *Node) last() (seq uint64, term uint64) {
+	if len(node.log) == 0 {
+		return 0, 0
+	}
+	entry := node.log[len(node.log)-1]
+	return entry.Seq, entry.Term
+}
+
+// notify wakes everything waiting for the node to change. The caller must hold the lock.
+func (node *Node) notify() {
+	close(node.changed)
+	node.changed = make(chan struct{})
+}
+
+// electionTimeout returns how long to wait to hear from a leader before standing for election.
+func (node *Node) electionTimeout() time.Duration {
+	return node.config.ElectionTimeout + rand.N(node.config.ElectionTimeout)
+}
+
+// stepDown makes the node a follower, of leaderId if it is known, in term. The caller must hold the lock.
+func (node *Node) stepDown(term uint64, leaderId string) {
+	if term > node.term {
+		node.term = term
+		node.votedFor = ""
+	}
+	if node.role == Leader {
+		slog.Warn("stepping down as replication leader", "node", node.config.NodeId, "term", node.term)
+	}
+	node.role = Follower
+	node.leaderId = leaderId
+	node.heard = node.now()
+	node.notify()
+}
@@ -0,0 +1,328 @@
+package replication
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+	"todoApp/auth"
+	"todoApp/data"
+	dataService "todoApp/services"
+	"todoApp/tenants"
+)
+
+const testSecret = "test-secret"
+
+type testCluster struct {
+	nodes   map[string]*Node
+	stores  map[string]*tenants.Router
+	servers map[string]*httptest.Server
+}
+
+// newTestCluster starts a node for each ID, each behind its own test server.
+func newTestCluster(t *testing.T, ids ...string) *testCluster {
+	cluster := &testCluster{nodes: map[string]*Node{}, stores: map[string]*tenants.Router{}, servers: map[string]*httptest.Server{}}
+	handlers := map[string]http.Handler{}
+	for _, id := range ids {
+		cluster.servers[id] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[id].ServeHTTP(w, r)
+		}))
+	}
+	for _, id := range ids {
+		peers := map[string]string{}
+		for _, peer := range ids {
+			if peer != id {
+				peers[peer] = cluster.servers[peer].URL
+			}
+		}
+		cluster.stores[id] = tenants.NewRouter(func(namespace string) *dataService.DataService { return dataService.NewDataService() }, tenants.Quota{})
+		cluster.nodes[id] = NewNode(Config{
+			NodeId:            id,
+			Peers:             peers,
+			Secret:            testSecret,
+			HeartbeatInterval: 20 * time.Millisecond,
+			ElectionTimeout:   100 * time.Millisecond,
+			CommitTimeout:     2 * time.Second,
+		}, cluster.stores[id])
+		handlers[id] = cluster.nodes[id].Handler()
+	}
+	for _, id := range ids {
+		cluster.nodes[id].Start()
+	}
+	t.Cleanup(func() {
+		for id := range cluster.nodes {
+			cluster.stop(id)
+		}
+	})
+	return cluster
+}
+
+// stop takes a node down as if its server had crashed.
+func (cluster *testCluster) stop(id string) {
+	if node, running := cluster.nodes[id]; running {
+		node.Close()
+		cluster.servers[id].Close()
+		delete(cluster.nodes, id)
+	}
+}
+
+// leader waits for the running nodes to agree on a leader and returns its ID.
+func (cluster *testCluster) leader(t *testing.T) string {
+	t.Helper()
+	var leaderId string
+	waitFor(t, "the cluster to elect a leader", func() bool {
+		leaderId = ""
+		for id, node := range cluster.nodes {
+			if status := node.Status(); status.Role == Leader {
+				leaderId = id
+			}
+		}
+		for _, node := range cluster.nodes {
+			if status := node.Status(); leaderId == "" || status.LeaderId != leaderId {
+				return false
+			}
+		}
+		return true
+	})
+	return leaderId
+}
+
+// follower returns the ID of a running node that isn't the leader.
+func (cluster *testCluster) follower(t *testing.T) string {
+	leaderId := cluster.leader(t)
+	for id := range cluster.nodes {
+		if id != leaderId {
+			return id
+		}
+	}
+	t.Fatal("the cluster has no followers")
+	return ""
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func itemNames(t *testing.T, store dataService.IDataService, ctx context.Context, listId int) []string {
+	items, err := store.GetAllTodoItems(ctx, listId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+// waitForReplicas waits for every running node to have the expected items in a list.
+func (cluster *testCluster) waitForReplicas(t *testing.T, ctx context.Context, listId int, expected []string) {
+	t.Helper()
+	for id, node := range cluster.nodes {
+		waitFor(t, "node "+id+" to replicate the list", func() bool {
+			// Writes are committed once a majority has them, so the list may not have reached every node yet
+			if _, err := node.GetAllTodoItems(ctx, listId); err != nil {
+				return false
+			}
+			return slices.Equal(itemNames(t, node, ctx, listId), expected)
+		})
+	}
+}
+
+func TestCluster_ElectsOneLeader(t *testing.T) {
+	cluster := newTestCluster(t, "a", "b", "c")
+	leaderId := cluster.leader(t)
+
+	leaders := 0
+	for _, node := range cluster.nodes {
+		if node.Status().Role == Leader {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("Unexpected number of leaders. Got: %v, Expected: 1", leaders)
+	}
+	if status := cluster.nodes[leaderId].Status(); status.Term == 0 {
+		t.Errorf("The leader was elected without a term. Got: %+v", status)
+	}
+}
+
+func TestCluster_ReplicatesWrites(t *testing.T) {
+	cluster := newTestCluster(t, "a", "b", "c")
+	follower := cluster.nodes[cluster.follower(t)]
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+
+	list, err := follower.CreateTodoList(alice, "Groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Milk", "Eggs", "Bread"} {
+		if err := follower.CreateTodoItem(alice, list.Id, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := follower.MarkItemAsComplete(alice, list.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+	items, _ := follower.GetAllTodoItems(alice, list.Id)
+	if _, err := follower.MoveTodoItem(alice, list.Id, items[2].Id, 0, items[0].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// A follower's writes can be read back from it straight away
+	expected := []string{"Bread", "Milk", "Eggs"}
+	if names := itemNames(t, follower, alice, list.Id); !slices.Equal(names, expected) {
+		t.Errorf("The follower can't read its own writes. Got: %v, Expected: %v", names, expected)
+	}
+	cluster.waitForReplicas(t, alice, list.Id, expected)
+	for id, node := range cluster.nodes {
+		if item, _ := node.GetTodoItem(alice, list.Id, 2); !item.Complete {
+			t.Errorf("Node %v didn't replicate the completed item. Got: %+v", id, item)
+		}
+		if lists := node.GetTodoLists(alice); len(lists) != 2 || lists[1].Id != list.Id {
+			t.Errorf("Node %v didn't replicate the lists. Got: %+v", id, lists)
+		}
+	}
+}
+
+func TestCluster_ForwardsErrors(t *testing.T) {
+	cluster := newTestCluster(t, "a", "b", "c")
+	follower := cluster.nodes[cluster.follower(t)]
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	other := tenants.WithTenant(alice, "other")
+
+	testCases := []struct {
+		testName      string
+		write         func() error
+		expectedError error
+	}{
+		{"Testing a list that doesn't exist", func() error { return follower.CreateTodoItem(alice, 9, "Milk") }, dataService.ErrListNotFound},
+		{"Testing an empty name", func() error { return follower.CreateTodoItem(alice, 0, " ") }, dataService.ErrEmptyName},
+		{"Testing an invalid role", func() error { return follower.AddListMember(alice, 0, 2, data.Role("admin")) }, dataService.ErrInvalidRole},
+		{"Testing an unknown tenant", func() error { return follower.CreateTodoItem(other, 0, "Milk") }, tenants.ErrUnknownTenant},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if err := test.write(); err != test.expectedError {
+				t.Errorf("Unexpected error. Got: %v, Expected: %v", err, test.expectedError)
+			}
+		})
+	}
+}
+
+func TestCluster_Failover(t *testing.T) {
+	cluster := newTestCluster(t, "a", "b", "c")
+	oldLeader := cluster.leader(t)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+
+	list, err := cluster.nodes[oldLeader].CreateTodoList(alice, "Incident checklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.nodes[oldLeader].CreateTodoItem(alice, list.Id, "Before"); err != nil {
+		t.Fatal(err)
+	}
+	cluster.stop(oldLeader)
+
+	newLeader := cluster.leader(t)
+	if newLeader == oldLeader {
+		t.Fatalf("The stopped node is still the leader")
+	}
+	follower := cluster.nodes[cluster.follower(t)]
+	if err := follower.CreateTodoItem(alice, list.Id, "After"); err != nil {
+		t.Fatalf("Writes failed after the leader went down: %v", err)
+	}
+	cluster.waitForReplicas(t, alice, list.Id, []string{"Before", "After"})
+}
+
+func TestCluster_RebuildsDivergedFollower(t *testing.T) {
+	cluster := newTestCluster(t, "a", "b", "c")
+	leader := cluster.nodes[cluster.leader(t)]
+	followerId := cluster.follower(t)
+	follower := cluster.nodes[followerId]
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	list, err := leader.CreateTodoList(alice, "Incident checklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.CreateTodoItem(alice, list.Id, "Committed"); err != nil {
+		t.Fatal(err)
+	}
+	cluster.waitForReplicas(t, alice, list.Id, []string{"Committed"})
+
+	// The follower applies a write the leader never made, as a leader cut off from the cluster would
+	follower.mu.Lock()
+	lastSeq, lastTerm := follower.last()
+	stray := Entry{Seq: lastSeq + 1, Term: lastTerm + 1, Op: opCreateItem, Tenant: tenants.DefaultTenant, UserId: 1, ListId: list.Id, Name: "Stray"}
+	apply(follower.store, stray)
+	follower.log = append(follower.log, stray)
+	follower.mu.Unlock()
+
+	if names := itemNames(t, follower, alice, list.Id); !slices.Equal(names, []string{"Committed", "Stray"}) {
+		t.Fatalf("The stray write wasn't applied. Got: %v", names)
+	}
+	if err := leader.CreateTodoItem(alice, list.Id, "Later"); err != nil {
+		t.Fatal(err)
+	}
+	cluster.waitForReplicas(t, alice, list.Id, []string{"Committed", "Later"})
+	if status := follower.Status(); status.LastSeq != leader.Status().LastSeq {
+		t.Errorf("The rebuilt follower's log doesn't match the leader's. Got: %+v", status)
+	}
+}
+
+func TestNode_NoLeader(t *testing.T) {
+	store := tenants.NewRouter(func(namespace string) *dataService.DataService { return dataService.NewDataService() }, tenants.Quota{})
+	node := NewNode(Config{NodeId: "a", Peers: map[string]string{"b": "http://127.0.0.1:1"}, Secret: testSecret}, store)
+	ctx := context.Background()
+
+	if err := node.CreateTodoItem(ctx, 0, "Milk"); err != ErrNoLeader {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrNoLeader)
+	}
+	if names := itemNames(t, node, ctx, 0); len(names) != 3 {
+		t.Errorf("A write was made without a leader. Got: %v", names)
+	}
+	// Reads don't need a leader, and a new user is shown an empty default list until one can be created
+	alice := auth.WithPrincipal(ctx, auth.Principal{UserId: 1})
+	if lists := node.GetTodoLists(alice); len(lists) != 1 || lists[0].Id != 0 || lists[0].OwnerId != 1 {
+		t.Errorf("Unexpected lists. Got: %+v", lists)
+	}
+	if _, err := store.GetDefaultListId(alice); err == nil {
+		t.Errorf("A default list was created without a leader")
+	}
+}
+
+func TestHandler_RequiresSecret(t *testing.T) {
+	node := NewNode(Config{NodeId: "a", Secret: testSecret}, nil)
+	testCases := []struct {
+		testName       string
+		secret         string
+		expectedStatus int
+	}{
+		{"Testing the cluster's secret", testSecret, http.StatusOK},
+		{"Testing the wrong secret", "guess", http.StatusUnauthorized},
+		{"Testing without a secret", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/replication/status", nil)
+			req.Header.Set(SecretHeader, test.secret)
+			rr := httptest.NewRecorder()
+			node.Handler().ServeHTTP(rr, req)
+
+			if status := rr.Code; status != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", status, test.expectedStatus)
+			}
+		})
+	}
+}
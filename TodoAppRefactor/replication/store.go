@@ -0,0 +1,93 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"todoApp/auth"
+	"todoApp/data"
+	dataService "todoApp/services"
+)
+
+// Reads are served from the node's own store, which may be behind the leader's by the writes it hasn't pulled yet.
+// Writes go through the leader's log.
+
+func (node *Node) CreateTodoItem(ctx context.Context, listId int, name string) error {
+	return node.write(ctx, Entry{Op: opCreateItem, ListId: listId, Name: name}, nil)
+}
+
+func (node *Node) GetTodoItem(ctx context.Context, listId int, index int) (data.TodoItem, error) {
+	return node.store.GetTodoItem(ctx, listId, index)
+}
+
+func (node *Node) GetAllTodoItems(ctx context.Context, listId int) ([]data.TodoItem, error) {
+	return node.store.GetAllTodoItems(ctx, listId)
+}
+
+func (node *Node) MarkItemAsComplete(ctx context.Context, listId int, index int) error {
+	return node.write(ctx, Entry{Op: opCompleteItem, ListId: listId, Index: index}, nil)
+}
+
+func (node *Node) DeleteTodoItem(ctx context.Context, listId int, index int) error {
+	return node.write(ctx, Entry{Op: opDeleteItem, ListId: listId, Index: index}, nil)
+}
+
+func (node *Node) MoveTodoItem(ctx context.Context, listId int, itemId int, after int, before int) (int, error) {
+	var index int
+	err := node.write(ctx, Entry{Op: opMoveItem, ListId: listId, ItemId: itemId, After: after, Before: before}, &index)
+	return index, err
+}
+
+func (node *Node) CreateTodoList(ctx context.Context, name string) (data.TodoList, error) {
+	var list data.TodoList
+	err := node.write(ctx, Entry{Op: opCreateList, Name: name}, &list)
+	return list, err
+}
+
+// GetTodoLists creates the caller's default list through the leader the first time they ask for their lists. If that
+// can't be done, e.g. during an election, they are shown an empty default list until it can.
+func (node *Node) GetTodoLists(ctx context.Context) []data.TodoList {
+	if _, err := node.store.GetDefaultListId(ctx); errors.Is(err, dataService.ErrListNotFound) {
+		if err := node.write(ctx, Entry{Op: opCreateDefaultList}, nil); err != nil {
+			var owner int
+			if principal, ok := auth.PrincipalFrom(ctx); ok {
+				owner = principal.UserId
+			}
+			return []data.TodoList{{OwnerId: owner, Name: dataService.DefaultListName}}
+		}
+	}
+	return node.store.GetTodoLists(ctx)
+}
+
+func (node *Node) GetDefaultListId(ctx context.Context) (int, error) {
+	return node.store.GetDefaultListId(ctx)
+}
+
+func (node *Node) GetListRole(ctx context.Context, listId int) (data.Role, error) {
+	return node.store.GetListRole(ctx, listId)
+}
+
+func (node *Node) GetListMembers(ctx context.Context, listId int) ([]data.Member, error) {
+	return node.store.GetListMembers(ctx, listId)
+}
+
+func (node *Node) AddListMember(ctx context.Context, listId int, userId int, role data.Role) error {
+	return node.write(ctx, Entry{Op: opAddMember, ListId: listId, MemberId: userId, Role: role}, nil)
+}
+
+func (node *Node) UpdateListMember(ctx context.Context, listId int, userId int, role data.Role) error {
+	return node.write(ctx, Entry{Op: opUpdateMember, ListId: listId, MemberId: userId, Role: role}, nil)
+}
+
+func (node *Node) RemoveListMember(ctx context.Context, listId int, userId int) error {
+	return node.write(ctx, Entry{Op: opRemoveMember, ListId: listId, MemberId: userId}, nil)
+}
+
+func (node *Node) Sync(ctx context.Context, listId int, batch data.SyncBatch) (data.SyncResult, error) {
+	var result data.SyncResult
+	err := node.write(ctx, Entry{Op: opSync, ListId: listId, Batch: &batch}, &result)
+	return result, err
+}
+
+func (node *Node) CheckStore(ctx context.Context) error {
+	return node.store.CheckStore(ctx)
+}
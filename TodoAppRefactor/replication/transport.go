@@ -0,0 +1,306 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"todoApp/api/responses"
+)
+
+var errDiverged = errors.New("the log has entries the leader's doesn't")
+
+// statusError is an error response from another node.
+type statusError struct {
+	status  int
+	message string
+}
+
+func (err *statusError) Error() string {
+	return strconv.Itoa(err.status) + ": " + err.message
+}
+
+// Handler serves the routes the nodes of a cluster call on each other, under '/replication/'. Every request must
+// carry the cluster's secret in the SecretHeader.
+func (node *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /replication/status", node.serveStatus)
+	mux.HandleFunc("POST /replication/heartbeat", node.serveHeartbeat)
+	mux.HandleFunc("POST /replication/vote", node.serveVote)
+	mux.HandleFunc("GET /replication/log", node.serveLog)
+	mux.HandleFunc("POST /replication/forward", node.serveForward)
+	return node.authenticate(mux)
+}
+
+func (node *Node) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get(SecretHeader)
+		if node.config.Secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(node.config.Secret)) != 1 {
+			responses.WriteError(w, http.StatusUnauthorized, "missing or invalid replication secret")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (node *Node) serveStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, node.Status())
+}
+
+// serveLog sends a follower the leader's log entries after 'since', waiting up to a heartbeat interval for there to
+// be some. 'term' is the term of the follower's entry at 'since', which tells the leader whether the follower's log
+// matches its own up to there. If it doesn't the follower is told to start over. Asking for the entries after 'since'
+// also tells the leader the follower has applied those before it.
+func (node *Node) serveLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since, sinceErr := strconv.ParseUint(query.Get("since"), 10, 64)
+	term, termErr := strconv.ParseUint(query.Get("term"), 10, 64)
+	if sinceErr != nil || termErr != nil {
+		responses.WriteError(w, http.StatusBadRequest, "'since' and 'term' must be non-negative integers")
+		return
+	}
+	peer := query.Get("node")
+
+	deadline := time.NewTimer(node.config.HeartbeatInterval)
+	defer deadline.Stop()
+	for {
+		node.mu.Lock()
+		if node.role != Leader {
+			node.mu.Unlock()
+			responses.WriteError(w, http.StatusServiceUnavailable, ErrNoLeader.Error())
+			return
+		}
+		if since > uint64(len(node.log)) || since > 0 && node.log[since-1].Term != term {
+			node.mu.Unlock()
+			responses.WriteError(w, http.StatusConflict, errDiverged.Error())
+			return
+		}
+		if _, isPeer := node.config.Peers[peer]; isPeer && node.matched[peer] != since {
+			node.matched[peer] = since
+			node.notify()
+		}
+		entries := slices.Clone(node.log[since:min(uint64(len(node.log)), since+maxEntriesPerPoll)])
+		changed := node.changed
+		node.mu.Unlock()
+
+		if len(entries) > 0 {
+			writeJSON(w, entries)
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			writeJSON(w, []Entry{})
+			return
+		case <-node.stop:
+			writeJSON(w, []Entry{})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveForward makes a write forwarded by a follower, answering once it is committed.
+func (node *Node) serveForward(w http.ResponseWriter, r *http.Request) {
+	var entry Entry
+	if !decode(w, r, &entry) {
+		return
+	}
+	answer, err := node.lead(entry)
+	if err != nil {
+		responses.WriteError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, answer)
+}
+
+// follow pulls new entries from the leader and applies them, for as long as the node is a follower.
+func (node *Node) follow() {
+	defer node.workers.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-node.stop
+		cancel()
+	}()
+
+	for {
+		node.mu.Lock()
+		following := node.role == Follower && node.leaderId != ""
+		leaderId := node.leaderId
+		since, term := node.last()
+		changed := node.changed
+		node.mu.Unlock()
+
+		if !following {
+			select {
+			case <-changed:
+				continue
+			case <-node.stop:
+				return
+			}
+		}
+
+		entries, err := node.pull(ctx, leaderId, since, term)
+		switch {
+		case errors.Is(err, errDiverged):
+			node.rebuild(leaderId)
+		case err != nil:
+			slog.Debug("couldn't pull the replication log from the leader", "node", node.config.NodeId, "leader", leaderId, "error", err)
+			select {
+			case <-time.After(node.config.HeartbeatInterval):
+			case <-node.stop:
+				return
+			}
+		default:
+			node.replicate(leaderId, since, entries)
+		}
+	}
+}
+
+func (node *Node) pull(ctx context.Context, leaderId string, since uint64, term uint64) ([]Entry, error) {
+	url, known := node.config.Peers[leaderId]
+	if !known {
+		return nil, errors.New("unknown leader " + leaderId)
+	}
+	ctx, cancel := context.WithTimeout(ctx, node.config.ElectionTimeout)
+	defer cancel()
+
+	url += "/replication/log?since=" + strconv.FormatUint(since, 10) + "&term=" + strconv.FormatUint(term, 10) + "&node=" + node.config.NodeId
+	var entries []Entry
+	err := node.call(ctx, http.MethodGet, url, nil, &entries)
+	if status := (*statusError)(nil); errors.As(err, &status) && status.status == http.StatusConflict {
+		return nil, errDiverged
+	}
+	return entries, err
+}
+
+// replicate applies entries pulled from the leader, unless the node has moved on since it asked for them.
+func (node *Node) replicate(leaderId string, since uint64, entries []Entry) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.role != Follower || node.leaderId != leaderId || uint64(len(node.log)) != since {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Seq != uint64(len(node.log))+1 {
+			slog.Error("replication log entries are out of order", "node", node.config.NodeId, "seq", entry.Seq, "expected", len(node.log)+1)
+			break
+		}
+		if _, err := apply(node.store, entry); err != nil {
+			slog.Debug("replicated write failed, as it did on the leader", "node", node.config.NodeId, "seq", entry.Seq, "op", entry.Op, "error", err)
+		}
+		node.log = append(node.log, entry)
+	}
+	if len(entries) > 0 {
+		node.notify()
+	}
+}
+
+// rebuild throws away the node's store and log, so that it is rebuilt from the start of the leader's. This happens
+// when the node applied writes that were never committed, e.g. as a leader cut off from the rest of the cluster.
+func (node *Node) rebuild(leaderId string) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	slog.Warn("replica has writes the leader doesn't, rebuilding it from the leader's log", "node", node.config.NodeId, "leader", leaderId, "seq", len(node.log))
+	node.store.Reset()
+	node.log = nil
+	node.notify()
+}
+
+// forward sends a write to the leader, returning once it is committed.
+func (node *Node) forward(ctx context.Context, leaderId string, entry Entry) (outcome, error) {
+	url, known := node.config.Peers[leaderId]
+	if !known {
+		return outcome{}, ErrNoLeader
+	}
+	ctx, cancel := context.WithTimeout(ctx, node.config.CommitTimeout+node.config.ElectionTimeout)
+	defer cancel()
+
+	var answer outcome
+	err := node.call(ctx, http.MethodPost, url+"/replication/forward", entry, &answer)
+	var status *statusError
+	switch {
+	case errors.As(err, &status):
+		return outcome{}, errorFrom(status.message)
+	case errors.Is(err, context.DeadlineExceeded):
+		return outcome{}, ErrNotCommitted
+	case err != nil:
+		slog.Warn("couldn't forward a write to the replication leader", "node", node.config.NodeId, "leader", leaderId, "error", err)
+		return outcome{}, ErrNoLeader
+	}
+	return answer, nil
+}
+
+// broadcast sends request to every peer at once, returning their answers. Peers that don't answer within a heartbeat
+// interval count as refusing.
+func (node *Node) broadcast(path string, request any) []ack {
+	ctx, cancel := context.WithTimeout(context.Background(), node.config.HeartbeatInterval)
+	defer cancel()
+
+	replies := make(chan ack, len(node.config.Peers))
+	for id, url := range node.config.Peers {
+		go func() {
+			var reply ack
+			if err := node.call(ctx, http.MethodPost, url+path, request, &reply); err != nil {
+				slog.Debug("replication peer didn't answer", "node", node.config.NodeId, "peer", id, "path", path, "error", err)
+			}
+			replies <- reply
+		}()
+	}
+	acks := make([]ack, 0, len(node.config.Peers))
+	for range node.config.Peers {
+		acks = append(acks, <-replies)
+	}
+	return acks
+}
+
+// call makes a request to another node, decoding its answer into reply.
+func (node *Node) call(ctx context.Context, method string, url string, request any, reply any) error {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(SecretHeader, node.config.Secret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := node.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var envelope responses.ErrorEnvelope
+		json.NewDecoder(resp.Body).Decode(&envelope)
+		return &statusError{status: resp.StatusCode, message: envelope.Error.Message}
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, value any) bool {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		responses.WriteError(w, http.StatusBadRequest, "invalid request body")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
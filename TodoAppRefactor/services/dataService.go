@@ -1,83 +1,542 @@
 package dataService
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
 	"sync"
+	"time"
+	"todoApp/auth"
 	"todoApp/data"
+	"todoApp/events"
+	"todoApp/logging"
+	"todoApp/metrics"
 	"todoApp/utils/stringUtils"
 )
 
+const DefaultListName = "My List"
+
+// Requests without an authenticated caller, such as those made internally, act as the anonymous owner.
+const anonymousOwner = 0
+
+var (
+	ErrEmptyName      = errors.New("name cannot be empty")
+	ErrItemNotFound   = errors.New("item at specified index does not exist")
+	ErrListNotFound   = errors.New("list does not exist")
+	ErrInvalidRole    = errors.New("role must be 'owner', 'editor' or 'viewer'")
+	ErrMemberNotFound = errors.New("user is not a member of the list")
+	ErrAlreadyMember  = errors.New("user is already a member of the list")
+	ErrListCreator    = errors.New("the creator of a list can't be removed or demoted")
+)
+
+// IDataService manages the todo lists the caller found in the context is a member of. A list ID of 0 refers to the
+// caller's default list. The data service only checks that the caller can see a list, what their role allows them to
+// do with it is checked by the api before a command is dispatched.
 type IDataService interface {
-	CreateTodoItem(name string) error
-	GetTodoItem(index int) (data.TodoItem, error)
-	GetAllTodoItems() []data.TodoItem
-	MarkItemAsComplete(index int) error
-	DeleteTodoItem(index int) error
+	CreateTodoItem(ctx context.Context, listId int, name string) error
+	GetTodoItem(ctx context.Context, listId int, index int) (data.TodoItem, error)
+	GetAllTodoItems(ctx context.Context, listId int) ([]data.TodoItem, error)
+	MarkItemAsComplete(ctx context.Context, listId int, index int) error
+	DeleteTodoItem(ctx context.Context, listId int, index int) error
+	CreateTodoList(ctx context.Context, name string) (data.TodoList, error)
+	GetTodoLists(ctx context.Context) []data.TodoList
+	GetDefaultListId(ctx context.Context) (int, error)
+	GetListRole(ctx context.Context, listId int) (data.Role, error)
+	GetListMembers(ctx context.Context, listId int) ([]data.Member, error)
+	AddListMember(ctx context.Context, listId int, userId int, role data.Role) error
+	UpdateListMember(ctx context.Context, listId int, userId int, role data.Role) error
+	RemoveListMember(ctx context.Context, listId int, userId int) error
+	Sync(ctx context.Context, listId int, batch data.SyncBatch) (data.SyncResult, error)
+	MoveTodoItem(ctx context.Context, listId int, itemId int, after int, before int) (int, error)
+}
+
+var storeWriteDuration = metrics.Default.NewHistogramVec("todoapp_store_write_duration_seconds",
+	"Time taken to write to the data store, including waiting for the lock, by operation.", metrics.DefaultBuckets, "operation")
+
+// IHealthChecker is implemented by services that can report whether their store is usable.
+type IHealthChecker interface {
+	CheckStore(ctx context.Context) error
+}
+
+type todoList struct {
+	data.TodoList
+	items   []data.TodoItem
+	members map[int]data.Role
+	// positions are the ordering keys of the items, which are kept sorted by them.
+	positions map[int]string
+	// states are what Sync needs to merge offline changes to the list's items, and the tombstones of deleted ones.
+	// prunedVersion is the version of the newest tombstone dropped.
+	states        map[int]*itemState
+	prunedVersion uint64
+	// changes is where what changed is recorded for the store. It is nil if the data service has no store.
+	changes *changeSet
 }
 
 type DataService struct {
-	data []data.TodoItem
-	mu   sync.RWMutex
+	lists        map[int]*todoList
+	defaultLists map[int]int
+	nextListId   int
+	nextItemId   int
+	// version numbers every change to an item, so clients that sync can be sent what changed since they last did.
+	// syncedSeqs is the Seq of the last mutation applied for each client.
+	version    uint64
+	syncedSeqs map[syncClient]uint64
+	// probe is written to the store and read back by CheckStore.
+	probe  uint64
+	events *events.Hub
+	tenant string
+	now    func() time.Time
+	// store, if there is one, is written what changed at the end of every change, and storeErr is the error writing
+	// to it last time if that failed. unpublished are the events of the change being made, published once it is
+	// written.
+	store       Store
+	changes     *changeSet
+	storeErr    error
+	unpublished []events.Event
+	mu          sync.RWMutex
 }
 
 func NewDataService() *DataService {
-	return &DataService{
-		data: data.DataStore,
+	return newDataService(data.DataStore)
+}
+
+// newDataService creates a data service where the anonymous owner's default list holds a copy of items.
+func newDataService(items []data.TodoItem) *DataService {
+	dataService := &DataService{
+		lists:        map[int]*todoList{},
+		defaultLists: map[int]int{},
+		nextListId:   1,
+		nextItemId:   1,
+		syncedSeqs:   map[syncClient]uint64{},
+		now:          time.Now,
+	}
+	list := dataService.addList(anonymousOwner, DefaultListName)
+	for _, item := range items {
+		item.Id = dataService.nextItemId
+		dataService.nextItemId++
+		list.items = append(list.items, item)
+		list.place(item.Id)
+	}
+	dataService.defaultLists[anonymousOwner] = list.Id
+	return dataService
+}
+
+// PublishTo makes the data service publish an event to hub, tagged with tenant, after every change it makes.
+func (dataService *DataService) PublishTo(hub *events.Hub, tenant string) *DataService {
+	dataService.events = hub
+	dataService.tenant = tenant
+	return dataService
+}
+
+// publish sends an event for a change once unlock has written it. The caller must hold the write lock, so events are
+// published in the order the changes were made.
+func (dataService *DataService) publish(ctx context.Context, list *todoList, event events.Event) {
+	if dataService.events == nil {
+		return
 	}
+	event.Tenant = dataService.tenant
+	event.ListId = list.Id
+	event.ActorId = ownerFrom(ctx)
+	event.Members = slices.Sorted(maps.Keys(list.members))
+	dataService.unpublished = append(dataService.unpublished, event)
 }
 
-func (dataService *DataService) CreateTodoItem(name string) error {
+type timeKey struct{}
+
+// WithTime makes the changes made with ctx happen at t rather than now. Replicas replaying changes use it so that
+// merges come out the same as they did where the change was first made.
+func WithTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, timeKey{}, t)
+}
+
+// timeOf returns when a change made with ctx happens.
+func (dataService *DataService) timeOf(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(timeKey{}).(time.Time); ok {
+		return t
+	}
+	return dataService.now()
+}
+
+func ownerFrom(ctx context.Context) int {
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		return principal.UserId
+	}
+	return anonymousOwner
+}
+
+func (dataService *DataService) CreateTodoItem(ctx context.Context, listId int, name string) (err error) {
 	if stringUtils.IsEmptyOrWhitespace(name) {
-		return errors.New("name cannot be empty")
+		return ErrEmptyName
 	} else {
+		defer observeStoreWrite("create", time.Now())
 		dataService.mu.Lock()
-		defer dataService.mu.Unlock()
+		defer dataService.unlock(&err)
+
+		list, err := dataService.findList(ownerFrom(ctx), listId, true)
+		if err != nil {
+			return err
+		}
 
-		todoItem := data.TodoItem{Name: name, Complete: false}
-		dataService.data = append(dataService.data, todoItem)
+		todoItem := data.TodoItem{Id: dataService.nextItemId, Name: name, Complete: false}
+		dataService.nextItemId++
+		list.items = append(list.items, todoItem)
+		list.place(todoItem.Id)
+		index := len(list.items) - 1
+		list.stamp(todoItem.Id, dataService.apiStamp(ctx), fieldName, fieldComplete)
+		logging.FromContext(ctx).Info("todo item created", "list", list.Id, "index", index, "item", todoItem.Id)
+		dataService.publish(ctx, list, events.Event{Type: events.ItemCreated, Item: &todoItem, Index: &index})
 		return nil
 	}
 }
 
-func (dataService *DataService) GetTodoItem(index int) (data.TodoItem, error) {
-	if !(index >= 0 && index < len(dataService.data)) {
-		return data.TodoItem{}, errors.New("item at specified index does not exist")
-	}
-
+func (dataService *DataService) GetTodoItem(ctx context.Context, listId int, index int) (data.TodoItem, error) {
 	dataService.mu.RLock()
 	defer dataService.mu.RUnlock()
 
-	todoItem := dataService.data[index]
+	list, err := dataService.findList(ownerFrom(ctx), listId, false)
+	if err != nil {
+		return data.TodoItem{}, err
+	}
+	if !(index >= 0 && index < len(list.items)) {
+		return data.TodoItem{}, ErrItemNotFound
+	}
+
+	todoItem := list.items[index]
 	return todoItem, nil
 }
 
-func (dataService *DataService) GetAllTodoItems() []data.TodoItem {
+func (dataService *DataService) GetAllTodoItems(ctx context.Context, listId int) ([]data.TodoItem, error) {
 	dataService.mu.RLock()
 	defer dataService.mu.RUnlock()
 
-	return dataService.data
+	list, err := dataService.findList(ownerFrom(ctx), listId, false)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(list.items), nil
 }
 
-func (dataService *DataService) MarkItemAsComplete(index int) error {
-	if !(index >= 0 && index < len(dataService.data)) {
-		return errors.New("item at specified index does not exist")
-	} else {
-		dataService.mu.Lock()
-		defer dataService.mu.Unlock()
+func (dataService *DataService) MarkItemAsComplete(ctx context.Context, listId int, index int) (err error) {
+	defer observeStoreWrite("markAsComplete", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
 
-		dataService.data[index].Complete = true
+	list, err := dataService.findList(ownerFrom(ctx), listId, false)
+	if err != nil {
+		return err
+	}
+	if !(index >= 0 && index < len(list.items)) {
+		return ErrItemNotFound
+	} else {
+		list.items[index].Complete = true
+		list.stamp(list.items[index].Id, dataService.apiStamp(ctx), fieldComplete)
+		logging.FromContext(ctx).Info("todo item marked as complete", "list", list.Id, "index", index)
+		item := list.items[index]
+		dataService.publish(ctx, list, events.Event{Type: events.ItemCompleted, Item: &item, Index: &index})
 		return nil
 	}
 }
 
-func (dataService *DataService) DeleteTodoItem(index int) error {
-	if !(index >= 0 && index < len(dataService.data)) {
-		return errors.New("item at specified index does not exist")
-	} else {
-		dataService.mu.Lock()
-		defer dataService.mu.Unlock()
+func (dataService *DataService) DeleteTodoItem(ctx context.Context, listId int, index int) (err error) {
+	defer observeStoreWrite("delete", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
 
-		dataService.data = append(dataService.data[:index], dataService.data[index+1:]...)
+	list, err := dataService.findList(ownerFrom(ctx), listId, false)
+	if err != nil {
+		return err
+	}
+	if !(index >= 0 && index < len(list.items)) {
+		return ErrItemNotFound
+	} else {
+		item := list.items[index]
+		list.items = append(list.items[:index], list.items[index+1:]...)
+		delete(list.positions, item.Id)
+		list.bury(item.Id, dataService.apiStamp(ctx))
+		logging.FromContext(ctx).Info("todo item deleted", "list", list.Id, "index", index)
+		dataService.publish(ctx, list, events.Event{Type: events.ItemDeleted, Item: &item, Index: &index})
 		return nil
 	}
 }
+
+func (dataService *DataService) CreateTodoList(ctx context.Context, name string) (_ data.TodoList, err error) {
+	if stringUtils.IsEmptyOrWhitespace(name) {
+		return data.TodoList{}, ErrEmptyName
+	}
+
+	defer observeStoreWrite("createList", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
+
+	owner := ownerFrom(ctx)
+	if _, exists := dataService.defaultLists[owner]; !exists {
+		dataService.defaultLists[owner] = dataService.addList(owner, DefaultListName).Id
+	}
+	list := dataService.addList(owner, name)
+	logging.FromContext(ctx).Info("todo list created", "list", list.Id)
+	created := list.TodoList
+	dataService.publish(ctx, list, events.Event{Type: events.ListCreated, List: &created})
+	return list.TodoList, nil
+}
+
+// GetTodoLists returns the lists the caller is a member of: their default list first, then the rest in the order
+// they were created. This takes the write lock as the default list is created the first time a caller asks for their
+// lists.
+func (dataService *DataService) GetTodoLists(ctx context.Context) []data.TodoList {
+	dataService.mu.Lock()
+	defer dataService.unlock(nil)
+
+	userId := ownerFrom(ctx)
+	defaultList, _ := dataService.findList(userId, 0, true)
+
+	lists := []data.TodoList{defaultList.TodoList}
+	for _, list := range dataService.lists {
+		if _, isMember := list.members[userId]; isMember && list.Id != defaultList.Id {
+			lists = append(lists, list.TodoList)
+		}
+	}
+	slices.SortFunc(lists[1:], func(a, b data.TodoList) int { return a.Id - b.Id })
+	return lists
+}
+
+// GetDefaultListId returns the ID of the caller's default list. Unlike GetTodoLists it doesn't create the list, and
+// returns ErrListNotFound if the caller doesn't have one yet.
+func (dataService *DataService) GetDefaultListId(ctx context.Context) (int, error) {
+	dataService.mu.RLock()
+	defer dataService.mu.RUnlock()
+
+	listId, exists := dataService.defaultLists[ownerFrom(ctx)]
+	if !exists {
+		return 0, ErrListNotFound
+	}
+	return listId, nil
+}
+
+// GetListRole returns the caller's role on a list.
+func (dataService *DataService) GetListRole(ctx context.Context, listId int) (data.Role, error) {
+	dataService.mu.RLock()
+	defer dataService.mu.RUnlock()
+
+	userId := ownerFrom(ctx)
+	list, err := dataService.findList(userId, listId, false)
+	if err != nil {
+		return "", err
+	}
+	return list.members[userId], nil
+}
+
+// GetListMembers returns the members of a list, owners first.
+func (dataService *DataService) GetListMembers(ctx context.Context, listId int) ([]data.Member, error) {
+	dataService.mu.RLock()
+	defer dataService.mu.RUnlock()
+
+	list, err := dataService.findList(ownerFrom(ctx), listId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]data.Member, 0, len(list.members))
+	for userId, role := range list.members {
+		members = append(members, data.Member{UserId: userId, Role: role})
+	}
+	slices.SortFunc(members, func(a, b data.Member) int {
+		if a.Role != b.Role {
+			return compareRoles(b.Role, a.Role)
+		}
+		return a.UserId - b.UserId
+	})
+	return members, nil
+}
+
+// AddListMember shares a list with another user.
+func (dataService *DataService) AddListMember(ctx context.Context, listId int, userId int, role data.Role) (err error) {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
+	defer observeStoreWrite("addMember", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
+
+	list, err := dataService.findList(ownerFrom(ctx), listId, true)
+	if err != nil {
+		return err
+	}
+	if _, isMember := list.members[userId]; isMember {
+		return ErrAlreadyMember
+	}
+
+	list.members[userId] = role
+	list.changedList()
+	logging.FromContext(ctx).Info("list member added", "list", list.Id, "user", userId, "role", role)
+	dataService.publish(ctx, list, events.Event{Type: events.MemberAdded, Member: &data.Member{UserId: userId, Role: role}})
+	return nil
+}
+
+// UpdateListMember changes the role of a member of a list. The list's creator always stays an owner.
+func (dataService *DataService) UpdateListMember(ctx context.Context, listId int, userId int, role data.Role) (err error) {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
+	defer observeStoreWrite("updateMember", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
+
+	list, err := dataService.findList(ownerFrom(ctx), listId, false)
+	if err != nil {
+		return err
+	}
+	if _, isMember := list.members[userId]; !isMember {
+		return ErrMemberNotFound
+	}
+	if userId == list.OwnerId && role != data.RoleOwner {
+		return ErrListCreator
+	}
+
+	list.members[userId] = role
+	list.changedList()
+	logging.FromContext(ctx).Info("list member updated", "list", list.Id, "user", userId, "role", role)
+	dataService.publish(ctx, list, events.Event{Type: events.MemberUpdated, Member: &data.Member{UserId: userId, Role: role}})
+	return nil
+}
+
+// RemoveListMember stops sharing a list with a user. The list's creator can't be removed.
+func (dataService *DataService) RemoveListMember(ctx context.Context, listId int, userId int) (err error) {
+	defer observeStoreWrite("removeMember", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
+
+	list, err := dataService.findList(ownerFrom(ctx), listId, false)
+	if err != nil {
+		return err
+	}
+	if _, isMember := list.members[userId]; !isMember {
+		return ErrMemberNotFound
+	}
+	if userId == list.OwnerId {
+		return ErrListCreator
+	}
+
+	role := list.members[userId]
+	delete(list.members, userId)
+	list.changedList()
+	logging.FromContext(ctx).Info("list member removed", "list", list.Id, "user", userId)
+	dataService.publish(ctx, list, events.Event{Type: events.MemberRemoved, Member: &data.Member{UserId: userId, Role: role}})
+	return nil
+}
+
+// findList returns the list with the given ID if userId is a member of it. Lists they aren't a member of are reported
+// as not existing. An ID of 0 refers to the user's default list. When they don't have one yet, it is created if
+// create is true, otherwise an empty list is returned. The caller must hold the lock, for writing if create is true.
+func (dataService *DataService) findList(userId int, listId int, create bool) (*todoList, error) {
+	if listId == 0 {
+		if id, exists := dataService.defaultLists[userId]; exists {
+			listId = id
+		} else if create {
+			list := dataService.addList(userId, DefaultListName)
+			dataService.defaultLists[userId] = list.Id
+			return list, nil
+		} else {
+			return newTodoList(data.TodoList{OwnerId: userId, Name: DefaultListName}), nil
+		}
+	}
+
+	list, exists := dataService.lists[listId]
+	if !exists {
+		return nil, ErrListNotFound
+	}
+	if _, isMember := list.members[userId]; !isMember {
+		return nil, ErrListNotFound
+	}
+	return list, nil
+}
+
+func (dataService *DataService) addList(owner int, name string) *todoList {
+	list := newTodoList(data.TodoList{Id: dataService.nextListId, Name: name, OwnerId: owner})
+	dataService.nextListId++
+	dataService.lists[list.Id] = list
+	list.changes = dataService.changes
+	list.changedList()
+	return list
+}
+
+// newTodoList creates a list whose only member is its creator, as an owner.
+func newTodoList(list data.TodoList) *todoList {
+	return &todoList{TodoList: list, members: map[int]data.Role{list.OwnerId: data.RoleOwner},
+		positions: map[int]string{}, states: map[int]*itemState{}}
+}
+
+func compareRoles(a, b data.Role) int {
+	switch {
+	case a == b:
+		return 0
+	case a.Allows(b):
+		return 1
+	default:
+		return -1
+	}
+}
+
+// CountItems returns the number of complete and incomplete items in the store, across every list.
+func (dataService *DataService) CountItems(ctx context.Context) (complete int, incomplete int) {
+	dataService.mu.RLock()
+	defer dataService.mu.RUnlock()
+
+	for _, list := range dataService.lists {
+		for _, item := range list.items {
+			if item.Complete {
+				complete++
+			} else {
+				incomplete++
+			}
+		}
+	}
+	return complete, incomplete
+}
+
+// CheckStore makes a round trip through the store, writing a probe under the write lock and reading it back under the
+// read lock, so that a stuck lock or a store that loses writes is reported. A data service kept in a Store also writes
+// the probe to it and reads it back. It gives up when ctx is done.
+func (dataService *DataService) CheckStore(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- dataService.probeStore() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// probeStore writes a new probe to the store and reads it back.
+func (dataService *DataService) probeStore() error {
+	dataService.mu.Lock()
+	dataService.probe++
+	written := dataService.probe
+	if dataService.store != nil {
+		read, err := dataService.store.Probe(written)
+		if err != nil {
+			dataService.mu.Unlock()
+			return fmt.Errorf("the probe couldn't be written to the store: %w", err)
+		} else if read != written {
+			dataService.mu.Unlock()
+			return fmt.Errorf("the store read back probe %d instead of %d", read, written)
+		}
+	}
+	dataService.mu.Unlock()
+
+	dataService.mu.RLock()
+	defer dataService.mu.RUnlock()
+	if dataService.probe < written {
+		return errors.New("the probe written to the store could not be read back")
+	}
+	return dataService.storeErr
+}
+
+func observeStoreWrite(operation string, start time.Time) {
+	storeWriteDuration.With(operation).Observe(time.Since(start).Seconds())
+}
@@ -1,36 +1,36 @@
 package dataService
 
 import (
+	"context"
 	"testing"
+	"time"
+	"todoApp/auth"
 	"todoApp/data"
+	"todoApp/events"
 	sliceUtils "todoApp/utils/sliceUtils"
 )
 
 func CreateTestData(testDataType int) *DataService {
 	switch testDataType {
 	case 1:
-		return &DataService{
-			data: []data.TodoItem{
-				{Name: "TodoItem1", Complete: false},
-				{Name: "TodoItem2", Complete: false},
-				{Name: "TodoItem3", Complete: false},
-			},
-		}
+		return newDataService([]data.TodoItem{
+			{Name: "TodoItem1", Complete: false},
+			{Name: "TodoItem2", Complete: false},
+			{Name: "TodoItem3", Complete: false},
+		})
 	default:
-		return &DataService{
-			data: []data.TodoItem{},
-		}
+		return newDataService([]data.TodoItem{})
 	}
 }
 
 func TestCreateTodoItem(t *testing.T) {
 	inputName := "Test"
-	expectedItem := data.TodoItem{Name: "Test", Complete: false}
+	expectedItem := data.TodoItem{Id: 4, Name: "Test", Complete: false}
 	dataService := CreateTestData(1)
 
-	if err := dataService.CreateTodoItem(inputName); err != nil {
+	if err := dataService.CreateTodoItem(context.Background(), 0, inputName); err != nil {
 		t.Errorf("An unexpected error occured whilst creating the todo item: %s", err.Error())
-	} else if item, getErr := dataService.GetTodoItem(3); getErr != nil {
+	} else if item, getErr := dataService.GetTodoItem(context.Background(), 0, 3); getErr != nil {
 		t.Errorf("An unexpected error occured whilst checking the newly created item exists: %s", getErr.Error())
 	} else if item != expectedItem {
 		t.Errorf("Todo item was not created correctly. Got %v, Expected %v", item, expectedItem)
@@ -50,7 +50,7 @@ func TestCreateTodoItem_EmptyName(t *testing.T) {
 
 	for _, test := range testcases {
 		t.Run(test.testName, func(t *testing.T) {
-			if err := dataService.CreateTodoItem(test.inputName); err == nil {
+			if err := dataService.CreateTodoItem(context.Background(), 0, test.inputName); err == nil {
 				t.Error("An invalid name was entered, an error was expected but not recieved")
 			} else if err.Error() != expectedError {
 				t.Errorf("An invalid name was entered, an error occured but not the expected one. Got: %s, Expected: %s", err.Error(), expectedError)
@@ -65,16 +65,15 @@ func TestGetTodoItem_ValidIndex(t *testing.T) {
 		inputIndex   int
 		expectedItem data.TodoItem
 	}{
-		{"Testing with index on lower bound", 0, data.TodoItem{Name: "TodoItem1", Complete: false}},
-		{"Testing with index on higher bound", 2, data.TodoItem{Name: "TodoItem3", Complete: false}},
+		{"Testing with index on lower bound", 0, data.TodoItem{Id: 1, Name: "TodoItem1", Complete: false}},
+		{"Testing with index on higher bound", 2, data.TodoItem{Id: 3, Name: "TodoItem3", Complete: false}},
 	}
 	dataService := CreateTestData(1)
 
 	for _, test := range testCases {
 		t.Run(test.testName, func(t *testing.T) {
-			if item, err := dataService.GetTodoItem(test.inputIndex); err != nil {
+			if item, err := dataService.GetTodoItem(context.Background(), 0, test.inputIndex); err != nil {
 				t.Errorf("An unexpected error occured: %s", err.Error())
-				t.Errorf("Data Size: %d", len(dataService.data))
 			} else if item != test.expectedItem {
 				t.Errorf("The incorrect item was returned. Got: %v, Expected: %v", item, test.expectedItem)
 			}
@@ -95,7 +94,7 @@ func TestGetTodoItem_InvalidIndex(t *testing.T) {
 
 	for _, test := range testCases {
 		t.Run(test.testName, func(t *testing.T) {
-			if _, err := dataService.GetTodoItem(test.inputIndex); err == nil {
+			if _, err := dataService.GetTodoItem(context.Background(), 0, test.inputIndex); err == nil {
 				t.Error("Index is out of range so an error was expected but not recieved")
 			} else if err.Error() != test.expectedError {
 				t.Errorf("An occured but not the expected error. Got: %s, Expected: %s", err.Error(), test.expectedError)
@@ -105,21 +104,23 @@ func TestGetTodoItem_InvalidIndex(t *testing.T) {
 }
 
 func TestGetAllTodoItems(t *testing.T) {
-	expectedItems := CreateTestData(1).data
+	expectedItems, _ := CreateTestData(1).GetAllTodoItems(context.Background(), 0)
 	dataService := CreateTestData(1)
 
-	items := dataService.GetAllTodoItems()
-	if !sliceUtils.TodoItemsEqual(items, expectedItems) {
+	if items, err := dataService.GetAllTodoItems(context.Background(), 0); err != nil {
+		t.Errorf("An unexpected error occured: %s", err.Error())
+	} else if !sliceUtils.TodoItemsEqual(items, expectedItems) {
 		t.Errorf("The returned list of items does not match the expected list of items. Got: %v, Expected %v", items, expectedItems)
 	}
 }
 
 func TestGetAllTodoItems_EmptyList(t *testing.T) {
-	expectedItems := CreateTestData(0).data
+	expectedItems, _ := CreateTestData(0).GetAllTodoItems(context.Background(), 0)
 	dataService := CreateTestData(0)
 
-	items := dataService.GetAllTodoItems()
-	if !sliceUtils.TodoItemsEqual(items, expectedItems) {
+	if items, err := dataService.GetAllTodoItems(context.Background(), 0); err != nil {
+		t.Errorf("An unexpected error occured: %s", err.Error())
+	} else if !sliceUtils.TodoItemsEqual(items, expectedItems) {
 		t.Errorf("The returned list of items does not match the expected list of items. Got: %v, Expected %v", items, expectedItems)
 	}
 }
@@ -128,9 +129,9 @@ func TestMarkItemAsComplete(t *testing.T) {
 	inputIndex := 0
 	dataService := CreateTestData(1)
 
-	if err := dataService.MarkItemAsComplete(inputIndex); err != nil {
+	if err := dataService.MarkItemAsComplete(context.Background(), 0, inputIndex); err != nil {
 		t.Errorf("An unexpected error occured: %s", err.Error())
-	} else if updatedItem, err := dataService.GetTodoItem(inputIndex); err != nil {
+	} else if updatedItem, err := dataService.GetTodoItem(context.Background(), 0, inputIndex); err != nil {
 		t.Errorf("An unexpected error occured whilst trying to obtain the updated item: %s", err.Error())
 	} else if updatedItem.Complete != true {
 		t.Error("The item was not correctly marked as complete. Still marked as incomplete in the data")
@@ -142,7 +143,7 @@ func TestMarkItemAsComplete_InvalidIndex(t *testing.T) {
 	expectedError := "item at specified index does not exist"
 	dataService := CreateTestData(1)
 
-	if err := dataService.MarkItemAsComplete(inputIndex); err == nil {
+	if err := dataService.MarkItemAsComplete(context.Background(), 0, inputIndex); err == nil {
 		t.Error("The specified index is invalid but an error was not produced")
 	} else if err.Error() != expectedError {
 		t.Errorf("The specified index is invalid but the error produced is unexpected. Got: %s, Expected: %s", err.Error(), expectedError)
@@ -152,14 +153,14 @@ func TestMarkItemAsComplete_InvalidIndex(t *testing.T) {
 func TestDeleteTodoItem_ValidIndex(t *testing.T) {
 	inputIndex := 1
 	expectedItems := []data.TodoItem{
-		{Name: "TodoItem1", Complete: false},
-		{Name: "TodoItem3", Complete: false},
+		{Id: 1, Name: "TodoItem1", Complete: false},
+		{Id: 3, Name: "TodoItem3", Complete: false},
 	}
 	dataService := CreateTestData(1)
 
-	if err := dataService.DeleteTodoItem(inputIndex); err != nil {
+	if err := dataService.DeleteTodoItem(context.Background(), 0, inputIndex); err != nil {
 		t.Errorf("An unexpected error occured: %s", err.Error())
-	} else if items := dataService.GetAllTodoItems(); !sliceUtils.TodoItemsEqual(items, expectedItems) {
+	} else if items, _ := dataService.GetAllTodoItems(context.Background(), 0); !sliceUtils.TodoItemsEqual(items, expectedItems) {
 		t.Errorf("The data does match what is expected after deleting the specified item. Got: %v, Expected: %v", items, expectedItems)
 	}
 }
@@ -177,7 +178,7 @@ func TestDeleteTodoItem_InvalidIndex(t *testing.T) {
 
 	for _, test := range testCases {
 		t.Run(test.testName, func(t *testing.T) {
-			if err := dataService.DeleteTodoItem(test.inputIndex); err == nil {
+			if err := dataService.DeleteTodoItem(context.Background(), 0, test.inputIndex); err == nil {
 				t.Error("Index is out of range so an error was expected but not recieved")
 			} else if err.Error() != test.expectedError {
 				t.Errorf("An occured but not the expected error. Got: %s, Expected: %s", err.Error(), test.expectedError)
@@ -185,3 +186,173 @@ func TestDeleteTodoItem_InvalidIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestCountItems(t *testing.T) {
+	dataService := CreateTestData(1)
+	dataService.MarkItemAsComplete(context.Background(), 0, 1)
+
+	if complete, incomplete := dataService.CountItems(context.Background()); complete != 1 || incomplete != 2 {
+		t.Errorf("Unexpected item counts. Got: %d complete, %d incomplete, Expected: 1 complete, 2 incomplete", complete, incomplete)
+	}
+}
+
+func TestCheckStore(t *testing.T) {
+	dataService := CreateTestData(1)
+
+	if err := dataService.CheckStore(context.Background()); err != nil {
+		t.Errorf("Unexpected error checking the store: %s", err.Error())
+	}
+}
+
+func TestCheckStore_StuckLock(t *testing.T) {
+	dataService := CreateTestData(1)
+	dataService.mu.Lock()
+	defer dataService.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := dataService.CheckStore(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error checking a store whose lock is held. Got: %v, Expected: %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestUserIsolation(t *testing.T) {
+	dataService := CreateTestData(1)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2, Username: "bob"})
+
+	if items, err := dataService.GetAllTodoItems(alice, 0); err != nil {
+		t.Errorf("An unexpected error occured: %s", err.Error())
+	} else if len(items) != 0 {
+		t.Errorf("A new user should start with an empty default list. Got: %v", items)
+	}
+
+	if err := dataService.CreateTodoItem(alice, 0, "Alice's item"); err != nil {
+		t.Fatalf("An unexpected error occured whilst creating the todo item: %s", err.Error())
+	}
+
+	if items, _ := dataService.GetAllTodoItems(bob, 0); len(items) != 0 {
+		t.Errorf("Bob can see items from another user's list. Got: %v", items)
+	}
+	if err := dataService.DeleteTodoItem(bob, 0, 0); err != ErrItemNotFound {
+		t.Errorf("Bob should not be able to delete another user's item. Got: %v, Expected: %v", err, ErrItemNotFound)
+	}
+	if items, _ := dataService.GetAllTodoItems(alice, 0); len(items) != 1 || items[0].Name != "Alice's item" {
+		t.Errorf("Alice's list was changed by another user. Got: %v", items)
+	}
+}
+
+func TestCreateTodoList(t *testing.T) {
+	dataService := CreateTestData(0)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2, Username: "bob"})
+
+	list, err := dataService.CreateTodoList(alice, "Groceries")
+	if err != nil {
+		t.Fatalf("An unexpected error occured whilst creating the list: %s", err.Error())
+	}
+
+	if lists := dataService.GetTodoLists(alice); len(lists) != 2 || lists[0].Name != DefaultListName || lists[1] != list {
+		t.Errorf("Alice's lists are not as expected. Got: %v", lists)
+	}
+	if err := dataService.CreateTodoItem(alice, list.Id, "Milk"); err != nil {
+		t.Errorf("An unexpected error occured whilst adding to the new list: %s", err.Error())
+	}
+	if _, err := dataService.GetAllTodoItems(bob, list.Id); err != ErrListNotFound {
+		t.Errorf("Bob should not be able to see Alice's list. Got: %v, Expected: %v", err, ErrListNotFound)
+	}
+	if lists := dataService.GetTodoLists(bob); len(lists) != 1 {
+		t.Errorf("Bob should only have his default list. Got: %v", lists)
+	}
+}
+
+func TestCreateTodoList_EmptyName(t *testing.T) {
+	dataService := CreateTestData(0)
+
+	if _, err := dataService.CreateTodoList(context.Background(), "  "); err != ErrEmptyName {
+		t.Errorf("An invalid name was entered, the expected error was not returned. Got: %v, Expected: %v", err, ErrEmptyName)
+	}
+}
+
+func TestListSharing(t *testing.T) {
+	dataService := CreateTestData(1)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2, Username: "bob"})
+	carol := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 3, Username: "carol"})
+
+	list, _ := dataService.CreateTodoList(alice, "Groceries")
+	dataService.CreateTodoItem(alice, list.Id, "Milk")
+	if err := dataService.AddListMember(alice, list.Id, 2, data.RoleViewer); err != nil {
+		t.Fatalf("An unexpected error occured whilst sharing the list: %s", err.Error())
+	}
+
+	if lists := dataService.GetTodoLists(bob); len(lists) != 2 || lists[1].Id != list.Id {
+		t.Errorf("Shared list is missing from Bob's lists. Got: %v", lists)
+	}
+	if items, err := dataService.GetAllTodoItems(bob, list.Id); err != nil || len(items) != 1 {
+		t.Errorf("Bob can't read the shared list. Got: %v, %v", items, err)
+	}
+	if role, _ := dataService.GetListRole(bob, list.Id); role != data.RoleViewer {
+		t.Errorf("Unexpected role. Got: %v, Expected: %v", role, data.RoleViewer)
+	}
+	if _, err := dataService.GetAllTodoItems(carol, list.Id); err != ErrListNotFound {
+		t.Errorf("Carol can see a list that wasn't shared with her. Got: %v", err)
+	}
+
+	if err := dataService.AddListMember(alice, list.Id, 2, data.RoleEditor); err != ErrAlreadyMember {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrAlreadyMember)
+	}
+	if err := dataService.UpdateListMember(alice, list.Id, 2, data.RoleEditor); err != nil {
+		t.Errorf("An unexpected error occured whilst changing the role: %s", err.Error())
+	}
+	if err := dataService.UpdateListMember(alice, list.Id, 1, data.RoleViewer); err != ErrListCreator {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrListCreator)
+	}
+	if err := dataService.RemoveListMember(bob, list.Id, 1); err != ErrListCreator {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrListCreator)
+	}
+	if members, _ := dataService.GetListMembers(alice, list.Id); len(members) != 2 || members[0].UserId != 1 || members[1].Role != data.RoleEditor {
+		t.Errorf("Unexpected members. Got: %v", members)
+	}
+
+	if err := dataService.RemoveListMember(alice, list.Id, 2); err != nil {
+		t.Errorf("An unexpected error occured whilst removing the member: %s", err.Error())
+	}
+	if _, err := dataService.GetAllTodoItems(bob, list.Id); err != ErrListNotFound {
+		t.Errorf("Bob can still see the list after being removed. Got: %v", err)
+	}
+}
+
+func TestPublishesChanges(t *testing.T) {
+	hub := events.NewHub(events.DefaultBufferSize)
+	dataService := CreateTestData(0).PublishTo(hub, "acme")
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+
+	list, _ := dataService.CreateTodoList(alice, "Groceries")
+	dataService.CreateTodoItem(alice, list.Id, "Milk")
+	dataService.MarkItemAsComplete(alice, list.Id, 0)
+	dataService.AddListMember(alice, list.Id, 2, data.RoleViewer)
+	dataService.RemoveListMember(alice, list.Id, 2)
+	dataService.DeleteTodoItem(alice, list.Id, 0)
+	dataService.CreateTodoItem(alice, list.Id, " ")
+
+	expected := []events.Type{events.ListCreated, events.ItemCreated, events.ItemCompleted, events.MemberAdded, events.MemberRemoved, events.ItemDeleted}
+	published, _ := hub.Since(0, events.All)
+	if len(published) != len(expected) {
+		t.Fatalf("Unexpected events. Got: %v, Expected types: %v", published, expected)
+	}
+	for i, event := range published {
+		if event.Type != expected[i] || event.Tenant != "acme" || event.ListId != list.Id || event.ActorId != 1 {
+			t.Errorf("Unexpected event. Got: %v, Expected type: %v", event, expected[i])
+		}
+	}
+	if members := published[3].Members; len(members) != 2 || members[1] != 2 {
+		t.Errorf("The new member should be told they were added. Got: %v", members)
+	}
+	if members := published[4].Members; len(members) != 1 || published[4].Member.UserId != 2 {
+		t.Errorf("Unexpected members after the removal. Got: %v, %v", members, published[4].Member)
+	}
+	if item := published[5].Item; item == nil || item.Name != "Milk" || *published[5].Index != 0 {
+		t.Errorf("The deleted item should be in its event. Got: %v", published[5])
+	}
+}
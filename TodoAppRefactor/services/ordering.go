@@ -0,0 +1,102 @@
+package dataService
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+	"todoApp/events"
+	"todoApp/logging"
+	"todoApp/ordering"
+)
+
+// maxPositionLength is how long an ordering key can get before the list's keys are spread out again. Keys only grow
+// when many items are moved into the same gap, or after thousands of items have been added.
+const maxPositionLength = 64
+
+var (
+	ErrItemIdNotFound = errors.New("item with specified id does not exist")
+	ErrAnchorNotFound = errors.New("the item to move next to does not exist")
+	ErrInvalidMove    = errors.New("an item must be moved after or before another item")
+)
+
+// MoveTodoItem moves the item with the ID itemId to just after the item with the ID after, or just before the item with
+// the ID before, and returns its new index. Items are named by ID rather than index, so a move made by a client that
+// hasn't seen the latest changes still lands next to the item it meant. If both are given the item goes after after,
+// unless that has been deleted. Every item has an ordering key between those of its neighbours, so a move only changes
+// the key of the item moved and concurrent moves of different items all take effect.
+func (dataService *DataService) MoveTodoItem(ctx context.Context, listId int, itemId int, after int, before int) (_ int, err error) {
+	if after == 0 && before == 0 || after == itemId || before == itemId {
+		return 0, ErrInvalidMove
+	}
+
+	defer observeStoreWrite("move", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
+
+	list, err := dataService.findList(ownerFrom(ctx), listId, false)
+	if err != nil {
+		return 0, err
+	}
+	from := list.indexOf(itemId)
+	if from < 0 {
+		return 0, ErrItemIdNotFound
+	}
+	item := list.items[from]
+	list.items = slices.Delete(list.items, from, from+1)
+
+	to := -1
+	if index := list.indexOf(after); after != 0 && index >= 0 {
+		to = index + 1
+	} else if index := list.indexOf(before); before != 0 && index >= 0 {
+		to = index
+	}
+	if to < 0 {
+		list.items = slices.Insert(list.items, from, item)
+		return 0, ErrAnchorNotFound
+	}
+
+	var low, high string
+	if to > 0 {
+		low = list.positions[list.items[to-1].Id]
+	}
+	if to < len(list.items) {
+		high = list.positions[list.items[to].Id]
+	}
+	position, err := ordering.KeyBetween(low, high)
+	if err != nil {
+		list.items = slices.Insert(list.items, from, item)
+		return 0, err
+	}
+	list.items = slices.Insert(list.items, to, item)
+	list.positions[item.Id] = position
+	if len(position) > maxPositionLength {
+		list.rebalance()
+	}
+
+	list.stamp(item.Id, dataService.apiStamp(ctx), fieldPosition)
+	logging.FromContext(ctx).Info("todo item moved", "list", list.Id, "item", item.Id, "from", from, "to", to)
+	dataService.publish(ctx, list, events.Event{Type: events.ItemMoved, Item: &item, Index: &to})
+	return to, nil
+}
+
+// place gives the last item of the list a key after the one before it.
+func (list *todoList) place(id int) {
+	var last string
+	if len(list.items) > 1 {
+		last = list.positions[list.items[len(list.items)-2].Id]
+	}
+	position, _ := ordering.KeyBetween(last, "")
+	list.positions[id] = position
+	if len(position) > maxPositionLength {
+		list.rebalance()
+	}
+}
+
+// rebalance gives every item a new, short key, keeping their order.
+func (list *todoList) rebalance() {
+	for index, position := range ordering.Spread(len(list.items)) {
+		list.positions[list.items[index].Id] = position
+		list.changedItems(list.items[index].Id)
+	}
+}
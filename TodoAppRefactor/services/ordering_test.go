@@ -0,0 +1,142 @@
+package dataService
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/events"
+)
+
+func itemNames(t *testing.T, dataService *DataService, ctx context.Context) []string {
+	items, err := dataService.GetAllTodoItems(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func TestMoveTodoItem(t *testing.T) {
+	ctx := context.Background()
+	testCases := []struct {
+		testName      string
+		itemId        int
+		after         int
+		before        int
+		expectedIndex int
+		expectedNames []string
+	}{
+		{"Testing moving an item after another", 1, 3, 0, 2, []string{"TodoItem2", "TodoItem3", "TodoItem1"}},
+		{"Testing moving an item before another", 3, 0, 1, 0, []string{"TodoItem3", "TodoItem1", "TodoItem2"}},
+		{"Testing moving an item between two others", 3, 1, 2, 1, []string{"TodoItem1", "TodoItem3", "TodoItem2"}},
+		{"Testing anchors that aren't next to each other", 2, 3, 1, 2, []string{"TodoItem1", "TodoItem3", "TodoItem2"}},
+		{"Testing moving an item to where it is", 2, 1, 0, 1, []string{"TodoItem1", "TodoItem2", "TodoItem3"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			dataService := CreateTestData(1)
+			index, err := dataService.MoveTodoItem(ctx, 0, test.itemId, test.after, test.before)
+			if err != nil || index != test.expectedIndex {
+				t.Errorf("Unexpected index. Got: %v, %v, Expected: %v", index, err, test.expectedIndex)
+			}
+			if names := itemNames(t, dataService, ctx); !slices.Equal(names, test.expectedNames) {
+				t.Errorf("Unexpected order. Got: %v, Expected: %v", names, test.expectedNames)
+			}
+		})
+	}
+}
+
+func TestMoveTodoItem_Errors(t *testing.T) {
+	ctx := context.Background()
+	dataService := CreateTestData(1)
+	testCases := []struct {
+		testName      string
+		itemId        int
+		after         int
+		before        int
+		expectedError error
+	}{
+		{"Testing an item that doesn't exist", 9, 1, 0, ErrItemIdNotFound},
+		{"Testing an anchor that doesn't exist", 1, 9, 0, ErrAnchorNotFound},
+		{"Testing without an anchor", 1, 0, 0, ErrInvalidMove},
+		{"Testing moving an item next to itself", 1, 0, 1, ErrInvalidMove},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if _, err := dataService.MoveTodoItem(ctx, 0, test.itemId, test.after, test.before); err != test.expectedError {
+				t.Errorf("Unexpected error. Got: %v, Expected: %v", err, test.expectedError)
+			}
+			if names := itemNames(t, dataService, ctx); !slices.Equal(names, []string{"TodoItem1", "TodoItem2", "TodoItem3"}) {
+				t.Errorf("A failed move changed the order. Got: %v", names)
+			}
+		})
+	}
+}
+
+// TestMoveTodoItem_StaleAnchors is a move made by a client that hasn't seen the item it was dropped after deleted.
+func TestMoveTodoItem_StaleAnchors(t *testing.T) {
+	ctx := context.Background()
+	dataService := CreateTestData(1)
+	dataService.CreateTodoItem(ctx, 0, "TodoItem4")
+
+	// Item 4 was dropped between items 2 and 3, so it goes before item 3
+	dataService.DeleteTodoItem(ctx, 0, 1)
+	if _, err := dataService.MoveTodoItem(ctx, 0, 4, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"TodoItem1", "TodoItem4", "TodoItem3"}
+	if names := itemNames(t, dataService, ctx); !slices.Equal(names, expected) {
+		t.Errorf("Unexpected order. Got: %v, Expected: %v", names, expected)
+	}
+
+	// New items still go at the end
+	dataService.CreateTodoItem(ctx, 0, "TodoItem5")
+	expected = append(expected, "TodoItem5")
+	if names := itemNames(t, dataService, ctx); !slices.Equal(names, expected) {
+		t.Errorf("Unexpected order. Got: %v, Expected: %v", names, expected)
+	}
+}
+
+func TestMoveTodoItem_Rebalances(t *testing.T) {
+	ctx := context.Background()
+	dataService := CreateTestData(1)
+
+	// Moving items back and forth into the same gap makes the keys longer each time
+	for i := range 500 {
+		itemId := 2 + i%2
+		dataService.MoveTodoItem(ctx, 0, itemId, 1, 5-itemId)
+	}
+	list, _ := dataService.findList(anonymousOwner, 0, false)
+	for i, item := range list.items {
+		if position := list.positions[item.Id]; len(position) > maxPositionLength || i > 0 && position <= list.positions[list.items[i-1].Id] {
+			t.Errorf("Unexpected position for %v. Got: %q", item, position)
+		}
+	}
+}
+
+func TestMoveTodoItem_PublishesAndSyncs(t *testing.T) {
+	dataService, list, _ := syncTestData(t)
+	hub := events.NewHub(10)
+	dataService.PublishTo(hub, "default")
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+	first, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone"})
+
+	if _, err := dataService.MoveTodoItem(alice, list.Id, first.Items[2].Id, 0, first.Items[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if moved, _ := hub.Since(0, events.All); len(moved) != 1 || moved[0].Type != events.ItemMoved || *moved[0].Index != 0 || moved[0].Item.Name != "Bread" {
+		t.Errorf("Unexpected events. Got: %+v", moved)
+	}
+	result, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone", Since: first.Token})
+	if len(result.Items) != 1 || result.Items[0].Name != "Bread" || result.Items[0].Index != 0 {
+		t.Errorf("A syncing client should be sent the item's new index. Got: %+v", result.Items)
+	}
+}
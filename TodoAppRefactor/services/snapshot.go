@@ -0,0 +1,281 @@
+package dataService
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+	"todoApp/data"
+	"todoApp/events"
+	"todoApp/logging"
+	"todoApp/ordering"
+)
+
+var ErrInvalidSnapshot = errors.New("snapshot is invalid")
+
+// Snapshot copies everything the data service stores.
+func (dataService *DataService) Snapshot() data.Snapshot {
+	dataService.mu.RLock()
+	defer dataService.mu.RUnlock()
+
+	return dataService.snapshot()
+}
+
+// Snapshots copies everything several data services store at the same moment: none of them changes until all of
+// them have been copied.
+func Snapshots(services map[string]*DataService) map[string]data.Snapshot {
+	ids := slices.Sorted(maps.Keys(services))
+	for _, id := range ids {
+		services[id].mu.RLock()
+		defer services[id].mu.RUnlock()
+	}
+
+	snapshots := make(map[string]data.Snapshot, len(services))
+	for _, id := range ids {
+		snapshots[id] = services[id].snapshot()
+	}
+	return snapshots
+}
+
+// snapshot copies the data service. The caller must hold the lock.
+func (dataService *DataService) snapshot() data.Snapshot {
+	snapshot := data.Snapshot{
+		Lists:        make([]data.ListSnapshot, 0, len(dataService.lists)),
+		DefaultLists: maps.Clone(dataService.defaultLists),
+		NextListId:   dataService.nextListId,
+		NextItemId:   dataService.nextItemId,
+		Version:      dataService.version,
+	}
+	for _, id := range slices.Sorted(maps.Keys(dataService.lists)) {
+		list := dataService.lists[id]
+		saved := data.ListSnapshot{
+			TodoList:      list.TodoList,
+			Items:         slices.Clone(list.items),
+			Members:       maps.Clone(list.members),
+			Positions:     maps.Clone(list.positions),
+			States:        make(map[int]data.ItemState, len(list.states)),
+			PrunedVersion: list.prunedVersion,
+		}
+		for itemId, state := range list.states {
+			saved.States[itemId] = state.export()
+		}
+		snapshot.Lists = append(snapshot.Lists, saved)
+	}
+	for client, seq := range dataService.syncedSeqs {
+		snapshot.SyncedSeqs = append(snapshot.SyncedSeqs, data.SyncedSeq{UserId: client.userId, Client: client.client, ListId: client.listId, Seq: seq})
+	}
+	slices.SortFunc(snapshot.SyncedSeqs, func(a, b data.SyncedSeq) int {
+		return cmp.Or(cmp.Compare(a.UserId, b.UserId), cmp.Compare(a.ListId, b.ListId), cmp.Compare(a.Client, b.Client))
+	})
+	return snapshot
+}
+
+func (state *itemState) export() data.ItemState {
+	exported := data.ItemState{Version: state.version, Fields: make(map[string]data.FieldStamp, len(state.fields)), Deleted: state.deleted, Origin: state.origin}
+	for field, s := range state.fields {
+		exported.Fields[field] = data.FieldStamp{Version: s.version, At: s.at, Client: s.client}
+	}
+	return exported
+}
+
+func importState(exported data.ItemState) *itemState {
+	state := &itemState{version: exported.Version, fields: make(map[string]stamp, len(exported.Fields)), deleted: exported.Deleted, origin: exported.Origin}
+	for field, s := range exported.Fields {
+		state.fields[field] = stamp{version: s.Version, at: s.At, client: s.Client}
+	}
+	return state
+}
+
+// ValidateSnapshot checks that a snapshot is one a data service could have taken: IDs are unique and below the next
+// ones to be given out, every list has its owner as a member, and every item has an ordering key, in order.
+func ValidateSnapshot(snapshot data.Snapshot) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSnapshot, fmt.Sprintf(format, args...))
+	}
+
+	lists := map[int]data.ListSnapshot{}
+	items := map[int]bool{}
+	for _, list := range snapshot.Lists {
+		if list.Id <= 0 || list.Id >= snapshot.NextListId {
+			return invalid("list %d has an ID outside of 1-%d", list.Id, snapshot.NextListId-1)
+		}
+		if _, exists := lists[list.Id]; exists {
+			return invalid("list %d appears twice", list.Id)
+		}
+		lists[list.Id] = list
+		if list.Members[list.OwnerId] != data.RoleOwner {
+			return invalid("list %d isn't owned by its creator", list.Id)
+		}
+		for userId, role := range list.Members {
+			if !role.Valid() {
+				return invalid("list %d gives user %d the unknown role %q", list.Id, userId, role)
+			}
+		}
+
+		previous := ""
+		for _, item := range list.Items {
+			if item.Id <= 0 || item.Id >= snapshot.NextItemId {
+				return invalid("item %d has an ID outside of 1-%d", item.Id, snapshot.NextItemId-1)
+			}
+			if items[item.Id] {
+				return invalid("item %d appears twice", item.Id)
+			}
+			items[item.Id] = true
+			position := list.Positions[item.Id]
+			if !ordering.Valid(position) || position <= previous {
+				return invalid("item %d in list %d has an ordering key out of order", item.Id, list.Id)
+			}
+			previous = position
+		}
+		for itemId, state := range list.States {
+			if state.Version > snapshot.Version {
+				return invalid("item %d was changed after the snapshot's version", itemId)
+			}
+		}
+	}
+	for userId, listId := range snapshot.DefaultLists {
+		if list, exists := lists[listId]; !exists || list.OwnerId != userId {
+			return invalid("user %d's default list %d doesn't exist", userId, listId)
+		}
+	}
+	return nil
+}
+
+// Restore replaces everything the data service stores with a snapshot. Clients following the events of the lists it
+// stored aren't told, and are best reconnected.
+func (dataService *DataService) Restore(snapshot data.Snapshot) (err error) {
+	if err := ValidateSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
+
+	dataService.restore(snapshot)
+	dataService.changedAll()
+	return nil
+}
+
+// restore replaces everything the data service stores with a snapshot that has been validated. The caller must hold
+// the write lock.
+func (dataService *DataService) restore(snapshot data.Snapshot) {
+	dataService.lists = make(map[int]*todoList, len(snapshot.Lists))
+	for _, saved := range snapshot.Lists {
+		list := newTodoList(saved.TodoList)
+		list.items = slices.Clone(saved.Items)
+		list.members = maps.Clone(saved.Members)
+		list.positions = maps.Clone(saved.Positions)
+		list.prunedVersion = saved.PrunedVersion
+		for itemId, state := range saved.States {
+			list.states[itemId] = importState(state)
+		}
+		list.changes = dataService.changes
+		dataService.lists[list.Id] = list
+	}
+	dataService.defaultLists = maps.Clone(snapshot.DefaultLists)
+	if dataService.defaultLists == nil {
+		dataService.defaultLists = map[int]int{}
+	}
+	dataService.nextListId = snapshot.NextListId
+	dataService.nextItemId = snapshot.NextItemId
+	dataService.version = snapshot.Version
+	dataService.syncedSeqs = map[syncClient]uint64{}
+	for _, synced := range snapshot.SyncedSeqs {
+		dataService.syncedSeqs[syncClient{userId: synced.UserId, client: synced.Client, listId: synced.ListId}] = synced.Seq
+	}
+}
+
+// Merge adds what a snapshot has that the data service doesn't, returning how many lists and items were added.
+// Nothing the data service stores is changed or removed. A list in the snapshot is merged into the list with the same
+// ID, owner and name; if there isn't one it is added as a new list. Items are merged into a list if it doesn't have an
+// item with their ID and name and they haven't been deleted from it since, and are given new IDs and put in order by
+// their ordering keys. Members the list doesn't have are added with the role they had. Lists and items that are added are
+// published like any other change, so clients following the list see them.
+func (dataService *DataService) Merge(ctx context.Context, snapshot data.Snapshot) (lists int, items int, err error) {
+	if err := ValidateSnapshot(snapshot); err != nil {
+		return 0, 0, err
+	}
+
+	defer observeStoreWrite("merge", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
+
+	for _, saved := range snapshot.Lists {
+		list, exists := dataService.lists[saved.Id]
+		if !exists || list.OwnerId != saved.OwnerId || list.Name != saved.Name {
+			// The list is gone, or its ID has been given to another list since the snapshot was taken
+			list = dataService.addList(saved.OwnerId, saved.Name)
+			if _, hasDefault := dataService.defaultLists[saved.OwnerId]; !hasDefault && snapshot.DefaultLists[saved.OwnerId] == saved.Id {
+				dataService.defaultLists[saved.OwnerId] = list.Id
+			}
+			lists++
+			created := list.TodoList
+			dataService.publish(ctx, list, events.Event{Type: events.ListCreated, List: &created})
+		}
+		for userId, role := range saved.Members {
+			if _, isMember := list.members[userId]; !isMember {
+				list.members[userId] = role
+				list.changedList()
+			}
+		}
+		for _, item := range saved.Items {
+			if exists && merged(list, item) {
+				continue
+			}
+			dataService.mergeItem(ctx, list, item, saved.Positions[item.Id])
+			items++
+		}
+	}
+	logging.FromContext(ctx).Info("snapshot merged", "lists", lists, "items", items)
+	return lists, items, nil
+}
+
+// merged reports whether a list already has an item from a snapshot, or has deleted it since. An item with the same ID
+// but another name is a different item that was given the ID after the snapshot was taken, e.g. because an older
+// snapshot was restored in between.
+func merged(list *todoList, item data.TodoItem) bool {
+	if index := list.indexOf(item.Id); index >= 0 {
+		return list.items[index].Name == item.Name
+	}
+	state, known := list.states[item.Id]
+	return known && state.deleted
+}
+
+// mergeItem adds an item from a snapshot to a list with a new ID, where its ordering key puts it. The caller must hold
+// the write lock.
+func (dataService *DataService) mergeItem(ctx context.Context, list *todoList, item data.TodoItem, position string) {
+	item.Id = dataService.nextItemId
+	dataService.nextItemId++
+
+	index, found := slices.BinarySearchFunc(list.items, position, func(existing data.TodoItem, position string) int {
+		return cmp.Compare(list.positions[existing.Id], position)
+	})
+	var low, high string
+	if index > 0 {
+		low = list.positions[list.items[index-1].Id]
+	}
+	if index < len(list.items) {
+		high = list.positions[list.items[index].Id]
+	}
+	if found {
+		// Another item has the key, so the merged item goes straight after it
+		index++
+		low = high
+		high = ""
+		if index < len(list.items) {
+			high = list.positions[list.items[index].Id]
+		}
+		position, _ = ordering.KeyBetween(low, high)
+	}
+	list.items = slices.Insert(list.items, index, item)
+	list.positions[item.Id] = position
+	if len(position) > maxPositionLength {
+		list.rebalance()
+	}
+
+	list.stamp(item.Id, dataService.apiStamp(ctx), fieldName, fieldComplete, fieldPosition)
+	dataService.publish(ctx, list, events.Event{Type: events.ItemCreated, Item: &item, Index: &index})
+}
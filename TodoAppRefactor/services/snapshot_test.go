@@ -0,0 +1,157 @@
+package dataService
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"todoApp/auth"
+	"todoApp/data"
+)
+
+func TestSnapshot_RestoreRoundTrip(t *testing.T) {
+	original, list, _ := syncTestData(t)
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+	original.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone"})
+	original.DeleteTodoItem(bob, list.Id, 1)
+	snapshot := original.Snapshot()
+
+	restored := CreateTestData(1)
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if copied := restored.Snapshot(); !reflect.DeepEqual(copied, snapshot) {
+		t.Errorf("The restored data service differs from the snapshot. Got: %+v, Expected: %+v", copied, snapshot)
+	}
+	items, err := restored.GetAllTodoItems(bob, list.Id)
+	if err != nil || len(items) != 2 || items[0].Name != "Milk" || items[1].Name != "Bread" {
+		t.Errorf("Unexpected items. Got: %v, %v", items, err)
+	}
+
+	// New IDs carry on from the snapshot's
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	if created, _ := restored.CreateTodoList(alice, "Work"); created.Id != snapshot.NextListId {
+		t.Errorf("Unexpected list ID. Got: %d, Expected: %d", created.Id, snapshot.NextListId)
+	}
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	dataService, _, _ := syncTestData(t)
+
+	testCases := []struct {
+		testName string
+		corrupt  func(snapshot *data.Snapshot)
+	}{
+		{"Testing a list ID that hasn't been given out", func(snapshot *data.Snapshot) { snapshot.NextListId = 1 }},
+		{"Testing an item ID that hasn't been given out", func(snapshot *data.Snapshot) { snapshot.NextItemId = 2 }},
+		{"Testing a list that appears twice", func(snapshot *data.Snapshot) { snapshot.Lists = append(snapshot.Lists, snapshot.Lists[2]) }},
+		{"Testing a list without its owner", func(snapshot *data.Snapshot) { delete(snapshot.Lists[2].Members, 1) }},
+		{"Testing an unknown role", func(snapshot *data.Snapshot) { snapshot.Lists[2].Members[2] = "boss" }},
+		{"Testing items out of order", func(snapshot *data.Snapshot) {
+			items := snapshot.Lists[2].Items
+			items[0], items[1] = items[1], items[0]
+		}},
+		{"Testing an item without an ordering key", func(snapshot *data.Snapshot) { delete(snapshot.Lists[2].Positions, snapshot.Lists[2].Items[0].Id) }},
+		{"Testing a change after the snapshot's version", func(snapshot *data.Snapshot) { snapshot.Version = 1 }},
+		{"Testing a default list that doesn't exist", func(snapshot *data.Snapshot) { snapshot.DefaultLists[3] = 9 }},
+		{"Testing another user's list as a default list", func(snapshot *data.Snapshot) { snapshot.DefaultLists[2] = snapshot.Lists[2].Id }},
+	}
+
+	if err := ValidateSnapshot(dataService.Snapshot()); err != nil {
+		t.Fatalf("A valid snapshot was rejected: %v", err)
+	}
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			snapshot := dataService.Snapshot()
+			test.corrupt(&snapshot)
+			if err := ValidateSnapshot(snapshot); !errors.Is(err, ErrInvalidSnapshot) {
+				t.Errorf("An invalid snapshot was accepted. Got: %v", err)
+			}
+			if err := CreateTestData(0).Restore(snapshot); !errors.Is(err, ErrInvalidSnapshot) {
+				t.Errorf("An invalid snapshot was restored. Got: %v", err)
+			}
+		})
+	}
+}
+
+func TestMerge_SkipsWhatIsThere(t *testing.T) {
+	dataService, list, _ := syncTestData(t)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	snapshot := dataService.Snapshot()
+
+	// Eggs are deleted after the snapshot is taken, so merging it mustn't bring them back
+	dataService.DeleteTodoItem(alice, list.Id, 1)
+	lists, items, err := dataService.Merge(alice, snapshot)
+	if err != nil || lists != 0 || items != 0 {
+		t.Errorf("Nothing should have been merged. Got: %d lists, %d items, %v", lists, items, err)
+	}
+	if remaining, _ := dataService.GetAllTodoItems(alice, list.Id); len(remaining) != 2 {
+		t.Errorf("Unexpected items. Got: %v", remaining)
+	}
+}
+
+func TestMerge_AddsMissingItemsInOrder(t *testing.T) {
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	source := CreateTestData(0)
+	list, _ := source.CreateTodoList(alice, "Groceries")
+	source.CreateTodoItem(alice, list.Id, "Milk")
+	before := source.Snapshot()
+	source.CreateTodoItem(alice, list.Id, "Eggs")
+	source.CreateTodoItem(alice, list.Id, "Bread")
+
+	// The target was restored before Eggs and Bread were added, and has had Tea added since
+	target := CreateTestData(0)
+	target.Restore(before)
+	target.CreateTodoItem(alice, list.Id, "Tea")
+	lists, items, err := target.Merge(alice, source.Snapshot())
+	if err != nil || lists != 0 || items != 2 {
+		t.Errorf("Unexpected merge. Got: %d lists, %d items, %v", lists, items, err)
+	}
+
+	merged, _ := target.GetAllTodoItems(alice, list.Id)
+	var names []string
+	ids := map[int]bool{}
+	for _, item := range merged {
+		names = append(names, item.Name)
+		ids[item.Id] = true
+	}
+	if expected := []string{"Milk", "Tea", "Eggs", "Bread"}; !reflect.DeepEqual(names, expected) || len(ids) != 4 {
+		t.Errorf("Unexpected items. Got: %v, Expected: %v", merged, expected)
+	}
+	if err := ValidateSnapshot(target.Snapshot()); err != nil {
+		t.Errorf("The merged data service is inconsistent: %v", err)
+	}
+}
+
+func TestMerge_AddsListsAsNew(t *testing.T) {
+	source, list, _ := syncTestData(t)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+
+	// The target has given the list's ID to another list
+	target := CreateTestData(0)
+	work, _ := target.CreateTodoList(alice, "Work")
+	if work.Id != list.Id {
+		t.Fatalf("The test needs the lists to have the same ID. Got: %d and %d", work.Id, list.Id)
+	}
+	lists, items, err := target.Merge(alice, source.Snapshot())
+	if err != nil || lists != 1 || items != 3 {
+		t.Errorf("Unexpected merge. Got: %d lists, %d items, %v", lists, items, err)
+	}
+
+	var groceries data.TodoList
+	for _, shared := range target.GetTodoLists(bob) {
+		if shared.Name == "Groceries" {
+			groceries = shared
+		}
+	}
+	if groceries.Id == 0 || groceries.Id == work.Id || groceries.OwnerId != 1 {
+		t.Fatalf("Unexpected list shared with Bob. Got: %v", groceries)
+	}
+	if merged, _ := target.GetAllTodoItems(bob, groceries.Id); len(merged) != 3 || merged[0].Name != "Milk" {
+		t.Errorf("Unexpected items. Got: %v", merged)
+	}
+	if remaining, _ := target.GetAllTodoItems(alice, work.Id); len(remaining) != 0 {
+		t.Errorf("The list already there was changed. Got: %v", remaining)
+	}
+}
@@ -0,0 +1,239 @@
+package dataService
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"todoApp/data"
+	"todoApp/metrics"
+)
+
+// Store keeps a data service somewhere that outlives the process, such as a data.KVStore. The data service still
+// serves everything from memory, the store is written to as each change is made and only read from when the data
+// service is created.
+type Store interface {
+	// Load returns everything the store holds, and false if it holds nothing yet.
+	Load() (data.Snapshot, bool, error)
+	// Save writes the records that changed, all of them or none.
+	Save(changes data.Changes) error
+	// Probe writes value to the store and returns what it reads back, for CheckStore.
+	Probe(value uint64) (uint64, error)
+}
+
+// ErrNotSaved is returned by a change that couldn't be written to the data service's store. The change is rolled back,
+// unless the store can't be read to do so either.
+var ErrNotSaved = errors.New("the change couldn't be saved")
+
+var storeSaves = metrics.Default.NewCounterVec("todoapp_store_saves_total",
+	"Changes written to data services' stores, by result: saved or failed.", "result")
+
+// changeSet is what has changed since the data service last wrote to its store.
+type changeSet struct {
+	reset bool
+	lists map[int]bool
+	// items are the IDs of the items changed, by list ID.
+	items  map[int]map[int]bool
+	synced map[syncClient]bool
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{lists: map[int]bool{}, items: map[int]map[int]bool{}, synced: map[syncClient]bool{}}
+}
+
+func (changes *changeSet) empty() bool {
+	return !changes.reset && len(changes.lists) == 0 && len(changes.items) == 0 && len(changes.synced) == 0
+}
+
+func (changes *changeSet) clear() {
+	changes.reset = false
+	clear(changes.lists)
+	clear(changes.items)
+	clear(changes.synced)
+}
+
+// NewStoredDataService creates a data service kept in store. It starts with what the store holds, or, if it holds
+// nothing yet, like NewDataService does, which is then written to it.
+func NewStoredDataService(store Store) (*DataService, error) {
+	snapshot, found, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if err := ValidateSnapshot(snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	dataService := NewDataService()
+	dataService.store = store
+	dataService.changes = newChangeSet()
+	if found {
+		dataService.restore(snapshot)
+	} else {
+		dataService.changedAll()
+		if err := dataService.save(); err != nil {
+			return nil, err
+		}
+	}
+	return dataService, nil
+}
+
+// changedAll records that everything the data service stores replaces what the store holds. The caller must hold the
+// write lock.
+func (dataService *DataService) changedAll() {
+	if dataService.changes == nil {
+		return
+	}
+	dataService.changes.reset = true
+	for _, list := range dataService.lists {
+		list.changes = dataService.changes
+		list.changedList()
+		list.changedItems(slices.Collect(maps.Keys(list.states))...)
+		for _, item := range list.items {
+			list.changedItems(item.Id)
+		}
+	}
+	for client := range dataService.syncedSeqs {
+		dataService.changes.synced[client] = true
+	}
+}
+
+// changedSynced records that the Seq of a sync client changed.
+func (dataService *DataService) changedSynced(client syncClient) {
+	if dataService.changes != nil {
+		dataService.changes.synced[client] = true
+	}
+}
+
+// changedList records that the list itself, rather than its items, needs writing to the store.
+func (list *todoList) changedList() {
+	if list.changes != nil {
+		list.changes.lists[list.Id] = true
+	}
+}
+
+// changedItems records that items of the list need writing to the store.
+func (list *todoList) changedItems(ids ...int) {
+	if list.changes == nil || len(ids) == 0 {
+		return
+	}
+	items, exists := list.changes.items[list.Id]
+	if !exists {
+		items = map[int]bool{}
+		list.changes.items[list.Id] = items
+	}
+	for _, id := range ids {
+		items[id] = true
+	}
+}
+
+// unlock writes what changed to the store, publishes the change's events and releases the write lock. Every method
+// that takes the write lock releases it with unlock, passing its error result if it has one. A change that can't be
+// written is rolled back and its events are dropped, and the method returns ErrNotSaved unless it had already failed.
+func (dataService *DataService) unlock(err *error) {
+	defer dataService.mu.Unlock()
+
+	unpublished := dataService.unpublished
+	dataService.unpublished = nil
+	if saveErr := dataService.save(); saveErr != nil {
+		if err != nil && *err == nil {
+			*err = saveErr
+		}
+		return
+	}
+	for _, event := range unpublished {
+		dataService.events.Publish(event)
+	}
+}
+
+// save writes what changed to the store, if the data service has one. A write that fails is logged and rolled back, and
+// CheckStore reports it until a change is written. The caller must hold the write lock.
+func (dataService *DataService) save() error {
+	changes := dataService.changes
+	if changes == nil || changes.empty() {
+		return nil
+	}
+
+	records := data.Changes{
+		Reset: changes.reset,
+		Meta:  data.StoreMeta{NextListId: dataService.nextListId, NextItemId: dataService.nextItemId, Version: dataService.version},
+	}
+	for _, id := range slices.Sorted(maps.Keys(changes.lists)) {
+		list := dataService.lists[id]
+		records.Lists = append(records.Lists, data.ListRecord{TodoList: list.TodoList, Members: list.members,
+			PrunedVersion: list.prunedVersion, Default: dataService.defaultLists[list.OwnerId] == list.Id})
+	}
+	for _, listId := range slices.Sorted(maps.Keys(changes.items)) {
+		list := dataService.lists[listId]
+		indexes := list.indexesOf(changes.items[listId])
+		for _, id := range slices.Sorted(maps.Keys(changes.items[listId])) {
+			record := data.ItemRecord{ListId: listId, Id: id}
+			if index, exists := indexes[id]; exists {
+				record.Item = &list.items[index]
+				record.Position = list.positions[id]
+			}
+			if state, exists := list.states[id]; exists {
+				exported := state.export()
+				record.State = &exported
+			}
+			records.Items = append(records.Items, record)
+		}
+	}
+	for client := range changes.synced {
+		records.SyncedSeqs = append(records.SyncedSeqs,
+			data.SyncedSeq{UserId: client.userId, Client: client.client, ListId: client.listId, Seq: dataService.syncedSeqs[client]})
+	}
+
+	if err := dataService.store.Save(records); err != nil {
+		storeSaves.With("failed").Inc()
+		dataService.storeErr = err
+		dataService.rollBack(err)
+		return fmt.Errorf("%w: %w", ErrNotSaved, err)
+	}
+	storeSaves.With("saved").Inc()
+	dataService.storeErr = nil
+	changes.clear()
+	return nil
+}
+
+// rollBack puts the data service back to what its store holds after a change couldn't be written to it. Every change
+// before it was written, or rolled back too, so that is how things were before the change was made. If the store can't
+// be read either, the change is kept and written with the next one. The caller must hold the write lock.
+func (dataService *DataService) rollBack(saveErr error) {
+	snapshot, found, err := dataService.store.Load()
+	if err == nil && !found {
+		err = errors.New("the store is empty")
+	}
+	if err != nil {
+		slog.Error("error writing to the store, the change couldn't be rolled back and will be written with the next one",
+			"tenant", dataService.tenant, "error", saveErr, "load_error", err)
+		return
+	}
+	dataService.changes.clear()
+	dataService.restore(snapshot)
+	slog.Error("error writing to the store, the change was rolled back", "tenant", dataService.tenant, "error", saveErr)
+}
+
+// indexesOf returns the indexes of the items with the given IDs that are in the list, in one pass over it.
+func (list *todoList) indexesOf(ids map[int]bool) map[int]int {
+	indexes := make(map[int]int, len(ids))
+	if len(ids) == 1 {
+		for id := range ids {
+			if index := list.indexOf(id); index >= 0 {
+				indexes[id] = index
+			}
+		}
+		return indexes
+	}
+	for index, item := range list.items {
+		if ids[item.Id] {
+			indexes[item.Id] = index
+			if len(indexes) == len(ids) {
+				break
+			}
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,230 @@
+package dataService
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+	"todoApp/auth"
+	"todoApp/data"
+	"todoApp/events"
+)
+
+// openStored opens a data service kept in the key-value store at path, whose clock is stopped at now.
+func openStored(t *testing.T, path string, now time.Time) (*DataService, *data.KV) {
+	kv, err := data.OpenKV(path, data.KVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { kv.Close() })
+	dataService, err := NewStoredDataService(data.NewKVStore(kv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataService.now = func() time.Time { return now }
+	return dataService, kv
+}
+
+// expectSameSnapshots compares the snapshots of two data services as JSON, which is how the times in them are stored.
+func expectSameSnapshots(t *testing.T, got *DataService, expected *DataService) {
+	t.Helper()
+	gotJson, _ := json.Marshal(got.Snapshot())
+	expectedJson, _ := json.Marshal(expected.Snapshot())
+	if string(gotJson) != string(expectedJson) {
+		t.Errorf("Unexpected data service. Got: %s, Expected: %s", gotJson, expectedJson)
+	}
+}
+
+func TestStoredDataService_KeepsEveryChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lists.kv")
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	original, kv := openStored(t, path, now)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+
+	list, _ := original.CreateTodoList(alice, "Groceries")
+	for _, name := range []string{"Milk", "Eggs", "Bread", "Tea"} {
+		original.CreateTodoItem(alice, list.Id, name)
+	}
+	original.MarkItemAsComplete(alice, list.Id, 0)
+	items, _ := original.GetAllTodoItems(alice, list.Id)
+	deleted := items[1]
+	original.DeleteTodoItem(alice, list.Id, 1)
+	items, _ = original.GetAllTodoItems(alice, list.Id)
+	original.MoveTodoItem(alice, list.Id, items[2].Id, 0, items[0].Id)
+	original.AddListMember(alice, list.Id, 2, data.RoleEditor)
+	name := "Coffee"
+	original.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone", Mutations: []data.Mutation{{Seq: 1, Op: data.SyncCreate, Ref: "c1", Name: &name}}})
+	original.GetTodoLists(bob)
+
+	kv.Close()
+	reopened, kv := openStored(t, path, now)
+	expectSameSnapshots(t, reopened, original)
+	if items, _ := reopened.GetAllTodoItems(bob, list.Id); len(items) != 4 || items[0].Name != "Tea" || items[3].Name != "Coffee" {
+		t.Errorf("Unexpected items after reopening the store. Got: %v", items)
+	}
+
+	// Tombstones that are dropped are deleted from the store too
+	tombstone := fmt.Sprintf("item/%d/%d", list.Id, deleted.Id)
+	if _, err := kv.Get(tombstone); err != nil {
+		t.Errorf("The tombstone of %v isn't in the store. Got: %v", deleted, err)
+	}
+	reopened.now = func() time.Time { return now.Add(TombstoneRetention + time.Hour) }
+	reopened.Sync(alice, list.Id, data.SyncBatch{ClientId: "laptop", Since: 1})
+	if _, err := kv.Get(tombstone); !errors.Is(err, data.ErrKeyNotFound) {
+		t.Errorf("The dropped tombstone of %v is still in the store. Got: %v", deleted, err)
+	}
+	kv.Close()
+	pruned := reopened
+	reopened, kv = openStored(t, path, now)
+	expectSameSnapshots(t, reopened, pruned)
+
+	// A restore replaces everything in the store
+	restored := NewDataService()
+	restored.CreateTodoList(alice, "Work")
+	if err := reopened.Restore(restored.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	kv.Close()
+	reopened, _ = openStored(t, path, now)
+	expectSameSnapshots(t, reopened, restored)
+}
+
+// failingStore is a store that fails to save and to probe while fail is set.
+type failingStore struct {
+	Store
+	fail  bool
+	saved []data.Changes
+}
+
+func (store *failingStore) Save(changes data.Changes) error {
+	if store.fail {
+		return errors.New("disk full")
+	}
+	store.saved = append(store.saved, changes)
+	return store.Store.Save(changes)
+}
+
+func (store *failingStore) Probe(value uint64) (uint64, error) {
+	if store.fail {
+		return 0, errors.New("disk full")
+	}
+	return store.Store.Probe(value)
+}
+
+// lossyStore is a store that loses the probes written to it.
+type lossyStore struct {
+	Store
+}
+
+func (store lossyStore) Probe(uint64) (uint64, error) {
+	return 0, nil
+}
+
+func TestStoredDataService_RollsBackFailedWrites(t *testing.T) {
+	kv, err := data.OpenKV(filepath.Join(t.TempDir(), "lists.kv"), data.KVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	store := &failingStore{Store: data.NewKVStore(kv)}
+	dataService, err := NewStoredDataService(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 || !store.saved[0].Reset || len(store.saved[0].Items) != len(data.DataStore) {
+		t.Fatalf("A new data service wasn't written to its empty store. Got: %+v", store.saved)
+	}
+	hub := events.NewHub(10)
+	dataService.PublishTo(hub, "default")
+	sub, _, _ := hub.Subscribe(0, func(events.Event) bool { return true })
+	defer sub.Close()
+
+	store.fail = true
+	ctx := context.Background()
+	changes := map[string]func() error{
+		"create":   func() error { return dataService.CreateTodoItem(ctx, 0, "Milk") },
+		"complete": func() error { return dataService.MarkItemAsComplete(ctx, 0, 0) },
+		"delete":   func() error { return dataService.DeleteTodoItem(ctx, 0, 1) },
+		"createList": func() error {
+			_, err := dataService.CreateTodoList(ctx, "Work")
+			return err
+		},
+	}
+	for operation, change := range changes {
+		if err := change(); !errors.Is(err, ErrNotSaved) {
+			t.Errorf("A %s that couldn't be written was accepted. Got: %v", operation, err)
+		}
+	}
+	if items, _ := dataService.GetAllTodoItems(ctx, 0); len(items) != len(data.DataStore) || items[0].Complete || items[1].Name != data.DataStore[1].Name {
+		t.Errorf("Changes that couldn't be written weren't rolled back. Got: %v", items)
+	}
+	if lists := dataService.GetTodoLists(ctx); len(lists) != 1 {
+		t.Errorf("A list that couldn't be written wasn't rolled back. Got: %v", lists)
+	}
+	select {
+	case event := <-sub.Events():
+		t.Errorf("A change that was rolled back was published. Got: %+v", event)
+	default:
+	}
+	if err := dataService.CheckStore(ctx); err == nil {
+		t.Error("A failed write to the store wasn't reported")
+	}
+
+	store.fail = false
+	if err := dataService.CreateTodoItem(ctx, 0, "Eggs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataService.CheckStore(ctx); err != nil {
+		t.Errorf("Unexpected error after the store recovered. Got: %v", err)
+	}
+	if written := store.saved[len(store.saved)-1]; len(store.saved) != 2 || len(written.Items) != 1 || written.Items[0].Item.Name != "Eggs" {
+		t.Errorf("Unexpected write after the store recovered. Got: %+v", store.saved)
+	}
+	if event := <-sub.Events(); event.Type != events.ItemCreated || event.Item.Name != "Eggs" {
+		t.Errorf("Unexpected event. Got: %+v", event)
+	}
+	reopened, err := NewStoredDataService(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSameSnapshots(t, reopened, dataService)
+
+	// Reads and changes that change nothing don't write to the store
+	dataService.GetAllTodoItems(ctx, 0)
+	dataService.GetTodoLists(ctx)
+	if len(store.saved) != 2 {
+		t.Errorf("Unexpected writes. Got: %d", len(store.saved)-2)
+	}
+}
+
+func TestStoredDataService_CheckStoreProbesTheStore(t *testing.T) {
+	kv, err := data.OpenKV(filepath.Join(t.TempDir(), "lists.kv"), data.KVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	ctx := context.Background()
+
+	dataService, err := NewStoredDataService(data.NewKVStore(kv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dataService.CheckStore(ctx); err != nil {
+		t.Errorf("Unexpected error. Got: %v", err)
+	}
+	if err := dataService.CheckStore(ctx); err != nil {
+		t.Errorf("Unexpected error on the second probe. Got: %v", err)
+	}
+
+	lossy, err := NewStoredDataService(lossyStore{Store: data.NewKVStore(kv)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lossy.CheckStore(ctx); err == nil {
+		t.Error("A store that lost the probe wasn't reported")
+	}
+}
@@ -0,0 +1,309 @@
+package dataService
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+	"todoApp/data"
+	"todoApp/events"
+	"todoApp/logging"
+	"todoApp/utils/stringUtils"
+)
+
+// TombstoneRetention is how long deleted items are remembered, so clients that were offline can be told about the
+// deletion. A client that last synced before a tombstone was dropped is sent the whole list again.
+const TombstoneRetention = 30 * 24 * time.Hour
+
+// Fields of an item that are merged separately. Syncs don't move items, but moves are stamped so the clients are sent
+// the item's new index.
+const (
+	fieldName     = "Name"
+	fieldComplete = "Complete"
+	fieldPosition = "Position"
+)
+
+var ErrUnknownOperation = errors.New("operation must be 'create', 'update' or 'delete'")
+
+// itemState is what's needed to merge changes to an item: the version and stamp of each field's last change, and
+// whether the item was deleted.
+type itemState struct {
+	version uint64
+	fields  map[string]stamp
+	deleted bool
+	// origin is the client and Ref of items created by a sync.
+	origin string
+}
+
+// stamp says when and by whom a field was last changed. Changes made through the API have an empty client.
+type stamp struct {
+	version uint64
+	at      time.Time
+	client  string
+}
+
+// after orders concurrent changes: the later one wins, with ties broken by client so every server agrees.
+func (s stamp) after(other stamp) bool {
+	if !s.at.Equal(other.at) {
+		return s.at.After(other.at)
+	}
+	return s.client > other.client
+}
+
+type syncClient struct {
+	userId int
+	client string
+	listId int
+}
+
+// Sync merges a batch of offline mutations into a list and returns what changed on the server since the client last
+// synced. Each field of an item is merged on its own: a change to a field the client had seen the latest version of
+// is applied, and of two concurrent changes the later one wins. Deletes always win, and are remembered as tombstones
+// for TombstoneRetention. Conflicts between concurrent changes are reported whichever way they go.
+func (dataService *DataService) Sync(ctx context.Context, listId int, batch data.SyncBatch) (_ data.SyncResult, err error) {
+	defer observeStoreWrite("sync", time.Now())
+	dataService.mu.Lock()
+	defer dataService.unlock(&err)
+
+	userId := ownerFrom(ctx)
+	list, err := dataService.findList(userId, listId, len(batch.Mutations) > 0)
+	if err != nil {
+		return data.SyncResult{}, err
+	}
+	for _, mutation := range batch.Mutations {
+		if !slices.Contains([]string{data.SyncCreate, data.SyncUpdate, data.SyncDelete}, mutation.Op) {
+			return data.SyncResult{}, ErrUnknownOperation
+		}
+		if mutation.Op == data.SyncCreate && (mutation.Name == nil || stringUtils.IsEmptyOrWhitespace(*mutation.Name)) ||
+			mutation.Op == data.SyncUpdate && mutation.Name != nil && stringUtils.IsEmptyOrWhitespace(*mutation.Name) {
+			return data.SyncResult{}, ErrEmptyName
+		}
+	}
+	now := dataService.timeOf(ctx)
+	list.pruneTombstones(now.Add(-TombstoneRetention))
+	// The client is sent the whole list if it hasn't synced before, its token is from before the server restarted or
+	// it has missed deletions that are no longer remembered
+	reset := batch.Since == 0 || batch.Since > dataService.version || batch.Since < list.prunedVersion
+
+	result := data.SyncResult{Created: map[string]int{}, Conflicts: []data.Conflict{}}
+	key := syncClient{userId: userId, client: batch.ClientId, listId: list.Id}
+	for _, mutation := range batch.Mutations {
+		if mutation.Seq <= dataService.syncedSeqs[key] {
+			// Already applied, but the client may not have heard the ID a create was given
+			if mutation.Op == data.SyncCreate {
+				if id, found := list.createdBy(batch.ClientId, mutation.Ref); found {
+					result.Created[mutation.Ref] = id
+				}
+			}
+			continue
+		}
+		dataService.syncedSeqs[key] = mutation.Seq
+		dataService.changedSynced(key)
+
+		s := stamp{at: mutation.Time, client: batch.ClientId}
+		// A client's clock can't put its changes ahead of everyone else's
+		if s.at.IsZero() || s.at.After(now) {
+			s.at = now
+		}
+		switch mutation.Op {
+		case data.SyncCreate:
+			dataService.syncCreate(ctx, list, mutation, s, &result)
+		case data.SyncUpdate:
+			dataService.syncUpdate(ctx, list, mutation, s, &result)
+		case data.SyncDelete:
+			dataService.syncDelete(ctx, list, mutation, s, &result)
+		}
+	}
+	if len(batch.Mutations) > 0 {
+		logging.FromContext(ctx).Info("list synced", "list", list.Id, "client", batch.ClientId,
+			"mutations", len(batch.Mutations), "changes", len(result.Changes), "conflicts", len(result.Conflicts))
+	}
+
+	result.Token = dataService.version
+	result.Reset = reset
+	result.Items = list.changesSince(batch.Since, reset)
+	return result, nil
+}
+
+func (dataService *DataService) syncCreate(ctx context.Context, list *todoList, mutation data.Mutation, s stamp, result *data.SyncResult) {
+	item := data.TodoItem{Id: dataService.nextItemId, Name: *mutation.Name}
+	if mutation.Complete != nil {
+		item.Complete = *mutation.Complete
+	}
+	dataService.nextItemId++
+	list.items = append(list.items, item)
+	list.place(item.Id)
+	index := len(list.items) - 1
+
+	s.version = dataService.nextVersion()
+	list.stamp(item.Id, s, fieldName, fieldComplete)
+	list.states[item.Id].origin = s.client + "/" + mutation.Ref
+	result.Created[mutation.Ref] = item.Id
+	result.Changes = append(result.Changes, data.ItemChange{Op: data.SyncCreate, After: &item})
+	dataService.publish(ctx, list, events.Event{Type: events.ItemCreated, Item: &item, Index: &index})
+}
+
+func (dataService *DataService) syncUpdate(ctx context.Context, list *todoList, mutation data.Mutation, s stamp, result *data.SyncResult) {
+	index := list.indexOf(mutation.ItemId)
+	if index < 0 {
+		reason := data.ConflictNotFound
+		if state, exists := list.states[mutation.ItemId]; exists && state.deleted {
+			reason = data.ConflictDeleted
+		}
+		result.Conflicts = append(result.Conflicts, data.Conflict{Seq: mutation.Seq, ItemId: mutation.ItemId, Reason: reason})
+		return
+	}
+
+	before := list.items[index]
+	after := before
+	var changed []string
+	for _, field := range []string{fieldName, fieldComplete} {
+		set := field == fieldName && mutation.Name != nil || field == fieldComplete && mutation.Complete != nil
+		if !set {
+			continue
+		}
+		current := list.state(mutation.ItemId).fields[field]
+		concurrent := current.version > mutation.BaseVersion && current.client != s.client
+		if concurrent && !s.after(current) {
+			result.Conflicts = append(result.Conflicts, data.Conflict{Seq: mutation.Seq, ItemId: mutation.ItemId, Field: field, Reason: data.ConflictSuperseded})
+			continue
+		}
+		if concurrent {
+			result.Conflicts = append(result.Conflicts, data.Conflict{Seq: mutation.Seq, ItemId: mutation.ItemId, Field: field, Reason: data.ConflictOverwritten})
+		}
+		if field == fieldName {
+			after.Name = *mutation.Name
+		} else {
+			after.Complete = *mutation.Complete
+		}
+		changed = append(changed, field)
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	s.version = dataService.nextVersion()
+	list.stamp(mutation.ItemId, s, changed...)
+	list.items[index] = after
+	result.Changes = append(result.Changes, data.ItemChange{Op: data.SyncUpdate, Before: &before, After: &after})
+	dataService.publish(ctx, list, events.Event{Type: events.ItemUpdated, Item: &after, Index: &index})
+}
+
+func (dataService *DataService) syncDelete(ctx context.Context, list *todoList, mutation data.Mutation, s stamp, result *data.SyncResult) {
+	index := list.indexOf(mutation.ItemId)
+	if index < 0 {
+		// Deleting an item twice is fine, whoever deleted it first
+		if state, exists := list.states[mutation.ItemId]; !exists || !state.deleted {
+			result.Conflicts = append(result.Conflicts, data.Conflict{Seq: mutation.Seq, ItemId: mutation.ItemId, Reason: data.ConflictNotFound})
+		}
+		return
+	}
+	for _, current := range list.state(mutation.ItemId).fields {
+		if current.version > mutation.BaseVersion && current.client != s.client {
+			result.Conflicts = append(result.Conflicts, data.Conflict{Seq: mutation.Seq, ItemId: mutation.ItemId, Reason: data.ConflictOverwritten})
+			break
+		}
+	}
+
+	item := list.items[index]
+	list.items = slices.Delete(list.items, index, index+1)
+	delete(list.positions, item.Id)
+	s.version = dataService.nextVersion()
+	list.bury(item.Id, s)
+	result.Changes = append(result.Changes, data.ItemChange{Op: data.SyncDelete, Before: &item})
+	dataService.publish(ctx, list, events.Event{Type: events.ItemDeleted, Item: &item, Index: &index})
+}
+
+// apiStamp numbers a change made through the API rather than by a sync. The caller must hold the write lock.
+func (dataService *DataService) apiStamp(ctx context.Context) stamp {
+	return stamp{version: dataService.nextVersion(), at: dataService.timeOf(ctx)}
+}
+
+// nextVersion numbers a change to an item. The caller must hold the write lock.
+func (dataService *DataService) nextVersion() uint64 {
+	dataService.version++
+	return dataService.version
+}
+
+// state returns the merge state of an item, starting one for items that haven't been changed since they were loaded.
+func (list *todoList) state(id int) *itemState {
+	state, exists := list.states[id]
+	if !exists {
+		state = &itemState{fields: map[string]stamp{}}
+		list.states[id] = state
+	}
+	return state
+}
+
+// stamp records a change to some of an item's fields.
+func (list *todoList) stamp(id int, s stamp, fields ...string) {
+	state := list.state(id)
+	state.version = s.version
+	for _, field := range fields {
+		state.fields[field] = s
+	}
+	list.changedItems(id)
+}
+
+// bury turns an item's state into a tombstone.
+func (list *todoList) bury(id int, s stamp) {
+	state := list.state(id)
+	state.version = s.version
+	state.deleted = true
+	state.fields = map[string]stamp{"": s}
+	list.changedItems(id)
+}
+
+// pruneTombstones drops the tombstones of items deleted before cutoff.
+func (list *todoList) pruneTombstones(cutoff time.Time) {
+	for id, state := range list.states {
+		if state.deleted && state.fields[""].at.Before(cutoff) {
+			list.prunedVersion = max(list.prunedVersion, state.version)
+			delete(list.states, id)
+			list.changedItems(id)
+			list.changedList()
+		}
+	}
+}
+
+func (list *todoList) indexOf(id int) int {
+	return slices.IndexFunc(list.items, func(item data.TodoItem) bool { return item.Id == id })
+}
+
+func (list *todoList) createdBy(client string, ref string) (int, bool) {
+	for id, state := range list.states {
+		if state.origin == client+"/"+ref {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// changesSince returns the items changed after version, in list order followed by the deleted ones, or every item
+// when all is set.
+func (list *todoList) changesSince(version uint64, all bool) []data.SyncedItem {
+	changes := []data.SyncedItem{}
+	for index, item := range list.items {
+		state, exists := list.states[item.Id]
+		var itemVersion uint64
+		if exists {
+			itemVersion = state.version
+		}
+		if all || itemVersion > version {
+			changes = append(changes, data.SyncedItem{TodoItem: item, Index: index, Version: itemVersion})
+		}
+	}
+	if all {
+		return changes
+	}
+
+	var deleted []data.SyncedItem
+	for id, state := range list.states {
+		if state.deleted && state.version > version {
+			deleted = append(deleted, data.SyncedItem{TodoItem: data.TodoItem{Id: id}, Index: -1, Version: state.version, Deleted: true})
+		}
+	}
+	slices.SortFunc(deleted, func(a, b data.SyncedItem) int { return cmp.Compare(a.Version, b.Version) })
+	return append(changes, deleted...)
+}
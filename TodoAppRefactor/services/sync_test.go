@@ -0,0 +1,208 @@
+package dataService
+
+import (
+	"context"
+	"testing"
+	"time"
+	"todoApp/auth"
+	"todoApp/data"
+)
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+// syncTestData returns a data service with a list of three items shared by Alice with Bob, and a clock to set.
+func syncTestData(t *testing.T) (dataService *DataService, list data.TodoList, now *time.Time) {
+	dataService = CreateTestData(0)
+	clock := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	dataService.now = func() time.Time { return clock }
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	list, _ = dataService.CreateTodoList(alice, "Groceries")
+	for _, name := range []string{"Milk", "Eggs", "Bread"} {
+		dataService.CreateTodoItem(alice, list.Id, name)
+	}
+	dataService.AddListMember(alice, list.Id, 2, data.RoleEditor)
+	return dataService, list, &clock
+}
+
+func TestSync_FirstSyncSendsEverything(t *testing.T) {
+	dataService, list, _ := syncTestData(t)
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+
+	result, err := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Reset || len(result.Items) != 3 || result.Items[2].Name != "Bread" || result.Items[2].Index != 2 || result.Token != 3 {
+		t.Errorf("Unexpected result. Got: %+v", result)
+	}
+
+	if result, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone", Since: result.Token}); result.Reset || len(result.Items) != 0 {
+		t.Errorf("Nothing has changed since the last sync. Got: %+v", result)
+	}
+	carol := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 3})
+	if _, err := dataService.Sync(carol, list.Id, data.SyncBatch{ClientId: "phone"}); err != ErrListNotFound {
+		t.Errorf("Unexpected error syncing a list that isn't shared. Got: %v", err)
+	}
+}
+
+func TestSync_AppliesMutations(t *testing.T) {
+	dataService, list, now := syncTestData(t)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+	first, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone"})
+	milk, eggs := first.Items[0], first.Items[1]
+
+	// Meanwhile Alice deletes the bread
+	dataService.DeleteTodoItem(alice, list.Id, 2)
+	offline := now.Add(-time.Minute)
+	batch := data.SyncBatch{ClientId: "phone", Since: first.Token, Mutations: []data.Mutation{
+		{Seq: 1, Op: data.SyncCreate, Ref: "tmp-1", Name: ptr("Tea"), Time: offline},
+		{Seq: 2, Op: data.SyncUpdate, ItemId: milk.Id, BaseVersion: milk.Version, Complete: ptr(true), Time: offline},
+		{Seq: 3, Op: data.SyncDelete, ItemId: eggs.Id, BaseVersion: eggs.Version, Time: offline},
+	}}
+	result, err := dataService.Sync(bob, list.Id, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) != 0 || len(result.Changes) != 3 || result.Created["tmp-1"] == 0 {
+		t.Errorf("Unexpected result. Got: %+v", result)
+	}
+	items, _ := dataService.GetAllTodoItems(alice, list.Id)
+	expected := []data.TodoItem{{Id: milk.Id, Name: "Milk", Complete: true}, {Id: result.Created["tmp-1"], Name: "Tea"}}
+	if len(items) != 2 || items[0] != expected[0] || items[1] != expected[1] {
+		t.Errorf("Unexpected items. Got: %v, Expected: %v", items, expected)
+	}
+
+	// The delta has the client's own changes and Alice's deletion
+	deleted := map[int]bool{}
+	for _, item := range result.Items {
+		if item.Deleted {
+			deleted[item.Id] = true
+		}
+	}
+	if result.Reset || len(result.Items) != 4 || !deleted[eggs.Id] || !deleted[first.Items[2].Id] {
+		t.Errorf("Unexpected delta. Got: %+v", result.Items)
+	}
+
+	// Sending the batch again changes nothing, but still says what the new item's ID is
+	again, _ := dataService.Sync(bob, list.Id, batch)
+	if len(again.Changes) != 0 || again.Created["tmp-1"] != result.Created["tmp-1"] || again.Token != result.Token {
+		t.Errorf("A batch sent twice should only be applied once. Got: %+v", again)
+	}
+}
+
+func TestSync_MergesFieldsAndReportsConflicts(t *testing.T) {
+	dataService, list, now := syncTestData(t)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+	first, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone"})
+	milk, eggs, bread := first.Items[0], first.Items[1], first.Items[2]
+
+	// Alice renames the milk and eggs from her laptop, then completes the bread through the API
+	*now = now.Add(time.Minute)
+	dataService.Sync(alice, list.Id, data.SyncBatch{ClientId: "laptop", Since: first.Token, Mutations: []data.Mutation{
+		{Seq: 1, Op: data.SyncUpdate, ItemId: milk.Id, BaseVersion: milk.Version, Name: ptr("Oat milk"), Time: *now},
+		{Seq: 2, Op: data.SyncUpdate, ItemId: eggs.Id, BaseVersion: eggs.Version, Name: ptr("Free range eggs"), Time: *now},
+	}})
+	dataService.MarkItemAsComplete(alice, list.Id, 2)
+
+	// Bob, offline since the first sync, renamed the milk before Alice did and the eggs after, completed the milk and
+	// deleted the bread
+	*now = now.Add(time.Minute)
+	result, err := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone", Since: first.Token, Mutations: []data.Mutation{
+		{Seq: 1, Op: data.SyncUpdate, ItemId: milk.Id, BaseVersion: milk.Version, Name: ptr("Skimmed milk"), Complete: ptr(true), Time: now.Add(-90 * time.Second)},
+		{Seq: 2, Op: data.SyncUpdate, ItemId: eggs.Id, BaseVersion: eggs.Version, Name: ptr("Duck eggs"), Time: now.Add(-30 * time.Second)},
+		{Seq: 3, Op: data.SyncDelete, ItemId: bread.Id, BaseVersion: bread.Version, Time: *now},
+		{Seq: 4, Op: data.SyncUpdate, ItemId: bread.Id, BaseVersion: bread.Version, Name: ptr("Rye bread"), Time: *now},
+		{Seq: 5, Op: data.SyncUpdate, ItemId: 99, Complete: ptr(true), Time: *now},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedConflicts := []data.Conflict{
+		{Seq: 1, ItemId: milk.Id, Field: fieldName, Reason: data.ConflictSuperseded},
+		{Seq: 2, ItemId: eggs.Id, Field: fieldName, Reason: data.ConflictOverwritten},
+		{Seq: 3, ItemId: bread.Id, Reason: data.ConflictOverwritten},
+		{Seq: 4, ItemId: bread.Id, Reason: data.ConflictDeleted},
+		{Seq: 5, ItemId: 99, Reason: data.ConflictNotFound},
+	}
+	if len(result.Conflicts) != len(expectedConflicts) {
+		t.Fatalf("Unexpected conflicts. Got: %+v, Expected: %+v", result.Conflicts, expectedConflicts)
+	}
+	for i, conflict := range result.Conflicts {
+		if conflict != expectedConflicts[i] {
+			t.Errorf("Unexpected conflict. Got: %+v, Expected: %+v", conflict, expectedConflicts[i])
+		}
+	}
+
+	items, _ := dataService.GetAllTodoItems(alice, list.Id)
+	expected := []data.TodoItem{{Id: milk.Id, Name: "Oat milk", Complete: true}, {Id: eggs.Id, Name: "Duck eggs"}}
+	if len(items) != 2 || items[0] != expected[0] || items[1] != expected[1] {
+		t.Errorf("Each field should be merged on its own. Got: %v, Expected: %v", items, expected)
+	}
+}
+
+func TestSync_ClientClocksCantWinFromTheFuture(t *testing.T) {
+	dataService, list, now := syncTestData(t)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+	first, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone"})
+	milk := first.Items[0]
+
+	dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone", Since: first.Token, Mutations: []data.Mutation{
+		{Seq: 1, Op: data.SyncUpdate, ItemId: milk.Id, BaseVersion: milk.Version, Name: ptr("Milk from the future"), Time: now.Add(time.Hour)},
+	}})
+	*now = now.Add(time.Minute)
+	result, _ := dataService.Sync(alice, list.Id, data.SyncBatch{ClientId: "laptop", Since: first.Token, Mutations: []data.Mutation{
+		{Seq: 1, Op: data.SyncUpdate, ItemId: milk.Id, BaseVersion: milk.Version, Name: ptr("Milk"), Time: *now},
+	}})
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Reason != data.ConflictOverwritten {
+		t.Errorf("The later change should win. Got: %+v", result.Conflicts)
+	}
+}
+
+func TestSync_ResetsAfterTombstonesAreDropped(t *testing.T) {
+	dataService, list, now := syncTestData(t)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	bob := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 2})
+	first, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone"})
+
+	dataService.DeleteTodoItem(alice, list.Id, 0)
+	*now = now.Add(TombstoneRetention + time.Hour)
+	result, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone", Since: first.Token})
+	if !result.Reset || len(result.Items) != 2 {
+		t.Errorf("A client that missed a forgotten deletion should be sent the whole list. Got: %+v", result)
+	}
+	if result, _ := dataService.Sync(bob, list.Id, data.SyncBatch{ClientId: "phone", Since: result.Token + 10}); !result.Reset {
+		t.Errorf("A token from before a restart should reset the client. Got: %+v", result)
+	}
+}
+
+func TestSync_InvalidBatches(t *testing.T) {
+	dataService, list, _ := syncTestData(t)
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1})
+	testCases := []struct {
+		testName      string
+		mutation      data.Mutation
+		expectedError error
+	}{
+		{"Testing an unknown operation", data.Mutation{Seq: 1, Op: "rename"}, ErrUnknownOperation},
+		{"Testing a create without a name", data.Mutation{Seq: 1, Op: data.SyncCreate, Ref: "a"}, ErrEmptyName},
+		{"Testing an update to an empty name", data.Mutation{Seq: 1, Op: data.SyncUpdate, ItemId: 1, Name: ptr(" ")}, ErrEmptyName},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			batch := data.SyncBatch{ClientId: "laptop", Mutations: []data.Mutation{{Seq: 1, Op: data.SyncCreate, Ref: "ok", Name: ptr("Tea")}, test.mutation}}
+			if _, err := dataService.Sync(alice, list.Id, batch); err != test.expectedError {
+				t.Errorf("Unexpected error. Got: %v, Expected: %v", err, test.expectedError)
+			}
+			if items, _ := dataService.GetAllTodoItems(alice, list.Id); len(items) != 3 {
+				t.Errorf("No mutation in an invalid batch should be applied. Got: %v", items)
+			}
+		})
+	}
+}
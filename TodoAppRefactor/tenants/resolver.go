@@ -0,0 +1,102 @@
+package tenants
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"todoApp/api/middleware"
+	"todoApp/api/responses"
+	"todoApp/auth"
+	"todoApp/logging"
+)
+
+const DefaultHeader = "X-Tenant-ID"
+
+// Membership says which tenants a user belongs to.
+type Membership interface {
+	InTenant(userId int, tenant string) bool
+}
+
+// Resolver works out which tenant a request is for. The tenant an API key or bearer token was issued for takes
+// precedence, then the tenant header and then the subdomain of BaseDomain the request was sent to. Requests that don't
+// name a tenant are for the default tenant.
+type Resolver struct {
+	Router *Router
+	// Members, when set, limits authenticated callers to the tenants they belong to.
+	Members Membership
+	Header  string
+	// BaseDomain enables resolving tenants from subdomains, e.g. a request to team-a.todo.example.com is for the team-a
+	// tenant when BaseDomain is todo.example.com. It is disabled when empty.
+	BaseDomain string
+}
+
+// Resolve attaches the tenant to the request context. Requests for tenants that don't exist are rejected with a 404,
+// and requests whose credentials were issued for a different tenant than the one named in the request, or whose caller
+// isn't a member of the tenant, with a 403.
+func (resolver *Resolver) Resolve() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant := resolver.fromRequest(r)
+
+			principal, authenticated := auth.PrincipalFrom(r.Context())
+			if authenticated && principal.Tenant != "" {
+				if tenant != "" && tenant != principal.Tenant {
+					responses.WriteError(w, http.StatusForbidden, "credentials were issued for another tenant")
+					return
+				}
+				tenant = principal.Tenant
+			}
+			if tenant == "" {
+				tenant = DefaultTenant
+			}
+			if !resolver.Router.Exists(tenant) {
+				responses.WriteError(w, http.StatusNotFound, ErrUnknownTenant.Error())
+				return
+			}
+			if authenticated && resolver.Members != nil && !resolver.Members.InTenant(principal.UserId, tenant) {
+				logging.FromContext(r.Context()).Info("rejected request for a tenant the caller isn't a member of", "tenant", tenant, "user", principal.UserId)
+				responses.WriteError(w, http.StatusForbidden, ErrNotMember.Error())
+				return
+			}
+
+			ctx := logging.WithAttrs(WithTenant(r.Context(), tenant), "tenant", tenant)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// fromRequest returns the tenant named by the header or subdomain, or an empty string if there isn't one.
+func (resolver *Resolver) fromRequest(r *http.Request) string {
+	if tenant := r.Header.Get(resolver.Header); resolver.Header != "" && tenant != "" {
+		return strings.ToLower(tenant)
+	}
+	if resolver.BaseDomain == "" {
+		return ""
+	}
+
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	subdomain, found := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(resolver.BaseDomain))
+	if !found || strings.Contains(subdomain, ".") {
+		return ""
+	}
+	return subdomain
+}
+
+// LimitRequests rejects requests with a 429 once their tenant has used up its request rate quota.
+func (router *Router) LimitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := From(r.Context())
+		if ok, retryAfter := router.Allow(tenant); !ok {
+			logging.FromContext(r.Context()).Info("tenant request quota exceeded")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			responses.WriteError(w, http.StatusTooManyRequests, "the tenant has reached its request quota")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,362 @@
+package tenants
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+	"todoApp/data"
+	"todoApp/ratelimit"
+	dataService "todoApp/services"
+)
+
+// DefaultTenant serves requests that don't name a tenant.
+const DefaultTenant = "default"
+
+var (
+	ErrUnknownTenant     = errors.New("tenant does not exist")
+	ErrInvalidTenant     = errors.New("tenant IDs may only contain lowercase letters, digits and '-'")
+	ErrItemQuotaExceeded = errors.New("the tenant has reached its item quota")
+	ErrNotMember         = errors.New("you aren't a member of this tenant")
+)
+
+var validTenant = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var (
+	_ dataService.IDataService   = (*Router)(nil)
+	_ dataService.IHealthChecker = (*Router)(nil)
+)
+
+// Quota caps how much a tenant can use. Zero values mean no limit.
+type Quota struct {
+	MaxItems          int
+	RequestsPerSecond float64
+	Burst             int
+}
+
+type tenantKey struct{}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// From returns the tenant of the request that ctx belongs to, or the default tenant if it wasn't resolved.
+func From(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+type tenant struct {
+	service *dataService.DataService
+	limiter *ratelimit.Bucket
+	quota   Quota
+}
+
+// Router is an IDataService that gives every tenant its own data service, picked by the tenant in the context. A
+// tenant's data service is created from its ID, which is the namespace for anything it stores, so no call made on
+// behalf of one tenant can reach another tenant's data.
+type Router struct {
+	newService func(namespace string) *dataService.DataService
+	quota      Quota
+	tenants    map[string]*tenant
+	mu         sync.RWMutex
+}
+
+// NewRouter creates a router serving only the default tenant. newService is called once for each tenant added.
+func NewRouter(newService func(namespace string) *dataService.DataService, quota Quota) *Router {
+	router := &Router{newService: newService, quota: quota, tenants: map[string]*tenant{}}
+	router.AddTenant(DefaultTenant)
+	return router
+}
+
+// AddTenant starts serving a tenant with the router's quota. Adding a tenant that already exists does nothing.
+func (router *Router) AddTenant(id string) error {
+	if !validTenant.MatchString(id) {
+		return ErrInvalidTenant
+	}
+
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	if _, exists := router.tenants[id]; exists {
+		return nil
+	}
+	router.tenants[id] = &tenant{
+		service: router.newService(id),
+		limiter: ratelimit.NewBucket(router.quota.RequestsPerSecond, router.quota.Burst),
+		quota:   router.quota,
+	}
+	return nil
+}
+
+// Reset replaces every tenant's data service with a new one, throwing away everything they have stored. Calls already
+// under way finish against the old one.
+func (router *Router) Reset() {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	for id, old := range router.tenants {
+		router.tenants[id] = &tenant{service: router.newService(id), limiter: old.limiter, quota: old.quota}
+	}
+}
+
+// SetQuota replaces the quota of every tenant, including those added later.
+func (router *Router) SetQuota(quota Quota) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	router.quota = quota
+	for _, tenant := range router.tenants {
+		tenant.quota = quota
+		tenant.limiter = ratelimit.NewBucket(quota.RequestsPerSecond, quota.Burst)
+	}
+}
+
+// Tenants returns the IDs of every tenant, in order.
+func (router *Router) Tenants() []string {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	ids := make([]string, 0, len(router.tenants))
+	for id := range router.tenants {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (router *Router) Exists(id string) bool {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	_, exists := router.tenants[id]
+	return exists
+}
+
+// Allow takes a request from the tenant's rate quota. When the quota is used up it returns how long until it isn't.
+func (router *Router) Allow(id string) (bool, time.Duration) {
+	tenant, err := router.lookup(id)
+	if err != nil {
+		return false, 0
+	}
+	return tenant.limiter.Allow()
+}
+
+func (router *Router) lookup(id string) (*tenant, error) {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	tenant, exists := router.tenants[id]
+	if !exists {
+		return nil, ErrUnknownTenant
+	}
+	return tenant, nil
+}
+
+func (router *Router) tenantFor(ctx context.Context) (*tenant, error) {
+	return router.lookup(From(ctx))
+}
+
+// CreateTodoItem refuses to create items past the tenant's item quota. Commands are carried out one at a time by the
+// RequestHandler, so the count can't change between checking it and creating the item.
+func (router *Router) CreateTodoItem(ctx context.Context, listId int, name string) error {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return err
+	}
+	if tenant.quota.MaxItems > 0 {
+		complete, incomplete := tenant.service.CountItems(ctx)
+		if complete+incomplete >= tenant.quota.MaxItems {
+			return ErrItemQuotaExceeded
+		}
+	}
+	return tenant.service.CreateTodoItem(ctx, listId, name)
+}
+
+func (router *Router) GetTodoItem(ctx context.Context, listId int, index int) (data.TodoItem, error) {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return data.TodoItem{}, err
+	}
+	return tenant.service.GetTodoItem(ctx, listId, index)
+}
+
+func (router *Router) GetAllTodoItems(ctx context.Context, listId int) ([]data.TodoItem, error) {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tenant.service.GetAllTodoItems(ctx, listId)
+}
+
+func (router *Router) MarkItemAsComplete(ctx context.Context, listId int, index int) error {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return err
+	}
+	return tenant.service.MarkItemAsComplete(ctx, listId, index)
+}
+
+func (router *Router) DeleteTodoItem(ctx context.Context, listId int, index int) error {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return err
+	}
+	return tenant.service.DeleteTodoItem(ctx, listId, index)
+}
+
+func (router *Router) CreateTodoList(ctx context.Context, name string) (data.TodoList, error) {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return data.TodoList{}, err
+	}
+	return tenant.service.CreateTodoList(ctx, name)
+}
+
+// GetTodoLists returns no lists for an unknown tenant. Requests for unknown tenants are rejected before they reach the
+// data service, so this only happens for calls made outside of a request.
+func (router *Router) GetTodoLists(ctx context.Context) []data.TodoList {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return []data.TodoList{}
+	}
+	return tenant.service.GetTodoLists(ctx)
+}
+
+func (router *Router) GetDefaultListId(ctx context.Context) (int, error) {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return tenant.service.GetDefaultListId(ctx)
+}
+
+func (router *Router) GetListRole(ctx context.Context, listId int) (data.Role, error) {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return "", err
+	}
+	return tenant.service.GetListRole(ctx, listId)
+}
+
+func (router *Router) GetListMembers(ctx context.Context, listId int) ([]data.Member, error) {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tenant.service.GetListMembers(ctx, listId)
+}
+
+func (router *Router) AddListMember(ctx context.Context, listId int, userId int, role data.Role) error {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return err
+	}
+	return tenant.service.AddListMember(ctx, listId, userId, role)
+}
+
+func (router *Router) UpdateListMember(ctx context.Context, listId int, userId int, role data.Role) error {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return err
+	}
+	return tenant.service.UpdateListMember(ctx, listId, userId, role)
+}
+
+func (router *Router) RemoveListMember(ctx context.Context, listId int, userId int) error {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return err
+	}
+	return tenant.service.RemoveListMember(ctx, listId, userId)
+}
+
+func (router *Router) MoveTodoItem(ctx context.Context, listId int, itemId int, after int, before int) (int, error) {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return tenant.service.MoveTodoItem(ctx, listId, itemId, after, before)
+}
+
+// Sync refuses batches that would create items past the tenant's item quota. Mutations the service has already applied
+// are counted too, so a batch sent again near the limit may be refused.
+func (router *Router) Sync(ctx context.Context, listId int, batch data.SyncBatch) (data.SyncResult, error) {
+	tenant, err := router.tenantFor(ctx)
+	if err != nil {
+		return data.SyncResult{}, err
+	}
+	if tenant.quota.MaxItems > 0 {
+		creates := 0
+		for _, mutation := range batch.Mutations {
+			if mutation.Op == data.SyncCreate {
+				creates++
+			}
+		}
+		complete, incomplete := tenant.service.CountItems(ctx)
+		if creates > 0 && complete+incomplete+creates > tenant.quota.MaxItems {
+			return data.SyncResult{}, ErrItemQuotaExceeded
+		}
+	}
+	return tenant.service.Sync(ctx, listId, batch)
+}
+
+// Snapshot copies the data of every tenant, all at the same moment.
+func (router *Router) Snapshot() map[string]data.Snapshot {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	services := make(map[string]*dataService.DataService, len(router.tenants))
+	for id, tenant := range router.tenants {
+		services[id] = tenant.service
+	}
+	return dataService.Snapshots(services)
+}
+
+// Restore replaces a tenant's data with a snapshot.
+func (router *Router) Restore(id string, snapshot data.Snapshot) error {
+	tenant, err := router.lookup(id)
+	if err != nil {
+		return err
+	}
+	return tenant.service.Restore(snapshot)
+}
+
+// Merge adds what a snapshot has that a tenant's data doesn't, returning how many lists and items were added.
+func (router *Router) Merge(ctx context.Context, id string, snapshot data.Snapshot) (lists int, items int, err error) {
+	tenant, err := router.lookup(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	return tenant.service.Merge(WithTenant(ctx, id), snapshot)
+}
+
+// CountItems returns the number of complete and incomplete items across every tenant.
+func (router *Router) CountItems(ctx context.Context) (complete int, incomplete int) {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	for _, tenant := range router.tenants {
+		tenantComplete, tenantIncomplete := tenant.service.CountItems(ctx)
+		complete += tenantComplete
+		incomplete += tenantIncomplete
+	}
+	return complete, incomplete
+}
+
+// CheckStore checks the store of every tenant.
+func (router *Router) CheckStore(ctx context.Context) error {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	for id, tenant := range router.tenants {
+		if err := tenant.service.CheckStore(ctx); err != nil {
+			return errors.Join(errors.New("tenant "+id), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package tenants
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"todoApp/auth"
+	"todoApp/data"
+	dataService "todoApp/services"
+)
+
+func newTestRouter(t *testing.T, quota Quota, ids ...string) *Router {
+	router := NewRouter(func(namespace string) *dataService.DataService { return dataService.NewDataService() }, quota)
+	for _, id := range ids {
+		if err := router.AddTenant(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return router
+}
+
+func TestRouter_Isolation(t *testing.T) {
+	router := newTestRouter(t, Quota{}, "team-a", "team-b")
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1, Username: "alice"})
+	teamA := WithTenant(alice, "team-a")
+	teamB := WithTenant(alice, "team-b")
+
+	if err := router.CreateTodoItem(teamA, 0, "Team A's item"); err != nil {
+		t.Fatalf("An unexpected error occured whilst creating the todo item: %s", err.Error())
+	}
+	list, _ := router.CreateTodoList(teamA, "Team A's list")
+
+	if items, _ := router.GetAllTodoItems(teamA, 0); len(items) != 1 {
+		t.Errorf("Team A's item is missing. Got: %v", items)
+	}
+	if items, err := router.GetAllTodoItems(teamB, 0); err != nil || len(items) != 0 {
+		t.Errorf("Team B can see another tenant's items. Got: %v, %v", items, err)
+	}
+	if _, err := router.GetAllTodoItems(teamB, list.Id); err != dataService.ErrListNotFound {
+		t.Errorf("Team B can see another tenant's list. Got: %v, Expected: %v", err, dataService.ErrListNotFound)
+	}
+	if lists := router.GetTodoLists(teamB); len(lists) != 1 {
+		t.Errorf("Team B's lists include another tenant's. Got: %v", lists)
+	}
+
+	// The anonymous user's seeded list is separate in every tenant too.
+	anonymousA := WithTenant(context.Background(), "team-a")
+	anonymousB := WithTenant(context.Background(), "team-b")
+	router.DeleteTodoItem(anonymousA, 0, 0)
+	if items, _ := router.GetAllTodoItems(anonymousB, 0); len(items) != 3 {
+		t.Errorf("Deleting in one tenant changed another. Got: %v", items)
+	}
+}
+
+func TestRouter_UnknownTenant(t *testing.T) {
+	router := newTestRouter(t, Quota{})
+	ctx := WithTenant(context.Background(), "team-c")
+
+	if _, err := router.GetAllTodoItems(ctx, 0); err != ErrUnknownTenant {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrUnknownTenant)
+	}
+	if err := router.AddTenant("Team C!"); err != ErrInvalidTenant {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrInvalidTenant)
+	}
+}
+
+func TestRouter_ItemQuota(t *testing.T) {
+	router := newTestRouter(t, Quota{MaxItems: 4}, "team-a")
+	teamA := WithTenant(context.Background(), "team-a")
+
+	// The anonymous user's default list starts with 3 items.
+	if err := router.CreateTodoItem(teamA, 0, "Fourth"); err != nil {
+		t.Fatalf("An unexpected error occured whilst creating the todo item: %s", err.Error())
+	}
+	if err := router.CreateTodoItem(teamA, 0, "Fifth"); err != ErrItemQuotaExceeded {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrItemQuotaExceeded)
+	}
+	if err := router.CreateTodoItem(context.Background(), 0, "Default tenant"); err != nil {
+		t.Errorf("One tenant's quota affected another. Got: %v", err)
+	}
+
+	name := "Synced"
+	batch := data.SyncBatch{ClientId: "phone", Mutations: []data.Mutation{{Seq: 1, Op: data.SyncCreate, Ref: "a", Name: &name}}}
+	if _, err := router.Sync(teamA, 0, batch); err != ErrItemQuotaExceeded {
+		t.Errorf("Unexpected error syncing past the quota. Got: %v, Expected: %v", err, ErrItemQuotaExceeded)
+	}
+	if _, err := router.Sync(teamA, 0, data.SyncBatch{ClientId: "phone"}); err != nil {
+		t.Errorf("Syncing without creating items should be allowed at the quota. Got: %v", err)
+	}
+}
+
+// memberships maps users to the tenants they belong to.
+type memberships map[int][]string
+
+func (members memberships) InTenant(userId int, tenant string) bool {
+	return slices.Contains(members[userId], tenant)
+}
+
+func TestRouter_Reset(t *testing.T) {
+	router := newTestRouter(t, Quota{}, "team-a")
+	alice := WithTenant(auth.WithPrincipal(context.Background(), auth.Principal{UserId: 1}), "team-a")
+	router.GetTodoLists(alice)
+	router.CreateTodoItem(alice, 0, "Milk")
+
+	router.Reset()
+	if _, err := router.GetDefaultListId(alice); err == nil {
+		t.Errorf("The tenant's lists survived a reset")
+	}
+	if items, _ := router.GetAllTodoItems(context.Background(), 0); len(items) != 3 {
+		t.Errorf("A reset tenant should start from the seeded data. Got: %v", items)
+	}
+	if tenants := router.Tenants(); len(tenants) != 2 {
+		t.Errorf("Tenants were lost in the reset. Got: %v", tenants)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	router := newTestRouter(t, Quota{}, "team-a", "team-b")
+	members := memberships{1: {DefaultTenant, "team-a", "team-b"}, 2: {DefaultTenant, "team-b"}}
+	resolver := &Resolver{Router: router, Members: members, Header: DefaultHeader, BaseDomain: "todo.example.com"}
+
+	testCases := []struct {
+		testName       string
+		host           string
+		header         string
+		userId         int
+		tokenTenant    string
+		expectedStatus int
+		expectedTenant string
+	}{
+		{"Testing without a tenant", "todo.example.com", "", 0, "", http.StatusOK, DefaultTenant},
+		{"Testing the tenant header", "todo.example.com", "team-a", 0, "", http.StatusOK, "team-a"},
+		{"Testing a subdomain", "team-b.todo.example.com:8080", "", 0, "", http.StatusOK, "team-b"},
+		{"Testing the header overriding the subdomain", "team-b.todo.example.com", "team-a", 0, "", http.StatusOK, "team-a"},
+		{"Testing a token's tenant claim", "todo.example.com", "", 1, "team-b", http.StatusOK, "team-b"},
+		{"Testing a token used with another tenant", "todo.example.com", "team-a", 1, "team-b", http.StatusForbidden, ""},
+		{"Testing an unknown tenant", "todo.example.com", "team-c", 0, "", http.StatusNotFound, ""},
+		{"Testing an unrelated domain", "team-a.elsewhere.com", "", 0, "", http.StatusOK, DefaultTenant},
+		{"Testing a member of the tenant", "todo.example.com", "team-b", 2, "", http.StatusOK, "team-b"},
+		{"Testing a user who isn't a member of the tenant", "todo.example.com", "team-a", 2, "", http.StatusForbidden, ""},
+		{"Testing a subdomain the user isn't a member of", "team-a.todo.example.com", "", 2, "", http.StatusForbidden, ""},
+		{"Testing a token for a tenant the user has left", "todo.example.com", "", 2, "team-a", http.StatusForbidden, ""},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			var seenTenant string
+			handler := resolver.Resolve()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seenTenant = From(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/todoapp/items/", nil)
+			req.Host = test.host
+			if test.header != "" {
+				req.Header.Set(DefaultHeader, test.header)
+			}
+			if test.userId != 0 {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{UserId: test.userId, Method: auth.MethodToken, Tenant: test.tokenTenant}))
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, test.expectedStatus)
+			}
+			if seenTenant != test.expectedTenant {
+				t.Errorf("Unexpected tenant. Got: %q, Expected: %q", seenTenant, test.expectedTenant)
+			}
+		})
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	router := newTestRouter(t, Quota{RequestsPerSecond: 1, Burst: 2}, "team-a")
+	handler := router.LimitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/todoapp/items/", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(WithTenant(req.Context(), tenant)))
+		return rr
+	}
+
+	send("team-a")
+	send("team-a")
+	rr := send("team-a")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code. Got: %v Want: %v", rr.Code, http.StatusTooManyRequests)
+	} else if rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("Unexpected Retry-After. Got: %q", rr.Header().Get("Retry-After"))
+	}
+	if rr := send(DefaultTenant); rr.Code != http.StatusOK {
+		t.Errorf("One tenant's rate quota affected another. Got: %v Want: %v", rr.Code, http.StatusOK)
+	}
+}
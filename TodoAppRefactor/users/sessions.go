@@ -0,0 +1,95 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+const DefaultSessionTTL = 24 * time.Hour
+
+// Session is a server-side login session. Only its ID is given to the browser, in a cookie. CSRFToken is embedded in
+// the pages rendered for the session and has to be sent back with every change made using the cookie.
+type Session struct {
+	Id        string
+	UserId    int
+	CSRFToken string
+	Created   time.Time
+	Expires   time.Time
+}
+
+type SessionStore struct {
+	sessions map[string]Session
+	ttl      time.Duration
+	mu       sync.Mutex
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{sessions: map[string]Session{}, ttl: ttl}
+}
+
+// CreateSession starts a new session for the user with a random, unguessable ID and CSRF token.
+func (store *SessionStore) CreateSession(userId int) (Session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return Session{}, err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return Session{}, err
+	}
+
+	now := time.Now()
+	session := Session{
+		Id:        id,
+		UserId:    userId,
+		CSRFToken: csrfToken,
+		Created:   now,
+		Expires:   now.Add(store.ttl),
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.removeExpired(now)
+	store.sessions[session.Id] = session
+	return session, nil
+}
+
+// GetSession returns the session if it exists and has not expired.
+func (store *SessionStore) GetSession(id string) (Session, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	session, exists := store.sessions[id]
+	if !exists {
+		return Session{}, false
+	}
+	if time.Now().After(session.Expires) {
+		delete(store.sessions, id)
+		return Session{}, false
+	}
+	return session, true
+}
+
+func (store *SessionStore) DeleteSession(id string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.sessions, id)
+}
+
+func (store *SessionStore) removeExpired(now time.Time) {
+	for id, session := range store.sessions {
+		if now.After(session.Expires) {
+			delete(store.sessions, id)
+		}
+	}
+}
+
+func randomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
@@ -0,0 +1,271 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"todoApp/utils/stringUtils"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 8
+)
+
+var (
+	ErrUsernameTaken      = errors.New("username is already taken")
+	ErrInvalidUsername    = errors.New("username must be 3-32 characters of letters, digits, '.', '_' or '-'")
+	ErrPasswordTooShort   = errors.New("password must be at least 8 characters")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserNotFound       = errors.New("user does not exist")
+)
+
+type User struct {
+	Id           int
+	Username     string
+	PasswordHash string `json:"-"`
+	Created      time.Time
+	// Tenants are the IDs of the tenants the user belongs to, in order. Their sessions, API keys and tokens can only be
+	// used with these tenants.
+	Tenants []string
+}
+
+type UserService struct {
+	users  map[int]User
+	byName map[string]int
+	nextId int
+	mu     sync.RWMutex
+}
+
+func NewUserService() *UserService {
+	return &UserService{
+		users:  map[int]User{},
+		byName: map[string]int{},
+		nextId: 1,
+	}
+}
+
+// Register creates a new user. Usernames are case-insensitive, so "Alice" and "alice" are the same user.
+func (userService *UserService) Register(username, password string) (User, error) {
+	if !validUsername(username) {
+		return User{}, ErrInvalidUsername
+	}
+	if len(password) < minPasswordLength || stringUtils.IsEmptyOrWhitespace(password) {
+		return User{}, ErrPasswordTooShort
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	userService.mu.Lock()
+	defer userService.mu.Unlock()
+
+	key := strings.ToLower(username)
+	if _, exists := userService.byName[key]; exists {
+		return User{}, ErrUsernameTaken
+	}
+
+	user := User{Id: userService.nextId, Username: username, PasswordHash: hash, Created: time.Now().UTC()}
+	userService.nextId++
+	userService.users[user.Id] = user
+	userService.byName[key] = user.Id
+	return user, nil
+}
+
+// Authenticate returns the user if the password matches. The same error is returned for an unknown user and a wrong
+// password so that callers can't tell which usernames exist.
+func (userService *UserService) Authenticate(username, password string) (User, error) {
+	userService.mu.RLock()
+	id, exists := userService.byName[strings.ToLower(username)]
+	user := userService.users[id]
+	userService.mu.RUnlock()
+
+	if !exists {
+		// Spend the same time hashing as for a real user
+		VerifyPassword(password, dummyHash())
+		return User{}, ErrInvalidCredentials
+	}
+
+	if ok, err := VerifyPassword(password, user.PasswordHash); err != nil || !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func (userService *UserService) GetUser(id int) (User, bool) {
+	userService.mu.RLock()
+	defer userService.mu.RUnlock()
+
+	user, exists := userService.users[id]
+	return user, exists
+}
+
+func (userService *UserService) GetUserByName(username string) (User, bool) {
+	userService.mu.RLock()
+	defer userService.mu.RUnlock()
+
+	id, exists := userService.byName[strings.ToLower(username)]
+	return userService.users[id], exists
+}
+
+// JoinTenant makes the user a member of a tenant. Joining a tenant the user already belongs to does nothing.
+func (userService *UserService) JoinTenant(id int, tenant string) error {
+	userService.mu.Lock()
+	defer userService.mu.Unlock()
+
+	user, exists := userService.users[id]
+	if !exists {
+		return ErrUserNotFound
+	}
+	if !slices.Contains(user.Tenants, tenant) {
+		user.Tenants = append(slices.Clone(user.Tenants), tenant)
+		slices.Sort(user.Tenants)
+		userService.users[id] = user
+	}
+	return nil
+}
+
+// LeaveTenant stops the user being a member of a tenant.
+func (userService *UserService) LeaveTenant(id int, tenant string) error {
+	userService.mu.Lock()
+	defer userService.mu.Unlock()
+
+	user, exists := userService.users[id]
+	if !exists {
+		return ErrUserNotFound
+	}
+	user.Tenants = slices.DeleteFunc(slices.Clone(user.Tenants), func(joined string) bool { return joined == tenant })
+	userService.users[id] = user
+	return nil
+}
+
+// InTenant reports whether the user is a member of a tenant. Unknown users aren't members of any.
+func (userService *UserService) InTenant(id int, tenant string) bool {
+	userService.mu.RLock()
+	defer userService.mu.RUnlock()
+
+	return slices.Contains(userService.users[id].Tenants, tenant)
+}
+
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("not a real password")
+	return hash
+})
+
+func validUsername(username string) bool {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return false
+	}
+	for _, c := range username {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+var ErrInvalidSnapshot = errors.New("user snapshot is invalid")
+
+// Snapshot is every user account, password hashes included, and the next ID to be given out.
+type Snapshot struct {
+	Users  []Account
+	NextId int
+}
+
+// Account is a user as kept in a snapshot. Unlike User its password hash is written out.
+type Account struct {
+	Id           int
+	Username     string
+	PasswordHash string
+	Created      time.Time
+	Tenants      []string
+}
+
+// Snapshot copies every user, in order of ID.
+func (userService *UserService) Snapshot() Snapshot {
+	userService.mu.RLock()
+	defer userService.mu.RUnlock()
+
+	snapshot := Snapshot{Users: make([]Account, 0, len(userService.users)), NextId: userService.nextId}
+	for _, user := range userService.users {
+		snapshot.Users = append(snapshot.Users, Account(user))
+	}
+	slices.SortFunc(snapshot.Users, func(a, b Account) int { return a.Id - b.Id })
+	return snapshot
+}
+
+// ValidateSnapshot checks that every user in a snapshot has a valid username and password hash, and that neither their
+// IDs nor their usernames clash.
+func ValidateSnapshot(snapshot Snapshot) error {
+	ids := map[int]bool{}
+	names := map[string]bool{}
+	for _, account := range snapshot.Users {
+		key := strings.ToLower(account.Username)
+		switch {
+		case account.Id <= 0 || account.Id >= snapshot.NextId:
+			return fmt.Errorf("%w: user %d has an ID outside of 1-%d", ErrInvalidSnapshot, account.Id, snapshot.NextId-1)
+		case !validUsername(account.Username):
+			return fmt.Errorf("%w: user %d: %w", ErrInvalidSnapshot, account.Id, ErrInvalidUsername)
+		case !strings.HasPrefix(account.PasswordHash, passwordScheme+"$"):
+			return fmt.Errorf("%w: user %d has no password hash", ErrInvalidSnapshot, account.Id)
+		case ids[account.Id] || names[key]:
+			return fmt.Errorf("%w: user %d or %q appears twice", ErrInvalidSnapshot, account.Id, account.Username)
+		}
+		ids[account.Id] = true
+		names[key] = true
+	}
+	return nil
+}
+
+// Restore replaces every user with those in a snapshot. Sessions of users who no longer exist stop working.
+func (userService *UserService) Restore(snapshot Snapshot) error {
+	if err := ValidateSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	userService.mu.Lock()
+	defer userService.mu.Unlock()
+
+	userService.users = map[int]User{}
+	userService.byName = map[string]int{}
+	for _, account := range snapshot.Users {
+		userService.users[account.Id] = User(account)
+		userService.byName[strings.ToLower(account.Username)] = account.Id
+	}
+	userService.nextId = snapshot.NextId
+	return nil
+}
+
+// Merge adds the users in a snapshot that don't exist yet, returning how many were added. A user is only added if
+// neither their ID nor their username has been taken since, so that the lists they are a member of stay theirs.
+func (userService *UserService) Merge(snapshot Snapshot) (int, error) {
+	if err := ValidateSnapshot(snapshot); err != nil {
+		return 0, err
+	}
+
+	userService.mu.Lock()
+	defer userService.mu.Unlock()
+
+	added := 0
+	for _, account := range snapshot.Users {
+		key := strings.ToLower(account.Username)
+		_, idTaken := userService.users[account.Id]
+		_, nameTaken := userService.byName[key]
+		if idTaken || nameTaken {
+			continue
+		}
+		userService.users[account.Id] = User(account)
+		userService.byName[key] = account.Id
+		added++
+	}
+	userService.nextId = max(userService.nextId, snapshot.NextId)
+	return added, nil
+}
@@ -0,0 +1,204 @@
+package users
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	UseCheapHashing()
+	os.Exit(m.Run())
+}
+
+func TestPbkdf2_KnownVector(t *testing.T) {
+	// Test vector for PBKDF2-HMAC-SHA256 from RFC 7914 section 11
+	expected := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1, 64)
+
+	if got := hex.EncodeToString(key); got != expected {
+		t.Errorf("Derived key does not match the test vector. Got: %s, Expected: %s", got, expected)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("An unexpected error occured whilst hashing: %s", err.Error())
+	}
+
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Errorf("Hash is not in the expected format. Got: %s", hash)
+	}
+	if ok, err := VerifyPassword("correct horse", hash); err != nil || !ok {
+		t.Errorf("The correct password was not accepted. Got: %v, %v", ok, err)
+	}
+	if ok, _ := VerifyPassword("wrong horse", hash); ok {
+		t.Error("An incorrect password was accepted")
+	}
+	if _, err := VerifyPassword("correct horse", "md5$abc"); err == nil {
+		t.Error("A malformed hash should produce an error")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		username      string
+		password      string
+		expectedError error
+	}{
+		{"Testing valid registration", "alice", "password123", nil},
+		{"Testing username that is taken, ignoring case", "ALICE", "password123", ErrUsernameTaken},
+		{"Testing username that is too short", "al", "password123", ErrInvalidUsername},
+		{"Testing username with invalid characters", "alice smith", "password123", ErrInvalidUsername},
+		{"Testing password that is too short", "bob", "short", ErrPasswordTooShort},
+	}
+	userService := NewUserService()
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if _, err := userService.Register(test.username, test.password); err != test.expectedError {
+				t.Errorf("Unexpected result from registering. Got: %v, Expected: %v", err, test.expectedError)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	userService := NewUserService()
+	registered, _ := userService.Register("alice", "password123")
+
+	if user, err := userService.Authenticate("Alice", "password123"); err != nil {
+		t.Errorf("An unexpected error occured: %s", err.Error())
+	} else if user.Id != registered.Id {
+		t.Errorf("The wrong user was returned. Got: %v, Expected: %v", user.Id, registered.Id)
+	}
+
+	if _, err := userService.Authenticate("alice", "wrong password"); err != ErrInvalidCredentials {
+		t.Errorf("A wrong password was not rejected. Got: %v, Expected: %v", err, ErrInvalidCredentials)
+	}
+	if _, err := userService.Authenticate("nobody", "password123"); err != ErrInvalidCredentials {
+		t.Errorf("An unknown user was not rejected. Got: %v, Expected: %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestTenantMembership(t *testing.T) {
+	userService := NewUserService()
+	alice, _ := userService.Register("alice", "password123")
+	if userService.InTenant(alice.Id, "default") {
+		t.Error("A new user is a member of a tenant they weren't added to")
+	}
+
+	userService.JoinTenant(alice.Id, "team-b")
+	userService.JoinTenant(alice.Id, "default")
+	userService.JoinTenant(alice.Id, "team-b")
+	if user, _ := userService.GetUser(alice.Id); !slices.Equal(user.Tenants, []string{"default", "team-b"}) {
+		t.Errorf("Unexpected tenants. Got: %v", user.Tenants)
+	}
+	userService.LeaveTenant(alice.Id, "team-b")
+	if user, _ := userService.GetUser(alice.Id); userService.InTenant(alice.Id, "team-b") || !userService.InTenant(alice.Id, "default") {
+		t.Errorf("Leaving a tenant didn't leave only that tenant. Got: %v", user.Tenants)
+	}
+	if err := userService.JoinTenant(42, "default"); err != ErrUserNotFound {
+		t.Errorf("Unexpected error. Got: %v, Expected: %v", err, ErrUserNotFound)
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	store := NewSessionStore(time.Hour)
+	session, err := store.CreateSession(7)
+	if err != nil {
+		t.Fatalf("An unexpected error occured: %s", err.Error())
+	}
+
+	if got, ok := store.GetSession(session.Id); !ok || got.UserId != 7 {
+		t.Errorf("The session could not be found. Got: %v, %v", got, ok)
+	}
+
+	store.DeleteSession(session.Id)
+	if _, ok := store.GetSession(session.Id); ok {
+		t.Error("The session was found after being deleted")
+	}
+}
+
+func TestSessionStore_Expiry(t *testing.T) {
+	store := NewSessionStore(-time.Second)
+	session, _ := store.CreateSession(7)
+
+	if _, ok := store.GetSession(session.Id); ok {
+		t.Error("An expired session should not be returned")
+	}
+}
+
+func TestSnapshot_RestoreAndMerge(t *testing.T) {
+	original := NewUserService()
+	alice, _ := original.Register("alice", "password123")
+	bob, _ := original.Register("bob", "password123")
+	original.JoinTenant(alice.Id, "team-a")
+	snapshot := original.Snapshot()
+	if len(snapshot.Users) != 2 || snapshot.Users[0].Id != alice.Id || snapshot.Users[1].PasswordHash == "" {
+		t.Fatalf("Unexpected snapshot. Got: %+v", snapshot)
+	}
+
+	restored := NewUserService()
+	restored.Register("carol", "password123")
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Authenticate("bob", "password123"); err != nil {
+		t.Errorf("A restored user couldn't log in: %v", err)
+	}
+	if !restored.InTenant(alice.Id, "team-a") {
+		t.Error("A restored user lost their tenants")
+	}
+	if _, ok := restored.GetUserByName("carol"); ok {
+		t.Error("A user who isn't in the snapshot survived restoring it")
+	}
+
+	// Alice's username and Bob's ID have been taken since, so only Carol is merged
+	carol, _ := original.Register("carol", "password123")
+	hash := snapshot.Users[0].PasswordHash
+	merged := NewUserService()
+	merged.Restore(Snapshot{Users: []Account{{Id: bob.Id, Username: "dave", PasswordHash: hash}, {Id: 4, Username: "ALICE", PasswordHash: hash}}, NextId: 5})
+	if added, err := merged.Merge(original.Snapshot()); err != nil || added != 1 {
+		t.Errorf("Unexpected merge. Got: %d, %v", added, err)
+	}
+	if user, ok := merged.GetUser(carol.Id); !ok || user.Username != "carol" {
+		t.Errorf("Carol wasn't merged. Got: %v", user)
+	}
+	if user, _ := merged.GetUser(bob.Id); user.Username != "dave" {
+		t.Errorf("An existing user was replaced. Got: %v", user)
+	}
+	if _, ok := merged.GetUser(alice.Id); ok {
+		t.Error("Alice was merged even though her username is taken")
+	}
+	if user, _ := merged.Register("erin", "password123"); user.Id != 5 {
+		t.Errorf("New IDs should carry on after the existing ones. Got: %d", user.Id)
+	}
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	hash := "pbkdf2-sha256$1$AA$AA"
+	testCases := []struct {
+		testName string
+		snapshot Snapshot
+	}{
+		{"Testing an ID that hasn't been given out", Snapshot{Users: []Account{{Id: 2, Username: "alice", PasswordHash: hash}}, NextId: 2}},
+		{"Testing an invalid username", Snapshot{Users: []Account{{Id: 1, Username: "a b", PasswordHash: hash}}, NextId: 2}},
+		{"Testing a missing password hash", Snapshot{Users: []Account{{Id: 1, Username: "alice"}}, NextId: 2}},
+		{"Testing a username that appears twice", Snapshot{Users: []Account{{Id: 1, Username: "alice", PasswordHash: hash}, {Id: 2, Username: "Alice", PasswordHash: hash}}, NextId: 3}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if err := ValidateSnapshot(test.snapshot); !errors.Is(err, ErrInvalidSnapshot) {
+				t.Errorf("An invalid snapshot was accepted. Got: %v", err)
+			}
+		})
+	}
+}
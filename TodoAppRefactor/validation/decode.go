@@ -0,0 +1,105 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxBodyBytes is the largest request body DecodeJSON reads by default.
+const DefaultMaxBodyBytes = 64 << 10
+
+// Problem is why a request body was rejected: its status is 400 if the body couldn't be read as the contract, 413 if
+// it was too large, 415 if it wasn't JSON and 422 if it was read but broke the contract's rules.
+type Problem struct {
+	Status  int
+	Message string
+	Fields  Errors
+}
+
+func (problem *Problem) Error() string {
+	if len(problem.Fields) == 0 {
+		return problem.Message
+	}
+	return problem.Message + ": " + problem.Fields.Error()
+}
+
+// DecodeJSON reads a single JSON object of at most maxBytes from the body of r into v, then validates it. Fields that
+// v doesn't have are rejected rather than ignored, so typos don't silently drop values.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v Validator, maxBytes int64) *Problem {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+			return &Problem{Status: http.StatusUnsupportedMediaType, Message: "request body must be application/json"}
+		}
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return decodeProblem(err)
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return decodeProblem(err)
+		}
+		return &Problem{Status: http.StatusBadRequest, Message: "request body must be a single JSON object"}
+	}
+
+	if fields := v.Validate(); len(fields) > 0 {
+		return &Problem{Status: http.StatusUnprocessableEntity, Message: "request is not valid", Fields: fields}
+	}
+	return nil
+}
+
+func decodeProblem(err error) *Problem {
+	var (
+		maxBytesErr *http.MaxBytesError
+		syntaxErr   *json.SyntaxError
+		typeErr     *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &Problem{Status: http.StatusRequestEntityTooLarge, Message: "request body must be at most " + strconv.FormatInt(maxBytesErr.Limit, 10) + " bytes"}
+	case errors.Is(err, io.EOF):
+		return &Problem{Status: http.StatusBadRequest, Message: "request body is required"}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return &Problem{Status: http.StatusBadRequest, Message: "request body is not valid JSON"}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			return &Problem{Status: http.StatusBadRequest, Message: "request body must be a JSON object"}
+		}
+		return &Problem{Status: http.StatusBadRequest, Message: "request body has fields of the wrong type",
+			Fields: Errors{{Field: field, Message: "must be " + jsonType(typeErr.Type.Kind())}}}
+	}
+
+	// encoding/json doesn't have an error type for unknown fields, only this message.
+	if field, found := strings.CutPrefix(err.Error(), "json: unknown field "); found {
+		return &Problem{Status: http.StatusBadRequest, Message: "request body has unknown fields",
+			Fields: Errors{{Field: strings.Trim(field, `"`), Message: "is not a known field"}}}
+	}
+	return &Problem{Status: http.StatusBadRequest, Message: "request body could not be read"}
+}
+
+// jsonType names the JSON type a Go value of the kind is read from.
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
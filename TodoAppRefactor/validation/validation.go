@@ -0,0 +1,212 @@
+package validation
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError is a single rule a field of a request broke.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Errors are every rule a request broke. A nil Errors means the request is valid.
+type Errors []FieldError
+
+func (errs Errors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Field + ": " + err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records that field broke a rule.
+func (errs *Errors) Add(field, message string) {
+	*errs = append(*errs, FieldError{Field: field, Message: message})
+}
+
+// String normalizes *value and checks it against the rules in order, recording the first one it breaks. Later rules
+// can assume the earlier ones passed, so Required should come first. Values with combining characters are always
+// rejected, see Normalize.
+func (errs *Errors) String(field string, value *string, rules ...Rule) {
+	*value = Normalize(*value)
+	for _, rule := range append([]Rule{composed()}, rules...) {
+		if message := rule(*value); message != "" {
+			errs.Add(field, message)
+			return
+		}
+	}
+}
+
+// Int records a violation if value is outside [min, max].
+func (errs *Errors) Int(field string, value, min, max int) {
+	if value < min || value > max {
+		errs.Add(field, "must be between "+strconv.Itoa(min)+" and "+strconv.Itoa(max))
+	}
+}
+
+// Validator is implemented by request contracts. Validate may normalize the contract as it checks it.
+type Validator interface {
+	Validate() Errors
+}
+
+// Rule checks a normalized string, returning why it isn't valid or "" if it is.
+type Rule func(value string) string
+
+// Required rejects empty strings. Strings of only white space are empty once normalized.
+func Required() Rule {
+	return func(value string) string {
+		if value == "" {
+			return "is required"
+		}
+		return ""
+	}
+}
+
+// MaxLength rejects strings of more than max characters. Characters are counted as code points, not bytes.
+func MaxLength(max int) Rule {
+	return func(value string) string {
+		if utf8.RuneCountInString(value) > max {
+			return "must be at most " + strconv.Itoa(max) + " characters"
+		}
+		return ""
+	}
+}
+
+// MinLength rejects non-empty strings of fewer than min characters. Combine with Required to reject empty ones too.
+func MinLength(min int) Rule {
+	return func(value string) string {
+		if value != "" && utf8.RuneCountInString(value) < min {
+			return "must be at least " + strconv.Itoa(min) + " characters"
+		}
+		return ""
+	}
+}
+
+// Printable rejects control characters and unassigned code points.
+func Printable() Rule {
+	return func(value string) string {
+		for _, r := range value {
+			if !unicode.IsPrint(r) && !unicode.Is(unicode.Join_Control, r) {
+				return "must only contain printable characters"
+			}
+		}
+		return ""
+	}
+}
+
+// Chars rejects strings containing characters that allowed doesn't accept. description says which characters are
+// allowed, for the error message.
+func Chars(description string, allowed func(rune) bool) Rule {
+	return func(value string) string {
+		for _, r := range value {
+			if !allowed(r) {
+				return "must only contain " + description
+			}
+		}
+		return ""
+	}
+}
+
+// OneOf rejects non-empty strings that aren't one of the values.
+func OneOf(values ...string) Rule {
+	return func(value string) string {
+		if value == "" {
+			return ""
+		}
+		for _, allowed := range values {
+			if value == allowed {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(values, ", ")
+	}
+}
+
+// Timestamp rejects non-empty strings that aren't RFC 3339 timestamps, e.g. '2024-05-01T12:00:00Z'.
+func Timestamp() Rule {
+	return func(value string) string {
+		if value == "" {
+			return ""
+		}
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "must be an RFC 3339 timestamp, e.g. 2024-05-01T12:00:00Z"
+		}
+		return ""
+	}
+}
+
+// Future rejects timestamps that aren't after now. Use it after Timestamp.
+func Future(now func() time.Time) Rule {
+	return func(value string) string {
+		if value == "" {
+			return ""
+		}
+		if parsed, err := time.Parse(time.RFC3339, value); err == nil && !parsed.After(now()) {
+			return "must be in the future"
+		}
+		return ""
+	}
+}
+
+// URL rejects non-empty strings that aren't absolute URLs with one of the schemes and a host.
+func URL(schemes ...string) Rule {
+	return func(value string) string {
+		if value == "" {
+			return ""
+		}
+		parsed, err := url.Parse(value)
+		if err != nil || !slices.Contains(schemes, parsed.Scheme) || parsed.Host == "" {
+			return "must be an absolute " + strings.Join(schemes, " or ") + " URL"
+		}
+		return ""
+	}
+}
+
+// composed rejects strings with combining characters, e.g. an 'e' followed by a combining acute accent rather than a
+// precomposed 'é'. Composing them (Unicode NFC) needs the Unicode composition tables, which aren't in the standard
+// library, and left as they are the same text could be stored two ways. Clients send NFC text, which most input
+// methods already produce.
+func composed() Rule {
+	return func(value string) string {
+		for _, r := range value {
+			if unicode.Is(unicode.M, r) {
+				return "must not contain combining characters, send text in Unicode NFC with precomposed characters"
+			}
+		}
+		return ""
+	}
+}
+
+// Normalize makes strings that look the same compare the same: invalid UTF-8 is replaced, invisible formatting
+// characters such as zero width spaces and byte order marks are removed (joiners, which change how emoji are drawn,
+// are kept), every run of Unicode white space becomes a single space and the ends are trimmed. Combining characters
+// are left for String to reject, as composing them needs tables that aren't in the standard library.
+func Normalize(value string) string {
+	value = strings.ToValidUTF8(value, string(utf8.RuneError))
+
+	var b strings.Builder
+	b.Grow(len(value))
+	space := false
+	for _, r := range value {
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+		case unicode.Is(unicode.Cf, r) && !unicode.Is(unicode.Join_Control, r):
+		default:
+			if space {
+				b.WriteByte(' ')
+				space = false
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
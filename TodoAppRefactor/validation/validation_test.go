@@ -0,0 +1,134 @@
+package validation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		testName string
+		input    string
+		expected string
+	}{
+		{"Testing surrounding white space", "  Milk \t", "Milk"},
+		{"Testing runs of white space", "Buy\n\nsome   milk", "Buy some milk"},
+		{"Testing Unicode spaces", "Buy\u00a0milk\u3000now", "Buy milk now"},
+		{"Testing zero width characters", "Mi\u200bl\ufeffk", "Milk"},
+		{"Testing joiners are kept", "\U0001F469\u200d\U0001F4BB", "\U0001F469\u200d\U0001F4BB"},
+		{"Testing invalid UTF-8", "Mi\xffk", "Mi\ufffdk"},
+		{"Testing only white space", " \u2003 ", ""},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if result := Normalize(test.input); result != test.expected {
+				t.Errorf("Unexpected result. Got: %q, Expected: %q", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestRules(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		testName string
+		rule     Rule
+		value    string
+		valid    bool
+	}{
+		{"Testing Required with a value", Required(), "Milk", true},
+		{"Testing Required without a value", Required(), "", false},
+		{"Testing MaxLength counts characters", MaxLength(4), "Café", true},
+		{"Testing MaxLength over the limit", MaxLength(4), "Cafés", false},
+		{"Testing MinLength under the limit", MinLength(3), "ab", false},
+		{"Testing MinLength allows empty", MinLength(3), "", true},
+		{"Testing Printable with emoji", Printable(), "Milk 🥛", true},
+		{"Testing Printable with a control character", Printable(), "Milk\u0007", false},
+		{"Testing Chars", Chars("digits", func(r rune) bool { return r >= '0' && r <= '9' }), "12a", false},
+		{"Testing OneOf with an allowed value", OneOf("read", "read-write"), "read", true},
+		{"Testing OneOf with another value", OneOf("read", "read-write"), "write", false},
+		{"Testing Timestamp with RFC 3339", Timestamp(), "2024-05-01T12:00:00Z", true},
+		{"Testing Timestamp with a date only", Timestamp(), "2024-05-01", false},
+		{"Testing Future with a later time", Future(func() time.Time { return now }), "2024-05-02T00:00:00Z", true},
+		{"Testing URL with an allowed scheme", URL("https"), "https://hooks.example.com/todo?x=1", true},
+		{"Testing URL with another scheme", URL("https"), "ftp://hooks.example.com", false},
+		{"Testing URL without a host", URL("https"), "https:///todo", false},
+		{"Testing Future with an earlier time", Future(func() time.Time { return now }), "2024-04-30T00:00:00Z", false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if message := test.rule(test.value); (message == "") != test.valid {
+				t.Errorf("Unexpected result for %q. Got message: %q, Expected valid: %v", test.value, message, test.valid)
+			}
+		})
+	}
+}
+
+func TestErrors_String(t *testing.T) {
+	var errs Errors
+	name := "  "
+	errs.String("Name", &name, Required(), MaxLength(1))
+	scope := " read "
+	errs.String("Scope", &scope, Required(), OneOf("read"))
+	errs.Int("TTLSeconds", 7200, 0, 3600)
+	decomposed := "Cafe\u0301"
+	errs.String("Description", &decomposed, MaxLength(10))
+	precomposed := "Caf\u00e9"
+	errs.String("Title", &precomposed, MaxLength(10))
+
+	if len(errs) != 3 || errs[0] != (FieldError{Field: "Name", Message: "is required"}) || errs[1].Field != "TTLSeconds" ||
+		errs[2].Field != "Description" {
+		t.Errorf("Unexpected errors. Got: %v", errs)
+	}
+	if scope != "read" {
+		t.Errorf("value was not normalized. Got: %q", scope)
+	}
+}
+
+type testContract struct {
+	Name string
+}
+
+func (contract *testContract) Validate() Errors {
+	var errs Errors
+	errs.String("Name", &contract.Name, Required())
+	return errs
+}
+
+func TestDecodeJSON(t *testing.T) {
+	testCases := []struct {
+		testName       string
+		body           string
+		expectedStatus int
+	}{
+		{"Testing a valid body", `{"name":"  Milk "}`, 0},
+		{"Testing an invalid value", `{"Name":""}`, http.StatusUnprocessableEntity},
+		{"Testing an unknown field", `{"Nmae":"Milk"}`, http.StatusBadRequest},
+		{"Testing a JSON array", `["Milk"]`, http.StatusBadRequest},
+		{"Testing a body over the limit", `{"Name":"` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			var contract testContract
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			problem := DecodeJSON(httptest.NewRecorder(), req, &contract, 32)
+
+			if test.expectedStatus == 0 {
+				if problem != nil {
+					t.Fatalf("valid body was rejected: %v", problem)
+				}
+				if contract.Name != "Milk" {
+					t.Errorf("Unexpected name. Got: %q, Expected: %q", contract.Name, "Milk")
+				}
+			} else if problem == nil || problem.Status != test.expectedStatus {
+				t.Errorf("Unexpected problem. Got: %v, Expected status: %v", problem, test.expectedStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+	"todoApp/events"
+	"todoApp/metrics"
+)
+
+// Headers sent with every delivery. The signature is 'sha256=' followed by the hex HMAC-SHA256, keyed with the
+// webhook's secret, of the timestamp, a '.' and the body, so receivers can check a delivery came from this server and
+// reject old ones being replayed.
+const (
+	SignatureHeader = "X-Todoapp-Signature"
+	TimestampHeader = "X-Todoapp-Timestamp"
+	EventHeader     = "X-Todoapp-Event"
+	DeliveryHeader  = "X-Todoapp-Delivery"
+)
+
+var ErrPrivateAddress = errors.New("webhook address is not publicly routable")
+
+// Payload is the JSON body of a delivery. Every attempt to deliver an event has the same DeliveryId, so receivers can
+// ignore ones they've already handled.
+type Payload struct {
+	DeliveryId string
+	WebhookId  string
+	Event      events.Event
+}
+
+type job struct {
+	deliveryId string
+	webhookId  string
+	event      events.Event
+	attempt    int
+}
+
+var deliveries = metrics.Default.NewCounterVec("todoapp_webhook_deliveries_total",
+	"Webhook delivery attempts, by result: delivered, retrying or dead (given up on).", "result")
+
+// Start delivers the events published to every tenant's hub until Close is called.
+func (service *Service) Start(hubs *events.Hubs) {
+	ctx, stop := context.WithCancel(context.Background())
+	service.mu.Lock()
+	service.stop = stop
+	service.mu.Unlock()
+
+	for range service.config.Workers {
+		service.workers.Add(1)
+		go service.work(ctx)
+	}
+	// Hubs are watched as they are created, before anything is published to them, so every event published from here
+	// on is delivered
+	hubs.Watch(func(tenant string, hub *events.Hub) {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+
+		if service.closed {
+			return
+		}
+		last := hub.Seq()
+		sub, _, _ := hub.Subscribe(last, events.All)
+		service.workers.Add(1)
+		go service.dispatch(ctx, tenant, hub, sub, last)
+	})
+}
+
+// Close stops delivering events. Deliveries in flight are cancelled and those waiting to be retried are dropped.
+func (service *Service) Close() {
+	service.mu.Lock()
+	service.closed = true
+	stop := service.stop
+	service.mu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+	service.workers.Wait()
+	service.retries.Wait()
+}
+
+// dispatch queues a delivery for every webhook registered for each event published to a tenant's hub. If it falls
+// behind the hub it subscribes again from the last event it handled.
+func (service *Service) dispatch(ctx context.Context, tenant string, hub *events.Hub, sub *events.Subscription, last uint64) {
+	defer service.workers.Done()
+
+	for {
+		for open := true; open; {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case <-hub.Done():
+				return
+			case event, ok := <-sub.Events():
+				if open = ok; ok {
+					service.fanOut(event)
+					last = event.Seq
+				}
+			}
+		}
+
+		var replay []events.Event
+		var complete bool
+		sub, replay, complete = hub.Subscribe(last, events.All)
+		if !complete {
+			slog.Warn("webhooks fell too far behind, some events won't be delivered", "tenant", tenant, "since", last)
+		}
+		for _, event := range replay {
+			service.fanOut(event)
+			last = event.Seq
+		}
+	}
+}
+
+// fanOut queues a delivery of event to every webhook registered for it. Webhooks only receive the events their owner
+// could see, so they stop when the owner is removed from the list.
+func (service *Service) fanOut(event events.Event) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	for _, hook := range service.webhooks {
+		if hook.Tenant != event.Tenant || hook.ListId != event.ListId || !slices.Contains(event.Members, hook.OwnerId) {
+			continue
+		}
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, event.Type) {
+			continue
+		}
+		service.enqueue(job{deliveryId: randomId(), webhookId: hook.Id, event: event, attempt: 1})
+	}
+}
+
+// enqueue hands a delivery to the workers. If the queue is full the event is parked straight away rather than holding
+// up the dispatcher. The caller must hold the lock.
+func (service *Service) enqueue(job job) {
+	if service.closed {
+		return
+	}
+	select {
+	case service.queue <- job:
+	default:
+		if hook, exists := service.webhooks[job.webhookId]; exists {
+			deliveries.With("dead").Inc()
+			hook.park(job, "delivery queue full", service.now())
+		}
+	}
+}
+
+func (service *Service) work(ctx context.Context) {
+	defer service.workers.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-service.queue:
+			service.attempt(ctx, job)
+		}
+	}
+}
+
+// attempt sends a delivery once, then logs it and either schedules a retry or parks the event.
+func (service *Service) attempt(ctx context.Context, job job) {
+	service.mu.Lock()
+	hook, exists := service.webhooks[job.webhookId]
+	var target Webhook
+	if exists {
+		target = hook.Webhook
+	}
+	service.mu.Unlock()
+	if !exists {
+		return
+	}
+
+	start := service.now()
+	status, err := service.post(ctx, target, job)
+	delivery := Delivery{
+		Id:         job.deliveryId,
+		WebhookId:  job.webhookId,
+		EventSeq:   job.event.Seq,
+		EventType:  job.event.Type,
+		Attempt:    job.attempt,
+		Time:       start.UTC(),
+		Duration:   service.now().Sub(start),
+		StatusCode: status,
+		Succeeded:  err == nil,
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if hook, exists = service.webhooks[job.webhookId]; !exists || service.closed {
+		return
+	}
+	switch {
+	case err == nil:
+		deliveries.With("delivered").Inc()
+	case retryable(status, err) && job.attempt < service.config.MaxAttempts:
+		deliveries.With("retrying").Inc()
+		delivery.Error = err.Error()
+		delay := service.backoff(job.attempt)
+		next := start.Add(delay).UTC()
+		delivery.NextAttempt = &next
+		job.attempt++
+		service.retries.Add(1)
+		go service.retry(ctx, job, delay)
+	default:
+		deliveries.With("dead").Inc()
+		delivery.Error = err.Error()
+		hook.park(job, err.Error(), start)
+		slog.Warn("webhook delivery failed, parked as a dead letter", "webhook", job.webhookId, "delivery", job.deliveryId,
+			"attempts", job.attempt, "error", err)
+	}
+	hook.deliveries = append(hook.deliveries, delivery)
+	if len(hook.deliveries) > deliveryLogSize {
+		hook.deliveries = slices.Delete(hook.deliveries, 0, len(hook.deliveries)-deliveryLogSize)
+	}
+}
+
+func (service *Service) retry(ctx context.Context, job job, delay time.Duration) {
+	defer service.retries.Done()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+		service.mu.Lock()
+		service.enqueue(job)
+		service.mu.Unlock()
+	}
+}
+
+// post sends a delivery, returning the status the receiver answered with, or 0 if it couldn't be reached.
+func (service *Service) post(ctx context.Context, hook Webhook, job job) (int, error) {
+	body, err := json.Marshal(Payload{DeliveryId: job.deliveryId, WebhookId: hook.Id, Event: job.event})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(service.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todoapp-webhooks")
+	req.Header.Set(EventHeader, string(job.event.Type))
+	req.Header.Set(DeliveryHeader, job.deliveryId)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(hook.secret, timestamp, body))
+
+	resp, err := service.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether a failed delivery might succeed later: the receiver couldn't be reached, had a problem of
+// its own or asked to be called back later. Other client errors mean the request itself was refused, and a private
+// address will stay private, so retrying is pointless.
+func retryable(status int, err error) bool {
+	if errors.Is(err, ErrPrivateAddress) {
+		return false
+	}
+	return status == 0 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// backoff returns how long to wait after a failed attempt: the base delay doubled for every attempt so far, capped at
+// the maximum, of which a random half is taken off so receivers coming back up aren't hit by every retry at once.
+func (service *Service) backoff(attempt int) time.Duration {
+	delay := service.config.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		delay = min(service.config.BaseDelay<<shift, service.config.MaxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// park moves an event to the dead letters. The caller must hold the service's lock.
+func (hook *webhook) park(job job, lastError string, now time.Time) {
+	hook.deadLetters = append(hook.deadLetters, DeadLetter{
+		DeliveryId: job.deliveryId,
+		WebhookId:  job.webhookId,
+		Event:      job.event,
+		Attempts:   job.attempt,
+		LastError:  lastError,
+		Time:       now.UTC(),
+	})
+	if len(hook.deadLetters) > deadLetterSize {
+		hook.deadLetters = slices.Delete(hook.deadLetters, 0, len(hook.deadLetters)-deadLetterSize)
+	}
+}
+
+// Sign returns the signature header for a delivery body sent at timestamp.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery, and that it was sent within tolerance of now. Receivers written in Go can
+// use it as it is.
+func Verify(secret string, timestamp string, body []byte, signature string, now time.Time, tolerance time.Duration) bool {
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(sent, 0)); age > tolerance || age < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
+
+// newClient returns the client deliveries are sent with. It doesn't follow redirects, which could lead anywhere, and
+// unless private addresses are allowed it refuses to connect to them, checked after the host name is resolved.
+func newClient(config Config) *http.Client {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if !config.AllowPrivate {
+		dialer.Control = refusePrivate
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would be dialled instead of the receiver, getting around the address check
+	transport.Proxy = nil
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() ||
+		ip.IsUnspecified() || sharedAddressSpace.Contains(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
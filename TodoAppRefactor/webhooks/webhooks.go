@@ -0,0 +1,255 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+	"todoApp/events"
+)
+
+const (
+	DefaultMaxAttempts = 8
+	DefaultBaseDelay   = time.Second
+	DefaultMaxDelay    = 5 * time.Minute
+	DefaultTimeout     = 10 * time.Second
+	DefaultWorkers     = 4
+	// MaxWebhooksPerList stops one list fanning every change out to an unbounded number of URLs.
+	MaxWebhooksPerList = 10
+	// deliveryLogSize and deadLetterSize are how many attempts and dead letters are kept for each webhook.
+	deliveryLogSize = 100
+	deadLetterSize  = 100
+	queueSize       = 1024
+)
+
+var (
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrTooManyWebhooks    = errors.New("the list already has the maximum number of webhooks")
+)
+
+// Config says how hard the service tries to deliver events.
+type Config struct {
+	// MaxAttempts is how many times an event is sent before it is parked as a dead letter.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, which doubles with each attempt up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout is how long a receiver has to answer a delivery.
+	Timeout time.Duration
+	Workers int
+	// AllowPrivate lets webhooks reach loopback, private and link-local addresses. Without it users could have the
+	// server make requests to services that are only reachable from inside the network.
+	AllowPrivate bool
+}
+
+// Webhook sends a list's events to a URL. An empty Events means every type of event.
+type Webhook struct {
+	Id      string
+	Tenant  string
+	ListId  int
+	OwnerId int
+	URL     string
+	Events  []events.Type
+	Created time.Time
+	secret  string
+}
+
+// Delivery is one attempt to send an event to a webhook.
+type Delivery struct {
+	Id         string
+	WebhookId  string
+	EventSeq   uint64
+	EventType  events.Type
+	Attempt    int
+	Time       time.Time
+	Duration   time.Duration
+	StatusCode int    `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	Succeeded  bool
+	// NextAttempt is when the delivery will be retried, if it failed and will be.
+	NextAttempt *time.Time `json:",omitempty"`
+}
+
+// DeadLetter is an event that couldn't be delivered to a webhook.
+type DeadLetter struct {
+	DeliveryId string
+	WebhookId  string
+	Event      events.Event
+	Attempts   int
+	LastError  string
+	Time       time.Time
+}
+
+// Service keeps the webhooks and delivers the events they're registered for, retrying failed deliveries with
+// exponential backoff and parking those that still fail as dead letters. Like the rest of the app's state, webhooks
+// and their history are kept in memory.
+type Service struct {
+	config   Config
+	client   *http.Client
+	webhooks map[string]*webhook
+	queue    chan job
+	retries  sync.WaitGroup
+	workers  sync.WaitGroup
+	closed   bool
+	stop     context.CancelFunc
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+type webhook struct {
+	Webhook
+	deliveries  []Delivery
+	deadLetters []DeadLetter
+}
+
+func NewService(config Config) *Service {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = DefaultBaseDelay
+	}
+	if config.MaxDelay < config.BaseDelay {
+		config.MaxDelay = max(DefaultMaxDelay, config.BaseDelay)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Workers <= 0 {
+		config.Workers = DefaultWorkers
+	}
+	return &Service{
+		config:   config,
+		client:   newClient(config),
+		webhooks: map[string]*webhook{},
+		queue:    make(chan job, queueSize),
+		now:      time.Now,
+	}
+}
+
+// Create registers a webhook for a list, returning it along with the secret its deliveries are signed with. The
+// secret is only ever returned here.
+func (service *Service) Create(tenant string, listId int, ownerId int, url string, types []events.Type) (Webhook, string, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	count := 0
+	for _, hook := range service.webhooks {
+		if hook.Tenant == tenant && hook.ListId == listId {
+			count++
+		}
+	}
+	if count >= MaxWebhooksPerList {
+		return Webhook{}, "", ErrTooManyWebhooks
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	encoded := hex.EncodeToString(secret)
+	hook := &webhook{Webhook: Webhook{
+		Id:      randomId(),
+		Tenant:  tenant,
+		ListId:  listId,
+		OwnerId: ownerId,
+		URL:     url,
+		Events:  slices.Clone(types),
+		Created: service.now().UTC(),
+		secret:  encoded,
+	}}
+	service.webhooks[hook.Id] = hook
+	return hook.Webhook, encoded, nil
+}
+
+// Webhooks returns a list's webhooks, oldest first.
+func (service *Service) Webhooks(tenant string, listId int) []Webhook {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	result := []Webhook{}
+	for _, hook := range service.webhooks {
+		if hook.Tenant == tenant && hook.ListId == listId {
+			result = append(result, hook.Webhook)
+		}
+	}
+	slices.SortFunc(result, func(a, b Webhook) int { return a.Created.Compare(b.Created) })
+	return result
+}
+
+// Delete removes a webhook. Deliveries still waiting to be retried are dropped.
+func (service *Service) Delete(tenant string, listId int, id string) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if _, err := service.lookup(tenant, listId, id); err != nil {
+		return err
+	}
+	delete(service.webhooks, id)
+	return nil
+}
+
+// Deliveries returns the most recent delivery attempts of a webhook, newest first.
+func (service *Service) Deliveries(tenant string, listId int, id string) ([]Delivery, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	hook, err := service.lookup(tenant, listId, id)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := slices.Clone(hook.deliveries)
+	slices.Reverse(deliveries)
+	return deliveries, nil
+}
+
+// DeadLetters returns the events that couldn't be delivered to a webhook, newest first.
+func (service *Service) DeadLetters(tenant string, listId int, id string) ([]DeadLetter, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	hook, err := service.lookup(tenant, listId, id)
+	if err != nil {
+		return nil, err
+	}
+	deadLetters := slices.Clone(hook.deadLetters)
+	slices.Reverse(deadLetters)
+	return deadLetters, nil
+}
+
+// Redeliver takes a dead letter off the list and tries delivering it again, starting from the first attempt.
+func (service *Service) Redeliver(tenant string, listId int, id string, deliveryId string) error {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	hook, err := service.lookup(tenant, listId, id)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(hook.deadLetters, func(deadLetter DeadLetter) bool { return deadLetter.DeliveryId == deliveryId })
+	if index < 0 {
+		return ErrDeadLetterNotFound
+	}
+	deadLetter := hook.deadLetters[index]
+	hook.deadLetters = slices.Delete(hook.deadLetters, index, index+1)
+	service.enqueue(job{deliveryId: deadLetter.DeliveryId, webhookId: id, event: deadLetter.Event, attempt: 1})
+	return nil
+}
+
+// lookup finds a webhook of a list. The caller must hold the lock.
+func (service *Service) lookup(tenant string, listId int, id string) (*webhook, error) {
+	hook, exists := service.webhooks[id]
+	if !exists || hook.Tenant != tenant || hook.ListId != listId {
+		return nil, ErrWebhookNotFound
+	}
+	return hook, nil
+}
+
+func randomId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
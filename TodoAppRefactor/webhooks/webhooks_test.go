@@ -0,0 +1,329 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"todoApp/events"
+)
+
+// receiver records the deliveries sent to it, answering with whatever status respond returns.
+type receiver struct {
+	server    *httptest.Server
+	respond   atomic.Value
+	mu        sync.Mutex
+	requests  []*http.Request
+	payloads  []Payload
+	signature []bool
+	secret    string
+}
+
+func newReceiver(t *testing.T) *receiver {
+	rec := &receiver{}
+	rec.respond.Store(func() int { return http.StatusOK })
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload Payload
+		json.Unmarshal(body, &payload)
+
+		rec.mu.Lock()
+		valid := Verify(rec.secret, r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader), time.Now(), time.Minute)
+		rec.requests = append(rec.requests, r)
+		rec.payloads = append(rec.payloads, payload)
+		rec.signature = append(rec.signature, valid)
+		rec.mu.Unlock()
+
+		w.WriteHeader(rec.respond.Load().(func() int)())
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func (rec *receiver) count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.payloads)
+}
+
+// start returns a running service that can reach the test receivers, and the hubs it delivers the events of.
+func start(t *testing.T, config Config) (*Service, *events.Hubs) {
+	config.AllowPrivate = true
+	config.BaseDelay = time.Millisecond
+	config.MaxDelay = 5 * time.Millisecond
+	config.Workers = 1
+	service := NewService(config)
+	hubs := events.NewHubs(64)
+	service.Start(hubs)
+	t.Cleanup(service.Close)
+	return service, hubs
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeliver_SignedEvents(t *testing.T) {
+	service, hubs := start(t, Config{})
+	rec := newReceiver(t)
+	hook, secret, err := service.Create("", 1, 7, rec.server.URL, []events.Type{events.ItemCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.mu.Lock()
+	rec.secret = secret
+	rec.mu.Unlock()
+
+	// Only the last event is for the webhook: the others are of another type, for another list, in another tenant or
+	// from before the owner could see the list
+	hubs.For("").Publish(events.Event{Type: events.ItemDeleted, ListId: 1, Members: []int{7}})
+	hubs.For("").Publish(events.Event{Type: events.ItemCreated, ListId: 2, Members: []int{7}})
+	hubs.For("acme").Publish(events.Event{Type: events.ItemCreated, ListId: 1, Tenant: "acme", Members: []int{7}})
+	hubs.For("").Publish(events.Event{Type: events.ItemCreated, ListId: 1, Members: []int{8}})
+	expected := hubs.For("").Publish(events.Event{Type: events.ItemCreated, ListId: 1, Members: []int{7, 8}})
+
+	waitFor(t, "the delivery", func() bool { return rec.count() > 0 })
+	waitFor(t, "the delivery to be logged", func() bool {
+		deliveries, _ := service.Deliveries("", 1, hook.Id)
+		return len(deliveries) > 0
+	})
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.payloads) != 1 || rec.payloads[0].Event.Seq != expected.Seq || rec.payloads[0].WebhookId != hook.Id {
+		t.Fatalf("Unexpected deliveries. Got: %+v", rec.payloads)
+	}
+	if !rec.signature[0] {
+		t.Error("The delivery's signature didn't verify")
+	}
+	if header := rec.requests[0].Header; header.Get(EventHeader) != string(events.ItemCreated) || header.Get(DeliveryHeader) != rec.payloads[0].DeliveryId {
+		t.Errorf("Unexpected headers. Got: %v", header)
+	}
+
+	deliveries, _ := service.Deliveries("", 1, hook.Id)
+	if len(deliveries) != 1 || !deliveries[0].Succeeded || deliveries[0].StatusCode != http.StatusOK || deliveries[0].Attempt != 1 {
+		t.Errorf("Unexpected delivery log. Got: %+v", deliveries)
+	}
+}
+
+func TestDeliver_TenantsAddedLater(t *testing.T) {
+	service, hubs := start(t, Config{})
+	rec := newReceiver(t)
+	hook, _, _ := service.Create("acme", 1, 7, rec.server.URL, nil)
+
+	// The tenant's hub is only created once the service is running, and numbers its events from 1
+	event := hubs.For("acme").Publish(events.Event{Type: events.ItemCreated, ListId: 1, Tenant: "acme", Members: []int{7}})
+	waitFor(t, "the delivery", func() bool {
+		deliveries, _ := service.Deliveries("acme", 1, hook.Id)
+		return len(deliveries) > 0
+	})
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.payloads) != 1 || rec.payloads[0].Event.Seq != event.Seq || event.Seq != 1 {
+		t.Errorf("Unexpected deliveries. Got: %+v", rec.payloads)
+	}
+}
+
+func TestDeliver_RetriesFailures(t *testing.T) {
+	service, hubs := start(t, Config{MaxAttempts: 5})
+	rec := newReceiver(t)
+	var calls atomic.Int32
+	rec.respond.Store(func() int {
+		if calls.Add(1) <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	})
+	hook, _, _ := service.Create("", 1, 7, rec.server.URL, nil)
+
+	hubs.For("").Publish(events.Event{Type: events.ItemCreated, ListId: 1, Members: []int{7}})
+	waitFor(t, "the retries", func() bool {
+		deliveries, _ := service.Deliveries("", 1, hook.Id)
+		return len(deliveries) == 3
+	})
+
+	deliveries, _ := service.Deliveries("", 1, hook.Id)
+	if !deliveries[0].Succeeded || deliveries[0].Attempt != 3 {
+		t.Errorf("The third attempt should have succeeded. Got: %+v", deliveries[0])
+	}
+	for _, failed := range deliveries[1:] {
+		if failed.Succeeded || failed.StatusCode != http.StatusServiceUnavailable || failed.NextAttempt == nil {
+			t.Errorf("Unexpected failed attempt. Got: %+v", failed)
+		}
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, payload := range rec.payloads {
+		if payload.DeliveryId != rec.payloads[0].DeliveryId {
+			t.Errorf("Every attempt should have the same delivery ID. Got: %v and %v", payload.DeliveryId, rec.payloads[0].DeliveryId)
+		}
+	}
+}
+
+func TestDeliver_DeadLetters(t *testing.T) {
+	testCases := []struct {
+		testName         string
+		status           int
+		expectedAttempts int
+	}{
+		{"Testing a receiver that keeps failing", http.StatusBadGateway, 3},
+		{"Testing a receiver that refuses the delivery", http.StatusGone, 1},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			service, hubs := start(t, Config{MaxAttempts: 3})
+			rec := newReceiver(t)
+			rec.respond.Store(func() int { return test.status })
+			hook, _, _ := service.Create("", 1, 7, rec.server.URL, nil)
+
+			event := hubs.For("").Publish(events.Event{Type: events.ItemCreated, ListId: 1, Members: []int{7}})
+			waitFor(t, "the dead letter", func() bool {
+				deadLetters, _ := service.DeadLetters("", 1, hook.Id)
+				return len(deadLetters) == 1
+			})
+			deadLetters, _ := service.DeadLetters("", 1, hook.Id)
+			if deadLetters[0].Attempts != test.expectedAttempts || deadLetters[0].Event.Seq != event.Seq || deadLetters[0].LastError == "" {
+				t.Errorf("Unexpected dead letter. Got: %+v", deadLetters[0])
+			}
+			if count := rec.count(); count != test.expectedAttempts {
+				t.Errorf("Unexpected number of attempts. Got: %v Want: %v", count, test.expectedAttempts)
+			}
+
+			rec.respond.Store(func() int { return http.StatusOK })
+			if err := service.Redeliver("", 1, hook.Id, deadLetters[0].DeliveryId); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, "the redelivery", func() bool {
+				deliveries, _ := service.Deliveries("", 1, hook.Id)
+				return len(deliveries) > 0 && deliveries[0].Succeeded
+			})
+			if deadLetters, _ := service.DeadLetters("", 1, hook.Id); len(deadLetters) != 0 {
+				t.Errorf("A redelivered dead letter should be taken off the list. Got: %v", deadLetters)
+			}
+			if err := service.Redeliver("", 1, hook.Id, deadLetters[0].DeliveryId); !errors.Is(err, ErrDeadLetterNotFound) {
+				t.Errorf("Unexpected error redelivering twice. Got: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeliver_RefusesPrivateAddresses(t *testing.T) {
+	service := NewService(Config{MaxAttempts: 3})
+	hubs := events.NewHubs(8)
+	service.Start(hubs)
+	defer service.Close()
+	rec := newReceiver(t)
+	hook, _, _ := service.Create("", 1, 7, rec.server.URL, nil)
+
+	hubs.For("").Publish(events.Event{Type: events.ItemCreated, ListId: 1, Members: []int{7}})
+	waitFor(t, "the dead letter", func() bool {
+		deadLetters, _ := service.DeadLetters("", 1, hook.Id)
+		return len(deadLetters) == 1
+	})
+	deadLetters, _ := service.DeadLetters("", 1, hook.Id)
+	if deadLetters[0].Attempts != 1 || !strings.Contains(deadLetters[0].LastError, ErrPrivateAddress.Error()) {
+		t.Errorf("A loopback address should be refused without retrying. Got: %+v", deadLetters[0])
+	}
+	if count := rec.count(); count != 0 {
+		t.Errorf("The receiver shouldn't have been reached. Got %v requests", count)
+	}
+}
+
+func TestService_ManagesWebhooks(t *testing.T) {
+	service := NewService(Config{})
+	first, _, _ := service.Create("", 1, 7, "https://example.com/first", nil)
+	second, _, _ := service.Create("", 1, 7, "https://example.com/second", nil)
+	service.Create("", 2, 7, "https://example.com/other", nil)
+
+	if hooks := service.Webhooks("", 1); len(hooks) != 2 || hooks[0].Id != first.Id || hooks[1].Id != second.Id {
+		t.Errorf("Unexpected webhooks. Got: %+v", hooks)
+	}
+	if err := service.Delete("acme", 1, first.Id); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Another tenant shouldn't find the webhook. Got: %v", err)
+	}
+	if err := service.Delete("", 2, first.Id); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Another list shouldn't find the webhook. Got: %v", err)
+	}
+	if err := service.Delete("", 1, first.Id); err != nil {
+		t.Fatal(err)
+	}
+	if hooks := service.Webhooks("", 1); len(hooks) != 1 || hooks[0].Id != second.Id {
+		t.Errorf("Unexpected webhooks after deleting. Got: %+v", hooks)
+	}
+	if _, err := service.Deliveries("", 1, first.Id); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("A deleted webhook shouldn't have deliveries. Got: %v", err)
+	}
+
+	for range MaxWebhooksPerList - 1 {
+		if _, _, err := service.Create("", 1, 7, "https://example.com", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := service.Create("", 1, 7, "https://example.com", nil); !errors.Is(err, ErrTooManyWebhooks) {
+		t.Errorf("Unexpected error going over the limit. Got: %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	service := NewService(Config{BaseDelay: time.Second, MaxDelay: time.Minute})
+	testCases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{3, 4 * time.Second},
+		{10, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, test := range testCases {
+		for range 20 {
+			if delay := service.backoff(test.attempt); delay < test.expected/2 || delay > test.expected {
+				t.Errorf("Unexpected delay after attempt %v. Got: %v Want: between %v and %v", test.attempt, delay, test.expected/2, test.expected)
+			}
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"DeliveryId":"1"}`)
+	signature := Sign("secret", "1700000000", body)
+	testCases := []struct {
+		testName  string
+		secret    string
+		timestamp string
+		body      []byte
+		expected  bool
+	}{
+		{"Testing a valid signature", "secret", "1700000000", body, true},
+		{"Testing another secret", "other", "1700000000", body, false},
+		{"Testing a changed body", "secret", "1700000000", []byte(`{"DeliveryId":"2"}`), false},
+		{"Testing a changed timestamp", "secret", "1700000001", body, false},
+		{"Testing an invalid timestamp", "secret", "yesterday", body, false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			if valid := Verify(test.secret, test.timestamp, test.body, signature, now, time.Minute); valid != test.expected {
+				t.Errorf("Unexpected result. Got: %v Want: %v", valid, test.expected)
+			}
+		})
+	}
+	if Verify("secret", "1700000000", body, signature, now.Add(time.Hour), time.Minute) {
+		t.Error("An old delivery should be rejected")
+	}
+}
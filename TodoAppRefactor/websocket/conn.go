@@ -0,0 +1,360 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Opcodes of the messages and control frames, see RFC 6455 section 5.2.
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// Status codes sent in close frames, see RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// maxControlPayload is the largest payload a ping, pong or close frame can carry.
+const maxControlPayload = 125
+
+var ErrClosed = errors.New("websocket: close already sent")
+
+// CloseError ends a connection, either because the peer sent a close frame or because it broke the protocol and was
+// sent one.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (err *CloseError) Error() string {
+	message := "websocket: closed with status " + strconv.Itoa(err.Code)
+	if err.Reason != "" {
+		message += ": " + err.Reason
+	}
+	return message
+}
+
+// Conn is a WebSocket connection. One goroutine may read from it while others write: writes are serialized, and pings
+// are answered by the reader.
+type Conn struct {
+	conn            net.Conn
+	reader          *bufio.Reader
+	client          bool
+	maxMessageBytes int64
+	idleTimeout     time.Duration
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool, maxMessageBytes int64) *Conn {
+	if reader == nil {
+		reader = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, reader: reader, client: client, maxMessageBytes: maxMessageBytes}
+}
+
+// Dial opens a client connection to a ws:// or wss:// URL, sending header with the handshake. If the server refuses
+// the handshake its response is returned with ErrBadHandshake.
+func Dial(rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	var netConn net.Conn
+	switch u.Scheme {
+	case "ws":
+		netConn, err = net.Dial("tcp", hostPort(u, "80"))
+	case "wss":
+		netConn, err = tls.Dial("tcp", hostPort(u, "443"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, nil, errors.New("websocket: unsupported scheme " + u.Scheme)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: header.Clone()}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(netConn); err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		netConn.Close()
+		return nil, resp, ErrBadHandshake
+	}
+	return newConn(netConn, reader, true, DefaultMaxMessageBytes), resp, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// SetIdleTimeout closes the connection if nothing, not even a pong, is received from the peer for d.
+func (c *Conn) SetIdleTimeout(d time.Duration) {
+	c.idleTimeout = d
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying connection without a closing handshake, see WriteClose.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadMessage returns the next text or binary message, put back together if it was fragmented. Pings are answered
+// and pongs skipped along the way. It returns a *CloseError once the peer closes the connection, after answering its
+// close frame, or once the peer breaks the protocol, after sending it a close frame saying why.
+func (c *Conn) ReadMessage() (opcode int, payload []byte, err error) {
+	var message []byte
+	for {
+		if c.idleTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+		}
+		frame, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame.opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, frame.payload); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, c.answerClose(frame.payload)
+		case continuationFrame:
+			if opcode == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "continuation frame without a message")
+			}
+		case TextMessage, BinaryMessage:
+			if opcode != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "new message before the last one finished")
+			}
+			opcode = frame.opcode
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode "+strconv.Itoa(frame.opcode))
+		}
+
+		if int64(len(message)+len(frame.payload)) > c.maxMessageBytes {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, frame.payload...)
+		if frame.fin {
+			if opcode == TextMessage && !utf8.Valid(message) {
+				return 0, nil, c.fail(CloseInvalidPayload, "text message isn't valid UTF-8")
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: header[0]&0x80 != 0, opcode: int(header[0] & 0x0f)}
+	if header[0]&0x70 != 0 {
+		return frame{}, c.fail(CloseProtocolError, "reserved bits set without an extension")
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return frame{}, c.fail(CloseProtocolError, "frames from clients must be masked, and from servers must not be")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if f.opcode >= CloseMessage && (!f.fin || length > maxControlPayload) {
+		return frame{}, c.fail(CloseProtocolError, "control frames can't be fragmented or longer than 125 bytes")
+	}
+	if length > uint64(c.maxMessageBytes) {
+		return frame{}, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+// answerClose echoes a close frame from the peer, completing the closing handshake.
+func (c *Conn) answerClose(payload []byte) error {
+	switch {
+	case len(payload) == 0:
+		c.writeFrame(CloseMessage, nil)
+		return &CloseError{Code: CloseNoStatus}
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	reason := string(payload[2:])
+	if !validCloseCode(code) || !utf8.ValidString(reason) {
+		return c.fail(CloseProtocolError, "invalid close frame")
+	}
+	c.WriteClose(code, "")
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+// fail closes the connection with code because the peer broke the protocol.
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// WriteMessage sends a text or binary message in a single frame.
+func (c *Conn) WriteMessage(opcode int, payload []byte) error {
+	return c.writeFrame(opcode, payload)
+}
+
+// Ping sends a ping, which the peer answers with a pong.
+func (c *Conn) Ping() error {
+	return c.writeFrame(PingMessage, nil)
+}
+
+// WriteClose starts the closing handshake, saying why the connection is being closed. Nothing can be written after it.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	return c.writeFrame(CloseMessage, append(payload, reason...))
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|byte(opcode))
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xffff:
+		buf = binary.BigEndian.AppendUint16(append(buf, maskBit|126), uint16(length))
+	default:
+		buf = binary.BigEndian.AppendUint64(append(buf, maskBit|127), uint64(length))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(mask, buf[start:])
+	} else {
+		buf = append(buf, payload...)
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func maskBytes(mask [4]byte, payload []byte) {
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+}
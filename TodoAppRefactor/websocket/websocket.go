@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"todoApp/api/responses"
+)
+
+// DefaultMaxMessageBytes is the largest message a connection reads before closing with CloseMessageTooBig.
+const DefaultMaxMessageBytes = 64 << 10
+
+// acceptGUID is appended to the client's key to work out the Sec-WebSocket-Accept header, see RFC 6455 section 4.2.2.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Upgrader turns HTTP requests into WebSocket connections.
+type Upgrader struct {
+	// CheckOrigin reports whether a browser on the request's origin may open a connection. Browsers send cookies with
+	// WebSocket handshakes from any site, so without this check any page could act as the user. Defaults to SameOrigin.
+	CheckOrigin     func(r *http.Request) bool
+	MaxMessageBytes int64
+}
+
+// Upgrade checks the opening handshake of r and takes over its connection. If the handshake isn't valid an error
+// response has been written and ErrBadHandshake is returned.
+func (upgrader Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		responses.WriteError(w, http.StatusMethodNotAllowed, "websocket handshakes must use GET")
+		return nil, ErrBadHandshake
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		responses.WriteError(w, http.StatusBadRequest, "not a websocket handshake")
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		responses.WriteError(w, http.StatusUpgradeRequired, "unsupported websocket version")
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		responses.WriteError(w, http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+		return nil, ErrBadHandshake
+	}
+	checkOrigin := upgrader.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = SameOrigin
+	}
+	if !checkOrigin(r) {
+		responses.WriteError(w, http.StatusForbidden, "origin not allowed")
+		return nil, ErrBadHandshake
+	}
+
+	netConn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		responses.WriteError(w, http.StatusInternalServerError, "connection can't be upgraded")
+		return nil, err
+	}
+	// The server may have set deadlines for reading the request, they don't apply to the connection from here on
+	netConn.SetDeadline(time.Time{})
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := netConn.Write([]byte(response)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	maxMessageBytes := upgrader.MaxMessageBytes
+	if maxMessageBytes <= 0 {
+		maxMessageBytes = DefaultMaxMessageBytes
+	}
+	var reader *bufio.Reader
+	if buffered != nil && buffered.Reader.Buffered() > 0 {
+		reader = buffered.Reader
+	}
+	return newConn(netConn, reader, false, maxMessageBytes), nil
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept header answering a client's Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// SameOrigin allows handshakes without an Origin header, which only browsers send, and those from pages served by the
+// host the request was made to.
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+// headerHasToken reports whether the comma separated header contains token, ignoring case.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, element := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(element), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
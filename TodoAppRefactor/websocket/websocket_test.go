@@ -0,0 +1,215 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3
+	if accept := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept key. Got: %v", accept)
+	}
+}
+
+func TestUpgrade_InvalidHandshakes(t *testing.T) {
+	valid := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+		req.Header.Set("Connection", "keep-alive, Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		return req
+	}
+	testCases := []struct {
+		testName       string
+		change         func(req *http.Request)
+		expectedStatus int
+	}{
+		{"Testing a POST", func(req *http.Request) { req.Method = http.MethodPost }, http.StatusMethodNotAllowed},
+		{"Testing a missing Upgrade header", func(req *http.Request) { req.Header.Del("Upgrade") }, http.StatusBadRequest},
+		{"Testing an old version", func(req *http.Request) { req.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired},
+		{"Testing a short key", func(req *http.Request) { req.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=") }, http.StatusBadRequest},
+		{"Testing another origin", func(req *http.Request) { req.Header.Set("Origin", "https://evil.example") }, http.StatusForbidden},
+		// httptest.ResponseRecorder can't be hijacked, so a valid handshake fails at the last step
+		{"Testing a valid handshake", func(req *http.Request) { req.Header.Set("Origin", "http://example.com") }, http.StatusInternalServerError},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			req := valid()
+			test.change(req)
+			rr := httptest.NewRecorder()
+			if _, err := (Upgrader{}).Upgrade(rr, req); err == nil {
+				t.Fatal("Expected the upgrade to fail")
+			}
+			if rr.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code. Got: %v Want: %v", rr.Code, test.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestDial_EchoesMessages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrader{}.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			opcode, payload, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(opcode, payload)
+		}
+	}))
+	defer server.Close()
+
+	conn, _, err := Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	long := bytes.Repeat([]byte("a"), 70000)
+	for _, message := range [][]byte{[]byte("hello"), bytes.Repeat([]byte("b"), 300), long[:DefaultMaxMessageBytes]} {
+		if err := conn.WriteMessage(BinaryMessage, message); err != nil {
+			t.Fatal(err)
+		}
+		if opcode, payload, err := conn.ReadMessage(); err != nil || opcode != BinaryMessage || !bytes.Equal(payload, message) {
+			t.Errorf("Unexpected echo of a %v byte message. Got: %v, %v bytes, %v", len(message), opcode, len(payload), err)
+		}
+	}
+
+	conn.WriteClose(CloseNormal, "bye")
+	var closeErr *CloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseNormal {
+		t.Errorf("The server should answer the close frame. Got: %v", err)
+	}
+}
+
+func TestDial_RefusedHandshake(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrader{CheckOrigin: func(*http.Request) bool { return false }}.Upgrade(w, r)
+	}))
+	defer server.Close()
+
+	if _, resp, err := Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil); err != ErrBadHandshake || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Unexpected result. Got: %v, %v", resp, err)
+	}
+}
+
+// pipe returns a server connection and the raw client end of it.
+func pipe(t *testing.T, maxMessageBytes int64) (*Conn, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return newConn(server, nil, false, maxMessageBytes), client
+}
+
+// clientFrame builds a masked frame as a client would send it.
+func clientFrame(fin bool, opcode int, payload string) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	start := len(frame)
+	frame = append(frame, payload...)
+	maskBytes([4]byte{1, 2, 3, 4}, frame[start:])
+	return frame
+}
+
+// readServerFrame reads an unmasked frame with a short payload.
+func readServerFrame(t *testing.T, client net.Conn) (opcode int, payload []byte) {
+	t.Helper()
+	header := make([]byte, 2)
+	if _, err := client.Read(header); err != nil {
+		t.Fatal(err)
+	}
+	payload = make([]byte, header[1]&0x7f)
+	if len(payload) > 0 {
+		if _, err := client.Read(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+func TestReadMessage_Fragments(t *testing.T) {
+	conn, client := pipe(t, DefaultMaxMessageBytes)
+	go func() {
+		client.Write(clientFrame(false, TextMessage, "Hel"))
+		client.Write(clientFrame(true, PingMessage, "are you there"))
+	}()
+
+	type result struct {
+		opcode  int
+		payload []byte
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		opcode, payload, err := conn.ReadMessage()
+		done <- result{opcode, payload, err}
+	}()
+
+	if opcode, payload := readServerFrame(t, client); opcode != PongMessage || string(payload) != "are you there" {
+		t.Errorf("A ping in the middle of a message should be answered. Got: %v %q", opcode, payload)
+	}
+	client.Write(clientFrame(true, continuationFrame, "lo"))
+	if got := <-done; got.err != nil || got.opcode != TextMessage || string(got.payload) != "Hello" {
+		t.Errorf("Unexpected message. Got: %v %q %v", got.opcode, got.payload, got.err)
+	}
+}
+
+func TestReadMessage_ProtocolErrors(t *testing.T) {
+	unmasked := []byte{0x81, 2, 'h', 'i'}
+	testCases := []struct {
+		testName     string
+		frames       [][]byte
+		expectedCode int
+	}{
+		{"Testing an unmasked frame", [][]byte{unmasked}, CloseProtocolError},
+		{"Testing a continuation without a message", [][]byte{clientFrame(true, continuationFrame, "hi")}, CloseProtocolError},
+		{"Testing an interrupted message", [][]byte{clientFrame(false, TextMessage, "a"), clientFrame(true, TextMessage, "b")}, CloseProtocolError},
+		{"Testing a fragmented ping", [][]byte{clientFrame(false, PingMessage, "a")}, CloseProtocolError},
+		{"Testing an unknown opcode", [][]byte{clientFrame(true, 3, "a")}, CloseProtocolError},
+		{"Testing invalid UTF-8", [][]byte{clientFrame(true, TextMessage, "\xff")}, CloseInvalidPayload},
+		{"Testing a message that is too big", [][]byte{clientFrame(false, TextMessage, "0123456789"), clientFrame(true, continuationFrame, "0123456789")}, CloseMessageTooBig},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.testName, func(t *testing.T) {
+			conn, client := pipe(t, 16)
+			go func() {
+				for _, frame := range test.frames {
+					client.Write(frame)
+				}
+			}()
+			errs := make(chan error, 1)
+			go func() {
+				_, _, err := conn.ReadMessage()
+				errs <- err
+			}()
+
+			opcode, payload := readServerFrame(t, client)
+			if opcode != CloseMessage || len(payload) < 2 || int(payload[0])<<8|int(payload[1]) != test.expectedCode {
+				t.Errorf("Expected a close frame with code %v. Got: %v %v", test.expectedCode, opcode, payload)
+			}
+			var closeErr *CloseError
+			if err := <-errs; !errors.As(err, &closeErr) || closeErr.Code != test.expectedCode {
+				t.Errorf("Unexpected error. Got: %v", err)
+			}
+		})
+	}
+}